@@ -0,0 +1,153 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+)
+
+// agentDebugAddressEnv is the env of the agent with the address of its datapath
+// debug API
+const agentDebugAddressEnv = "DEBUG_BIND_ADDRESS"
+
+var datapathSelector string
+
+var datapathCmd = &cobra.Command{
+	Use:   "datapath",
+	Short: "datapath get|diff <node>",
+	Long:  "Show the datapath state programmed by the agent of a node: the ipsets, the EGRESSGATEWAY chains, the VXLAN neighbors and FDB entries, and the ip rules and routes.",
+}
+
+var datapathGetCmd = &cobra.Command{
+	Use:   "get <node>",
+	Short: "get <node>",
+	Long:  "Print the desired and the programmed datapath state of the node as JSON.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshot, err := fetchDatapath(args[0])
+		if err != nil {
+			cmd.PrintErr("Get datapath failed: ", err)
+			os.Exit(1)
+		}
+		raw, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			cmd.PrintErr("Get datapath failed: ", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(raw))
+	},
+}
+
+var datapathDiffCmd = &cobra.Command{
+	Use:   "diff <node>",
+	Short: "diff <node>",
+	Long:  "Compare the programmed datapath state of the node with the desired one, the command fails when they differ.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshot, err := fetchDatapath(args[0])
+		if err != nil {
+			cmd.PrintErr("Diff datapath failed: ", err)
+			os.Exit(1)
+		}
+		if !printDatapathDiff(os.Stdout, snapshot) {
+			os.Exit(1)
+		}
+	},
+}
+
+// fetchDatapath gets the datapath snapshot from the agent of the node through
+// the proxy of the API server.
+func fetchDatapath(node string) (*datapath.Snapshot, error) {
+	kubeConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	cli, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pods, err := cli.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: datapathSelector,
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agent pods: %w", err)
+	}
+	pod, port, err := agentDebugPort(pods.Items, node)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := cli.CoreV1().Pods(pod.Namespace).ProxyGet("http", pod.Name, port, datapath.Path, nil).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the datapath from agent %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	snapshot := new(datapath.Snapshot)
+	if err := json.Unmarshal(raw, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode the datapath from agent %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	return snapshot, nil
+}
+
+// agentDebugPort returns the running agent pod of the node and the port of its
+// datapath debug API.
+func agentDebugPort(pods []corev1.Pod, node string) (*corev1.Pod, string, error) {
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, env := range container.Env {
+				if env.Name != agentDebugAddressEnv {
+					continue
+				}
+				_, port, err := net.SplitHostPort(env.Value)
+				if err != nil || port == "" || port == "0" {
+					return nil, "", fmt.Errorf("agent %s/%s has an invalid %s %q", pod.Namespace, pod.Name, agentDebugAddressEnv, env.Value)
+				}
+				return pod, port, nil
+			}
+		}
+		return nil, "", fmt.Errorf("agent %s/%s does not enable the datapath debug API, set agent.debug.apiPort", pod.Namespace, pod.Name)
+	}
+	return nil, "", fmt.Errorf("no running agent pod on node %s", node)
+}
+
+// printDatapathDiff prints the differences of the snapshot, it returns true
+// when the programmed state could be read and is the desired one.
+func printDatapathDiff(out io.Writer, snapshot *datapath.Snapshot) bool {
+	for _, err := range snapshot.Errors {
+		fmt.Fprintf(out, "Error: %s\n", err)
+	}
+	diff := snapshot.Diff()
+	if len(diff) == 0 {
+		fmt.Fprintf(out, "The datapath of node %s is in sync.\n", snapshot.Node)
+		return len(snapshot.Errors) == 0
+	}
+	fmt.Fprintf(out, "The datapath of node %s differs from the desired state (- missing, + extra):\n", snapshot.Node)
+	for _, item := range diff {
+		fmt.Fprint(out, item.String())
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+)
+
+func TestAgentDebugPort(t *testing.T) {
+	agent := func(phase corev1.PodPhase, env ...corev1.EnvVar) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "egressgateway-agent-x1"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Env: env}}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	debugEnv := corev1.EnvVar{Name: agentDebugAddressEnv, Value: ":5813"}
+
+	pod, port, err := agentDebugPort([]corev1.Pod{agent(corev1.PodPending, debugEnv), agent(corev1.PodRunning, debugEnv)}, "node1")
+	assert.NoError(t, err)
+	assert.Equal(t, "egressgateway-agent-x1", pod.Name)
+	assert.Equal(t, "5813", port)
+
+	_, _, err = agentDebugPort([]corev1.Pod{agent(corev1.PodRunning)}, "node1")
+	assert.ErrorContains(t, err, "does not enable the datapath debug API")
+
+	_, _, err = agentDebugPort([]corev1.Pod{agent(corev1.PodPending, debugEnv)}, "node1")
+	assert.ErrorContains(t, err, "no running agent pod on node node1")
+}
+
+func TestPrintDatapathDiff(t *testing.T) {
+	snapshot := &datapath.Snapshot{Node: "node1", Desired: datapath.NewState(), Programmed: datapath.NewState()}
+	buf := new(bytes.Buffer)
+	assert.True(t, printDatapathDiff(buf, snapshot))
+	assert.Equal(t, "The datapath of node node1 is in sync.\n", buf.String())
+
+	snapshot.Desired.IPSets["egress-src-v4-p1"] = []string{"10.21.0.10"}
+	snapshot.Errors = []string{"list tunnel rules and routes: operation not permitted"}
+	buf.Reset()
+	assert.False(t, printDatapathDiff(buf, snapshot))
+	assert.Equal(t, "Error: list tunnel rules and routes: operation not permitted\n"+
+		"The datapath of node node1 differs from the desired state (- missing, + extra):\n"+
+		"ipset egress-src-v4-p1:\n  - 10.21.0.10\n", buf.String())
+}
@@ -28,8 +28,17 @@ func Execute() {
 	moveCmd.Flags().StringVarP(&vipAddress, "vip", "", "", "Specify the VIP address to MoveEgressIP")
 	moveCmd.Flags().StringVarP(&targetNode, "targetNode", "", "", "Specify the name of the node to MoveEgressIP the VIP to")
 
+	traceCmd.Flags().StringVarP(&traceNamespace, "namespace", "n", "default", "Specify the namespace of the pod")
+	traceCmd.Flags().StringVarP(&traceDest, "dest", "", "", "Specify the destination ip:port of the traffic")
+
+	datapathCmd.PersistentFlags().StringVarP(&datapathSelector, "selector", "l", "app.kubernetes.io/component=egressgateway-agent", "Specify the label selector of the agent pods")
+
 	rootCmd.AddCommand(vipCmd)
 	vipCmd.AddCommand(moveCmd)
+	rootCmd.AddCommand(traceCmd)
+	rootCmd.AddCommand(datapathCmd)
+	datapathCmd.AddCommand(datapathGetCmd)
+	datapathCmd.AddCommand(datapathDiffCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -0,0 +1,556 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+// clusterInfoName is the name of the EgressClusterInfo of the cluster
+const clusterInfoName = "default"
+
+var traceNamespace, traceDest string
+
+var traceCmd = &cobra.Command{
+	Use:   "trace <pod> --dest <ip:port>",
+	Short: "trace <pod> --dest <ip:port>",
+	Long:  "Explain the egress path of the pod towards the destination: the matched policy, the gateway node, the EIP and the tunnel, and the inconsistencies between the objects.",
+	Args:  cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		if traceDest == "" {
+			fmt.Println("Error: dest must be specified")
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := Trace(os.Stdout, traceNamespace, args[0], traceDest)
+		if err != nil {
+			cmd.PrintErr("Trace failed: ", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// traceInput are the objects the egress path of a pod is built from.
+type traceInput struct {
+	pod             *corev1.Pod
+	namespace       *corev1.Namespace
+	policies        []egressv1.EgressPolicy
+	clusterPolicies []egressv1.EgressClusterPolicy
+	slices          []egressv1.EgressEndpointSlice
+	clusterSlices   []egressv1.EgressClusterEndpointSlice
+	clusterInfo     *egressv1.EgressClusterInfo
+	gateways        []egressv1.EgressGateway
+	tunnels         []egressv1.EgressTunnel
+}
+
+// tracePolicy is an EgressPolicy or an EgressClusterPolicy that applies to the
+// pod.
+type tracePolicy struct {
+	policy   egressv1.Policy
+	gateway  string
+	priority uint64
+	dryRun   bool
+	// destMatch is set when the destination is in the destination subnets of
+	// the policy, or outside the cluster CIDRs when it has none
+	destMatch bool
+	status    egressv1.EgressPolicyStatus
+}
+
+func (p tracePolicy) String() string {
+	if p.policy.Namespace == "" {
+		return "EgressClusterPolicy " + p.policy.Name
+	}
+	return "EgressPolicy " + p.policy.Namespace + "/" + p.policy.Name
+}
+
+// traceHop is a gateway node of the policy with an EIP.
+type traceHop struct {
+	node        string
+	ipv4        string
+	ipv6        string
+	sourcePorts string
+	tunnel      *egressv1.EgressTunnel
+}
+
+type traceResult struct {
+	podIP    net.IP
+	dest     net.IP
+	port     string
+	podNode  string
+	policies []tracePolicy
+	chosen   *tracePolicy
+	hops     []traceHop
+	// local is set when the node of the pod is a gateway node of the policy,
+	// the traffic is SNATed there without the tunnel
+	local  bool
+	issues []string
+}
+
+func (r *traceResult) issuef(format string, args ...interface{}) {
+	r.issues = append(r.issues, fmt.Sprintf(format, args...))
+}
+
+func Trace(out io.Writer, namespace, podName, dest string) error {
+	ip, port, err := parseDest(dest)
+	if err != nil {
+		return err
+	}
+	if ns, name, ok := strings.Cut(podName, "/"); ok {
+		namespace, podName = ns, name
+	}
+
+	kubeConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	cli, err := client.New(kubeConfig, client.Options{Scheme: schema.GetScheme()})
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	in, err := loadTraceInput(ctx, cli, namespace, podName)
+	if err != nil {
+		return err
+	}
+	res, err := tracePod(in, ip, port)
+	if err != nil {
+		return err
+	}
+	res.print(out)
+	return nil
+}
+
+// parseDest parses ip:port, [ipv6]:port or a bare IP.
+func parseDest(dest string) (net.IP, string, error) {
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		host, port = strings.Trim(dest, "[]"), ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, "", fmt.Errorf("invalid destination %s, should be ip:port", dest)
+	}
+	return ip, port, nil
+}
+
+func loadTraceInput(ctx context.Context, cli client.Client, namespace, podName string) (*traceInput, error) {
+	in := &traceInput{pod: new(corev1.Pod), namespace: new(corev1.Namespace)}
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: namespace, Name: podName}, in.pod); err != nil {
+		return nil, fmt.Errorf("failed to get Pod: %w", err)
+	}
+	if err := cli.Get(ctx, types.NamespacedName{Name: namespace}, in.namespace); err != nil {
+		return nil, fmt.Errorf("failed to get Namespace: %w", err)
+	}
+
+	policies := new(egressv1.EgressPolicyList)
+	if err := cli.List(ctx, policies, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list EgressPolicy: %w", err)
+	}
+	in.policies = policies.Items
+	clusterPolicies := new(egressv1.EgressClusterPolicyList)
+	if err := cli.List(ctx, clusterPolicies); err != nil {
+		return nil, fmt.Errorf("failed to list EgressClusterPolicy: %w", err)
+	}
+	in.clusterPolicies = clusterPolicies.Items
+	slices := new(egressv1.EgressEndpointSliceList)
+	if err := cli.List(ctx, slices, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list EgressEndpointSlice: %w", err)
+	}
+	in.slices = slices.Items
+	clusterSlices := new(egressv1.EgressClusterEndpointSliceList)
+	if err := cli.List(ctx, clusterSlices); err != nil {
+		return nil, fmt.Errorf("failed to list EgressClusterEndpointSlice: %w", err)
+	}
+	in.clusterSlices = clusterSlices.Items
+
+	info := new(egressv1.EgressClusterInfo)
+	err := cli.Get(ctx, types.NamespacedName{Name: clusterInfoName}, info)
+	if err != nil && !apierr.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get EgressClusterInfo: %w", err)
+	}
+	if err == nil {
+		in.clusterInfo = info
+	}
+
+	gateways := new(egressv1.EgressGatewayList)
+	if err := cli.List(ctx, gateways); err != nil {
+		return nil, fmt.Errorf("failed to list EgressGateway: %w", err)
+	}
+	in.gateways = gateways.Items
+	tunnels := new(egressv1.EgressTunnelList)
+	if err := cli.List(ctx, tunnels); err != nil {
+		return nil, fmt.Errorf("failed to list EgressTunnel: %w", err)
+	}
+	in.tunnels = tunnels.Items
+	return in, nil
+}
+
+// tracePod resolves the policy of the pod towards the destination the way the
+// agents build their rules, from the EgressEndpointSlices, the destination
+// subnets and the cluster CIDRs of the EgressClusterInfo. With several matching
+// policies, the one with the highest priority is chosen.
+func tracePod(in *traceInput, dest net.IP, port string) (*traceResult, error) {
+	res := &traceResult{dest: dest, port: port, podNode: in.pod.Spec.NodeName}
+	for _, item := range in.pod.Status.PodIPs {
+		ip := net.ParseIP(item.IP)
+		if ip != nil && (ip.To4() != nil) == (dest.To4() != nil) {
+			res.podIP = ip
+			break
+		}
+	}
+	if res.podIP == nil {
+		return nil, fmt.Errorf("pod %s/%s has no IP of the family of %s", in.pod.Namespace, in.pod.Name, dest)
+	}
+
+	inSlices := slicePolicies(in, res.podIP.String())
+	excluded := clusterCIDRs(in.clusterInfo)
+	add := func(policy egressv1.Policy, spec policySpec, status egressv1.EgressPolicyStatus, selected bool, err error) {
+		_, inSlice := inSlices[policy]
+		p := tracePolicy{policy: policy, gateway: spec.gateway, priority: spec.priority,
+			dryRun: spec.mode == egressv1.PolicyModeDryRun, status: status}
+		switch {
+		case err != nil:
+			res.issuef("%s has an invalid selector: %v", p, err)
+		case selected && !inSlice:
+			res.issuef("%s selects the pod but its EgressEndpointSlices do not have the pod IP %s", p, res.podIP)
+		case !selected && inSlice:
+			res.issuef("%s does not select the pod but its EgressEndpointSlices have the pod IP %s", p, res.podIP)
+		}
+		if !inSlice {
+			return
+		}
+		p.destMatch = destMatches(spec.destSubnet, excluded, dest)
+		res.policies = append(res.policies, p)
+	}
+	podLabels := labels.Set(in.pod.Labels)
+	for _, item := range in.policies {
+		selected, err := selectorMatches(item.Spec.AppliedTo.PodSelector, podLabels)
+		add(egressv1.Policy{Namespace: item.Namespace, Name: item.Name}, policySpec{
+			gateway: item.Spec.EgressGatewayName, priority: item.Spec.Priority,
+			mode: item.Spec.Mode, destSubnet: item.Spec.DestSubnet,
+		}, item.Status, selected, err)
+	}
+	for _, item := range in.clusterPolicies {
+		selected, err := selectorMatches(item.Spec.AppliedTo.PodSelector, podLabels)
+		if err == nil && selected && item.Spec.AppliedTo.NamespaceSelector != nil {
+			selected, err = selectorMatches(item.Spec.AppliedTo.NamespaceSelector, labels.Set(in.namespace.Labels))
+		}
+		add(egressv1.Policy{Name: item.Name}, policySpec{
+			gateway: item.Spec.EgressGatewayName, priority: item.Spec.Priority,
+			mode: item.Spec.Mode, destSubnet: item.Spec.DestSubnet,
+		}, item.Status, selected, err)
+	}
+
+	sort.SliceStable(res.policies, func(i, j int) bool {
+		a, b := res.policies[i], res.policies[j]
+		if a.destMatch != b.destMatch {
+			return a.destMatch
+		}
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.policy.Namespace != b.policy.Namespace {
+			return a.policy.Namespace > b.policy.Namespace
+		}
+		return a.policy.Name < b.policy.Name
+	})
+	matched := 0
+	for _, item := range res.policies {
+		if item.destMatch {
+			matched++
+		}
+	}
+	if matched == 0 {
+		return res, nil
+	}
+	res.chosen = &res.policies[0]
+	if matched > 1 {
+		second := res.policies[1]
+		if second.priority == res.chosen.priority {
+			res.issuef("%s and %s match with the same priority %d, the agents apply them in no fixed order",
+				res.chosen, second, second.priority)
+		} else {
+			res.issuef("%d policies match the pod and the destination, the agents do not order them by priority", matched)
+		}
+	}
+	res.traceGateway(in)
+	return res, nil
+}
+
+type policySpec struct {
+	gateway    string
+	priority   uint64
+	mode       string
+	destSubnet []string
+}
+
+// slicePolicies returns the policies whose endpoint slices have the IP.
+func slicePolicies(in *traceInput, ip string) map[egressv1.Policy]struct{} {
+	res := make(map[egressv1.Policy]struct{})
+	has := func(obj metav1.Object, endpoints []egressv1.EgressEndpoint) {
+		name, ok := obj.GetLabels()[egressv1.LabelPolicyName]
+		if !ok || obj.GetDeletionTimestamp() != nil {
+			return
+		}
+		for _, ep := range endpoints {
+			for _, item := range append(append([]string{}, ep.IPv4...), ep.IPv6...) {
+				if item == ip {
+					res[egressv1.Policy{Namespace: obj.GetNamespace(), Name: name}] = struct{}{}
+				}
+			}
+		}
+	}
+	for i := range in.slices {
+		has(&in.slices[i], in.slices[i].Endpoints)
+	}
+	for i := range in.clusterSlices {
+		has(&in.clusterSlices[i], in.clusterSlices[i].Endpoints)
+	}
+	return res
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set labels.Set) (bool, error) {
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	return sel.Matches(set), nil
+}
+
+// clusterCIDRs returns the CIDRs of the EgressClusterInfo, the policies without
+// destination subnets exclude them.
+func clusterCIDRs(info *egressv1.EgressClusterInfo) []*net.IPNet {
+	if info == nil {
+		return nil
+	}
+	items := make([]string, 0)
+	for _, pair := range info.Status.NodeIP {
+		items = append(append(items, pair.IPv4...), pair.IPv6...)
+	}
+	for _, pair := range info.Status.PodCIDR {
+		items = append(append(items, pair.IPv4...), pair.IPv6...)
+	}
+	if info.Status.ClusterIP != nil {
+		items = append(append(items, info.Status.ClusterIP.IPv4...), info.Status.ClusterIP.IPv6...)
+	}
+	items = append(items, info.Status.ExtraCidr...)
+	return parseCIDRs(items)
+}
+
+func parseCIDRs(items []string) []*net.IPNet {
+	res := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			res = append(res, cidr)
+		}
+	}
+	return res
+}
+
+func containsIP(cidrs []*net.IPNet, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func destMatches(destSubnet []string, excluded []*net.IPNet, dest net.IP) bool {
+	if len(destSubnet) == 0 {
+		return !containsIP(excluded, dest)
+	}
+	return containsIP(parseCIDRs(destSubnet), dest)
+}
+
+// traceGateway finds the gateway nodes of the chosen policy with their EIPs
+// and tunnels, and checks them against the status of the policy.
+func (r *traceResult) traceGateway(in *traceInput) {
+	policy := r.chosen
+	var gateway *egressv1.EgressGateway
+	for i := range in.gateways {
+		if in.gateways[i].Name == policy.gateway {
+			gateway = &in.gateways[i]
+		}
+	}
+	if gateway == nil {
+		r.issuef("EgressGateway %s of %s is not found", policy.gateway, policy)
+		return
+	}
+
+	tunnels := make(map[string]*egressv1.EgressTunnel, len(in.tunnels))
+	for i := range in.tunnels {
+		tunnels[in.tunnels[i].Name] = &in.tunnels[i]
+	}
+	for _, node := range gateway.Status.NodeList {
+		for _, eip := range node.Eips {
+			for _, item := range eip.Policies {
+				if item != policy.policy {
+					continue
+				}
+				r.hops = append(r.hops, traceHop{
+					node:        node.Name,
+					ipv4:        eip.IPv4,
+					ipv6:        eip.IPv6,
+					sourcePorts: eip.GetSourcePorts(item.Namespace, item.Name),
+					tunnel:      tunnels[node.Name],
+				})
+				if node.Status != string(egressv1.EgressTunnelReady) {
+					r.issuef("gateway node %s of EgressGateway %s is %s", node.Name, gateway.Name, node.Status)
+				}
+			}
+		}
+	}
+	if len(r.hops) == 0 {
+		r.issuef("EgressGateway %s has no EIP of %s in its status", gateway.Name, policy)
+		return
+	}
+
+	first := r.hops[0]
+	if policy.status.Node != first.node {
+		r.issuef("%s status has node %q, but EgressGateway %s assigns it to %s", policy, policy.status.Node, gateway.Name, first.node)
+	}
+	if policy.status.Eip.Ipv4 != first.ipv4 || policy.status.Eip.Ipv6 != first.ipv6 {
+		r.issuef("%s status has EIP %s, but EgressGateway %s assigns it %s",
+			policy, formatPair(policy.status.Eip.Ipv4, policy.status.Eip.Ipv6), gateway.Name, formatPair(first.ipv4, first.ipv6))
+	}
+	useNodeIP := first.ipv4 == "" && first.ipv6 == ""
+	if !useNodeIP && ((r.dest.To4() != nil && first.ipv4 == "") || (r.dest.To4() == nil && first.ipv6 == "")) {
+		r.issuef("the EIP of %s has no address of the family of %s", policy, r.dest)
+	}
+
+	for _, hop := range r.hops {
+		if hop.node == r.podNode {
+			r.local = true
+		}
+		if hop.tunnel == nil {
+			r.issuef("EgressTunnel of gateway node %s is not found", hop.node)
+			continue
+		}
+		if hop.tunnel.Status.Phase != egressv1.EgressTunnelReady {
+			r.issuef("EgressTunnel %s is %s", hop.node, hop.tunnel.Status.Phase)
+		}
+		if hop.tunnel.Status.Mark == "" {
+			r.issuef("EgressTunnel %s has no mark, the traffic is not sent to it", hop.node)
+		}
+		if hop.tunnel.Status.Tunnel.MAC == "" {
+			r.issuef("EgressTunnel %s has no MAC, the replies are not routed back", hop.node)
+		}
+	}
+	if !r.local {
+		if _, ok := tunnels[r.podNode]; !ok && r.podNode != "" {
+			r.issuef("EgressTunnel of the pod node %s is not found", r.podNode)
+		}
+	}
+}
+
+func formatPair(ipv4, ipv6 string) string {
+	items := make([]string, 0, 2)
+	for _, item := range []string{ipv4, ipv6} {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return "<none>"
+	}
+	return strings.Join(items, ",")
+}
+
+func (r *traceResult) print(out io.Writer) {
+	dest := r.dest.String()
+	if r.port != "" {
+		dest = net.JoinHostPort(dest, r.port)
+	}
+	fmt.Fprintf(out, "Pod:          %s on node %s\n", r.podIP, r.podNode)
+	fmt.Fprintf(out, "Destination:  %s\n", dest)
+
+	fmt.Fprintln(out, "Policies:")
+	if len(r.policies) == 0 {
+		fmt.Fprintln(out, "  <none>")
+	}
+	for _, item := range r.policies {
+		state := "destination not matched"
+		if item.destMatch {
+			state = "matched"
+		}
+		if r.chosen != nil && item.policy == r.chosen.policy {
+			state = "chosen"
+		}
+		fmt.Fprintf(out, "  %s (priority %d): %s\n", item, item.priority, state)
+	}
+
+	switch {
+	case r.chosen == nil:
+		fmt.Fprintln(out, "Result:       no policy matches, the traffic leaves through the node of the pod")
+	case r.chosen.dryRun:
+		fmt.Fprintln(out, "Result:       the policy is in dryRun mode, the traffic is counted but leaves through the node of the pod")
+	case r.local:
+		fmt.Fprintln(out, "Result:       the node of the pod is a gateway node of the policy, the traffic is SNATed there")
+	case len(r.hops) > 1:
+		fmt.Fprintln(out, "Result:       the traffic is spread over the gateway nodes by the hash of the flow")
+	case len(r.hops) == 1:
+		fmt.Fprintln(out, "Result:       the traffic is sent through the tunnel to the gateway node")
+	}
+
+	if len(r.hops) > 0 {
+		fmt.Fprintf(out, "Gateway:      %s\n", r.chosen.gateway)
+	}
+	for _, hop := range r.hops {
+		eip := formatPair(hop.ipv4, hop.ipv6)
+		if hop.ipv4 == "" && hop.ipv6 == "" {
+			eip = "node IP"
+		}
+		fmt.Fprintf(out, "  Node:       %s\n", hop.node)
+		fmt.Fprintf(out, "    EIP:      %s\n", eip)
+		if hop.sourcePorts != "" {
+			fmt.Fprintf(out, "    Ports:    %s\n", hop.sourcePorts)
+		}
+		if hop.tunnel != nil {
+			tunnel := hop.tunnel.Status.Tunnel
+			fmt.Fprintf(out, "    Tunnel:   %s MAC %s, phase %s\n", formatPair(tunnel.IPv4, tunnel.IPv6), tunnel.MAC, hop.tunnel.Status.Phase)
+			fmt.Fprintf(out, "    Mark:     %s\n", hop.tunnel.Status.Mark)
+		}
+	}
+
+	if len(r.issues) > 0 {
+		fmt.Fprintln(out, "Issues:")
+		for _, item := range r.issues {
+			fmt.Fprintf(out, "  - %s\n", item)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func traceTestInput() *traceInput {
+	policyLabels := func(name string) map[string]string {
+		return map[string]string{egressv1.LabelPolicyName: name}
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "mock"}}
+	return &traceInput{
+		pod: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mock-app", Labels: map[string]string{"app": "mock"}},
+			Spec:       corev1.PodSpec{NodeName: "node1"},
+			Status:     corev1.PodStatus{PodIPs: []corev1.PodIP{{IP: "10.21.0.10"}, {IP: "fd00:21::10"}}},
+		},
+		namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		policies: []egressv1.EgressPolicy{{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "p1"},
+			Spec: egressv1.EgressPolicySpec{
+				EgressGatewayName: "egw",
+				AppliedTo:         egressv1.AppliedTo{PodSelector: selector},
+			},
+			Status: egressv1.EgressPolicyStatus{Eip: egressv1.Eip{Ipv4: "10.6.1.100"}, Node: "node2"},
+		}},
+		slices: []egressv1.EgressEndpointSlice{{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "p1-s1", Labels: policyLabels("p1")},
+			Endpoints: []egressv1.EgressEndpoint{
+				{Namespace: "default", Pod: "mock-app", Node: "node1", IPv4: []string{"10.21.0.10"}, IPv6: []string{"fd00:21::10"}},
+			},
+		}},
+		clusterInfo: &egressv1.EgressClusterInfo{Status: egressv1.EgressClusterInfoStatus{
+			PodCIDR:   map[string]egressv1.IPListPair{"default": {IPv4: []string{"10.21.0.0/16"}}},
+			ClusterIP: &egressv1.IPListPair{IPv4: []string{"10.233.0.0/18"}},
+			NodeIP:    map[string]egressv1.IPListPair{"node1": {IPv4: []string{"172.18.0.2"}}},
+		}},
+		gateways: []egressv1.EgressGateway{{
+			ObjectMeta: metav1.ObjectMeta{Name: "egw"},
+			Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{{
+				Name:   "node2",
+				Status: string(egressv1.EgressTunnelReady),
+				Eips: []egressv1.Eips{{
+					IPv4:     "10.6.1.100",
+					Policies: []egressv1.Policy{{Namespace: "default", Name: "p1"}},
+				}},
+			}}},
+		}},
+		tunnels: []egressv1.EgressTunnel{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Status:     egressv1.EgressTunnelStatus{Phase: egressv1.EgressTunnelReady, Mark: "0x26000001"},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+				Status: egressv1.EgressTunnelStatus{
+					Phase:  egressv1.EgressTunnelReady,
+					Mark:   "0x26000002",
+					Tunnel: egressv1.Tunnel{IPv4: "192.200.0.2", MAC: "66:5e:0c:1a:2b:3c"},
+				},
+			},
+		},
+	}
+}
+
+func TestTracePod(t *testing.T) {
+	cases := map[string]struct {
+		dest      string
+		modify    func(in *traceInput)
+		expChosen string
+		expHops   []string
+		expLocal  bool
+		expIssues []string
+		expErr    bool
+	}{
+		"through the tunnel": {
+			dest:      "1.1.1.1",
+			expChosen: "p1",
+			expHops:   []string{"node2"},
+		},
+		"cluster destination": {
+			dest: "10.233.0.1",
+		},
+		"higher priority": {
+			dest: "1.1.1.1",
+			modify: func(in *traceInput) {
+				in.clusterPolicies = []egressv1.EgressClusterPolicy{{
+					ObjectMeta: metav1.ObjectMeta{Name: "c1"},
+					Spec: egressv1.EgressClusterPolicySpec{
+						EgressGatewayName: "egw",
+						Priority:          10,
+						DestSubnet:        []string{"1.1.1.0/24"},
+						AppliedTo: egressv1.ClusterAppliedTo{
+							PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "mock"}},
+						},
+					},
+				}}
+				in.clusterSlices = []egressv1.EgressClusterEndpointSlice{{
+					ObjectMeta: metav1.ObjectMeta{Name: "c1-s1", Labels: map[string]string{egressv1.LabelPolicyName: "c1"}},
+					Endpoints:  []egressv1.EgressEndpoint{{IPv4: []string{"10.21.0.10"}}},
+				}}
+			},
+			expChosen: "c1",
+			expIssues: []string{
+				"2 policies match the pod and the destination, the agents do not order them by priority",
+				"EgressGateway egw has no EIP of EgressClusterPolicy c1 in its status",
+			},
+		},
+		"local gateway node with stale status": {
+			dest: "1.1.1.1:443",
+			modify: func(in *traceInput) {
+				in.pod.Spec.NodeName = "node2"
+				in.policies[0].Status.Node = "node3"
+			},
+			expChosen: "p1",
+			expHops:   []string{"node2"},
+			expLocal:  true,
+			expIssues: []string{
+				`EgressPolicy default/p1 status has node "node3", but EgressGateway egw assigns it to node2`,
+			},
+		},
+		"pod missing from the slices": {
+			dest: "1.1.1.1",
+			modify: func(in *traceInput) {
+				in.slices = nil
+			},
+			expIssues: []string{
+				"EgressPolicy default/p1 selects the pod but its EgressEndpointSlices do not have the pod IP 10.21.0.10",
+			},
+		},
+		"tunnel without mark": {
+			dest: "1.1.1.1",
+			modify: func(in *traceInput) {
+				in.tunnels[1].Status.Mark = ""
+				in.tunnels[1].Status.Phase = egressv1.EgressTunnelHeartbeatTimeout
+			},
+			expChosen: "p1",
+			expHops:   []string{"node2"},
+			expIssues: []string{
+				"EgressTunnel node2 is HeartbeatTimeout",
+				"EgressTunnel node2 has no mark, the traffic is not sent to it",
+			},
+		},
+		"ipv6 destination without ipv6 EIP": {
+			dest:      "[2001:db8::1]:443",
+			expChosen: "p1",
+			expHops:   []string{"node2"},
+			expIssues: []string{
+				"the EIP of EgressPolicy default/p1 has no address of the family of 2001:db8::1",
+			},
+		},
+		"pod without ip of the family": {
+			dest: "1.1.1.1",
+			modify: func(in *traceInput) {
+				in.pod.Status.PodIPs = []corev1.PodIP{{IP: "fd00:21::10"}}
+			},
+			expErr: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in := traceTestInput()
+			if tc.modify != nil {
+				tc.modify(in)
+			}
+			ip, port, err := parseDest(tc.dest)
+			assert.NoError(t, err)
+			res, err := tracePod(in, ip, port)
+			if tc.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if tc.expChosen == "" {
+				assert.Nil(t, res.chosen)
+			} else {
+				assert.NotNil(t, res.chosen)
+				assert.Equal(t, tc.expChosen, res.chosen.policy.Name)
+			}
+			hops := make([]string, 0)
+			for _, hop := range res.hops {
+				hops = append(hops, hop.node)
+			}
+			assert.Equal(t, len(tc.expHops), len(hops))
+			if len(tc.expHops) > 0 {
+				assert.Equal(t, tc.expHops, hops)
+			}
+			assert.Equal(t, tc.expLocal, res.local)
+			assert.Equal(t, tc.expIssues, res.issues)
+
+			// the result prints without the chosen policy or gateway
+			buf := new(bytes.Buffer)
+			res.print(buf)
+			assert.Contains(t, buf.String(), "Destination:")
+		})
+	}
+}
+
+func TestParseDest(t *testing.T) {
+	ip, port, err := parseDest("1.1.1.1:443")
+	assert.NoError(t, err)
+	assert.Equal(t, net.ParseIP("1.1.1.1"), ip)
+	assert.Equal(t, "443", port)
+
+	ip, port, err = parseDest("2001:db8::1")
+	assert.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8::1"), ip)
+	assert.Equal(t, "", port)
+
+	_, _, err = parseDest("example.com:443")
+	assert.Error(t, err)
+}
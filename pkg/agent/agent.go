@@ -14,8 +14,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/cloudeip"
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
 	"github.com/spidernet-io/egressgateway/pkg/agent/metrics"
+	"github.com/spidernet-io/egressgateway/pkg/agent/snatmon"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/eiplease"
 	"github.com/spidernet-io/egressgateway/pkg/logger"
 	"github.com/spidernet-io/egressgateway/pkg/profiling"
 	"github.com/spidernet-io/egressgateway/pkg/schema"
@@ -73,21 +77,57 @@ func New(cfg *config.Config) (types.Service, error) {
 
 	metrics.RegisterMetricCollectors()
 
-	err = newEgressTunnelController(mgr, cfg, log)
+	datapathServer := datapath.NewServer(cfg.DebugBindAddress, cfg.NodeName, log)
+	err = mgr.Add(datapathServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add datapath debug server: %w", err)
+	}
+
+	err = newEgressTunnelController(mgr, cfg, log, datapathServer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create node controller: %w", err)
 	}
 
-	err = newPolicyController(mgr, log, cfg)
+	var fence *eiplease.Fence
+	if cfg.FileConfig.EIPLease.Enable {
+		fence = eiplease.NewFence(mgr.GetClient(), mgr.GetAPIReader(), log, cfg)
+		// subscribed by the controllers below before it starts
+		if err := mgr.Add(fence); err != nil {
+			return nil, fmt.Errorf("failed to add EIP lease fence: %w", err)
+		}
+	}
+
+	err = newPolicyController(mgr, log, cfg, fence, datapathServer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress gateway policy controller: %w", err)
 	}
 
-	err = newEipCtrl(mgr, log, cfg)
+	if verifier := cfg.FileConfig.DatapathVerifier; verifier.Enable {
+		interval := time.Duration(verifier.IntervalSecond) * time.Second
+		if err := mgr.Add(datapath.NewVerifier(datapathServer, interval, verifier.Repair, log)); err != nil {
+			return nil, fmt.Errorf("failed to add datapath verifier: %w", err)
+		}
+	}
+
+	err = newEipCtrl(mgr, log, cfg, fence)
 	if err != nil {
 		return nil, fmt.Errorf("failed to eip controller: %w", err)
 	}
 
+	if cfg.FileConfig.CloudEIP.Provider != "" {
+		err = cloudeip.NewController(mgr, log, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cloud eip controller: %w", err)
+		}
+	}
+
+	if cfg.FileConfig.SNATMonitor.Enable {
+		monitor := snatmon.New(mgr.GetClient(), mgr.GetEventRecorderFor("egressgateway-agent"), log, cfg)
+		if err := mgr.Add(monitor); err != nil {
+			return nil, fmt.Errorf("failed to add snat monitor: %w", err)
+		}
+	}
+
 	return &Agent{client: mgr.GetClient(), manager: mgr}, err
 }
 
@@ -0,0 +1,86 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/bandwidth"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// ensureBandwidth gives each policy of this node with a bandwidth limit a class,
+// in the order of the policies so that the classes are stable, and sets the
+// classes on the egress interfaces of the policies. It returns the class minor
+// of each policy for its CLASSIFY rules.
+func (r *policeReconciler) ensureBandwidth(snatPolicies map[egressv1.Policy]*PolicyCommon) map[egressv1.Policy]uint16 {
+	policies := make([]egressv1.Policy, 0)
+	for policy, val := range snatPolicies {
+		if !val.DryRun && val.Bandwidth != nil {
+			policies = append(policies, policy)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Namespace != policies[j].Namespace {
+			return policies[i].Namespace < policies[j].Namespace
+		}
+		return policies[i].Name < policies[j].Name
+	})
+
+	res := make(map[egressv1.Policy]uint16, len(policies))
+	classes := make(map[uint16]bandwidth.Class, len(policies))
+	ifaceSet := make(map[string]struct{})
+	var defaultIfaces []string
+	for i, policy := range policies {
+		val := snatPolicies[policy]
+		rate, burst, err := val.Bandwidth.Parse()
+		if err != nil {
+			r.log.Error(err, "invalid bandwidth of policy, skip it", "policy", policy)
+			continue
+		}
+		if val.Uplink != nil {
+			ifaceSet[val.Uplink.Interface] = struct{}{}
+		} else {
+			if defaultIfaces == nil {
+				defaultIfaces, err = bandwidth.DefaultRouteInterfaces()
+				if err != nil {
+					r.log.Error(err, "failed to get the interfaces of the default routes")
+				}
+			}
+			for _, item := range defaultIfaces {
+				ifaceSet[item] = struct{}{}
+			}
+		}
+		minor := uint16(i + 1)
+		res[policy] = minor
+		classes[minor] = bandwidth.Class{
+			Namespace: policy.Namespace,
+			Name:      policy.Name,
+			Rate:      rate,
+			Burst:     burst,
+		}
+	}
+
+	ifaces := make([]string, 0, len(ifaceSet))
+	for item := range ifaceSet {
+		ifaces = append(ifaces, item)
+	}
+	sort.Strings(ifaces)
+	if err := r.shaper.Ensure(ifaces, classes); err != nil {
+		r.log.Error(err, "failed to set the bandwidth classes", "interfaces", ifaces)
+	}
+	return res
+}
+
+// buildBandwidthRule puts the traffic of the policy in its class.
+func (r *policeReconciler) buildBandwidthRule(policyName string, minor uint16, version uint8, isIgnoreInternalCIDR bool) iptables.Rule {
+	rule := r.buildPolicyRule(policyName, 0, version, isIgnoreInternalCIDR)
+	rule.Action = iptables.ClassifyAction{Class: bandwidth.ClassID(minor)}
+	rule.Comment = []string{
+		fmt.Sprintf("Classify bandwidth for EgressPolicy %s", policyName),
+	}
+	return *rule
+}
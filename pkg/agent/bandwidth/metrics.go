@@ -0,0 +1,45 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bandwidth
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	gaugeLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "bandwidth",
+		Name:      "limit_bits_per_second",
+		Help:      "Bandwidth limit of the policy on the interface",
+	}, []string{"namespace", "policy", "interface"})
+
+	gaugeRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "bandwidth",
+		Name:      "rate_bits_per_second",
+		Help:      "Rate of the egress traffic of the policy on the interface over the last sample interval",
+	}, []string{"namespace", "policy", "interface"})
+
+	countSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "bandwidth",
+		Name:      "sent_bytes_total",
+		Help:      "Number of bytes of the policy sent on the interface",
+	}, []string{"namespace", "policy", "interface"})
+
+	countDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "bandwidth",
+		Name:      "dropped_packets_total",
+		Help:      "Number of packets of the policy dropped on the interface because of the limit",
+	}, []string{"namespace", "policy", "interface"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		gaugeLimit,
+		gaugeRate,
+		countSent,
+		countDropped,
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bandwidth limits the egress traffic of the policies on the gateway
+// node. Each policy with a limit gets an HTB class under the qdisc of the agent
+// on the egress interfaces, the CLASSIFY rules of the policy put its traffic in
+// the class, and the rates of the classes are reported as metrics. The
+// interfaces whose root qdisc was set by someone else are left alone.
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Major is the handle major of the qdisc of the agent, the class of a
+	// policy is Major:minor
+	Major = 0x26
+
+	sampleInterval = 10 * time.Second
+)
+
+// ClassID returns the class of the minor for the CLASSIFY target.
+func ClassID(minor uint16) string {
+	return fmt.Sprintf("%x:%x", Major, minor)
+}
+
+// Class is the limit of a policy.
+type Class struct {
+	Namespace string
+	Name      string
+	// Rate is in bits per second
+	Rate uint64
+	// Burst is in bytes, computed from the rate when 0
+	Burst uint32
+}
+
+type classKey struct {
+	iface string
+	minor uint16
+}
+
+// sample are the kernel counters of a class.
+type sample struct {
+	bytes uint64
+	drops uint32
+	time  time.Time
+}
+
+// Shaper keeps the classes of the policies on the egress interfaces.
+type Shaper struct {
+	log logr.Logger
+	now func() time.Time
+
+	mu      sync.Mutex
+	ifaces  []string
+	classes map[uint16]Class
+	// last are the counters of the classes at the last sample
+	last map[classKey]sample
+	// swept is set once the qdiscs of a previous run were removed from all
+	// the interfaces
+	swept bool
+}
+
+func New(log logr.Logger) *Shaper {
+	return &Shaper{
+		log:     log.WithName("bandwidth"),
+		now:     time.Now,
+		classes: make(map[uint16]Class),
+		last:    make(map[classKey]sample),
+	}
+}
+
+// Ensure sets the classes on the interfaces, and removes the qdisc of the agent
+// from the other interfaces, including the ones left by a previous run.
+func (s *Shaper) Ensure(ifaces []string, classes map[uint16]Class) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want := make(map[string]struct{}, len(ifaces))
+	for _, item := range ifaces {
+		want[item] = struct{}{}
+	}
+	links, err := s.links(ifaces)
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+
+	var errs []error
+	swept := true
+	for _, link := range links {
+		name := link.Attrs().Name
+		if _, ok := want[name]; !ok || len(classes) == 0 {
+			if err := deleteQdisc(link); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete qdisc of %s: %w", name, err))
+				swept = false
+			}
+			continue
+		}
+		if err := ensureClasses(link, classes); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set classes of %s: %w", name, err))
+		}
+	}
+
+	for key := range s.last {
+		_, ok := want[key.iface]
+		old, cur := s.classes[key.minor], classes[key.minor]
+		if !ok || old.Namespace != cur.Namespace || old.Name != cur.Name {
+			s.forget(key)
+		}
+	}
+	s.ifaces = ifaces
+	s.classes = classes
+	if swept {
+		s.swept = true
+	}
+	return errors.Join(errs...)
+}
+
+// links returns all the links until they were swept, then the links of the
+// current and the new interfaces.
+func (s *Shaper) links(ifaces []string) ([]netlink.Link, error) {
+	if !s.swept {
+		return netlink.LinkList()
+	}
+	res := make([]netlink.Link, 0)
+	seen := make(map[string]struct{})
+	for _, name := range append(append([]string{}, ifaces...), s.ifaces...) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			var notFound netlink.LinkNotFoundError
+			if errors.As(err, &notFound) {
+				continue
+			}
+			return nil, err
+		}
+		res = append(res, link)
+	}
+	return res, nil
+}
+
+func (s *Shaper) forget(key classKey) {
+	class := s.classes[key.minor]
+	labels := []string{class.Namespace, class.Name, key.iface}
+	gaugeLimit.DeleteLabelValues(labels...)
+	gaugeRate.DeleteLabelValues(labels...)
+	countSent.DeleteLabelValues(labels...)
+	countDropped.DeleteLabelValues(labels...)
+	delete(s.last, key)
+}
+
+func deleteQdisc(link netlink.Link) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return err
+	}
+	for _, qdisc := range qdiscs {
+		if isOwnQdisc(qdisc) {
+			return netlink.QdiscDel(qdisc)
+		}
+	}
+	return nil
+}
+
+func isOwnQdisc(qdisc netlink.Qdisc) bool {
+	attrs := qdisc.Attrs()
+	return qdisc.Type() == "htb" && attrs.Parent == netlink.HANDLE_ROOT &&
+		attrs.Handle == netlink.MakeHandle(Major, 0)
+}
+
+// errForeignQdisc is returned for the interfaces whose root qdisc was set by
+// someone else, the agent leaves them alone.
+var errForeignQdisc = errors.New("the root qdisc is not the default of the kernel, the bandwidth is not limited")
+
+// rootQdisc reports whether the root qdisc of the interface is the one of the
+// agent. The default root qdisc of the kernel, with handle 0:, may be
+// replaced, the kernel puts it back when the qdisc of the agent is deleted.
+// Any other root qdisc was set by someone else and is not replaced.
+func rootQdisc(qdiscs []netlink.Qdisc) (bool, error) {
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if attrs.Parent != netlink.HANDLE_ROOT {
+			continue
+		}
+		if isOwnQdisc(qdisc) {
+			return true, nil
+		}
+		if attrs.Handle != netlink.MakeHandle(0, 0) {
+			major, minor := netlink.MajorMinor(attrs.Handle)
+			return false, fmt.Errorf("%w: %s %x:%x", errForeignQdisc, qdisc.Type(), major, minor)
+		}
+	}
+	return false, nil
+}
+
+func ensureClasses(link netlink.Link, classes map[uint16]Class) error {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return err
+	}
+	found, err := rootQdisc(qdiscs)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the unclassified traffic is sent directly without limit
+		qdisc := netlink.NewHtb(netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(Major, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		})
+		if err := netlink.QdiscReplace(qdisc); err != nil {
+			return err
+		}
+	}
+
+	list, err := netlink.ClassList(link, netlink.MakeHandle(Major, 0))
+	if err != nil {
+		return err
+	}
+	existing := make(map[uint16]*netlink.HtbClass, len(list))
+	for _, item := range list {
+		htb, ok := item.(*netlink.HtbClass)
+		if !ok {
+			continue
+		}
+		major, minor := netlink.MajorMinor(item.Attrs().Handle)
+		if major != Major {
+			continue
+		}
+		if _, ok := classes[minor]; !ok {
+			if err := netlink.ClassDel(item); err != nil {
+				return err
+			}
+			continue
+		}
+		existing[minor] = htb
+	}
+
+	for minor, class := range classes {
+		item := netlink.NewHtbClass(netlink.ClassAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.MakeHandle(Major, 0),
+			Handle:    netlink.MakeHandle(Major, minor),
+		}, netlink.HtbClassAttrs{
+			Rate:    class.Rate,
+			Buffer:  class.Burst,
+			Cbuffer: class.Burst,
+		})
+		if cur, ok := existing[minor]; ok && cur.Rate == item.Rate && cur.Ceil == item.Ceil &&
+			cur.Buffer == item.Buffer && cur.Cbuffer == item.Cbuffer {
+			continue
+		}
+		if err := netlink.ClassReplace(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Shaper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		s.sample()
+	}
+}
+
+func (s *Shaper) sample() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.classes) == 0 {
+		return
+	}
+
+	now := s.now()
+	for _, name := range s.ifaces {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			s.log.V(1).Info("failed to get link", "interface", name, "err", err)
+			continue
+		}
+		list, err := netlink.ClassList(link, netlink.MakeHandle(Major, 0))
+		if err != nil {
+			s.log.Error(err, "failed to list classes", "interface", name)
+			continue
+		}
+		for _, item := range list {
+			major, minor := netlink.MajorMinor(item.Attrs().Handle)
+			stats := item.Attrs().Statistics
+			if major != Major || stats == nil || stats.Basic == nil {
+				continue
+			}
+			cur := sample{bytes: stats.Basic.Bytes, time: now}
+			if stats.Queue != nil {
+				cur.drops = stats.Queue.Drops
+			}
+			s.record(name, minor, cur)
+		}
+	}
+}
+
+// record updates the metrics of the class with its counters.
+func (s *Shaper) record(iface string, minor uint16, cur sample) {
+	class, ok := s.classes[minor]
+	if !ok {
+		return
+	}
+	labels := []string{class.Namespace, class.Name, iface}
+	gaugeLimit.WithLabelValues(labels...).Set(float64(class.Rate))
+
+	key := classKey{iface: iface, minor: minor}
+	last, ok := s.last[key]
+	s.last[key] = cur
+	if !ok {
+		// the counters of a new class count from its creation
+		last = sample{time: cur.time}
+	}
+	sent, dropped, rate := delta(last, cur)
+	countSent.WithLabelValues(labels...).Add(float64(sent))
+	countDropped.WithLabelValues(labels...).Add(float64(dropped))
+	if ok {
+		gaugeRate.WithLabelValues(labels...).Set(rate)
+	}
+}
+
+// delta returns the bytes sent and packets dropped between the samples, and
+// the rate in bits per second. The counters restart from 0 when the class is
+// created again.
+func delta(last, cur sample) (uint64, uint32, float64) {
+	sent := cur.bytes - last.bytes
+	if cur.bytes < last.bytes {
+		sent = cur.bytes
+	}
+	dropped := cur.drops - last.drops
+	if cur.drops < last.drops {
+		dropped = cur.drops
+	}
+	rate := 0.0
+	if elapsed := cur.time.Sub(last.time).Seconds(); elapsed > 0 {
+		rate = float64(sent) * 8 / elapsed
+	}
+	return sent, dropped, rate
+}
+
+// DefaultRouteInterfaces returns the interfaces of the default routes of the
+// main routing table, the traffic without an uplink leaves through them.
+func DefaultRouteInterfaces() ([]string, error) {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL,
+		&netlink.Route{Table: unix.RT_TABLE_MAIN}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]struct{})
+	res := make([]string, 0)
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		indexes := []int{route.LinkIndex}
+		for _, path := range route.MultiPath {
+			indexes = append(indexes, path.LinkIndex)
+		}
+		for _, index := range indexes {
+			if _, ok := seen[index]; ok || index == 0 {
+				continue
+			}
+			seen[index] = struct{}{}
+			link, err := netlink.LinkByIndex(index)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, link.Attrs().Name)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bandwidth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestClassID(t *testing.T) {
+	assert.Equal(t, "26:1", ClassID(1))
+	assert.Equal(t, "26:1f", ClassID(31))
+}
+
+func TestDelta(t *testing.T) {
+	start := time.Unix(1000, 0)
+	cases := map[string]struct {
+		last, cur  sample
+		expSent    uint64
+		expDropped uint32
+		expRate    float64
+	}{
+		"counters grow": {
+			last:       sample{bytes: 1000, drops: 1, time: start},
+			cur:        sample{bytes: 11000, drops: 3, time: start.Add(10 * time.Second)},
+			expSent:    10000,
+			expDropped: 2,
+			expRate:    8000,
+		},
+		"class created again": {
+			last:    sample{bytes: 50000, drops: 5, time: start},
+			cur:     sample{bytes: 2000, time: start.Add(2 * time.Second)},
+			expSent: 2000,
+			expRate: 8000,
+		},
+		"same time": {
+			last:    sample{bytes: 1000, time: start},
+			cur:     sample{bytes: 2000, time: start},
+			expSent: 1000,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sent, dropped, rate := delta(tc.last, tc.cur)
+			assert.Equal(t, tc.expSent, sent)
+			assert.Equal(t, tc.expDropped, dropped)
+			assert.Equal(t, tc.expRate, rate)
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := New(logr.Discard())
+	s.classes = map[uint16]Class{1: {Namespace: "default", Name: "p1", Rate: 1000000}}
+	start := time.Unix(1000, 0)
+
+	s.record("eth0", 1, sample{bytes: 500, time: start})
+	s.record("eth0", 1, sample{bytes: 1500, time: start.Add(time.Second)})
+	assert.Equal(t, sample{bytes: 1500, time: start.Add(time.Second)}, s.last[classKey{iface: "eth0", minor: 1}])
+
+	// the counters of an unknown class are ignored
+	s.record("eth0", 2, sample{bytes: 500, time: start})
+	assert.Len(t, s.last, 1)
+
+	s.forget(classKey{iface: "eth0", minor: 1})
+	assert.Empty(t, s.last)
+}
+
+func TestRootQdisc(t *testing.T) {
+	root := func(qdiscType string, handle uint32) netlink.Qdisc {
+		return &netlink.GenericQdisc{
+			QdiscAttrs: netlink.QdiscAttrs{Parent: netlink.HANDLE_ROOT, Handle: handle},
+			QdiscType:  qdiscType,
+		}
+	}
+	ingress := &netlink.Ingress{QdiscAttrs: netlink.QdiscAttrs{
+		Parent: netlink.HANDLE_INGRESS, Handle: netlink.MakeHandle(0xffff, 0),
+	}}
+
+	cases := map[string]struct {
+		qdiscs  []netlink.Qdisc
+		own     bool
+		wantErr bool
+	}{
+		"no qdisc":          {},
+		"kernel default mq": {qdiscs: []netlink.Qdisc{root("mq", 0), ingress}},
+		"own": {qdiscs: []netlink.Qdisc{
+			netlink.NewHtb(netlink.QdiscAttrs{Parent: netlink.HANDLE_ROOT, Handle: netlink.MakeHandle(Major, 0)}),
+		}, own: true},
+		"configured fq":      {qdiscs: []netlink.Qdisc{root("fq", netlink.MakeHandle(0x8001, 0))}, wantErr: true},
+		"configured htb":     {qdiscs: []netlink.Qdisc{root("htb", netlink.MakeHandle(1, 0))}, wantErr: true},
+		"ingress not a root": {qdiscs: []netlink.Qdisc{ingress}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			own, err := rootQdisc(tc.qdiscs)
+			assert.Equal(t, tc.own, own)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, errForeignQdisc)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
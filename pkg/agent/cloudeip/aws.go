@@ -0,0 +1,303 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	awsMetadataEndpoint = "http://169.254.169.254"
+	awsEC2APIVersion    = "2016-11-15"
+)
+
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// AWS attaches the EIPs as secondary private IPs of an ENI, IPv4 addresses
+// are reassigned from other ENIs, IPv6 addresses must be released first.
+type AWS struct {
+	opts Options
+
+	mutex         sync.Mutex
+	metadataToken cachedToken
+	credentials   awsCredentials
+	mac           string
+	eni           string
+	region        string
+}
+
+// NewAWS returns the EIPProvider of AWS, it uses IMDSv2 and the instance role.
+func NewAWS(opts Options) *AWS {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &AWS{opts: opts}
+}
+
+func (p *AWS) Name() string { return "aws" }
+
+func (p *AWS) Attach(ctx context.Context, ip net.IP) error {
+	params := url.Values{}
+	if ip.To4() != nil {
+		params.Set("Action", "AssignPrivateIpAddresses")
+		params.Set("PrivateIpAddress.1", ip.String())
+		params.Set("AllowReassignment", "true")
+	} else {
+		params.Set("Action", "AssignIpv6Addresses")
+		params.Set("Ipv6Addresses.1", ip.String())
+	}
+	return p.call(ctx, params)
+}
+
+func (p *AWS) Detach(ctx context.Context, ip net.IP) error {
+	params := url.Values{}
+	if ip.To4() != nil {
+		params.Set("Action", "UnassignPrivateIpAddresses")
+		params.Set("PrivateIpAddress.1", ip.String())
+	} else {
+		params.Set("Action", "UnassignIpv6Addresses")
+		params.Set("Ipv6Addresses.1", ip.String())
+	}
+	return p.call(ctx, params)
+}
+
+func (p *AWS) List(ctx context.Context) ([]net.IP, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err := p.resolve(ctx); err != nil {
+		return nil, err
+	}
+
+	res := make([]net.IP, 0)
+	ipv4s, err := p.metadata(ctx, "network/interfaces/macs/"+p.mac+"/local-ipv4s")
+	if err != nil {
+		return nil, err
+	}
+	// the first address is the primary IP of the ENI
+	for i, item := range strings.Fields(ipv4s) {
+		if ip := net.ParseIP(item); i > 0 && ip != nil {
+			res = append(res, ip)
+		}
+	}
+	ipv6s, err := p.metadata(ctx, "network/interfaces/macs/"+p.mac+"/ipv6s")
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	for _, item := range strings.Fields(ipv6s) {
+		if ip := net.ParseIP(item); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	return res, nil
+}
+
+func (p *AWS) call(ctx context.Context, params url.Values) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err := p.resolve(ctx); err != nil {
+		return err
+	}
+	creds, err := p.getCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	params.Set("Version", awsEC2APIVersion)
+	params.Set("NetworkInterfaceId", p.eni)
+	body := []byte(params.Encode())
+	endpoint := endpointOrDefault(p.opts.Endpoint, "https://ec2."+p.region+".amazonaws.com")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, body, creds, p.region, "ec2", time.Now())
+
+	_, err = doRequest(p.opts.Client, req)
+	if err != nil {
+		return fmt.Errorf("failed to %s on %s: %w", params.Get("Action"), p.eni, awsError(err))
+	}
+	return nil
+}
+
+// resolve looks up the ENI and the region of the instance once.
+func (p *AWS) resolve(ctx context.Context) error {
+	if p.eni != "" {
+		return nil
+	}
+	mac := p.opts.MAC.String()
+	if mac == "" {
+		res, err := p.metadata(ctx, "mac")
+		if err != nil {
+			return err
+		}
+		mac = strings.TrimSpace(res)
+	}
+	eni, err := p.metadata(ctx, "network/interfaces/macs/"+mac+"/interface-id")
+	if err != nil {
+		return err
+	}
+	region, err := p.metadata(ctx, "placement/region")
+	if err != nil {
+		return err
+	}
+	p.mac, p.eni, p.region = mac, strings.TrimSpace(eni), strings.TrimSpace(region)
+	return nil
+}
+
+// getCredentials returns the credentials of the environment or of the instance role.
+func (p *AWS) getCredentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if time.Now().Add(tokenExpiryDelta).Before(p.credentials.Expiration) {
+		return p.credentials, nil
+	}
+
+	roles, err := p.metadata(ctx, "iam/security-credentials/")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to get instance role: %w", err)
+	}
+	fields := strings.Fields(roles)
+	if len(fields) == 0 {
+		return awsCredentials{}, fmt.Errorf("no instance role attached")
+	}
+	res, err := p.metadata(ctx, "iam/security-credentials/"+fields[0])
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to get credentials of instance role %s: %w", fields[0], err)
+	}
+	creds := awsCredentials{}
+	if err := json.Unmarshal([]byte(res), &creds); err != nil {
+		return awsCredentials{}, err
+	}
+	p.credentials = creds
+	return creds, nil
+}
+
+func (p *AWS) metadata(ctx context.Context, path string) (string, error) {
+	endpoint := endpointOrDefault(p.opts.MetadataEndpoint, awsMetadataEndpoint)
+	if !p.metadataToken.valid() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		token, err := doRequest(p.opts.Client, req)
+		if err != nil {
+			return "", fmt.Errorf("failed to get IMDSv2 token: %w", err)
+		}
+		p.metadataToken = cachedToken{value: string(token), expire: time.Now().Add(6 * time.Hour)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", p.metadataToken.value)
+	res, err := doRequest(p.opts.Client, req)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+// awsError extracts the error code and message from the EC2 error response.
+func awsError(err error) error {
+	e, ok := err.(*httpError)
+	if !ok {
+		return err
+	}
+	res := struct {
+		Errors []struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"Errors>Error"`
+	}{}
+	if xml.Unmarshal([]byte(e.body), &res) != nil || len(res.Errors) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %s", res.Errors[0].Code, res.Errors[0].Message)
+}
+
+// signV4 signs req with AWS Signature Version 4, all the headers set on req are signed.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := strings.Builder{}
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, uri, query, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignV4(t *testing.T) {
+	// the example of the AWS Signature Version 4 documentation
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	signV4(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+func TestAWS(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	mac := "0a:00:00:00:00:01"
+	calls := make([]url.Values, 0)
+	metadata := map[string]string{
+		"/latest/meta-data/mac": mac,
+		"/latest/meta-data/network/interfaces/macs/" + mac + "/interface-id": "eni-0001",
+		"/latest/meta-data/network/interfaces/macs/" + mac + "/local-ipv4s":  "10.6.0.10\n10.6.1.21",
+		"/latest/meta-data/placement/region":                                 "us-east-1",
+		"/latest/meta-data/iam/security-credentials/":                        "egress-role",
+		"/latest/meta-data/iam/security-credentials/egress-role": `{"AccessKeyId":"AKID","SecretAccessKey":"secret",` +
+			`"Token":"session","Expiration":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("imds-token"))
+		case strings.HasPrefix(r.URL.Path, "/latest/meta-data/"):
+			res, ok := metadata[r.URL.Path]
+			if !ok || r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(res))
+		case r.Method == http.MethodPost && r.URL.Path == "/":
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+				r.Header.Get("X-Amz-Security-Token") != "session" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_ = r.ParseForm()
+			calls = append(calls, r.PostForm)
+			if r.PostForm.Get("Action") == "AssignIpv6Addresses" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`<Response><Errors><Error><Code>InvalidParameterValue</Code>` +
+					`<Message>subnet has no IPv6 CIDR</Message></Error></Errors></Response>`))
+			}
+		}
+	}))
+	defer server.Close()
+
+	p := NewAWS(Options{Endpoint: server.URL, MetadataEndpoint: server.URL})
+	ctx := context.Background()
+
+	ips, err := p.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.6.1.21")}, ips)
+
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.22")))
+	assert.NoError(t, p.Detach(ctx, net.ParseIP("10.6.1.21")))
+	err = p.Attach(ctx, net.ParseIP("fd00::21"))
+	assert.ErrorContains(t, err, "InvalidParameterValue: subnet has no IPv6 CIDR")
+
+	assert.Len(t, calls, 3)
+	assert.Equal(t, "AssignPrivateIpAddresses", calls[0].Get("Action"))
+	assert.Equal(t, "eni-0001", calls[0].Get("NetworkInterfaceId"))
+	assert.Equal(t, "10.6.1.22", calls[0].Get("PrivateIpAddress.1"))
+	assert.Equal(t, "true", calls[0].Get("AllowReassignment"))
+	assert.Equal(t, "UnassignPrivateIpAddresses", calls[1].Get("Action"))
+	assert.Equal(t, "10.6.1.21", calls[1].Get("PrivateIpAddress.1"))
+	assert.Equal(t, "fd00::21", calls[2].Get("Ipv6Addresses.1"))
+}
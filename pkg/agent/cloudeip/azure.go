@@ -0,0 +1,297 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	azureMetadataEndpoint  = "http://169.254.169.254"
+	azureEndpoint          = "https://management.azure.com"
+	azureComputeAPIVersion = "2023-03-01"
+	azureNetworkAPIVersion = "2023-05-01"
+)
+
+// Azure attaches the EIPs as secondary IP configurations of the NIC. An IP
+// can only be used by one NIC, it is attached once the previous node released it.
+type Azure struct {
+	opts Options
+
+	mutex sync.Mutex
+	token cachedToken
+	nicID string
+}
+
+// NewAzure returns the EIPProvider of Azure, it uses the managed identity of the VM.
+func NewAzure(opts Options) *Azure {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &Azure{opts: opts}
+}
+
+func (p *Azure) Name() string { return "azure" }
+
+func (p *Azure) Attach(ctx context.Context, ip net.IP) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	nic, err := p.getNIC(ctx)
+	if err != nil {
+		return err
+	}
+	configs := azureIPConfigs(nic)
+	var subnet interface{}
+	for _, item := range configs {
+		props := azureIPConfigProps(item)
+		if net.ParseIP(fmt.Sprint(props["privateIPAddress"])).Equal(ip) {
+			return nil
+		}
+		if props["primary"] == true {
+			subnet = props["subnet"]
+		}
+	}
+	if subnet == nil {
+		return fmt.Errorf("no primary IP configuration found on %s", p.nicID)
+	}
+
+	version := "IPv4"
+	if ip.To4() == nil {
+		version = "IPv6"
+	}
+	configs = append(configs, map[string]interface{}{
+		"name": "egress-" + strings.NewReplacer(".", "-", ":", "-").Replace(ip.String()),
+		"properties": map[string]interface{}{
+			"privateIPAddress":          ip.String(),
+			"privateIPAllocationMethod": "Static",
+			"privateIPAddressVersion":   version,
+			"subnet":                    subnet,
+		},
+	})
+	return p.putNIC(ctx, nic, configs)
+}
+
+func (p *Azure) Detach(ctx context.Context, ip net.IP) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	nic, err := p.getNIC(ctx)
+	if err != nil {
+		return err
+	}
+	configs := azureIPConfigs(nic)
+	keep := make([]interface{}, 0, len(configs))
+	for _, item := range configs {
+		props := azureIPConfigProps(item)
+		if props["primary"] != true && net.ParseIP(fmt.Sprint(props["privateIPAddress"])).Equal(ip) {
+			continue
+		}
+		keep = append(keep, item)
+	}
+	if len(keep) == len(configs) {
+		return nil
+	}
+	return p.putNIC(ctx, nic, keep)
+}
+
+func (p *Azure) List(ctx context.Context) ([]net.IP, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	nic, err := p.getNIC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]net.IP, 0)
+	for _, item := range azureIPConfigs(nic) {
+		props := azureIPConfigProps(item)
+		if props["primary"] == true {
+			continue
+		}
+		if ip := net.ParseIP(fmt.Sprint(props["privateIPAddress"])); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	return res, nil
+}
+
+func (p *Azure) getNIC(ctx context.Context) (map[string]interface{}, error) {
+	if err := p.resolve(ctx); err != nil {
+		return nil, err
+	}
+	nic := make(map[string]interface{})
+	if err := p.call(ctx, http.MethodGet, p.nicID, azureNetworkAPIVersion, nil, nil, &nic); err != nil {
+		return nil, err
+	}
+	return nic, nil
+}
+
+// putNIC updates the IP configurations of nic, the etag guards against
+// concurrent changes of the NIC.
+func (p *Azure) putNIC(ctx context.Context, nic map[string]interface{}, configs []interface{}) error {
+	props, _ := nic["properties"].(map[string]interface{})
+	props["ipConfigurations"] = configs
+	body, err := json.Marshal(nic)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if etag, ok := nic["etag"].(string); ok {
+		header.Set("If-Match", etag)
+	}
+	return p.call(ctx, http.MethodPut, p.nicID, azureNetworkAPIVersion, header, body, nil)
+}
+
+// resolve looks up the NIC resource of the VM once, the metadata service
+// only knows the MAC address of the NICs.
+func (p *Azure) resolve(ctx context.Context) error {
+	if p.nicID != "" {
+		return nil
+	}
+
+	instance := struct {
+		Compute struct {
+			SubscriptionID    string `json:"subscriptionId"`
+			ResourceGroupName string `json:"resourceGroupName"`
+			Name              string `json:"name"`
+		} `json:"compute"`
+		Network struct {
+			Interface []struct {
+				MacAddress string `json:"macAddress"`
+			} `json:"interface"`
+		} `json:"network"`
+	}{}
+	res, err := p.metadata(ctx, "/metadata/instance?api-version=2021-02-01")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(res, &instance); err != nil {
+		return err
+	}
+	mac := azureMAC(p.opts.MAC.String())
+	if mac == "" {
+		if len(instance.Network.Interface) == 0 {
+			return fmt.Errorf("no network interface found in instance metadata")
+		}
+		mac = azureMAC(instance.Network.Interface[0].MacAddress)
+	}
+
+	vm := struct {
+		Properties struct {
+			NetworkProfile struct {
+				NetworkInterfaces []struct {
+					ID string `json:"id"`
+				} `json:"networkInterfaces"`
+			} `json:"networkProfile"`
+		} `json:"properties"`
+	}{}
+	vmID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		instance.Compute.SubscriptionID, instance.Compute.ResourceGroupName, instance.Compute.Name)
+	if err := p.call(ctx, http.MethodGet, vmID, azureComputeAPIVersion, nil, nil, &vm); err != nil {
+		return err
+	}
+	for _, item := range vm.Properties.NetworkProfile.NetworkInterfaces {
+		nic := struct {
+			Properties struct {
+				MacAddress string `json:"macAddress"`
+			} `json:"properties"`
+		}{}
+		if err := p.call(ctx, http.MethodGet, item.ID, azureNetworkAPIVersion, nil, nil, &nic); err != nil {
+			return err
+		}
+		if azureMAC(nic.Properties.MacAddress) == mac {
+			p.nicID = item.ID
+			return nil
+		}
+	}
+	return fmt.Errorf("no network interface with MAC address %s found on %s", mac, vmID)
+}
+
+func (p *Azure) call(ctx context.Context, method, resource, apiVersion string, header http.Header, body []byte, out interface{}) error {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := endpointOrDefault(p.opts.Endpoint, azureEndpoint)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+resource+"?api-version="+apiVersion, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := doRequest(p.opts.Client, req)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res, out)
+}
+
+func (p *Azure) getToken(ctx context.Context) (string, error) {
+	if p.token.valid() {
+		return p.token.value, nil
+	}
+	res, err := p.metadata(ctx, "/metadata/identity/oauth2/token?api-version=2018-02-01&resource="+
+		url.QueryEscape(azureEndpoint+"/"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get managed identity token: %w", err)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}{}
+	if err := json.Unmarshal(res, &token); err != nil {
+		return "", err
+	}
+	expire, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid token expiry %q: %w", token.ExpiresOn, err)
+	}
+	p.token = cachedToken{value: token.AccessToken, expire: time.Unix(expire, 0)}
+	return p.token.value, nil
+}
+
+func (p *Azure) metadata(ctx context.Context, path string) ([]byte, error) {
+	endpoint := endpointOrDefault(p.opts.MetadataEndpoint, azureMetadataEndpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	return doRequest(p.opts.Client, req)
+}
+
+func azureIPConfigs(nic map[string]interface{}) []interface{} {
+	props, _ := nic["properties"].(map[string]interface{})
+	configs, _ := props["ipConfigurations"].([]interface{})
+	return configs
+}
+
+func azureIPConfigProps(config interface{}) map[string]interface{} {
+	item, _ := config.(map[string]interface{})
+	props, _ := item["properties"].(map[string]interface{})
+	return props
+}
+
+// azureMAC normalizes the MAC address formats of the metadata service and
+// of the API, which are 000D3A123456 and 00-0D-3A-12-34-56.
+func azureMAC(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzure(t *testing.T) {
+	vmID := "/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Compute/virtualMachines/node1"
+	nicIDs := []string{
+		"/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Network/networkInterfaces/node1-nic0",
+		"/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Network/networkInterfaces/node1-nic1",
+	}
+	nics := map[string]map[string]interface{}{}
+	for i, id := range nicIDs {
+		nics[id] = map[string]interface{}{
+			"id":   id,
+			"etag": "W/\"" + strconv.Itoa(i) + "\"",
+			"properties": map[string]interface{}{
+				"macAddress": "00-0D-3A-00-00-0" + strconv.Itoa(i),
+				"ipConfigurations": []interface{}{
+					map[string]interface{}{"name": "ipconfig1", "properties": map[string]interface{}{
+						"primary":          true,
+						"privateIPAddress": "10.6.0." + strconv.Itoa(10+i),
+						"subnet":           map[string]interface{}{"id": "subnet" + strconv.Itoa(i)},
+					}},
+				},
+			},
+		}
+	}
+	puts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/instance":
+			_, _ = w.Write([]byte(`{"compute":{"subscriptionId":"sub1","resourceGroupName":"rg1","name":"node1"},` +
+				`"network":{"interface":[{"macAddress":"000D3A000000"},{"macAddress":"000D3A000001"}]}}`))
+			return
+		case "/metadata/identity/oauth2/token":
+			_, _ = w.Write([]byte(`{"access_token":"token1","expires_on":"` +
+				strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == vmID {
+			_, _ = w.Write([]byte(`{"properties":{"networkProfile":{"networkInterfaces":[{"id":"` +
+				nicIDs[0] + `"},{"id":"` + nicIDs[1] + `"}]}}}`))
+			return
+		}
+		nic, ok := nics[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPut {
+			if r.Header.Get("If-Match") != nic["etag"] {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			nic = map[string]interface{}{}
+			_ = json.Unmarshal(body, &nic)
+			puts++
+			nic["etag"] = "W/\"put" + strconv.Itoa(puts) + "\""
+			nics[r.URL.Path] = nic
+		}
+		_ = json.NewEncoder(w).Encode(nic)
+	}))
+	defer server.Close()
+
+	mac, _ := net.ParseMAC("00:0d:3a:00:00:01")
+	p := NewAzure(Options{MAC: mac, Endpoint: server.URL, MetadataEndpoint: server.URL})
+	ctx := context.Background()
+
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.21")))
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.21")))
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.22")))
+	assert.Equal(t, 2, puts)
+
+	ips, err := p.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.6.1.21"), net.ParseIP("10.6.1.22")}, ips)
+
+	configs := azureIPConfigs(nics[nicIDs[1]])
+	props := azureIPConfigProps(configs[1])
+	assert.Equal(t, "egress-10-6-1-21", configs[1].(map[string]interface{})["name"])
+	assert.Equal(t, "Static", props["privateIPAllocationMethod"])
+	assert.Equal(t, map[string]interface{}{"id": "subnet1"}, props["subnet"])
+	assert.Len(t, azureIPConfigs(nics[nicIDs[0]]), 1)
+
+	assert.NoError(t, p.Detach(ctx, net.ParseIP("10.6.1.21")))
+	assert.NoError(t, p.Detach(ctx, net.ParseIP("10.6.0.11")))
+	ips, err = p.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.6.1.22")}, ips)
+	assert.Equal(t, 3, puts)
+}
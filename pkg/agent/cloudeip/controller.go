@@ -0,0 +1,134 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// syncRequest is the only request of the controller, every change of an
+// EgressGateway syncs all the EIPs of the node.
+var syncRequest = reconcile.Request{}
+
+type cloudEIP struct {
+	client     client.Client
+	log        logr.Logger
+	nodeName   string
+	provider   EIPProvider
+	syncPeriod time.Duration
+}
+
+func (r *cloudEIP) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if err := r.sync(ctx); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	return reconcile.Result{RequeueAfter: r.syncPeriod}, nil
+}
+
+// sync attaches the EIPs assigned to this node in EgressGatewayStatus.NodeList
+// and detaches the others. Only the IPs in the EgressGateway pools are
+// detached, the NIC can have secondary IPs of other users.
+func (r *cloudEIP) sync(ctx context.Context) error {
+	egwList := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, egwList); err != nil {
+		return err
+	}
+
+	pools := make([]string, 0)
+	desired := sets.New[string]()
+	for _, egw := range egwList.Items {
+		pools = append(pools, egw.Spec.Ippools.IPv4...)
+		pools = append(pools, egw.Spec.Ippools.IPv6...)
+		for _, eip := range egw.Status.GetNodeIPs(r.nodeName) {
+			for _, item := range []string{eip.IPv4, eip.IPv6} {
+				if addr := net.ParseIP(item); addr != nil {
+					desired.Insert(addr.String())
+				}
+			}
+		}
+	}
+
+	attached, err := r.provider.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list IPs attached by %s: %w", r.provider.Name(), err)
+	}
+	current := sets.New[string]()
+	for _, addr := range attached {
+		included, err := ip.CheckIPIncluded(addr.String(), pools)
+		if err != nil {
+			return err
+		}
+		if included {
+			current.Insert(addr.String())
+		}
+	}
+
+	errs := make([]error, 0)
+	for _, item := range sets.List(desired.Difference(current)) {
+		if err := r.provider.Attach(ctx, net.ParseIP(item)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to attach EIP %s: %w", item, err))
+			continue
+		}
+		r.log.Info("attached EIP", "provider", r.provider.Name(), "eip", item)
+	}
+	for _, item := range sets.List(current.Difference(desired)) {
+		if err := r.provider.Detach(ctx, net.ParseIP(item)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to detach EIP %s: %w", item, err))
+			continue
+		}
+		r.log.Info("detached EIP", "provider", r.provider.Name(), "eip", item)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// NewController returns the controller which attaches the EIPs of the node
+// with the cloud provider of the cloudEIP config.
+func NewController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	provider, err := NewProvider(cfg.FileConfig.CloudEIP)
+	if err != nil {
+		return err
+	}
+
+	r := &cloudEIP{
+		client:     mgr.GetClient(),
+		log:        log.WithName("cloudEIP"),
+		nodeName:   cfg.EnvConfig.NodeName,
+		provider:   provider,
+		syncPeriod: time.Duration(cfg.FileConfig.CloudEIP.SyncPeriodSecond) * time.Second,
+	}
+
+	c, err := controller.New("cloudEIP", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	sourceEgressGateway := utils.SourceKind(
+		mgr.GetCache(),
+		&egressv1.EgressGateway{},
+		handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+			return []reconcile.Request{syncRequest}
+		}),
+	)
+	if err := c.Watch(sourceEgressGateway); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestCloudEIPReconcile(t *testing.T) {
+	egw := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+		Spec: egressv1.EgressGatewaySpec{
+			Ippools: egressv1.Ippools{
+				IPv4: []string{"10.6.1.21-10.6.1.30"},
+				IPv6: []string{"fd00::21-fd00::30"},
+			},
+		},
+		Status: egressv1.EgressGatewayStatus{
+			NodeList: []egressv1.EgressIPStatus{
+				{Name: "node1", Eips: []egressv1.Eips{
+					{IPv4: "10.6.1.21", IPv6: "fd00::21"},
+					{IPv4: "10.6.1.22"},
+				}},
+				{Name: "node2", Eips: []egressv1.Eips{{IPv4: "10.6.1.23"}}},
+			},
+		},
+	}
+
+	cases := map[string]struct {
+		attached []string
+		err      error
+		want     []string
+		wantErr  bool
+	}{
+		"attach assigned EIPs": {
+			want: []string{"10.6.1.21", "10.6.1.22", "fd00::21"},
+		},
+		"detach moved EIPs": {
+			attached: []string{"10.6.1.21", "10.6.1.23", "10.6.1.24"},
+			want:     []string{"10.6.1.21", "10.6.1.22", "fd00::21"},
+		},
+		"keep IPs out of the pools": {
+			attached: []string{"10.6.0.5", "fd00::99"},
+			want:     []string{"10.6.0.5", "10.6.1.21", "10.6.1.22", "fd00::21", "fd00::99"},
+		},
+		"provider failed": {
+			attached: []string{"10.6.1.23"},
+			err:      errors.New("rate limited"),
+			want:     []string{"10.6.1.23"},
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			provider := NewFake(tc.attached...)
+			provider.Err = tc.err
+			r := &cloudEIP{
+				client:     fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(egw).Build(),
+				log:        logr.Discard(),
+				nodeName:   "node1",
+				provider:   provider,
+				syncPeriod: time.Minute,
+			}
+
+			res, err := r.Reconcile(context.Background(), reconcile.Request{})
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.True(t, res.Requeue)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, time.Minute, res.RequeueAfter)
+			}
+			provider.Err = nil
+			assert.Equal(t, tc.want, provider.Attached())
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Fake is an in-memory EIPProvider, used by tests and by clusters which only
+// want to try the cloud EIP mode.
+type Fake struct {
+	mutex sync.Mutex
+	ips   map[string]net.IP
+	// Err is returned by every call when it is set
+	Err error
+}
+
+// NewFake returns a Fake with ips attached.
+func NewFake(ips ...string) *Fake {
+	f := &Fake{ips: make(map[string]net.IP)}
+	for _, item := range ips {
+		ip := net.ParseIP(item)
+		f.ips[ip.String()] = ip
+	}
+	return f
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Attach(ctx context.Context, ip net.IP) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	f.ips[ip.String()] = ip
+	return nil
+}
+
+func (f *Fake) Detach(ctx context.Context, ip net.IP) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	delete(f.ips, ip.String())
+	return nil
+}
+
+func (f *Fake) List(ctx context.Context) ([]net.IP, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	res := make([]net.IP, 0, len(f.ips))
+	for _, ip := range f.ips {
+		res = append(res, ip)
+	}
+	return res, nil
+}
+
+// Attached returns the sorted attached IPs.
+func (f *Fake) Attached() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	res := make([]string, 0, len(f.ips))
+	for ip := range f.ips {
+		res = append(res, ip)
+	}
+	sort.Strings(res)
+	return res
+}
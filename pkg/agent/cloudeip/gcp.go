@@ -0,0 +1,250 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcpMetadataEndpoint = "http://metadata.google.internal"
+	gcpEndpoint         = "https://compute.googleapis.com"
+)
+
+type gcpAliasIPRange struct {
+	IPCidrRange         string `json:"ipCidrRange"`
+	SubnetworkRangeName string `json:"subnetworkRangeName,omitempty"`
+}
+
+type gcpNetworkInterface struct {
+	Name          string            `json:"name"`
+	Fingerprint   string            `json:"fingerprint"`
+	AliasIPRanges []gcpAliasIPRange `json:"aliasIpRanges"`
+}
+
+// GCP attaches the EIPs as /32 alias IP ranges of the network interface, an
+// alias IP can only be used by one instance, it is attached once the previous
+// node released it. IPv6 alias IPs are not supported by GCP.
+type GCP struct {
+	opts Options
+
+	mutex    sync.Mutex
+	token    cachedToken
+	instance string
+	nic      string
+}
+
+// NewGCP returns the EIPProvider of GCP, it uses the default service account of the instance.
+func NewGCP(opts Options) *GCP {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &GCP{opts: opts}
+}
+
+func (p *GCP) Name() string { return "gcp" }
+
+func (p *GCP) Attach(ctx context.Context, ip net.IP) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("IPv6 EIP %s is not supported by gcp", ip)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	nic, err := p.getNetworkInterface(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range nic.AliasIPRanges {
+		if item.IPCidrRange == ip.String()+"/32" {
+			return nil
+		}
+	}
+	ranges := append(nic.AliasIPRanges, gcpAliasIPRange{IPCidrRange: ip.String() + "/32"})
+	return p.updateNetworkInterface(ctx, nic, ranges)
+}
+
+func (p *GCP) Detach(ctx context.Context, ip net.IP) error {
+	if ip.To4() == nil {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	nic, err := p.getNetworkInterface(ctx)
+	if err != nil {
+		return err
+	}
+	ranges := make([]gcpAliasIPRange, 0, len(nic.AliasIPRanges))
+	for _, item := range nic.AliasIPRanges {
+		if item.IPCidrRange != ip.String()+"/32" {
+			ranges = append(ranges, item)
+		}
+	}
+	if len(ranges) == len(nic.AliasIPRanges) {
+		return nil
+	}
+	return p.updateNetworkInterface(ctx, nic, ranges)
+}
+
+func (p *GCP) List(ctx context.Context) ([]net.IP, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	nic, err := p.getNetworkInterface(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]net.IP, 0)
+	for _, item := range nic.AliasIPRanges {
+		// only single IP ranges can be EIPs, wider ranges are usually given to pods
+		if ip, ok := strings.CutSuffix(item.IPCidrRange, "/32"); ok {
+			res = append(res, net.ParseIP(ip))
+		}
+	}
+	return res, nil
+}
+
+func (p *GCP) getNetworkInterface(ctx context.Context) (gcpNetworkInterface, error) {
+	if err := p.resolve(ctx); err != nil {
+		return gcpNetworkInterface{}, err
+	}
+	instance := struct {
+		NetworkInterfaces []gcpNetworkInterface `json:"networkInterfaces"`
+	}{}
+	if err := p.call(ctx, http.MethodGet, p.instance, nil, &instance); err != nil {
+		return gcpNetworkInterface{}, err
+	}
+	for _, item := range instance.NetworkInterfaces {
+		if item.Name == p.nic {
+			return item, nil
+		}
+	}
+	return gcpNetworkInterface{}, fmt.Errorf("network interface %s not found on %s", p.nic, p.instance)
+}
+
+// updateNetworkInterface replaces the alias IP ranges, the fingerprint guards
+// against concurrent changes of the interface.
+func (p *GCP) updateNetworkInterface(ctx context.Context, nic gcpNetworkInterface, ranges []gcpAliasIPRange) error {
+	body, err := json.Marshal(struct {
+		AliasIPRanges []gcpAliasIPRange `json:"aliasIpRanges"`
+		Fingerprint   string            `json:"fingerprint"`
+	}{ranges, nic.Fingerprint})
+	if err != nil {
+		return err
+	}
+	path := p.instance + "/updateNetworkInterface?networkInterface=" + url.QueryEscape(p.nic)
+	return p.call(ctx, http.MethodPatch, path, body, nil)
+}
+
+// resolve looks up the instance and the name of the network interface once.
+func (p *GCP) resolve(ctx context.Context) error {
+	if p.instance != "" {
+		return nil
+	}
+	project, err := p.metadata(ctx, "project/project-id")
+	if err != nil {
+		return err
+	}
+	zone, err := p.metadata(ctx, "instance/zone")
+	if err != nil {
+		return err
+	}
+	name, err := p.metadata(ctx, "instance/name")
+	if err != nil {
+		return err
+	}
+
+	nic := "nic0"
+	if len(p.opts.MAC) > 0 {
+		indexes, err := p.metadata(ctx, "instance/network-interfaces/")
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, index := range strings.Fields(indexes) {
+			index = strings.TrimSuffix(index, "/")
+			mac, err := p.metadata(ctx, "instance/network-interfaces/"+index+"/mac")
+			if err != nil {
+				return err
+			}
+			if strings.EqualFold(mac, p.opts.MAC.String()) {
+				nic, found = "nic"+index, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no network interface with MAC address %s found in instance metadata", p.opts.MAC)
+		}
+	}
+
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+	p.instance = fmt.Sprintf("/compute/v1/projects/%s/zones/%s/instances/%s", project, zone, name)
+	p.nic = nic
+	return nil
+}
+
+func (p *GCP) call(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := endpointOrDefault(p.opts.Endpoint, gcpEndpoint)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := doRequest(p.opts.Client, req)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res, out)
+}
+
+func (p *GCP) getToken(ctx context.Context) (string, error) {
+	if p.token.valid() {
+		return p.token.value, nil
+	}
+	res, err := p.metadata(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", fmt.Errorf("failed to get service account token: %w", err)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal([]byte(res), &token); err != nil {
+		return "", err
+	}
+	p.token = cachedToken{value: token.AccessToken, expire: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)}
+	return p.token.value, nil
+}
+
+func (p *GCP) metadata(ctx context.Context, path string) (string, error) {
+	endpoint := endpointOrDefault(p.opts.MetadataEndpoint, gcpMetadataEndpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := doRequest(p.opts.Client, req)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(res)), nil
+}
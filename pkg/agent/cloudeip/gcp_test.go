@@ -0,0 +1,97 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudeip
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCP(t *testing.T) {
+	instancePath := "/compute/v1/projects/project1/zones/us-central1-a/instances/node1"
+	metadata := map[string]string{
+		"project/project-id":                      "project1",
+		"instance/zone":                           "projects/123/zones/us-central1-a",
+		"instance/name":                           "node1",
+		"instance/network-interfaces/":            "0/\n1/\n",
+		"instance/network-interfaces/0/mac":       "42:01:0a:00:00:01",
+		"instance/network-interfaces/1/mac":       "42:01:0a:00:00:02",
+		"instance/service-accounts/default/token": `{"access_token":"token1","expires_in":3599}`,
+	}
+	nics := []gcpNetworkInterface{
+		{Name: "nic0", Fingerprint: "fp0"},
+		{Name: "nic1", Fingerprint: "fp1", AliasIPRanges: []gcpAliasIPRange{
+			{IPCidrRange: "10.8.0.0/24", SubnetworkRangeName: "pods"},
+		}},
+	}
+	updates := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") == "Google" {
+			res, ok := metadata[r.URL.Path[len("/computeMetadata/v1/"):]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(res))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == instancePath:
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"networkInterfaces": nics})
+		case r.Method == http.MethodPatch && r.URL.Path == instancePath+"/updateNetworkInterface":
+			index := -1
+			for i := range nics {
+				if nics[i].Name == r.URL.Query().Get("networkInterface") {
+					index = i
+				}
+			}
+			update := gcpNetworkInterface{}
+			_ = json.NewDecoder(r.Body).Decode(&update)
+			if index < 0 || update.Fingerprint != nics[index].Fingerprint {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			updates++
+			nics[index].AliasIPRanges = update.AliasIPRanges
+			nics[index].Fingerprint = "fp-update" + strconv.Itoa(updates)
+			_, _ = w.Write([]byte(`{"kind":"compute#operation"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	mac, _ := net.ParseMAC("42:01:0a:00:00:02")
+	p := NewGCP(Options{MAC: mac, Endpoint: server.URL, MetadataEndpoint: server.URL})
+	ctx := context.Background()
+
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.21")))
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.21")))
+	assert.NoError(t, p.Attach(ctx, net.ParseIP("10.6.1.22")))
+	assert.Error(t, p.Attach(ctx, net.ParseIP("fd00::21")))
+	assert.Equal(t, 2, updates)
+	assert.Empty(t, nics[0].AliasIPRanges)
+
+	ips, err := p.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("10.6.1.21"), net.ParseIP("10.6.1.22")}, ips)
+
+	assert.NoError(t, p.Detach(ctx, net.ParseIP("10.6.1.21")))
+	assert.Equal(t, []gcpAliasIPRange{
+		{IPCidrRange: "10.8.0.0/24", SubnetworkRangeName: "pods"},
+		{IPCidrRange: "10.6.1.22/32"},
+	}, nics[1].AliasIPRanges)
+}
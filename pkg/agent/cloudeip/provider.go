@@ -0,0 +1,129 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cloudeip attaches the EIPs of the gateway node to the NIC of its
+// instance through the cloud API, public clouds drop gratuitous ARP so the
+// layer2 announcement alone does not move an EIP there.
+package cloudeip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+// EIPProvider attaches secondary IPs to the NIC of the local instance.
+type EIPProvider interface {
+	// Name returns the provider name
+	Name() string
+	// Attach attaches ip to the NIC as secondary IP, it is a no-op when ip is already attached
+	Attach(ctx context.Context, ip net.IP) error
+	// Detach detaches ip from the NIC, it is a no-op when ip is not attached
+	Detach(ctx context.Context, ip net.IP) error
+	// List returns the secondary IPs attached to the NIC
+	List(ctx context.Context) ([]net.IP, error)
+}
+
+// Options are shared by the cloud providers.
+type Options struct {
+	// MAC selects the NIC, the primary NIC is used when it is empty
+	MAC net.HardwareAddr
+	// Endpoint overrides the cloud API endpoint
+	Endpoint string
+	// MetadataEndpoint overrides the instance metadata endpoint
+	MetadataEndpoint string
+	Client           *http.Client
+}
+
+// NewProvider returns the EIPProvider configured by cfg.
+func NewProvider(cfg config.CloudEIP) (EIPProvider, error) {
+	opts := Options{
+		Endpoint:         cfg.Endpoint,
+		MetadataEndpoint: cfg.MetadataEndpoint,
+		Client:           &http.Client{Timeout: 30 * time.Second},
+	}
+	if cfg.Interface != "" {
+		ifi, err := net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cloudEIP interface %s: %w", cfg.Interface, err)
+		}
+		opts.MAC = ifi.HardwareAddr
+	}
+
+	switch cfg.Provider {
+	case "aws":
+		return NewAWS(opts), nil
+	case "azure":
+		return NewAzure(opts), nil
+	case "gcp":
+		return NewGCP(opts), nil
+	case "fake":
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unsupported cloudEIP provider %q", cfg.Provider)
+	}
+}
+
+// tokenExpiryDelta renews the cached tokens and credentials before they expire.
+const tokenExpiryDelta = 5 * time.Minute
+
+type cachedToken struct {
+	value  string
+	expire time.Time
+}
+
+func (t cachedToken) valid() bool {
+	return t.value != "" && time.Now().Add(tokenExpiryDelta).Before(t.expire)
+}
+
+type httpError struct {
+	method     string
+	url        string
+	statusCode int
+	body       string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%s %s: status code %d: %s", e.method, e.url, e.statusCode, e.body)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*httpError)
+	return ok && e.statusCode == http.StatusNotFound
+}
+
+// doRequest sends req and returns the body of a successful response.
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &httpError{
+			method:     req.Method,
+			url:        req.URL.Redacted(),
+			statusCode: resp.StatusCode,
+			body:       strings.TrimSpace(string(body)),
+		}
+	}
+	return body, nil
+}
+
+func endpointOrDefault(endpoint, def string) string {
+	if endpoint == "" {
+		return def
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
@@ -0,0 +1,238 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// setIPSetEntries records the entries the ipset should have, the host entries
+// are listed by ipset without their prefix length.
+func (r *policeReconciler) setIPSetEntries(name string, entries []string) {
+	res := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(entry, "/32")
+		entry = strings.TrimSuffix(entry, "/128")
+		res = append(res, entry)
+	}
+	r.ipsetEntries.Store(name, res)
+}
+
+// storeDesiredChains keeps the rules of the tables after they are applied, the
+// tables can not be read while the reconciler updates them.
+func (r *policeReconciler) storeDesiredChains() {
+	chains := make(map[string][]datapath.ChainRule)
+	for _, table := range r.allTables() {
+		for name, rules := range table.DesiredChains() {
+			chains[chainKey(table, name)] = chainRules(rules)
+		}
+	}
+	r.desiredChainsLock.Lock()
+	r.desiredChains = chains
+	r.desiredChainsLock.Unlock()
+}
+
+func (r *policeReconciler) DesiredState(state *datapath.State) {
+	r.ipsetEntries.Range(func(name string, entries []string) bool {
+		state.IPSets[name] = append([]string(nil), entries...)
+		return true
+	})
+	r.desiredChainsLock.Lock()
+	for name, rules := range r.desiredChains {
+		state.Chains[name] = append([]datapath.ChainRule(nil), rules...)
+	}
+	r.desiredChainsLock.Unlock()
+	r.ruleRoute.Desired(state)
+}
+
+func (r *policeReconciler) ProgrammedState(state *datapath.State) error {
+	errs := make([]string, 0)
+	sets, err := r.ipset.ListSets()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("list ipsets: %v", err))
+	}
+	for _, name := range sets {
+		if !strings.HasPrefix(name, "egress-") {
+			continue
+		}
+		entries, err := r.ipset.ListEntries(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("list entries of ipset %s: %v", name, err))
+			continue
+		}
+		state.IPSets[name] = entries
+	}
+
+	for _, table := range r.allTables() {
+		chains, err := table.DataplaneChains()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("read ipv%d %s table: %v", table.IPVersion, table.Name, err))
+			continue
+		}
+		for name, rules := range chains {
+			state.Chains[chainKey(table, name)] = chainRules(rules)
+		}
+	}
+
+	if err := r.ruleRoute.Programmed(state, r.cfg.FileConfig.UplinkMark); err != nil {
+		errs = append(errs, fmt.Sprintf("list uplink rules and routes: %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Repair queues the repair of the datapath, the tables can only be changed by
+// the reconciler. The drift of a repair still queued is replaced by the one
+// found again, it is counted by repairDatapath.
+func (r *policeReconciler) Repair(diff []datapath.Difference) error {
+	r.repairDiffLock.Lock()
+	r.repairDiff = diff
+	r.repairDiffLock.Unlock()
+	select {
+	case r.repair <- event.GenericEvent{Object: &egressv1.EgressClusterInfo{ObjectMeta: metav1.ObjectMeta{Name: "datapath"}}}:
+	default:
+		// a repair is already queued
+	}
+	return datapath.ErrRepairQueued
+}
+
+// repairDatapath programs the whole desired state again: the ipsets, the rules
+// of the tables read again from iptables, the uplink routes and the ipsets of
+// the cluster CIDRs. The drift of the queued repair is counted with its result.
+func (r *policeReconciler) repairDatapath(ctx context.Context, log logr.Logger) (res reconcile.Result, err error) {
+	log.Info("repairing the datapath")
+	r.repairDiffLock.Lock()
+	diff := r.repairDiff
+	r.repairDiff = nil
+	r.repairDiffLock.Unlock()
+	defer func() { datapath.CountRepair(diff, err) }()
+
+	// the sets destroyed by others are created again
+	sets, err := r.ipset.ListSets()
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list ipsets: %w", err)
+	}
+	existing := make(map[string]struct{}, len(sets))
+	for _, name := range sets {
+		existing[name] = struct{}{}
+	}
+	r.ipsetMap.Range(func(name string, _ *ipset.IPSet) bool {
+		if _, ok := existing[name]; !ok {
+			r.ipsetMap.Delete(name)
+		}
+		return true
+	})
+
+	for _, table := range r.allTables() {
+		table.InvalidateDataplaneCache("datapath drift")
+	}
+	if err := r.initApplyPolicy(); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	infos := new(egressv1.EgressClusterInfoList)
+	if err := r.client.List(ctx, infos); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list EgressClusterInfo: %w", err)
+	}
+	for _, info := range infos.Items {
+		res, err := r.reconcileClusterInfo(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: info.Name}}, log)
+		if err != nil || res.Requeue {
+			return res, err
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// allTables returns the tables in a new slice, it is called from the debug
+// server concurrently with the reconciler.
+func (r *policeReconciler) allTables() []*iptables.Table {
+	res := make([]*iptables.Table, 0, len(r.natTables)+len(r.filterTables)+len(r.mangleTables))
+	res = append(res, r.natTables...)
+	res = append(res, r.filterTables...)
+	return append(res, r.mangleTables...)
+}
+
+func chainKey(table *iptables.Table, chain string) string {
+	return fmt.Sprintf("ipv%d/%s/%s", table.IPVersion, table.Name, chain)
+}
+
+func chainRules(rules []iptables.RuleState) []datapath.ChainRule {
+	res := make([]datapath.ChainRule, 0, len(rules))
+	for _, rule := range rules {
+		res = append(res, datapath.ChainRule{Hash: rule.Hash, Rule: rule.Rule})
+	}
+	return res
+}
+
+func (r *vxlanReconciler) DesiredState(state *datapath.State) {
+	r.peerMap.Range(func(key string, peer vxlan.Peer) bool {
+		if key == r.cfg.EnvConfig.NodeName {
+			return true
+		}
+		for _, ip := range []*net.IP{peer.IPv4, peer.IPv6} {
+			if ip != nil {
+				state.Neighbors = append(state.Neighbors, datapath.Neighbor{IP: ip.String(), MAC: peer.MAC.String()})
+			}
+		}
+		state.FDB = append(state.FDB, datapath.Neighbor{IP: peer.Parent.String(), MAC: peer.MAC.String()})
+		return true
+	})
+	r.ruleRoute.Desired(state)
+}
+
+func (r *vxlanReconciler) ProgrammedState(state *datapath.State) error {
+	errs := make([]string, 0)
+	neighs, fdb, err := r.vxlan.ListNeighFDB()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("list neighbors of the vxlan device: %v", err))
+	}
+	for _, neigh := range neighs {
+		if neigh.State&netlink.NUD_PERMANENT == 0 || neigh.HardwareAddr == nil {
+			continue
+		}
+		state.Neighbors = append(state.Neighbors, datapath.Neighbor{IP: neigh.IP.String(), MAC: neigh.HardwareAddr.String()})
+	}
+	for _, entry := range fdb {
+		// the entries without a remote are not added by the agent
+		if entry.IP == nil || entry.IP.IsUnspecified() {
+			continue
+		}
+		state.FDB = append(state.FDB, datapath.Neighbor{IP: entry.IP.String(), MAC: entry.HardwareAddr.String()})
+	}
+
+	if err := r.ruleRoute.Programmed(state, r.cfg.FileConfig.Mark); err != nil {
+		errs = append(errs, fmt.Sprintf("list tunnel rules and routes: %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Repair ensures the neighbors, FDB entries, rules and routes of the peers
+// again, as the agent does at the interval of keepVXLAN.
+func (r *vxlanReconciler) Repair(_ []datapath.Difference) error {
+	if err := r.ensureRoute(); err != nil {
+		return err
+	}
+	return r.ensureRuleRoutes()
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package datapath
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// kinds are the types of the datapath entries
+var kinds = []string{"ipset", "chain", "neighbor", "fdb", "rule", "route"}
+
+var (
+	gaugeDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "datapath",
+		Name:      "drift_entries",
+		Help:      "Number of datapath entries which differ from the desired state at the last verification",
+	}, []string{"type"})
+
+	countDrift = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "datapath",
+		Name:      "drift_total",
+		Help:      "Number of datapath entries found to differ from the desired state",
+	}, []string{"type"})
+
+	countRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "datapath",
+		Name:      "repairs_total",
+		Help:      "Number of drifted datapath entries repaired",
+	}, []string{"type"})
+
+	countRepairErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "datapath",
+		Name:      "repair_errors_total",
+		Help:      "Number of drifted datapath entries which could not be repaired",
+	}, []string{"type"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		gaugeDrift,
+		countDrift,
+		countRepairs,
+		countRepairErrors,
+	}
+}
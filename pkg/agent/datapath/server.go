@@ -0,0 +1,115 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package datapath
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Path is the path of the snapshot on the debug server.
+const Path = "/debug/datapath"
+
+// Source is a part of the agent which programs the datapath.
+type Source interface {
+	// DesiredState adds what the source wants to be programmed to the state
+	DesiredState(state *State)
+	// ProgrammedState adds what is programmed on the node to the state
+	ProgrammedState(state *State) error
+}
+
+// Server serves the datapath snapshot of the node, it is read-only.
+type Server struct {
+	addr string
+	node string
+	log  logr.Logger
+
+	mutex   sync.Mutex
+	sources []Source
+}
+
+// NewServer returns the server listening on addr, nothing is served when addr
+// is empty.
+func NewServer(addr, node string, log logr.Logger) *Server {
+	return &Server{addr: addr, node: node, log: log}
+}
+
+// Register adds a source to the snapshot.
+func (s *Server) Register(source Source) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sources = append(s.sources, source)
+}
+
+func (s *Server) sourceList() []Source {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Source(nil), s.sources...)
+}
+
+// Snapshot reads the desired and the programmed state of the sources.
+func (s *Server) Snapshot() *Snapshot {
+	sources := s.sourceList()
+
+	res := &Snapshot{
+		Node:       s.node,
+		Time:       time.Now(),
+		Desired:    NewState(),
+		Programmed: NewState(),
+	}
+	for _, source := range sources {
+		source.DesiredState(&res.Desired)
+		if err := source.ProgrammedState(&res.Programmed); err != nil {
+			res.Errors = append(res.Errors, err.Error())
+		}
+	}
+	res.Desired.Sort()
+	res.Programmed.Sort()
+	return res
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Snapshot()); err != nil {
+		s.log.Error(err, "failed to write the datapath snapshot")
+	}
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	if s.addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	s.log.Info("datapath debug server is started", "addr", s.addr)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) NeedLeaderElection() bool { return false }
@@ -0,0 +1,260 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package datapath exposes the datapath state of the agent: the ipsets, the
+// EGRESSGATEWAY chains, the VXLAN neighbors and FDB entries, and the ip rules
+// and routes of the marks. Each part is reported both as programmed on the node
+// and as desired by the agent, so that the two can be compared.
+package datapath
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is the datapath state of an agent.
+type Snapshot struct {
+	Node       string    `json:"node"`
+	Time       time.Time `json:"time"`
+	Desired    State     `json:"desired"`
+	Programmed State     `json:"programmed"`
+	// Errors are the parts of the programmed state which could not be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// State is the datapath of a node.
+type State struct {
+	// IPSets are the entries of the ipsets by name
+	IPSets map[string][]string `json:"ipsets"`
+	// Chains are the rules by ipv4/table/chain or ipv6/table/chain, with the
+	// rules the agent inserts into the other chains
+	Chains    map[string][]ChainRule `json:"chains"`
+	Neighbors []Neighbor             `json:"neighbors"`
+	FDB       []Neighbor             `json:"fdb"`
+	Rules     []Rule                 `json:"rules"`
+	Routes    []Route                `json:"routes"`
+}
+
+func NewState() State {
+	return State{
+		IPSets:    make(map[string][]string),
+		Chains:    make(map[string][]ChainRule),
+		Neighbors: make([]Neighbor, 0),
+		FDB:       make([]Neighbor, 0),
+		Rules:     make([]Rule, 0),
+		Routes:    make([]Route, 0),
+	}
+}
+
+// ChainRule is an iptables rule, the hash identifies it in both states.
+type ChainRule struct {
+	Hash string `json:"hash"`
+	Rule string `json:"rule"`
+}
+
+// Neighbor is a neighbor or an FDB entry of the VXLAN device.
+type Neighbor struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
+}
+
+func (n Neighbor) String() string {
+	return n.IP + " lladdr " + n.MAC
+}
+
+// Rule is the ip rule of a mark.
+type Rule struct {
+	Family   string `json:"family"`
+	Priority int    `json:"priority"`
+	Mark     int    `json:"mark"`
+	Table    int    `json:"table"`
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s fwmark %#x lookup %d priority %d", r.Family, r.Mark, r.Table, r.Priority)
+}
+
+// Route is the default route of the table of a mark.
+type Route struct {
+	Family  string `json:"family"`
+	Table   int    `json:"table"`
+	Link    string `json:"link"`
+	Gateway string `json:"gateway"`
+}
+
+func (r Route) String() string {
+	return fmt.Sprintf("%s default via %s dev %s table %d", r.Family, r.Gateway, r.Link, r.Table)
+}
+
+// Sort orders the lists of the state, so that the states compare equal
+// regardless of the order they were read in.
+func (s *State) Sort() {
+	for _, entries := range s.IPSets {
+		sort.Strings(entries)
+	}
+	sortList(s.Neighbors)
+	sortList(s.FDB)
+	sortList(s.Rules)
+	sortList(s.Routes)
+}
+
+// Difference is a part of the datapath whose programmed state is not the
+// desired one.
+type Difference struct {
+	// Kind is ipset, chain, neighbor, fdb, rule or route
+	Kind string `json:"kind"`
+	// Name is the ipset or the chain
+	Name string `json:"name,omitempty"`
+	// Missing are desired but not programmed
+	Missing []string `json:"missing,omitempty"`
+	// Extra are programmed but not desired
+	Extra []string `json:"extra,omitempty"`
+	// Order is set when a chain has the desired rules in another order
+	Order bool `json:"order,omitempty"`
+}
+
+// Diff compares the programmed state with the desired one.
+func (s *Snapshot) Diff() []Difference {
+	res := make([]Difference, 0)
+	add := func(kind, name string, desired, programmed []string) {
+		missing, extra := diffStrings(desired, programmed)
+		if len(missing) > 0 || len(extra) > 0 {
+			res = append(res, Difference{Kind: kind, Name: name, Missing: missing, Extra: extra})
+		}
+	}
+
+	for _, name := range unionKeys(s.Desired.IPSets, s.Programmed.IPSets) {
+		add("ipset", name, s.Desired.IPSets[name], s.Programmed.IPSets[name])
+	}
+	for _, name := range unionKeys(s.Desired.Chains, s.Programmed.Chains) {
+		desired, programmed := s.Desired.Chains[name], s.Programmed.Chains[name]
+		missing, extra := diffRules(desired, programmed)
+		item := Difference{Kind: "chain", Name: name, Missing: missing, Extra: extra}
+		if len(missing) == 0 && len(extra) == 0 {
+			item.Order = !sameOrder(desired, programmed)
+		}
+		if len(missing) > 0 || len(extra) > 0 || item.Order {
+			res = append(res, item)
+		}
+	}
+	add("neighbor", "", stringList(s.Desired.Neighbors), stringList(s.Programmed.Neighbors))
+	add("fdb", "", stringList(s.Desired.FDB), stringList(s.Programmed.FDB))
+	add("rule", "", stringList(s.Desired.Rules), stringList(s.Programmed.Rules))
+	add("route", "", stringList(s.Desired.Routes), stringList(s.Programmed.Routes))
+	return res
+}
+
+func (d Difference) String() string {
+	buf := new(strings.Builder)
+	name := d.Kind
+	if d.Name != "" {
+		name += " " + d.Name
+	}
+	buf.WriteString(name + ":\n")
+	for _, item := range d.Missing {
+		buf.WriteString("  - " + item + "\n")
+	}
+	for _, item := range d.Extra {
+		buf.WriteString("  + " + item + "\n")
+	}
+	if d.Order {
+		buf.WriteString("  rules are not in the desired order\n")
+	}
+	return buf.String()
+}
+
+func unionKeys[T any](a, b map[string]T) []string {
+	keys := make(map[string]struct{}, len(a)+len(b))
+	for key := range a {
+		keys[key] = struct{}{}
+	}
+	for key := range b {
+		keys[key] = struct{}{}
+	}
+	res := make([]string, 0, len(keys))
+	for key := range keys {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func sortList[T fmt.Stringer](items []T) {
+	sort.Slice(items, func(i, j int) bool { return items[i].String() < items[j].String() })
+}
+
+func stringList[T fmt.Stringer](items []T) []string {
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.String())
+	}
+	return res
+}
+
+// diffStrings returns the items of desired missing from programmed, and the
+// items of programmed not in desired.
+func diffStrings(desired, programmed []string) ([]string, []string) {
+	count := make(map[string]int, len(desired))
+	for _, item := range desired {
+		count[item]++
+	}
+	extra := make([]string, 0)
+	for _, item := range programmed {
+		if count[item] > 0 {
+			count[item]--
+			continue
+		}
+		extra = append(extra, item)
+	}
+	missing := make([]string, 0)
+	for _, item := range desired {
+		if count[item] > 0 {
+			count[item]--
+			missing = append(missing, item)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
+
+// diffRules compares the rules by hash, the missing rules are rendered as
+// desired and the extra rules as programmed.
+func diffRules(desired, programmed []ChainRule) ([]string, []string) {
+	desiredRules := make(map[string]string, len(desired))
+	desiredHashes := make([]string, 0, len(desired))
+	for _, item := range desired {
+		desiredRules[item.Hash] = item.Rule
+		desiredHashes = append(desiredHashes, item.Hash)
+	}
+	programmedRules := make(map[string]string, len(programmed))
+	programmedHashes := make([]string, 0, len(programmed))
+	for _, item := range programmed {
+		programmedRules[item.Hash] = item.Rule
+		programmedHashes = append(programmedHashes, item.Hash)
+	}
+	missing, extra := diffStrings(desiredHashes, programmedHashes)
+	for i, hash := range missing {
+		missing[i] = desiredRules[hash]
+	}
+	for i, hash := range extra {
+		extra[i] = programmedRules[hash]
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
+
+func sameOrder(desired, programmed []ChainRule) bool {
+	if len(desired) != len(programmed) {
+		return false
+	}
+	for i := range desired {
+		if desired[i].Hash != programmed[i].Hash {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package datapath
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	cases := map[string]struct {
+		modify func(programmed *State)
+		exp    []Difference
+	}{
+		"in sync": {
+			modify: func(programmed *State) {},
+			exp:    []Difference{},
+		},
+		"missing ipset entry": {
+			modify: func(programmed *State) {
+				programmed.IPSets["egress-src-v4-p1"] = []string{"10.21.0.10"}
+			},
+			exp: []Difference{{Kind: "ipset", Name: "egress-src-v4-p1", Missing: []string{"10.21.0.11"}, Extra: []string{}}},
+		},
+		"stale ipset": {
+			modify: func(programmed *State) {
+				programmed.IPSets["egress-src-v4-p2"] = []string{"10.21.0.12"}
+			},
+			exp: []Difference{{Kind: "ipset", Name: "egress-src-v4-p2", Missing: []string{}, Extra: []string{"10.21.0.12"}}},
+		},
+		"unknown rule in chain": {
+			modify: func(programmed *State) {
+				key := "ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST"
+				programmed.Chains[key] = append(programmed.Chains[key], ChainRule{Rule: "-A EGRESSGATEWAY-MARK-REQUEST -j ACCEPT"})
+			},
+			exp: []Difference{{
+				Kind:    "chain",
+				Name:    "ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST",
+				Missing: []string{},
+				Extra:   []string{"-A EGRESSGATEWAY-MARK-REQUEST -j ACCEPT"},
+			}},
+		},
+		"rules out of order": {
+			modify: func(programmed *State) {
+				rules := programmed.Chains["ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST"]
+				rules[0], rules[1] = rules[1], rules[0]
+			},
+			exp: []Difference{{Kind: "chain", Name: "ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST", Missing: []string{}, Extra: []string{}, Order: true}},
+		},
+		"missing neighbor and rule": {
+			modify: func(programmed *State) {
+				programmed.Neighbors = programmed.Neighbors[:0]
+				programmed.Rules[0].Table = 254
+			},
+			exp: []Difference{
+				{Kind: "neighbor", Missing: []string{"192.200.0.2 lladdr 66:5e:0c:1a:2b:3c"}, Extra: []string{}},
+				{
+					Kind:    "rule",
+					Missing: []string{"ipv4 fwmark 0x26000002 lookup 637534210 priority 99"},
+					Extra:   []string{"ipv4 fwmark 0x26000002 lookup 254 priority 99"},
+				},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			snapshot := &Snapshot{Desired: testState(), Programmed: testState()}
+			tc.modify(&snapshot.Programmed)
+			assert.Equal(t, tc.exp, snapshot.Diff())
+		})
+	}
+}
+
+func TestDifferenceString(t *testing.T) {
+	d := Difference{Kind: "ipset", Name: "egress-src-v4-p1", Missing: []string{"10.21.0.11"}, Extra: []string{"10.21.0.12"}}
+	assert.Equal(t, "ipset egress-src-v4-p1:\n  - 10.21.0.11\n  + 10.21.0.12\n", d.String())
+}
+
+type testSource struct {
+	err error
+}
+
+func (s testSource) DesiredState(state *State) {
+	state.IPSets["egress-src-v4-p1"] = []string{"10.21.0.11", "10.21.0.10"}
+}
+
+func (s testSource) ProgrammedState(state *State) error {
+	state.IPSets["egress-src-v4-p1"] = []string{"10.21.0.10"}
+	return s.err
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer("", "node1", logr.Discard())
+	server.Register(testSource{})
+	server.Register(testSource{err: errors.New("failed to list ipsets")})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	snapshot := new(Snapshot)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(snapshot))
+	assert.Equal(t, "node1", snapshot.Node)
+	assert.Equal(t, []string{"10.21.0.10", "10.21.0.11"}, snapshot.Desired.IPSets["egress-src-v4-p1"])
+	assert.Equal(t, []string{"failed to list ipsets"}, snapshot.Errors)
+	assert.Len(t, snapshot.Diff(), 1)
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, Path, strings.NewReader("{}")))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func testState() State {
+	state := NewState()
+	state.IPSets["egress-src-v4-p1"] = []string{"10.21.0.10", "10.21.0.11"}
+	state.Chains["ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST"] = []ChainRule{
+		{Hash: "a1", Rule: "-A EGRESSGATEWAY-MARK-REQUEST -m set --match-set egress-src-v4-p1 src -j MARK --set-xmark 0x26000002/0xffffffff"},
+		{Hash: "b2", Rule: "-A EGRESSGATEWAY-MARK-REQUEST -m mark --mark 0x26000002 -j ACCEPT"},
+	}
+	state.Neighbors = append(state.Neighbors, Neighbor{IP: "192.200.0.2", MAC: "66:5e:0c:1a:2b:3c"})
+	state.FDB = append(state.FDB, Neighbor{IP: "172.18.0.3", MAC: "66:5e:0c:1a:2b:3c"})
+	state.Rules = append(state.Rules, Rule{Family: "ipv4", Priority: 99, Mark: 0x26000002, Table: 0x26000002})
+	state.Routes = append(state.Routes, Route{Family: "ipv4", Table: 0x26000002, Link: "egress.vxlan", Gateway: "192.200.0.2"})
+	return state
+}
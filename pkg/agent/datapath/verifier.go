@@ -0,0 +1,202 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package datapath
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Repairer is a source which can bring the programmed state back to the desired
+// one, by programming its whole desired state again.
+type Repairer interface {
+	Repair(diff []Difference) error
+}
+
+// ErrRepairQueued is returned by a repairer which repairs the datapath later,
+// the repairer counts the repair with CountRepair once it is done.
+var ErrRepairQueued = errors.New("the datapath repair is queued")
+
+// Verifier compares the programmed state of each source with the desired one
+// at an interval. The state read while a source is being reconciled can differ
+// for a moment, so a difference is only drift when it is read again after the
+// settle time. The drift is counted by type, and repaired when enabled.
+type Verifier struct {
+	server   *Server
+	interval time.Duration
+	settle   time.Duration
+	repair   bool
+	log      logr.Logger
+	// drift are the drifted entries of each source by type
+	drift map[Source]map[string]int
+}
+
+// NewVerifier returns the verifier of the sources of the server, the drift is
+// only reported when repair is false.
+func NewVerifier(server *Server, interval time.Duration, repair bool, log logr.Logger) *Verifier {
+	return &Verifier{
+		server:   server,
+		interval: interval,
+		settle:   3 * time.Second,
+		repair:   repair,
+		log:      log.WithName("datapath-verifier"),
+		drift:    make(map[Source]map[string]int),
+	}
+}
+
+func (v *Verifier) Start(ctx context.Context) error {
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, source := range v.server.sourceList() {
+				v.verify(ctx, source)
+			}
+		}
+	}
+}
+
+func (v *Verifier) NeedLeaderElection() bool { return false }
+
+// verify checks a source, and repairs its confirmed drift.
+func (v *Verifier) verify(ctx context.Context, source Source) {
+	diff, ok := v.check(source)
+	if !ok || len(diff) == 0 {
+		v.setDrift(source, nil)
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(v.settle):
+	}
+	again, ok := v.check(source)
+	if !ok {
+		return
+	}
+	diff = confirmDrift(diff, again)
+	v.setDrift(source, diff)
+	if len(diff) == 0 {
+		return
+	}
+
+	for _, item := range diff {
+		v.log.Info("datapath drift", "type", item.Kind, "name", item.Name,
+			"missing", item.Missing, "extra", item.Extra, "order", item.Order)
+		countDrift.WithLabelValues(item.Kind).Add(float64(item.entries()))
+	}
+	if !v.repair {
+		return
+	}
+	repairer, ok := source.(Repairer)
+	if !ok {
+		return
+	}
+	err := repairer.Repair(diff)
+	if errors.Is(err, ErrRepairQueued) {
+		return
+	}
+	if err != nil {
+		v.log.Error(err, "failed to repair the datapath")
+	}
+	CountRepair(diff, err)
+}
+
+// CountRepair counts the drifted entries as repaired, or as not repaired when
+// the repair failed with err.
+func CountRepair(diff []Difference, err error) {
+	counter := countRepairs
+	if err != nil {
+		counter = countRepairErrors
+	}
+	for _, item := range diff {
+		counter.WithLabelValues(item.Kind).Add(float64(item.entries()))
+	}
+}
+
+// check returns the differences of the source, ok is false when the programmed
+// state could not be read, a partial state would be taken for drift.
+func (v *Verifier) check(source Source) ([]Difference, bool) {
+	snapshot := &Snapshot{Desired: NewState(), Programmed: NewState()}
+	source.DesiredState(&snapshot.Desired)
+	if err := source.ProgrammedState(&snapshot.Programmed); err != nil {
+		v.log.Error(err, "failed to read the programmed datapath")
+		return nil, false
+	}
+	snapshot.Desired.Sort()
+	snapshot.Programmed.Sort()
+	return snapshot.Diff(), true
+}
+
+func (v *Verifier) setDrift(source Source, diff []Difference) {
+	entries := make(map[string]int, len(kinds))
+	for _, item := range diff {
+		entries[item.Kind] += item.entries()
+	}
+	v.drift[source] = entries
+	for _, kind := range kinds {
+		total := 0
+		for _, item := range v.drift {
+			total += item[kind]
+		}
+		gaugeDrift.WithLabelValues(kind).Set(float64(total))
+	}
+}
+
+// confirmDrift returns the differences of the first check found again by the
+// second one.
+func confirmDrift(first, second []Difference) []Difference {
+	type key struct{ kind, name string }
+	seen := make(map[key]Difference, len(first))
+	for _, item := range first {
+		seen[key{item.Kind, item.Name}] = item
+	}
+	res := make([]Difference, 0)
+	for _, item := range second {
+		prev, ok := seen[key{item.Kind, item.Name}]
+		if !ok {
+			continue
+		}
+		confirmed := Difference{
+			Kind:    item.Kind,
+			Name:    item.Name,
+			Missing: intersect(prev.Missing, item.Missing),
+			Extra:   intersect(prev.Extra, item.Extra),
+			Order:   prev.Order && item.Order,
+		}
+		if confirmed.entries() > 0 {
+			res = append(res, confirmed)
+		}
+	}
+	return res
+}
+
+func intersect(a, b []string) []string {
+	set := make(map[string]struct{}, len(a))
+	for _, item := range a {
+		set[item] = struct{}{}
+	}
+	res := make([]string, 0)
+	for _, item := range b {
+		if _, ok := set[item]; ok {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// entries is the number of drifted entries of the difference, a chain with
+// the rules out of order counts as one.
+func (d Difference) entries() int {
+	if d.Order {
+		return 1
+	}
+	return len(d.Missing) + len(d.Extra)
+}
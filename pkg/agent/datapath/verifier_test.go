@@ -0,0 +1,126 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package datapath
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// driftSource has the programmed entries of an ipset, the entries read by the
+// checks are taken in order.
+type driftSource struct {
+	reads   [][]string
+	repairs int
+	// queued repairs are counted by the source
+	queued bool
+}
+
+func (s *driftSource) DesiredState(state *State) {
+	state.IPSets["egress-src-v4-p1"] = []string{"10.21.0.10", "10.21.0.11"}
+}
+
+func (s *driftSource) ProgrammedState(state *State) error {
+	state.IPSets["egress-src-v4-p1"] = s.reads[0]
+	if len(s.reads) > 1 {
+		s.reads = s.reads[1:]
+	}
+	return nil
+}
+
+func (s *driftSource) Repair(_ []Difference) error {
+	s.repairs++
+	if s.queued {
+		return ErrRepairQueued
+	}
+	return nil
+}
+
+func counterValue(t *testing.T, vec *prometheus.CounterVec, kind string) float64 {
+	metric := new(dto.Metric)
+	assert.NoError(t, vec.WithLabelValues(kind).Write(metric))
+	return metric.GetCounter().GetValue()
+}
+
+func gaugeValue(t *testing.T, vec *prometheus.GaugeVec, kind string) float64 {
+	metric := new(dto.Metric)
+	assert.NoError(t, vec.WithLabelValues(kind).Write(metric))
+	return metric.GetGauge().GetValue()
+}
+
+func TestVerify(t *testing.T) {
+	cases := map[string]struct {
+		reads      [][]string
+		repair     bool
+		queued     bool
+		expRepairs int
+		expCounted float64
+		expDrift   float64
+	}{
+		"in sync": {
+			reads: [][]string{{"10.21.0.10", "10.21.0.11"}},
+		},
+		"transient difference": {
+			reads:  [][]string{{"10.21.0.10"}, {"10.21.0.10", "10.21.0.11"}},
+			repair: true,
+		},
+		"drift repaired": {
+			reads:      [][]string{{"10.21.0.10", "10.21.0.12"}},
+			repair:     true,
+			expRepairs: 1,
+			expCounted: 2,
+			expDrift:   2,
+		},
+		"repair queued": {
+			reads:      [][]string{{"10.21.0.10", "10.21.0.12"}},
+			repair:     true,
+			queued:     true,
+			expRepairs: 1,
+			expDrift:   2,
+		},
+		"drift reported": {
+			reads:    [][]string{{"10.21.0.10"}},
+			expDrift: 1,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			source := &driftSource{reads: tc.reads, queued: tc.queued}
+			server := NewServer("", "node1", logr.Discard())
+			server.Register(source)
+			v := NewVerifier(server, 0, tc.repair, logr.Discard())
+			v.settle = 0
+
+			drift := counterValue(t, countDrift, "ipset")
+			repairs := counterValue(t, countRepairs, "ipset")
+			v.verify(context.Background(), source)
+			assert.Equal(t, tc.expRepairs, source.repairs)
+			assert.Equal(t, tc.expDrift, counterValue(t, countDrift, "ipset")-drift)
+			assert.Equal(t, tc.expCounted, counterValue(t, countRepairs, "ipset")-repairs)
+			assert.Equal(t, tc.expDrift, gaugeValue(t, gaugeDrift, "ipset"))
+		})
+	}
+}
+
+func TestConfirmDrift(t *testing.T) {
+	first := []Difference{
+		{Kind: "ipset", Name: "egress-src-v4-p1", Missing: []string{"10.21.0.11"}, Extra: []string{"10.21.0.12"}},
+		{Kind: "chain", Name: "ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST", Order: true},
+		{Kind: "rule", Missing: []string{"ipv4 fwmark 0x26000002 lookup 637534210 priority 99"}},
+	}
+	second := []Difference{
+		{Kind: "ipset", Name: "egress-src-v4-p1", Missing: []string{"10.21.0.11"}},
+		{Kind: "chain", Name: "ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST", Order: true},
+		{Kind: "neighbor", Missing: []string{"192.200.0.2 lladdr 66:5e:0c:1a:2b:3c"}},
+	}
+	assert.Equal(t, []Difference{
+		{Kind: "ipset", Name: "egress-src-v4-p1", Missing: []string{"10.21.0.11"}, Extra: []string{}},
+		{Kind: "chain", Name: "ipv4/mangle/EGRESSGATEWAY-MARK-REQUEST", Missing: []string{}, Extra: []string{}, Order: true},
+	}, confirmDrift(first, second))
+}
@@ -0,0 +1,29 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package dryrun
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	countPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "dryrun",
+		Name:      "packets_total",
+		Help:      "Number of packets of the node matched by the policy in dry run mode",
+	}, []string{"namespace", "policy"})
+
+	countBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "dryrun",
+		Name:      "bytes_total",
+		Help:      "Number of bytes of the node matched by the policy in dry run mode",
+	}, []string{"namespace", "policy"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		countPackets,
+		countBytes,
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dryrun samples the counting rules of the policies in dry run mode
+// and reports the traffic they matched as metrics.
+package dryrun
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const sampleInterval = 30 * time.Second
+
+// Counters are the packets and bytes matched by the rules of a policy.
+type Counters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// Source reads the counters of the rules of the dry run policies.
+type Source interface {
+	DryRunCounters() (map[egressv1.Policy]Counters, error)
+}
+
+// Sampler reports the counters of the dry run policies.
+type Sampler struct {
+	source Source
+	log    logr.Logger
+	// last are the counters of the policies at the last sample
+	last map[egressv1.Policy]Counters
+}
+
+func New(source Source, log logr.Logger) *Sampler {
+	return &Sampler{
+		source: source,
+		log:    log.WithName("dryRun"),
+		last:   make(map[egressv1.Policy]Counters),
+	}
+}
+
+func (s *Sampler) NeedLeaderElection() bool { return false }
+
+func (s *Sampler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		counters, err := s.source.DryRunCounters()
+		if err != nil {
+			s.log.Error(err, "failed to read the counters of the dry run policies")
+			continue
+		}
+		s.record(counters)
+	}
+}
+
+// record adds the traffic counted since the last sample to the metrics, and
+// removes the metrics of the policies no longer in dry run mode.
+func (s *Sampler) record(counters map[egressv1.Policy]Counters) {
+	for policy, cur := range counters {
+		// the counters of a new rule count from its creation, and the rules
+		// replaced by a repair count again from zero
+		last := s.last[policy]
+		if cur.Packets < last.Packets || cur.Bytes < last.Bytes {
+			last = Counters{}
+		}
+		countPackets.WithLabelValues(policy.Namespace, policy.Name).Add(float64(cur.Packets - last.Packets))
+		countBytes.WithLabelValues(policy.Namespace, policy.Name).Add(float64(cur.Bytes - last.Bytes))
+	}
+	for policy := range s.last {
+		if _, ok := counters[policy]; !ok {
+			countPackets.DeleteLabelValues(policy.Namespace, policy.Name)
+			countBytes.DeleteLabelValues(policy.Namespace, policy.Name)
+		}
+	}
+	s.last = counters
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package dryrun
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func counterValue(t *testing.T, vec *prometheus.CounterVec, policy egressv1.Policy) float64 {
+	metric := new(dto.Metric)
+	assert.NoError(t, vec.WithLabelValues(policy.Namespace, policy.Name).Write(metric))
+	return metric.GetCounter().GetValue()
+}
+
+func TestRecord(t *testing.T) {
+	p1 := egressv1.Policy{Namespace: "default", Name: "p1"}
+	p2 := egressv1.Policy{Name: "cluster-p2"}
+	s := New(nil, logr.Discard())
+
+	// new rules count from their creation
+	s.record(map[egressv1.Policy]Counters{p1: {Packets: 10, Bytes: 1000}, p2: {Packets: 1, Bytes: 60}})
+	assert.Equal(t, float64(10), counterValue(t, countPackets, p1))
+	assert.Equal(t, float64(1000), counterValue(t, countBytes, p1))
+
+	s.record(map[egressv1.Policy]Counters{p1: {Packets: 15, Bytes: 1500}, p2: {Packets: 1, Bytes: 60}})
+	assert.Equal(t, float64(15), counterValue(t, countPackets, p1))
+	assert.Equal(t, float64(1500), counterValue(t, countBytes, p1))
+	assert.Equal(t, float64(60), counterValue(t, countBytes, p2))
+
+	// the rule is created again
+	s.record(map[egressv1.Policy]Counters{p1: {Packets: 2, Bytes: 200}})
+	assert.Equal(t, float64(17), counterValue(t, countPackets, p1))
+	assert.Equal(t, float64(1700), counterValue(t, countBytes, p1))
+
+	// p2 is no longer in dry run mode, its metrics are removed
+	assert.False(t, countBytes.DeleteLabelValues(p2.Namespace, p2.Name))
+}
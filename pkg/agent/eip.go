@@ -7,28 +7,50 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"path"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/localaddr"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/eiplease"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/layer2"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
 )
 
+// announcer announces the EIPs of the policies with ARP and NDP, it is
+// implemented by layer2.Announce
+type announcer interface {
+	SetBalancer(name string, adv layer2.IPAdvertisement)
+	DeleteBalancer(name string)
+	DeleteBalancerIP(name string, ip net.IP)
+	BalancerIPs(name string) []net.IP
+	Conflicted(ip net.IP) bool
+}
+
 type eip struct {
 	client client.Client
 	log    logr.Logger
 	cfg    *config.Config
 
-	announce *layer2.Announce
+	announce announcer
+	linkList func() ([]netlink.Link, error)
+	// fence is nil when the EIP leases are disabled
+	fence *eiplease.Fence
 }
 
 func (r *eip) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -69,21 +91,15 @@ func (r *eip) reconcilePolicy(ctx context.Context, req reconcile.Request, log lo
 		return reconcile.Result{}, nil
 	}
 
-	if policy.Status.Node != r.cfg.NodeName {
+	eips := nodeEips(r.cfg.NodeName, policy.Status)
+	if len(eips) == 0 {
 		r.announce.DeleteBalancer(req.NamespacedName.String())
 		return reconcile.Result{}, nil
 	}
 
-	ip := net.ParseIP(policy.Status.Eip.Ipv4)
-	if ip.To4() != nil {
-		adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-		r.announce.SetBalancer(req.NamespacedName.String(), adv)
-	}
-
-	ip = net.ParseIP(policy.Status.Eip.Ipv6)
-	if ip.To16() != nil {
-		adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-		r.announce.SetBalancer(req.NamespacedName.String(), adv)
+	err = r.setBalancer(ctx, req.NamespacedName.String(), policy.Spec.EgressGatewayName, eips, log)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
 	}
 
 	return reconcile.Result{}, nil
@@ -109,38 +125,296 @@ func (r *eip) reconcileClusterPolicy(ctx context.Context, req reconcile.Request,
 		return reconcile.Result{}, nil
 	}
 
-	if policy.Status.Node != r.cfg.NodeName {
+	eips := nodeEips(r.cfg.NodeName, policy.Status)
+	if len(eips) == 0 {
 		r.announce.DeleteBalancer(req.NamespacedName.String())
 		return reconcile.Result{}, nil
 	}
 
-	ip := net.ParseIP(policy.Status.Eip.Ipv4)
-	if ip.To4() != nil {
-		adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-		r.announce.SetBalancer(req.NamespacedName.String(), adv)
+	err = r.setBalancer(ctx, req.NamespacedName.String(), policy.Spec.EgressGatewayName, eips, log)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
 	}
 
-	ip = net.ParseIP(policy.Status.Eip.Ipv6)
-	if ip.To16() != nil {
-		adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-		r.announce.SetBalancer(req.NamespacedName.String(), adv)
+	return reconcile.Result{}, nil
+}
+
+// nodeEips returns the EIPs of the policy held by the node, the EIPs of a
+// policy with more than one EIP may be on other nodes than the first one
+func nodeEips(node string, status egressv1.EgressPolicyStatus) []egressv1.Eip {
+	res := make([]egressv1.Eip, 0, 1)
+	if len(status.Assignments) > 0 {
+		for _, item := range status.Assignments {
+			if item.Node == node {
+				res = append(res, egressv1.Eip{Ipv4: item.Ipv4, Ipv6: item.Ipv6})
+			}
+		}
+		return res
+	}
+	if status.Node == node {
+		res = append(res, status.Eip)
 	}
+	return res
+}
 
-	return reconcile.Result{}, nil
+func (r *eip) setBalancer(ctx context.Context, name, egwName string, eips []egressv1.Eip, log logr.Logger) error {
+	var announcement *egressv1.Announcement
+	if egwName != "" {
+		egw := new(egressv1.EgressGateway)
+		err := r.client.Get(ctx, types.NamespacedName{Name: egwName}, egw)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		announcement = egw.Spec.Announcement
+	}
+
+	owned := make([]net.IP, 0, 2*len(eips))
+	held := sets.New[string]()
+	for _, eip := range eips {
+		for _, item := range []string{eip.Ipv4, eip.Ipv6} {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				continue
+			}
+			if !r.fence.Owns(ip.String()) {
+				log.Info("lease of EIP is not held, it will not be announced", "eip", item)
+				continue
+			}
+			held.Insert(ip.String())
+			owned = append(owned, ip)
+		}
+	}
+	// withdraw only the EIPs which moved away or whose lease is lost, the
+	// announcement of the others is kept
+	for _, ip := range r.announce.BalancerIPs(name) {
+		if !held.Has(ip.String()) {
+			r.announce.DeleteBalancerIP(name, ip)
+		}
+	}
+
+	for _, ip := range owned {
+		item := ip.String()
+		target, err := announcementTarget(announcement, r.cfg.NodeName, item)
+		if err != nil {
+			return err
+		}
+		all, interfaces, err := r.announceInterfaces(target)
+		if err != nil {
+			return err
+		}
+		if !all && interfaces.Len() == 0 {
+			log.Info("no interface matches the announcement of EIP, it will not be announced",
+				"eip", item, "interfaces", target.Interfaces, "vlan", target.VLAN)
+		}
+		adv := layer2.NewIPAdvertisement(ip, all, interfaces).
+			WithGateway(egwName).
+			WithSchedule(gratuitousSchedule(announcement))
+		r.announce.SetBalancer(name, adv)
+	}
+	return nil
+}
+
+// gratuitousSchedule returns the gratuitous schedule of the gateway, the unset
+// fields take the default
+func gratuitousSchedule(announcement *egressv1.Announcement) layer2.Schedule {
+	res := layer2.DefaultSchedule
+	if announcement == nil || announcement.Gratuitous == nil {
+		return res
+	}
+	item := announcement.Gratuitous
+	if item.Count > 0 {
+		res.Count = item.Count
+	}
+	if item.IntervalMillis > 0 {
+		res.Interval = time.Duration(item.IntervalMillis) * time.Millisecond
+	}
+	res.Refresh = time.Duration(item.RefreshSecond) * time.Second
+	return res
+}
+
+// announcementTarget returns the announcement target of the EIP on the node,
+// a matched pool only takes its own node overrides
+func announcementTarget(announcement *egressv1.Announcement, node, eip string) (egressv1.AnnouncementTarget, error) {
+	if announcement == nil {
+		return egressv1.AnnouncementTarget{}, nil
+	}
+
+	target, nodes := announcement.AnnouncementTarget, announcement.Nodes
+	for _, pool := range announcement.Pools {
+		included, err := ip.CheckIPIncluded(eip, pool.IPPools)
+		if err != nil {
+			return egressv1.AnnouncementTarget{}, fmt.Errorf("failed to match EIP %s with announcement pool: %w", eip, err)
+		}
+		if included {
+			target, nodes = pool.AnnouncementTarget, pool.Nodes
+			break
+		}
+	}
+	for _, item := range nodes {
+		if item.Name == node {
+			return item.AnnouncementTarget, nil
+		}
+	}
+	return target, nil
+}
+
+// announceInterfaces resolves the target to interface names, all is true when
+// the target is empty
+func (r *eip) announceInterfaces(target egressv1.AnnouncementTarget) (bool, sets.Set[string], error) {
+	if len(target.Interfaces) == 0 && target.VLAN == 0 {
+		return true, sets.New[string](), nil
+	}
+	if target.VLAN == 0 {
+		return false, sets.New[string](target.Interfaces...), nil
+	}
+
+	links, err := r.linkList()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	names := make(map[int]string, len(links))
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	parents := sets.New[string](target.Interfaces...)
+	res := sets.New[string]()
+	for _, link := range links {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.VlanId != target.VLAN {
+			continue
+		}
+		if parents.Len() > 0 && !parents.Has(names[vlan.Attrs().ParentIndex]) {
+			continue
+		}
+		res.Insert(vlan.Attrs().Name)
+	}
+	return false, res, nil
+}
+
+// gatewayInterfaces returns the announcement interfaces of the EIP of egw on this node
+func (r *eip) gatewayInterfaces(egw *egressv1.EgressGateway, eip string) (bool, sets.Set[string], error) {
+	target, err := announcementTarget(egw.Spec.Announcement, r.cfg.NodeName, eip)
+	if err != nil {
+		return false, nil, err
+	}
+	return r.announceInterfaces(target)
+}
+
+// held reports whether the node answers for eip, its lease is held and its
+// announcement is not stopped after a conflict
+func (r *eip) held(eip string) bool {
+	ip := net.ParseIP(eip)
+	return ip != nil && r.fence.Owns(ip.String()) && !r.announce.Conflicted(ip)
+}
+
+// mapGatewayPolicies enqueues the policies using the EIPs of this node when
+// the EgressGateway changes, so that the announcement follows the gateway
+func (r *eip) mapGatewayPolicies(ctx context.Context, obj client.Object) []reconcile.Request {
+	egw, ok := obj.(*egressv1.EgressGateway)
+	if !ok {
+		return nil
+	}
+	res := make([]reconcile.Request, 0)
+	for _, eip := range egw.Status.GetNodeIPs(r.cfg.NodeName) {
+		for _, policy := range eip.Policies {
+			ns := "EgressClusterPolicy/"
+			if policy.Namespace != "" {
+				ns = path.Join("EgressPolicy", policy.Namespace)
+			}
+			res = append(res, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: ns, Name: policy.Name},
+			})
+		}
+	}
+	return res
+}
+
+// mapLinkPolicies enqueues the policies using the EIPs of this node announced
+// on a VLAN after a link change, the VLAN interfaces are resolved when the
+// policies are reconciled.
+func (r *eip) mapLinkPolicies(ctx context.Context, _ client.Object) []reconcile.Request {
+	list := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, list); err != nil {
+		r.log.Error(err, "failed to list EgressGateway")
+		return nil
+	}
+	res := make([]reconcile.Request, 0)
+	for i := range list.Items {
+		if hasVLAN(list.Items[i].Spec.Announcement) {
+			res = append(res, r.mapGatewayPolicies(ctx, &list.Items[i])...)
+		}
+	}
+	return res
+}
+
+// hasVLAN reports whether a target of the announcement is a VLAN.
+func hasVLAN(announcement *egressv1.Announcement) bool {
+	if announcement == nil {
+		return false
+	}
+	targets := []egressv1.AnnouncementTarget{announcement.AnnouncementTarget}
+	nodes := announcement.Nodes
+	for _, pool := range announcement.Pools {
+		targets = append(targets, pool.AnnouncementTarget)
+		nodes = append(nodes, pool.Nodes...)
+	}
+	for _, node := range nodes {
+		targets = append(targets, node.AnnouncementTarget)
+	}
+	for _, target := range targets {
+		if target.VLAN != 0 {
+			return true
+		}
+	}
+	return false
 }
 
 // newEipCtrl return a new egress ip controller
-func newEipCtrl(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+func newEipCtrl(mgr manager.Manager, log logr.Logger, cfg *config.Config, fence *eiplease.Fence) error {
 	an, err := layer2.New(log, cfg.FileConfig.AnnounceExcludeRegexp)
 	if err != nil {
 		return err
 	}
 
+	conflict := newEIPConflict(mgr.GetClient(), mgr.GetEventRecorderFor("egressgateway-agent"), log, cfg.NodeName)
+	hold := time.Duration(0)
+	if cfg.FileConfig.AnnounceConflict.StopAnnouncing {
+		hold = time.Duration(cfg.FileConfig.AnnounceConflict.HoldSecond) * time.Second
+	}
+	// the local addresses of a stopped EIP are removed at once
+	stopped := make(chan event.GenericEvent, 16)
+	an.OnConflict(func(item layer2.Conflict) {
+		conflict.Handle(item)
+		if hold == 0 {
+			return
+		}
+		select {
+		case stopped <- event.GenericEvent{Object: &egressv1.EgressGateway{}}:
+		default:
+		}
+	}, hold)
+	if err := mgr.Add(conflict); err != nil {
+		return err
+	}
+	// a VLAN interface created or renamed after its EIPs were reconciled is
+	// resolved again
+	linked, linkedAddrs := make(chan event.GenericEvent, 1), make(chan event.GenericEvent, 1)
+	an.OnLinkChange(func() {
+		for _, ch := range []chan event.GenericEvent{linked, linkedAddrs} {
+			select {
+			case ch <- event.GenericEvent{Object: &egressv1.EgressGateway{}}:
+			default:
+			}
+		}
+	})
+
 	eip := &eip{
 		cfg:      cfg,
 		log:      log,
 		client:   mgr.GetClient(),
 		announce: an,
+		linkList: netlink.LinkList,
+		fence:    fence,
 	}
 
 	c, err := controller.New("eip", mgr, controller.Options{Reconciler: eip})
@@ -167,5 +441,32 @@ func newEipCtrl(mgr manager.Manager, log logr.Logger, cfg *config.Config) error
 
 	}
 
+	sourceEgressGateway := utils.SourceKind(
+		mgr.GetCache(),
+		&egressv1.EgressGateway{},
+		handler.EnqueueRequestsFromMapFunc(eip.mapGatewayPolicies),
+	)
+	if err := c.Watch(sourceEgressGateway); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
+	sourceLink := source.Channel(linked, handler.EnqueueRequestsFromMapFunc(eip.mapLinkPolicies))
+	if err := c.Watch(sourceLink); err != nil {
+		return fmt.Errorf("failed to watch link changes: %w", err)
+	}
+
+	sources := []<-chan event.GenericEvent{stopped, linkedAddrs}
+	if fence != nil {
+		sourceFence := source.Channel(fence.Subscribe(), handler.EnqueueRequestsFromMapFunc(eip.mapGatewayPolicies))
+		if err := c.Watch(sourceFence); err != nil {
+			return fmt.Errorf("failed to watch EIP leases: %w", err)
+		}
+		sources = append(sources, fence.Subscribe())
+	}
+
+	if err := localaddr.NewController(mgr, log, cfg, eip.gatewayInterfaces, eip.held, sources...); err != nil {
+		return fmt.Errorf("failed to create local address controller: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,180 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/layer2"
+)
+
+const (
+	ReasonEIPConflict = "EIPConflict"
+
+	// conflictUpdateInterval limits the status updates of a conflict which
+	// is seen again and again
+	conflictUpdateInterval = time.Minute
+	// conflictExpire removes a conflict from the status when it is not seen anymore
+	conflictExpire = 5 * time.Minute
+)
+
+// eipConflict reports the conflicts detected by the announcer as Events and
+// in the status of the EgressGateway owning the EIP.
+type eipConflict struct {
+	client   client.Client
+	recorder record.EventRecorder
+	log      logr.Logger
+	nodeName string
+
+	ch       chan layer2.Conflict
+	reported map[string]time.Time
+}
+
+func newEIPConflict(cli client.Client, recorder record.EventRecorder, log logr.Logger, nodeName string) *eipConflict {
+	return &eipConflict{
+		client:   cli,
+		recorder: recorder,
+		log:      log.WithName("eipConflict"),
+		nodeName: nodeName,
+		ch:       make(chan layer2.Conflict, 64),
+		reported: make(map[string]time.Time),
+	}
+}
+
+// Handle is the conflict handler of the announcer, the conflicts are dropped
+// while the reporter is busy.
+func (c *eipConflict) Handle(conflict layer2.Conflict) {
+	select {
+	case c.ch <- conflict:
+	default:
+	}
+}
+
+func (c *eipConflict) Start(ctx context.Context) error {
+	ticker := time.NewTicker(conflictUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case conflict := <-c.ch:
+			if err := c.report(ctx, conflict); err != nil {
+				c.log.Error(err, "failed to report EIP conflict", "eip", conflict.IP)
+			}
+		case <-ticker.C:
+			if err := c.prune(ctx); err != nil {
+				c.log.Error(err, "failed to prune EIP conflicts")
+			}
+		}
+	}
+}
+
+func (c *eipConflict) report(ctx context.Context, conflict layer2.Conflict) error {
+	ip := conflict.IP.String()
+	if time.Since(c.reported[ip]) < conflictUpdateInterval {
+		return nil
+	}
+
+	egw, err := c.findGateway(ctx, conflict.IP)
+	if err != nil {
+		return err
+	}
+	if egw == nil {
+		return nil
+	}
+	c.reported[ip] = time.Now()
+
+	c.recorder.Eventf(egw, corev1.EventTypeWarning, ReasonEIPConflict,
+		"EIP %s on node %s interface %s is also announced by %s", ip, c.nodeName, conflict.Interface, conflict.MAC)
+
+	return c.updateStatus(ctx, egw.Name, func(list []egressv1.EIPConflict) []egressv1.EIPConflict {
+		item := egressv1.EIPConflict{
+			IP:        ip,
+			Node:      c.nodeName,
+			Interface: conflict.Interface,
+			MAC:       conflict.MAC.String(),
+			LastSeen:  metav1.Now(),
+		}
+		for i := range list {
+			if list[i].IP == ip && list[i].Node == c.nodeName {
+				list[i] = item
+				return list
+			}
+		}
+		return append(list, item)
+	})
+}
+
+// prune removes the conflicts of this node which are not seen anymore.
+func (c *eipConflict) prune(ctx context.Context) error {
+	egwList := new(egressv1.EgressGatewayList)
+	if err := c.client.List(ctx, egwList); err != nil {
+		return err
+	}
+	for _, egw := range egwList.Items {
+		expired := false
+		for _, item := range egw.Status.Conflicts {
+			if item.Node == c.nodeName && time.Since(item.LastSeen.Time) > conflictExpire {
+				expired = true
+			}
+		}
+		if !expired {
+			continue
+		}
+		err := c.updateStatus(ctx, egw.Name, func(list []egressv1.EIPConflict) []egressv1.EIPConflict {
+			res := make([]egressv1.EIPConflict, 0, len(list))
+			for _, item := range list {
+				if item.Node == c.nodeName && time.Since(item.LastSeen.Time) > conflictExpire {
+					delete(c.reported, item.IP)
+					continue
+				}
+				res = append(res, item)
+			}
+			return res
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *eipConflict) updateStatus(ctx context.Context, name string, update func([]egressv1.EIPConflict) []egressv1.EIPConflict) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		egw := new(egressv1.EgressGateway)
+		if err := c.client.Get(ctx, types.NamespacedName{Name: name}, egw); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		egw.Status.Conflicts = update(egw.Status.Conflicts)
+		return c.client.Status().Update(ctx, egw)
+	})
+}
+
+// findGateway returns the EgressGateway which assigned ip to this node.
+func (c *eipConflict) findGateway(ctx context.Context, ip net.IP) (*egressv1.EgressGateway, error) {
+	egwList := new(egressv1.EgressGatewayList)
+	if err := c.client.List(ctx, egwList); err != nil {
+		return nil, fmt.Errorf("failed to list EgressGateway: %w", err)
+	}
+	for i, egw := range egwList.Items {
+		for _, eip := range egw.Status.GetNodeIPs(c.nodeName) {
+			if ip.Equal(net.ParseIP(eip.IPv4)) || ip.Equal(net.ParseIP(eip.IPv6)) {
+				return &egwList.Items[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
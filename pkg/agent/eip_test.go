@@ -0,0 +1,236 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/eiplease"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/layer2"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+// fakeAnnouncer keeps the announced IPs of each name
+type fakeAnnouncer struct {
+	ips map[string][]string
+	// deleted counts the calls to DeleteBalancer
+	deleted int
+	// conflicts are the IPs stopped after a conflict
+	conflicts map[string]bool
+}
+
+func newFakeAnnouncer() *fakeAnnouncer {
+	return &fakeAnnouncer{ips: make(map[string][]string), conflicts: make(map[string]bool)}
+}
+
+func (a *fakeAnnouncer) SetBalancer(name string, adv layer2.IPAdvertisement) {
+	ip := adv.IP().String()
+	for _, item := range a.ips[name] {
+		if item == ip {
+			return
+		}
+	}
+	a.ips[name] = append(a.ips[name], ip)
+}
+
+func (a *fakeAnnouncer) DeleteBalancer(name string) {
+	a.deleted++
+	delete(a.ips, name)
+}
+
+func (a *fakeAnnouncer) DeleteBalancerIP(name string, ip net.IP) {
+	res := make([]string, 0)
+	for _, item := range a.ips[name] {
+		if item != ip.String() {
+			res = append(res, item)
+		}
+	}
+	if len(res) == 0 {
+		delete(a.ips, name)
+		return
+	}
+	a.ips[name] = res
+}
+
+func (a *fakeAnnouncer) BalancerIPs(name string) []net.IP {
+	res := make([]net.IP, 0, len(a.ips[name]))
+	for _, item := range a.ips[name] {
+		res = append(res, net.ParseIP(item))
+	}
+	return res
+}
+
+func (a *fakeAnnouncer) Conflicted(ip net.IP) bool {
+	return a.conflicts[ip.String()]
+}
+
+func newTestEip(fence *eiplease.Fence, objs ...client.Object) (*eip, *fakeAnnouncer) {
+	cfg := &config.Config{}
+	cfg.NodeName = "node1"
+	an := newFakeAnnouncer()
+	return &eip{
+		client:   fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).Build(),
+		log:      logr.Discard(),
+		cfg:      cfg,
+		announce: an,
+		fence:    fence,
+	}, an
+}
+
+func TestSetBalancerLeaseLost(t *testing.T) {
+	fence := eiplease.NewFence(nil, nil, logr.Discard(), &config.Config{})
+	owned := map[string]bool{"10.6.1.21": true, "fd00::21": true}
+	patches := gomonkey.ApplyMethod(fence, "Owns", func(_ *eiplease.Fence, eip string) bool {
+		return owned[eip]
+	})
+	defer patches.Reset()
+
+	r, an := newTestEip(fence)
+	ctx := context.Background()
+	eips := []egressv1.Eip{{Ipv4: "10.6.1.21", Ipv6: "fd00::21"}}
+
+	assert.NoError(t, r.setBalancer(ctx, "default/policy", "", eips, logr.Discard()))
+	assert.Equal(t, []string{"10.6.1.21", "fd00::21"}, an.ips["default/policy"])
+
+	// only the EIP whose lease is lost is withdrawn
+	owned["10.6.1.21"] = false
+	assert.NoError(t, r.setBalancer(ctx, "default/policy", "", eips, logr.Discard()))
+	assert.Equal(t, []string{"fd00::21"}, an.ips["default/policy"])
+	assert.Equal(t, 0, an.deleted)
+
+	owned["10.6.1.21"] = true
+	assert.NoError(t, r.setBalancer(ctx, "default/policy", "", eips, logr.Discard()))
+	assert.ElementsMatch(t, []string{"10.6.1.21", "fd00::21"}, an.ips["default/policy"])
+}
+
+func TestReconcilePolicyExtraEip(t *testing.T) {
+	policy := &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"},
+		Status: egressv1.EgressPolicyStatus{
+			Eip:  egressv1.Eip{Ipv4: "10.6.1.21"},
+			Node: "node2",
+			Assignments: []egressv1.EipAssignment{
+				{Ipv4: "10.6.1.21", Node: "node2"},
+				{Ipv4: "10.6.1.22", Node: "node1"},
+				{Ipv4: "10.6.1.23", Node: "node1"},
+			},
+		},
+	}
+	r, an := newTestEip(nil, policy)
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy"}}
+
+	// node1 is not the node of the first EIP, it announces the other EIPs
+	// of the policy it holds
+	_, err := r.reconcilePolicy(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.6.1.22", "10.6.1.23"}, an.ips["default/policy"])
+
+	// an EIP moved to another node is withdrawn
+	assert.NoError(t, r.client.Get(ctx, req.NamespacedName, policy))
+	policy.Status.Assignments[2].Node = "node2"
+	assert.NoError(t, r.client.Update(ctx, policy))
+	_, err = r.reconcilePolicy(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.6.1.22"}, an.ips["default/policy"])
+
+	assert.NoError(t, r.client.Get(ctx, req.NamespacedName, policy))
+	policy.Status.Assignments[1].Node = "node2"
+	assert.NoError(t, r.client.Update(ctx, policy))
+	_, err = r.reconcilePolicy(ctx, req, logr.Discard())
+	assert.NoError(t, err)
+	assert.NotContains(t, an.ips, "default/policy")
+}
+
+func TestNodeEips(t *testing.T) {
+	cases := map[string]struct {
+		status egressv1.EgressPolicyStatus
+		exp    []egressv1.Eip
+	}{
+		"single EIP on the node": {
+			status: egressv1.EgressPolicyStatus{Eip: egressv1.Eip{Ipv4: "10.6.1.21"}, Node: "node1"},
+			exp:    []egressv1.Eip{{Ipv4: "10.6.1.21"}},
+		},
+		"single EIP on another node": {
+			status: egressv1.EgressPolicyStatus{Eip: egressv1.Eip{Ipv4: "10.6.1.21"}, Node: "node2"},
+			exp:    []egressv1.Eip{},
+		},
+		"assignments": {
+			status: egressv1.EgressPolicyStatus{
+				Eip:  egressv1.Eip{Ipv4: "10.6.1.21"},
+				Node: "node1",
+				Assignments: []egressv1.EipAssignment{
+					{Ipv4: "10.6.1.21", Ipv6: "fd00::21", Node: "node1"},
+					{Ipv4: "10.6.1.22", Node: "node2"},
+				},
+			},
+			exp: []egressv1.Eip{{Ipv4: "10.6.1.21", Ipv6: "fd00::21"}},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.exp, nodeEips("node1", tc.status))
+		})
+	}
+}
+
+func TestEipHeld(t *testing.T) {
+	fence := eiplease.NewFence(nil, nil, logr.Discard(), &config.Config{})
+	owned := map[string]bool{"10.6.1.21": true, "10.6.1.22": true}
+	patches := gomonkey.ApplyMethod(fence, "Owns", func(_ *eiplease.Fence, eip string) bool {
+		return owned[eip]
+	})
+	defer patches.Reset()
+
+	r, an := newTestEip(fence)
+	an.conflicts["10.6.1.22"] = true
+	assert.True(t, r.held("10.6.1.21"))
+	// stopped after a conflict
+	assert.False(t, r.held("10.6.1.22"))
+	// lease not held
+	assert.False(t, r.held("10.6.1.23"))
+	assert.False(t, r.held("invalid"))
+}
+
+func TestMapLinkPolicies(t *testing.T) {
+	gateway := func(name string, announcement *egressv1.Announcement, policy string) *egressv1.EgressGateway {
+		return &egressv1.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       egressv1.EgressGatewaySpec{Announcement: announcement},
+			Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{{
+				Name: "node1",
+				Eips: []egressv1.Eips{{IPv4: "10.6.1.21", Policies: []egressv1.Policy{{Namespace: "default", Name: policy}}}},
+			}}},
+		}
+	}
+	r, _ := newTestEip(nil,
+		gateway("vlan", &egressv1.Announcement{AnnouncementTarget: egressv1.AnnouncementTarget{VLAN: 100}}, "p1"),
+		gateway("pool-vlan", &egressv1.Announcement{Pools: []egressv1.PoolAnnouncement{{
+			IPPools: []string{"10.6.1.21"},
+			Nodes:   []egressv1.NodeAnnouncement{{Name: "node1", AnnouncementTarget: egressv1.AnnouncementTarget{VLAN: 200}}},
+		}}}, "p2"),
+		gateway("interfaces", &egressv1.Announcement{AnnouncementTarget: egressv1.AnnouncementTarget{Interfaces: []string{"eth1"}}}, "p3"),
+		gateway("all", nil, "p4"),
+	)
+
+	// only the policies announced on a VLAN are resolved again
+	res := r.mapLinkPolicies(context.Background(), &egressv1.EgressGateway{})
+	assert.ElementsMatch(t, []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/default", Name: "p1"}},
+		{NamespacedName: types.NamespacedName{Namespace: "EgressPolicy/default", Name: "p2"}},
+	}, res)
+}
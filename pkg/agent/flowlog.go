@@ -0,0 +1,37 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"github.com/spidernet-io/egressgateway/pkg/agent/flowlog"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// setFlowLogEgress gives the EIPs of the policies of this node to the flow log,
+// the connections of the dry run policies are not SNATed and not exported.
+func (r *policeReconciler) setFlowLogEgress(snatPolicies map[egressv1.Policy]*PolicyCommon) {
+	if r.flowLog == nil {
+		return
+	}
+	egress := make(map[egressv1.Policy]flowlog.Egress, len(snatPolicies))
+	for policy, val := range snatPolicies {
+		if val.DryRun {
+			continue
+		}
+		item := flowlog.Egress{NodeIP: val.UseNodeIP}
+		ips := []IP{val.IP}
+		for _, extra := range val.Extra {
+			ips = append(ips, extra.IP)
+		}
+		for _, ip := range ips {
+			for _, addr := range []string{ip.V4, ip.V6} {
+				if addr != "" {
+					item.IPs = append(item.IPs, addr)
+				}
+			}
+		}
+		egress[policy] = item
+	}
+	r.flowLog.SetEgress(egress)
+}
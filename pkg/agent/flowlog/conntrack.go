@@ -0,0 +1,186 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// the ctnetlink attributes of a conntrack event, from
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h
+const (
+	ctaTupleOrig      = 1
+	ctaTupleReply     = 2
+	ctaCountersOrig   = 9
+	ctaCountersReply  = 10
+	ctaTimestamp      = 20
+	ctaTupleIP        = 1
+	ctaTupleProto     = 2
+	ctaIPv4Src        = 1
+	ctaIPv4Dst        = 2
+	ctaIPv6Src        = 3
+	ctaIPv6Dst        = 4
+	ctaProtoNum       = 1
+	ctaProtoSrcPort   = 2
+	ctaProtoDstPort   = 3
+	ctaCountersPacket = 1
+	ctaCountersBytes  = 2
+	ctaTimestampStart = 1
+	ctaTimestampStop  = 2
+
+	// ipctnlMsgCtDelete is the message type of a destroyed connection
+	ipctnlMsgCtDelete = 2
+	// nfgenmsgLen is the length of the header before the attributes
+	nfgenmsgLen = 4
+)
+
+type tuple struct {
+	Src     net.IP
+	Dst     net.IP
+	SrcPort uint16
+	DstPort uint16
+}
+
+// flow is a destroyed connection.
+type flow struct {
+	Family       uint8
+	Protocol     uint8
+	Orig         tuple
+	Reply        tuple
+	OrigBytes    uint64
+	OrigPackets  uint64
+	ReplyBytes   uint64
+	ReplyPackets uint64
+	// Start and Stop are only set with net.netfilter.nf_conntrack_timestamp
+	Start time.Time
+	Stop  time.Time
+}
+
+// isDestroyEvent returns true if the netlink message type is a destroyed
+// connection.
+func isDestroyEvent(msgType uint16) bool {
+	return msgType == unix.NFNL_SUBSYS_CTNETLINK<<8|ipctnlMsgCtDelete
+}
+
+// parseFlow parses the payload of a ctnetlink message.
+func parseFlow(data []byte) (*flow, error) {
+	if len(data) < nfgenmsgLen {
+		return nil, fmt.Errorf("message too short: %d", len(data))
+	}
+	res := &flow{Family: data[0]}
+	err := walkAttrs(data[nfgenmsgLen:], func(attrType uint16, value []byte) error {
+		var err error
+		switch attrType {
+		case ctaTupleOrig:
+			res.Protocol, err = parseTuple(value, &res.Orig)
+		case ctaTupleReply:
+			_, err = parseTuple(value, &res.Reply)
+		case ctaCountersOrig:
+			res.OrigPackets, res.OrigBytes, err = parseCounters(value)
+		case ctaCountersReply:
+			res.ReplyPackets, res.ReplyBytes, err = parseCounters(value)
+		case ctaTimestamp:
+			res.Start, res.Stop, err = parseTimestamp(value)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Orig.Src == nil || res.Reply.Dst == nil {
+		return nil, fmt.Errorf("message without tuples")
+	}
+	return res, nil
+}
+
+// walkAttrs calls fn with the type and value of each netlink attribute.
+func walkAttrs(data []byte, fn func(attrType uint16, value []byte) error) error {
+	for len(data) >= unix.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		attrType := binary.NativeEndian.Uint16(data[2:4]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if length < unix.SizeofNlAttr || length > len(data) {
+			return fmt.Errorf("invalid attribute length %d", length)
+		}
+		if err := fn(attrType, data[unix.SizeofNlAttr:length]); err != nil {
+			return err
+		}
+		aligned := (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+	return nil
+}
+
+func parseTuple(data []byte, t *tuple) (uint8, error) {
+	var protocol uint8
+	err := walkAttrs(data, func(attrType uint16, value []byte) error {
+		switch attrType {
+		case ctaTupleIP:
+			return walkAttrs(value, func(attrType uint16, value []byte) error {
+				switch attrType {
+				case ctaIPv4Src, ctaIPv6Src:
+					t.Src = net.IP(append([]byte{}, value...))
+				case ctaIPv4Dst, ctaIPv6Dst:
+					t.Dst = net.IP(append([]byte{}, value...))
+				}
+				return nil
+			})
+		case ctaTupleProto:
+			return walkAttrs(value, func(attrType uint16, value []byte) error {
+				switch {
+				case attrType == ctaProtoNum && len(value) >= 1:
+					protocol = value[0]
+				case attrType == ctaProtoSrcPort && len(value) >= 2:
+					t.SrcPort = binary.BigEndian.Uint16(value)
+				case attrType == ctaProtoDstPort && len(value) >= 2:
+					t.DstPort = binary.BigEndian.Uint16(value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return protocol, err
+}
+
+func parseCounters(data []byte) (uint64, uint64, error) {
+	var packets, bytes uint64
+	err := walkAttrs(data, func(attrType uint16, value []byte) error {
+		if len(value) < 8 {
+			return nil
+		}
+		switch attrType {
+		case ctaCountersPacket:
+			packets = binary.BigEndian.Uint64(value)
+		case ctaCountersBytes:
+			bytes = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return packets, bytes, err
+}
+
+func parseTimestamp(data []byte) (time.Time, time.Time, error) {
+	var start, stop time.Time
+	err := walkAttrs(data, func(attrType uint16, value []byte) error {
+		if len(value) < 8 {
+			return nil
+		}
+		switch attrType {
+		case ctaTimestampStart:
+			start = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		case ctaTimestampStop:
+			stop = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		}
+		return nil
+	})
+	return start, stop, err
+}
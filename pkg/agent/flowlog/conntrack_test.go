@@ -0,0 +1,111 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func attr(attrType uint16, value []byte) []byte {
+	length := unix.SizeofNlAttr + len(value)
+	res := make([]byte, unix.SizeofNlAttr, (length+3)&^3)
+	binary.NativeEndian.PutUint16(res[0:], uint16(length))
+	binary.NativeEndian.PutUint16(res[2:], attrType)
+	res = append(res, value...)
+	for len(res)%unix.NLA_ALIGNTO != 0 {
+		res = append(res, 0)
+	}
+	return res
+}
+
+func nested(attrType uint16, attrs ...[]byte) []byte {
+	value := make([]byte, 0)
+	for _, item := range attrs {
+		value = append(value, item...)
+	}
+	return attr(attrType|unix.NLA_F_NESTED, value)
+}
+
+func be16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func be64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func tupleAttr(attrType uint16, src, dst string, srcPort, dstPort uint16) []byte {
+	return nested(attrType,
+		nested(ctaTupleIP,
+			attr(ctaIPv4Src, net.ParseIP(src).To4()),
+			attr(ctaIPv4Dst, net.ParseIP(dst).To4())),
+		nested(ctaTupleProto,
+			attr(ctaProtoNum, []byte{unix.IPPROTO_TCP}),
+			attr(ctaProtoSrcPort, be16(srcPort)),
+			attr(ctaProtoDstPort, be16(dstPort))))
+}
+
+// destroyEvent returns the payload of the event of a connection from the pod
+// to the destination SNATed to the EIP.
+func destroyEvent(pod, dst, eip string) []byte {
+	data := []byte{unix.AF_INET, 0, 0, 0}
+	data = append(data, tupleAttr(ctaTupleOrig, pod, dst, 40000, 443)...)
+	data = append(data, tupleAttr(ctaTupleReply, dst, eip, 443, 50000)...)
+	data = append(data, nested(ctaCountersOrig,
+		attr(ctaCountersPacket, be64(10)), attr(ctaCountersBytes, be64(1000)))...)
+	data = append(data, nested(ctaCountersReply,
+		attr(ctaCountersPacket, be64(20)), attr(ctaCountersBytes, be64(30000)))...)
+	data = append(data, nested(ctaTimestamp,
+		attr(ctaTimestampStart, be64(uint64(time.Unix(100, 0).UnixNano()))),
+		attr(ctaTimestampStop, be64(uint64(time.Unix(160, 0).UnixNano()))))...)
+	return data
+}
+
+func TestParseFlow(t *testing.T) {
+	f, err := parseFlow(destroyEvent("10.6.1.21", "1.1.1.1", "10.6.1.100"))
+	assert.NoError(t, err)
+	assert.Equal(t, &flow{
+		Family:   unix.AF_INET,
+		Protocol: unix.IPPROTO_TCP,
+		Orig: tuple{
+			Src: net.ParseIP("10.6.1.21").To4(), Dst: net.ParseIP("1.1.1.1").To4(),
+			SrcPort: 40000, DstPort: 443,
+		},
+		Reply: tuple{
+			Src: net.ParseIP("1.1.1.1").To4(), Dst: net.ParseIP("10.6.1.100").To4(),
+			SrcPort: 443, DstPort: 50000,
+		},
+		OrigBytes:    1000,
+		OrigPackets:  10,
+		ReplyBytes:   30000,
+		ReplyPackets: 20,
+		Start:        time.Unix(100, 0),
+		Stop:         time.Unix(160, 0),
+	}, f)
+}
+
+func TestParseFlowInvalid(t *testing.T) {
+	cases := map[string][]byte{
+		"short":          {unix.AF_INET},
+		"without tuples": {unix.AF_INET, 0, 0, 0},
+		"invalid length": append([]byte{unix.AF_INET, 0, 0, 0}, 0xff, 0, 1, 0),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseFlow(data)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestIsDestroyEvent(t *testing.T) {
+	assert.True(t, isDestroyEvent(unix.NFNL_SUBSYS_CTNETLINK<<8|2))
+	assert.False(t, isDestroyEvent(unix.NFNL_SUBSYS_CTNETLINK<<8))
+}
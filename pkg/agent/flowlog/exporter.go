@@ -0,0 +1,273 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package flowlog exports a record of each connection which left the gateway
+// node through an EIP. The records are built from the conntrack events of the
+// destroyed connections, and written as JSON lines or IPFIX messages to a local
+// file and a collector by a writer, so that a slow sink does not hold the
+// receive loop of the events.
+package flowlog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/podindex"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// templateInterval is how often the IPFIX templates are sent again, for
+	// the collectors which were restarted
+	templateInterval = time.Minute
+	receiveTimeout   = time.Second
+	// queueSize is the number of records waiting for the writer, the records
+	// beyond it are dropped
+	queueSize = 4096
+)
+
+// Egress are the EIPs of a policy on the gateway node.
+type Egress struct {
+	IPs []string
+	// NodeIP is set when the policy uses the IP of the node
+	NodeIP bool
+}
+
+type target struct {
+	sink       sink
+	encoder    encoder
+	templateAt time.Time
+}
+
+// Exporter writes the records of the connections of the policies.
+type Exporter struct {
+	log      logr.Logger
+	nodeName string
+	index    *podindex.Index
+	procRoot string
+	now      func() time.Time
+
+	mu      sync.RWMutex
+	egress  map[egressv1.Policy]Egress
+	targets []*target
+	queue   chan *Record
+}
+
+func New(log logr.Logger, cfg *config.Config, index *podindex.Index) (*Exporter, error) {
+	flowLog := cfg.FileConfig.FlowLog
+	e := &Exporter{
+		log:      log.WithName("flowLog"),
+		nodeName: cfg.NodeName,
+		index:    index,
+		procRoot: "/proc",
+		now:      time.Now,
+		egress:   make(map[egressv1.Policy]Egress),
+		queue:    make(chan *Record, queueSize),
+	}
+	newEncoder := func() encoder {
+		if flowLog.Format == "ipfix" {
+			return &ipfixEncoder{}
+		}
+		return jsonEncoder{}
+	}
+	if flowLog.File != "" {
+		e.targets = append(e.targets, &target{sink: newFileSink(flowLog.File, flowLog.MaxFileSizeMB), encoder: newEncoder()})
+	}
+	if flowLog.Collector != "" {
+		s, err := newConnSink(flowLog.Collector)
+		if err != nil {
+			return nil, err
+		}
+		e.targets = append(e.targets, &target{sink: s, encoder: newEncoder()})
+	}
+	return e, nil
+}
+
+// SetEgress sets the policies whose connections are exported.
+func (e *Exporter) SetEgress(egress map[egressv1.Policy]Egress) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.egress = egress
+}
+
+func (e *Exporter) Start(ctx context.Context) error {
+	// the counters and the start time of the connections are only kept with
+	// these sysctls
+	for _, name := range []string{"nf_conntrack_acct", "nf_conntrack_timestamp"} {
+		path := filepath.Join(e.procRoot, "sys/net/netfilter", name)
+		if err := os.WriteFile(path, []byte("1"), 0o644); err != nil {
+			e.log.Error(err, "failed to enable sysctl, the records will miss its fields", "path", path)
+		}
+	}
+
+	sock, err := nl.Subscribe(unix.NETLINK_NETFILTER, unix.NFNLGRP_CONNTRACK_DESTROY)
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	timeout := unix.NsecToTimeval(receiveTimeout.Nanoseconds())
+	if err := sock.SetReceiveTimeout(&timeout); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.write(ctx)
+	}()
+	defer func() {
+		wg.Wait()
+		for _, t := range e.targets {
+			t.sink.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		msgs, _, err := sock.Receive()
+		if err != nil {
+			switch {
+			case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+			case errors.Is(err, unix.ENOBUFS):
+				countLostEvents.Inc()
+			default:
+				e.log.Error(err, "failed to receive conntrack events")
+				time.Sleep(receiveTimeout)
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			if !isDestroyEvent(msg.Header.Type) {
+				continue
+			}
+			f, err := parseFlow(msg.Data)
+			if err != nil {
+				e.log.V(1).Info("failed to parse conntrack event", "err", err)
+				continue
+			}
+			if record := e.match(f); record != nil {
+				e.enqueue(record)
+			}
+		}
+	}
+}
+
+// match returns the record of the flow when it left through an EIP of a policy
+// of its source.
+func (e *Exporter) match(f *flow) *Record {
+	src := f.Orig.Src.String()
+	policies := e.index.Policies(src)
+	if len(policies) == 0 {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	// the reply of a SNATed connection is sent to the EIP
+	eip := f.Reply.Dst
+	for _, policy := range policies {
+		egress, ok := e.egress[policy]
+		if !ok || !(containsIP(egress.IPs, eip) || egress.NodeIP && !eip.Equal(f.Orig.Src)) {
+			continue
+		}
+		record := &Record{
+			Time:            f.Stop,
+			Start:           f.Start,
+			Node:            e.nodeName,
+			PolicyNamespace: policy.Namespace,
+			Policy:          policy.Name,
+			Protocol:        f.Protocol,
+			SrcIP:           f.Orig.Src,
+			SrcPort:         f.Orig.SrcPort,
+			DstIP:           f.Orig.Dst,
+			DstPort:         f.Orig.DstPort,
+			EIP:             eip,
+			EIPPort:         f.Reply.DstPort,
+			Bytes:           f.OrigBytes,
+			Packets:         f.OrigPackets,
+			ReplyBytes:      f.ReplyBytes,
+			ReplyPackets:    f.ReplyPackets,
+		}
+		if record.Time.IsZero() {
+			record.Time = e.now()
+		}
+		if pod, ok := e.index.Pod(src); ok {
+			record.Namespace = pod.Namespace
+			record.Pod = pod.Name
+		}
+		return record
+	}
+	return nil
+}
+
+// enqueue queues the record for the writer, it is dropped when the queue is
+// full.
+func (e *Exporter) enqueue(record *Record) {
+	select {
+	case e.queue <- record:
+	default:
+		e.log.V(1).Info("flow log queue is full, the record is dropped",
+			"policyNamespace", record.PolicyNamespace, "policy", record.Policy)
+		for _, t := range e.targets {
+			countErrors.WithLabelValues(t.sink.Name()).Inc()
+		}
+	}
+}
+
+// write exports the queued records until the context is done.
+func (e *Exporter) write(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-e.queue:
+			e.export(record)
+		}
+	}
+}
+
+// export writes the record to the sinks, the templates are sent first when
+// they are due or the last write failed.
+func (e *Exporter) export(record *Record) {
+	now := e.now()
+	for _, t := range e.targets {
+		withTemplate := now.Sub(t.templateAt) >= templateInterval
+		msgs, err := t.encoder.Encode(record, withTemplate)
+		if err == nil {
+			err = t.sink.Write(msgs)
+		}
+		if err != nil {
+			countErrors.WithLabelValues(t.sink.Name()).Inc()
+			e.log.V(1).Info("failed to export flow record", "sink", t.sink.Name(), "err", err)
+			t.templateAt = time.Time{}
+			continue
+		}
+		if withTemplate {
+			t.templateAt = now
+		}
+		countRecords.WithLabelValues(t.sink.Name()).Inc()
+	}
+}
+
+func containsIP(ips []string, ip net.IP) bool {
+	for _, item := range ips {
+		if parsed := net.ParseIP(item); parsed != nil && parsed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
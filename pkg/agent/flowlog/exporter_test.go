@@ -0,0 +1,209 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/podindex"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	policy1 = egressv1.Policy{Namespace: "default", Name: "p1"}
+	policy2 = egressv1.Policy{Name: "c1"}
+)
+
+func newTestExporter(t *testing.T, flowLog config.FlowLog) *Exporter {
+	index := podindex.New("node1")
+	index.UpdateSlice(types.NamespacedName{Namespace: "default", Name: "p1-s1"}, policy1, []egressv1.EgressEndpoint{
+		{Namespace: "default", Pod: "pod1", Node: "node2", IPv4: []string{"10.6.1.21"}},
+	})
+	index.UpdateSlice(types.NamespacedName{Name: "c1-s1"}, policy2, []egressv1.EgressEndpoint{
+		{Namespace: "kube-system", Pod: "pod2", Node: "node1", IPv4: []string{"10.6.1.22"}},
+	})
+	cfg := &config.Config{}
+	cfg.NodeName = "node1"
+	cfg.FileConfig.FlowLog = flowLog
+	e, err := New(logr.Discard(), cfg, index)
+	assert.NoError(t, err)
+	e.SetEgress(map[egressv1.Policy]Egress{
+		policy1: {IPs: []string{"10.6.1.100"}},
+		policy2: {NodeIP: true},
+	})
+	return e
+}
+
+func TestMatch(t *testing.T) {
+	e := newTestExporter(t, config.FlowLog{})
+	cases := map[string]struct {
+		event     []byte
+		expPolicy egressv1.Policy
+		expPod    string
+	}{
+		"eip of the policy": {
+			event:     destroyEvent("10.6.1.21", "1.1.1.1", "10.6.1.100"),
+			expPolicy: policy1,
+			expPod:    "pod1",
+		},
+		"eip of another policy": {
+			event: destroyEvent("10.6.1.21", "1.1.1.1", "10.6.1.101"),
+		},
+		"not SNATed": {
+			event: destroyEvent("10.6.1.22", "1.1.1.1", "10.6.1.22"),
+		},
+		"node ip": {
+			event:     destroyEvent("10.6.1.22", "1.1.1.1", "172.18.0.2"),
+			expPolicy: policy2,
+			expPod:    "pod2",
+		},
+		"pod without policy": {
+			event: destroyEvent("10.6.1.23", "1.1.1.1", "10.6.1.100"),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			f, err := parseFlow(tc.event)
+			assert.NoError(t, err)
+			record := e.match(f)
+			if tc.expPod == "" {
+				assert.Nil(t, record)
+				return
+			}
+			assert.NotNil(t, record)
+			assert.Equal(t, tc.expPolicy, egressv1.Policy{Namespace: record.PolicyNamespace, Name: record.Policy})
+			assert.Equal(t, tc.expPod, record.Pod)
+			assert.Equal(t, "node1", record.Node)
+			assert.Equal(t, uint64(1000), record.Bytes)
+			assert.Equal(t, uint64(30000), record.ReplyBytes)
+			assert.Equal(t, time.Unix(100, 0), record.Start)
+		})
+	}
+}
+
+func TestExportFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flow.log")
+	e := newTestExporter(t, config.FlowLog{Format: "json", File: path})
+	f, err := parseFlow(destroyEvent("10.6.1.21", "1.1.1.1", "10.6.1.100"))
+	assert.NoError(t, err)
+	e.export(e.match(f))
+	e.export(e.match(f))
+	e.targets[0].sink.Close()
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lines := 0
+	for scanner.Scan() {
+		record := new(Record)
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), record))
+		assert.Equal(t, "pod1", record.Pod)
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+}
+
+func TestExportCollector(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	e := newTestExporter(t, config.FlowLog{Format: "ipfix", Collector: "udp://" + conn.LocalAddr().String()})
+	f, err := parseFlow(destroyEvent("10.6.1.21", "1.1.1.1", "10.6.1.100"))
+	assert.NoError(t, err)
+	e.export(e.match(f))
+	e.export(e.match(f))
+	defer e.targets[0].sink.Close()
+
+	// the templates are only sent with the first record
+	buf := make([]byte, 1500)
+	for i := 0; i < 4; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(ipfixVersion), uint16(buf[0])<<8|uint16(buf[1]))
+		assert.Equal(t, n, int(buf[2])<<8|int(buf[3]))
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buf)
+	assert.Error(t, err)
+}
+
+func TestEnqueue(t *testing.T) {
+	e := newTestExporter(t, config.FlowLog{Format: "json", File: filepath.Join(t.TempDir(), "flow.log")})
+	e.queue = make(chan *Record, 1)
+	f, err := parseFlow(destroyEvent("10.6.1.21", "1.1.1.1", "10.6.1.100"))
+	assert.NoError(t, err)
+
+	// the record beyond the queue is dropped instead of holding the receive loop
+	e.enqueue(e.match(f))
+	e.enqueue(e.match(f))
+	assert.Len(t, e.queue, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.write(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return len(e.queue) == 0 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flow.log")
+	s := newFileSink(path, 1)
+	defer s.Close()
+	msg := make([]byte, 600<<10)
+
+	assert.NoError(t, s.Write([][]byte{msg}))
+	assert.NoError(t, s.Write([][]byte{msg}))
+	assert.NoFileExists(t, path+".1")
+	// the file reached 1MiB, it is rotated before the next write
+	assert.NoError(t, s.Write([][]byte{msg}))
+	for file, size := range map[string]int64{path: 600 << 10, path + ".1": 1200 << 10} {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		assert.Equal(t, size, info.Size(), file)
+	}
+}
+
+func TestConnSinkBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	s, err := newConnSink("tcp://" + address)
+	assert.NoError(t, err)
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	assert.Error(t, s.Write([][]byte{[]byte("record")}))
+	assert.Equal(t, minBackoff, s.backoff)
+	// the collector is not dialed before the end of the backoff
+	assert.ErrorContains(t, s.Write([][]byte{[]byte("record")}), "next attempt in 1s")
+
+	now = now.Add(minBackoff)
+	assert.Error(t, s.Write([][]byte{[]byte("record")}))
+	assert.Equal(t, 2*minBackoff, s.backoff)
+	s.backoff = maxBackoff
+	now = now.Add(2 * minBackoff)
+	assert.Error(t, s.Write([][]byte{[]byte("record")}))
+	assert.Equal(t, maxBackoff, s.backoff)
+}
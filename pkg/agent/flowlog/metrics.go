@@ -0,0 +1,37 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	countRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "flow_log",
+		Name:      "records_total",
+		Help:      "Number of flow records exported to the sink",
+	}, []string{"sink"})
+
+	countErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "flow_log",
+		Name:      "errors_total",
+		Help:      "Number of flow records which could not be exported to the sink",
+	}, []string{"sink"})
+
+	countLostEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "flow_log",
+		Name:      "lost_events_total",
+		Help:      "Number of times conntrack events were lost because the socket buffer was full",
+	})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		countRecords,
+		countErrors,
+		countLostEvents,
+	}
+}
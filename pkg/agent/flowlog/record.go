@@ -0,0 +1,183 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// Record is the flow record of a connection which left through an EIP.
+type Record struct {
+	// Time is when the connection was destroyed
+	Time time.Time `json:"time"`
+	// Start is when the connection was created, zero without
+	// net.netfilter.nf_conntrack_timestamp
+	Start           time.Time `json:"start,omitempty"`
+	Node            string    `json:"node"`
+	Namespace       string    `json:"namespace,omitempty"`
+	Pod             string    `json:"pod,omitempty"`
+	PolicyNamespace string    `json:"policyNamespace,omitempty"`
+	Policy          string    `json:"policy"`
+	Protocol        uint8     `json:"protocol"`
+	SrcIP           net.IP    `json:"srcIP"`
+	SrcPort         uint16    `json:"srcPort"`
+	DstIP           net.IP    `json:"dstIP"`
+	DstPort         uint16    `json:"dstPort"`
+	EIP             net.IP    `json:"eip"`
+	EIPPort         uint16    `json:"eipPort"`
+	// the counters are only set with net.netfilter.nf_conntrack_acct
+	Bytes        uint64 `json:"bytes"`
+	Packets      uint64 `json:"packets"`
+	ReplyBytes   uint64 `json:"replyBytes"`
+	ReplyPackets uint64 `json:"replyPackets"`
+}
+
+// encoder turns records into the messages written to a sink.
+type encoder interface {
+	// Encode returns the messages of the record, with a template first when
+	// withTemplate is set and the format has one
+	Encode(record *Record, withTemplate bool) ([][]byte, error)
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(record *Record, _ bool) ([][]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{append(data, '\n')}, nil
+}
+
+// the IPFIX information elements of the records, from
+// https://www.iana.org/assignments/ipfix/ipfix.xhtml
+const (
+	ieOctetDeltaCount             = 1
+	iePacketDeltaCount            = 2
+	ieProtocolIdentifier          = 4
+	ieSourceTransportPort         = 7
+	ieSourceIPv4Address           = 8
+	ieDestinationTransportPort    = 11
+	ieDestinationIPv4Address      = 12
+	ieSourceIPv6Address           = 27
+	ieDestinationIPv6Address      = 28
+	ieFlowStartMilliseconds       = 152
+	ieFlowEndMilliseconds         = 153
+	iePostNATSourceIPv4Address    = 225
+	iePostNAPTSourceTransportPort = 227
+	iePostNATSourceIPv6Address    = 281
+
+	ipfixVersion   = 10
+	ipfixHeaderLen = 16
+	templateSetID  = 2
+	templateIDv4   = 256
+	templateIDv6   = 257
+	setHeaderLen   = 4
+)
+
+type field struct {
+	id     uint16
+	length uint16
+}
+
+// templateFields are the fields of the templates, the addresses are 4 bytes in
+// the IPv4 template and 16 bytes in the IPv6 one
+func templateFields(v6 bool) []field {
+	src, dst, eip, size := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(iePostNATSourceIPv4Address), uint16(net.IPv4len)
+	if v6 {
+		src, dst, eip, size = ieSourceIPv6Address, ieDestinationIPv6Address, iePostNATSourceIPv6Address, net.IPv6len
+	}
+	return []field{
+		{id: src, length: size},
+		{id: dst, length: size},
+		{id: ieSourceTransportPort, length: 2},
+		{id: ieDestinationTransportPort, length: 2},
+		{id: ieProtocolIdentifier, length: 1},
+		{id: eip, length: size},
+		{id: iePostNAPTSourceTransportPort, length: 2},
+		{id: ieOctetDeltaCount, length: 8},
+		{id: iePacketDeltaCount, length: 8},
+		{id: ieFlowStartMilliseconds, length: 8},
+		{id: ieFlowEndMilliseconds, length: 8},
+	}
+}
+
+// ipfixEncoder encodes a message per record. The pod and the policy are not
+// standard information elements, they are only in the JSON records.
+type ipfixEncoder struct {
+	domain   uint32
+	sequence uint32
+}
+
+func (e *ipfixEncoder) Encode(record *Record, withTemplate bool) ([][]byte, error) {
+	v6 := record.SrcIP.To4() == nil
+	res := make([][]byte, 0, 2)
+	if withTemplate {
+		res = append(res, e.message(record.Time, e.templateSet(v6), 0),
+			e.message(record.Time, e.templateSet(!v6), 0))
+	}
+	return append(res, e.message(record.Time, e.dataSet(record, v6), 1)), nil
+}
+
+func (e *ipfixEncoder) templateSet(v6 bool) []byte {
+	fields := templateFields(v6)
+	id := uint16(templateIDv4)
+	if v6 {
+		id = templateIDv6
+	}
+	set := make([]byte, setHeaderLen+4+4*len(fields))
+	binary.BigEndian.PutUint16(set[0:], templateSetID)
+	binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+	binary.BigEndian.PutUint16(set[4:], id)
+	binary.BigEndian.PutUint16(set[6:], uint16(len(fields)))
+	for i, item := range fields {
+		binary.BigEndian.PutUint16(set[8+4*i:], item.id)
+		binary.BigEndian.PutUint16(set[10+4*i:], item.length)
+	}
+	return set
+}
+
+func (e *ipfixEncoder) dataSet(record *Record, v6 bool) []byte {
+	id := uint16(templateIDv4)
+	ip := func(ip net.IP) []byte { return ip.To4() }
+	if v6 {
+		id = templateIDv6
+		ip = func(ip net.IP) []byte { return ip.To16() }
+	}
+	set := make([]byte, setHeaderLen, 64)
+	binary.BigEndian.PutUint16(set[0:], id)
+	set = append(set, ip(record.SrcIP)...)
+	set = append(set, ip(record.DstIP)...)
+	set = binary.BigEndian.AppendUint16(set, record.SrcPort)
+	set = binary.BigEndian.AppendUint16(set, record.DstPort)
+	set = append(set, record.Protocol)
+	set = append(set, ip(record.EIP)...)
+	set = binary.BigEndian.AppendUint16(set, record.EIPPort)
+	set = binary.BigEndian.AppendUint64(set, record.Bytes+record.ReplyBytes)
+	set = binary.BigEndian.AppendUint64(set, record.Packets+record.ReplyPackets)
+	start := record.Start
+	if start.IsZero() {
+		start = record.Time
+	}
+	set = binary.BigEndian.AppendUint64(set, uint64(start.UnixMilli()))
+	set = binary.BigEndian.AppendUint64(set, uint64(record.Time.UnixMilli()))
+	binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+	return set
+}
+
+// message wraps the set with the message header, records is the number of
+// data records in the set for the sequence number.
+func (e *ipfixEncoder) message(now time.Time, set []byte, records uint32) []byte {
+	res := make([]byte, ipfixHeaderLen, ipfixHeaderLen+len(set))
+	binary.BigEndian.PutUint16(res[0:], ipfixVersion)
+	binary.BigEndian.PutUint16(res[2:], uint16(ipfixHeaderLen+len(set)))
+	binary.BigEndian.PutUint32(res[4:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(res[8:], e.sequence)
+	binary.BigEndian.PutUint32(res[12:], e.domain)
+	e.sequence += records
+	return append(res, set...)
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecord(src, dst, eip string) *Record {
+	return &Record{
+		Time:      time.Unix(160, 0).UTC(),
+		Start:     time.Unix(100, 0).UTC(),
+		Node:      "node1",
+		Namespace: "default",
+		Pod:       "pod1",
+		Policy:    "p1",
+		Protocol:  6,
+		SrcIP:     net.ParseIP(src),
+		SrcPort:   40000,
+		DstIP:     net.ParseIP(dst),
+		DstPort:   443,
+		EIP:       net.ParseIP(eip),
+		EIPPort:   50000,
+		Bytes:     1000,
+		Packets:   10,
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	msgs, err := jsonEncoder{}.Encode(testRecord("10.6.1.21", "1.1.1.1", "10.6.1.100"), true)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, byte('\n'), msgs[0][len(msgs[0])-1])
+
+	res := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(msgs[0], &res))
+	assert.Equal(t, "default", res["namespace"])
+	assert.Equal(t, "pod1", res["pod"])
+	assert.Equal(t, "p1", res["policy"])
+	assert.Equal(t, "10.6.1.100", res["eip"])
+	assert.Equal(t, float64(50000), res["eipPort"])
+	assert.NotContains(t, res, "policyNamespace")
+}
+
+func TestIPFIXEncoder(t *testing.T) {
+	cases := map[string]struct {
+		record     *Record
+		templateID uint16
+		dataLen    int
+	}{
+		"ipv4": {
+			record:     testRecord("10.6.1.21", "1.1.1.1", "10.6.1.100"),
+			templateID: templateIDv4,
+			dataLen:    setHeaderLen + 3*4 + 2*3 + 1 + 8*4,
+		},
+		"ipv6": {
+			record:     testRecord("fd00::21", "2001:db8::1", "fd00::100"),
+			templateID: templateIDv6,
+			dataLen:    setHeaderLen + 3*16 + 2*3 + 1 + 8*4,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := &ipfixEncoder{}
+			msgs, err := e.Encode(tc.record, true)
+			assert.NoError(t, err)
+			// the templates of both families and the data
+			assert.Len(t, msgs, 3)
+			for _, msg := range msgs {
+				assert.Equal(t, uint16(ipfixVersion), binary.BigEndian.Uint16(msg[0:]))
+				assert.Equal(t, len(msg), int(binary.BigEndian.Uint16(msg[2:])))
+			}
+			assert.Equal(t, uint16(templateSetID), binary.BigEndian.Uint16(msgs[0][ipfixHeaderLen:]))
+			assert.Equal(t, tc.templateID, binary.BigEndian.Uint16(msgs[0][ipfixHeaderLen+4:]))
+
+			data := msgs[2][ipfixHeaderLen:]
+			assert.Equal(t, tc.templateID, binary.BigEndian.Uint16(data[0:]))
+			assert.Equal(t, tc.dataLen, int(binary.BigEndian.Uint16(data[2:])))
+			assert.Equal(t, tc.dataLen, len(data))
+			assert.Equal(t, uint32(0), binary.BigEndian.Uint32(msgs[2][8:]))
+
+			msgs, err = e.Encode(tc.record, false)
+			assert.NoError(t, err)
+			assert.Len(t, msgs, 1)
+			// the sequence counts the data records sent before the message
+			assert.Equal(t, uint32(1), binary.BigEndian.Uint32(msgs[0][8:]))
+		})
+	}
+}
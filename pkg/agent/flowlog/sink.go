@@ -0,0 +1,155 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// minBackoff and maxBackoff bound the delay before the collector is
+	// connected again after an error, it doubles at each error
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	// defaultMaxFileSizeMB is the size at which the file is rotated
+	defaultMaxFileSizeMB = 100
+)
+
+// sink is where the messages of the records are written.
+type sink interface {
+	Name() string
+	Write(msgs [][]byte) error
+	Close()
+}
+
+// fileSink appends the messages to a local file. The file is rotated when it
+// reaches the max size, the previous records are kept in one file with the .1
+// suffix.
+type fileSink struct {
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+func newFileSink(path string, maxSizeMB int) *fileSink {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxFileSizeMB
+	}
+	return &fileSink{path: path, maxSize: int64(maxSizeMB) << 20}
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Write(msgs [][]byte) error {
+	if s.file != nil && s.size >= s.maxSize {
+		s.Close()
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		s.file = file
+		s.size = info.Size()
+	}
+	for _, msg := range msgs {
+		n, err := s.file.Write(msg)
+		s.size += int64(n)
+		if err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSink) Close() {
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+}
+
+// connSink sends the messages to a collector, it connects again after an error
+// once the backoff is over, the writes fail in the meantime.
+type connSink struct {
+	network string
+	address string
+	conn    net.Conn
+	now     func() time.Time
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// newConnSink returns the sink of a tcp://host:port or udp://host:port
+// collector.
+func newConnSink(collector string) (*connSink, error) {
+	u, err := url.Parse(collector)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("unsupported collector scheme %q", u.Scheme)
+	}
+	return &connSink{network: u.Scheme, address: u.Host, now: time.Now}, nil
+}
+
+func (s *connSink) Name() string {
+	return "collector"
+}
+
+func (s *connSink) Write(msgs [][]byte) error {
+	if s.conn == nil {
+		if now := s.now(); now.Before(s.retryAt) {
+			return fmt.Errorf("collector %s is not connected, next attempt in %s", s.address, s.retryAt.Sub(now))
+		}
+		conn, err := net.DialTimeout(s.network, s.address, dialTimeout)
+		if err != nil {
+			s.fail()
+			return err
+		}
+		s.conn = conn
+	}
+	for _, msg := range msgs {
+		_ = s.conn.SetWriteDeadline(s.now().Add(dialTimeout))
+		if _, err := s.conn.Write(msg); err != nil {
+			s.Close()
+			s.fail()
+			return err
+		}
+	}
+	s.backoff = 0
+	return nil
+}
+
+// fail delays the next connection, the delay doubles at each error.
+func (s *connSink) fail() {
+	s.backoff = min(max(2*s.backoff, minBackoff), maxBackoff)
+	s.retryAt = s.now().Add(s.backoff)
+}
+
+func (s *connSink) Close() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
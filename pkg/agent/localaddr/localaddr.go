@@ -0,0 +1,365 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package localaddr adds the EIPs of the gateway node to host interfaces, so
+// that host tools, firewalls and reply paths can see them.
+package localaddr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+// DefaultDevice is the dummy device receiving the EIPs announced on all
+// interfaces, and the IPv6 EIPs.
+const DefaultDevice = "egress-eip"
+
+// labelSuffix tags the IPv4 addresses added by the agent, the label of an
+// address starts with the name of its interface and is at most 15 characters.
+const (
+	labelSuffix = ":egw"
+	maxLabelLen = unix.IFNAMSIZ - 1
+)
+
+// arpSysctls make the kernel answer ARP only on the interface of the address,
+// and not use the EIPs as source of its requests, so that only the announcer
+// decides which node answers for an EIP.
+var arpSysctls = map[string]int{
+	"net/ipv4/conf/all/arp_ignore":   1,
+	"net/ipv4/conf/all/arp_announce": 2,
+}
+
+// resyncPeriod repairs the addresses removed by other tools.
+const resyncPeriod = time.Minute
+
+// Netlink is the subset of netlink.Handle used to manage the addresses.
+type Netlink interface {
+	LinkList() ([]netlink.Link, error)
+	LinkByName(name string) (netlink.Link, error)
+	LinkAdd(link netlink.Link) error
+	LinkSetUp(link netlink.Link) error
+	LinkDel(link netlink.Link) error
+	AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd(link netlink.Link, addr *netlink.Addr) error
+	AddrDel(link netlink.Link, addr *netlink.Addr) error
+}
+
+// InterfaceResolver returns the announcement interfaces of eip, all is true
+// when it is announced on all interfaces.
+type InterfaceResolver func(egw *egressv1.EgressGateway, eip string) (all bool, interfaces sets.Set[string], err error)
+
+var syncRequest = reconcile.Request{}
+
+type localAddress struct {
+	client   client.Client
+	log      logr.Logger
+	nodeName string
+	netlink  Netlink
+	resolver InterfaceResolver
+	// held reports whether the node answers for the EIP
+	held func(eip string) bool
+	// sysctl raises a sysctl to at least value
+	sysctl func(name string, value int) error
+}
+
+func (r *localAddress) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if err := r.sync(ctx); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	return reconcile.Result{RequeueAfter: resyncPeriod}, nil
+}
+
+// sync makes the local addresses follow EgressGatewayStatus.NodeList, only the
+// EIPs the node answers for are added: their lease is held and they are not
+// stopped after a conflict. The addresses managed by the agent are the ones of
+// DefaultDevice and the IPv4 addresses labelled with labelSuffix, they are
+// removed when the EIP moves, when the policy is deleted, and when the option
+// is disabled, also those left by a previous agent.
+func (r *localAddress) sync(ctx context.Context) error {
+	egwList := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, egwList); err != nil {
+		return err
+	}
+
+	desired := make(map[string]sets.Set[string])
+	dummies := sets.New[string]()
+	arp := false
+	for i := range egwList.Items {
+		egw := &egwList.Items[i]
+		if egw.Spec.LocalAddress == nil || !egw.Spec.LocalAddress.Enable {
+			continue
+		}
+		for _, eip := range egw.Status.GetNodeIPs(r.nodeName) {
+			for _, item := range []string{eip.IPv4, eip.IPv6} {
+				addr := net.ParseIP(item)
+				if addr == nil {
+					continue
+				}
+				if !r.held(addr.String()) {
+					r.log.V(1).Info("EIP is not held by the node, it is not added to the interfaces", "eip", item)
+					continue
+				}
+				arp = arp || addr.To4() != nil
+				devices, dummy, err := r.devices(egw, item)
+				if err != nil {
+					return err
+				}
+				if dummy != "" {
+					dummies.Insert(dummy)
+				}
+				for _, device := range devices {
+					if desired[device] == nil {
+						desired[device] = sets.New[string]()
+					}
+					desired[device].Insert(addr.String())
+				}
+			}
+		}
+	}
+
+	for _, name := range sets.List(dummies) {
+		if err := r.ensureDummy(name); err != nil {
+			return err
+		}
+	}
+	if arp {
+		if err := r.ensureARP(); err != nil {
+			return err
+		}
+	}
+
+	links, err := r.netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	errs := make([]error, 0)
+	for _, link := range links {
+		name := link.Attrs().Name
+		current, err := r.managedAddrs(link)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		want := desired[name]
+		if want == nil {
+			want = sets.New[string]()
+		}
+		for _, item := range sets.List(want.Difference(current)) {
+			if err := r.netlink.AddrAdd(link, newAddr(item, name)); err != nil && !errors.Is(err, unix.EEXIST) {
+				errs = append(errs, fmt.Errorf("failed to add EIP %s to %s: %w", item, name, err))
+				continue
+			}
+			r.log.Info("added EIP to interface", "eip", item, "interface", name)
+		}
+		for _, item := range sets.List(current.Difference(want)) {
+			if err := r.netlink.AddrDel(link, newAddr(item, name)); err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+				errs = append(errs, fmt.Errorf("failed to delete EIP %s from %s: %w", item, name, err))
+				continue
+			}
+			r.log.Info("deleted EIP from interface", "eip", item, "interface", name)
+		}
+		delete(desired, name)
+
+		// the default device only exists for the EIPs
+		if name == DefaultDevice && want.Len() == 0 {
+			if err := r.netlink.LinkDel(link); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete %s: %w", name, err))
+			}
+		}
+	}
+	for name := range desired {
+		r.log.Info("interface of local EIP address not found", "interface", name)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// devices returns the devices receiving eip, and the name of the dummy device
+// to create if it does not exist. The IPv6 addresses can not be labelled, they
+// are only added to DefaultDevice, the kernel answers NDP only on the interface
+// of the address anyway. The interfaces whose name is too long for the label
+// are replaced by DefaultDevice too.
+func (r *localAddress) devices(egw *egressv1.EgressGateway, eip string) ([]string, string, error) {
+	if net.ParseIP(eip).To4() == nil {
+		return []string{DefaultDevice}, DefaultDevice, nil
+	}
+	if name := egw.Spec.LocalAddress.Interface; name != "" {
+		if len(name+labelSuffix) > maxLabelLen {
+			r.log.Info("interface name is too long to label the EIP, it is added to the default device",
+				"eip", eip, "interface", name, "device", DefaultDevice)
+			return []string{DefaultDevice}, DefaultDevice, nil
+		}
+		return []string{name}, name, nil
+	}
+	all, interfaces, err := r.resolver(egw, eip)
+	if err != nil {
+		return nil, "", err
+	}
+	if all || interfaces.Len() == 0 {
+		return []string{DefaultDevice}, DefaultDevice, nil
+	}
+	res := sets.New[string]()
+	dummy := ""
+	for _, name := range sets.List(interfaces) {
+		if len(name+labelSuffix) > maxLabelLen {
+			r.log.Info("interface name is too long to label the EIP, it is added to the default device",
+				"eip", eip, "interface", name, "device", DefaultDevice)
+			name, dummy = DefaultDevice, DefaultDevice
+		}
+		res.Insert(name)
+	}
+	return sets.List(res), dummy, nil
+}
+
+// ensureARP raises the ARP sysctls, they are not restored when the option is
+// disabled since the node may use them for other reasons.
+func (r *localAddress) ensureARP() error {
+	for name, value := range arpSysctls {
+		if err := r.sysctl(name, value); err != nil {
+			return fmt.Errorf("failed to set sysctl %s to %d: %w", name, value, err)
+		}
+	}
+	return nil
+}
+
+// raiseSysctl sets the sysctl name to value when it is lower, the kernel takes
+// the highest of the all and interface values of the ARP sysctls.
+func raiseSysctl(name string, value int) error {
+	path := filepath.Join("/proc/sys", name)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	current, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err == nil && current >= value {
+		return nil
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(value)), 0o644)
+}
+
+func (r *localAddress) ensureDummy(name string) error {
+	_, err := r.netlink.LinkByName(name)
+	if err == nil {
+		return nil
+	}
+	if !errors.As(err, &netlink.LinkNotFoundError{}) {
+		return fmt.Errorf("failed to get %s: %w", name, err)
+	}
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := r.netlink.LinkAdd(link); err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add dummy device %s: %w", name, err)
+	}
+	if err := r.netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set up dummy device %s: %w", name, err)
+	}
+	r.log.Info("added dummy device for local EIP addresses", "interface", name)
+	return nil
+}
+
+// managedAddrs returns the addresses added by the agent on link, the addresses
+// configured by the operator or other software are left alone.
+func (r *localAddress) managedAddrs(link netlink.Link) (sets.Set[string], error) {
+	name := link.Attrs().Name
+	addrs, err := r.netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %w", name, err)
+	}
+	res := sets.New[string]()
+	for _, addr := range addrs {
+		if name != DefaultDevice && addr.Label != name+labelSuffix {
+			continue
+		}
+		res.Insert(addr.IP.String())
+	}
+	return res, nil
+}
+
+func newAddr(item, device string) *netlink.Addr {
+	addr := net.ParseIP(item)
+	flags := unix.IFA_F_NOPREFIXROUTE
+	mask := net.CIDRMask(32, 32)
+	label := ""
+	if addr.To4() == nil {
+		// the EIP may still be on the previous node while it moves
+		flags |= unix.IFA_F_NODAD
+		mask = net.CIDRMask(128, 128)
+	} else {
+		addr = addr.To4()
+		label = device + labelSuffix
+	}
+	return &netlink.Addr{IPNet: &net.IPNet{IP: addr, Mask: mask}, Flags: flags, Label: label}
+}
+
+// NewController returns the controller which adds the EIPs of the node to
+// host interfaces when EgressGateway.spec.localAddress is enabled. It runs
+// with the option disabled too, to remove the addresses left behind. held
+// reports whether the node answers for an EIP, the events of sources sync the
+// addresses again when it or the links change.
+func NewController(mgr manager.Manager, log logr.Logger, cfg *config.Config, resolver InterfaceResolver,
+	held func(eip string) bool, sources ...<-chan event.GenericEvent) error {
+	r := &localAddress{
+		client:   mgr.GetClient(),
+		log:      log.WithName("localAddress"),
+		nodeName: cfg.EnvConfig.NodeName,
+		netlink:  &netlink.Handle{},
+		resolver: resolver,
+		held:     held,
+		sysctl:   raiseSysctl,
+	}
+
+	c, err := controller.New("localAddress", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	sourceEgressGateway := utils.SourceKind(
+		mgr.GetCache(),
+		&egressv1.EgressGateway{},
+		handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+			return []reconcile.Request{syncRequest}
+		}),
+	)
+	if err := c.Watch(sourceEgressGateway); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
+	for _, ch := range sources {
+		sourceHeld := source.Channel(ch, handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+			return []reconcile.Request{syncRequest}
+		}))
+		if err := c.Watch(sourceHeld); err != nil {
+			return fmt.Errorf("failed to watch held EIPs: %w", err)
+		}
+	}
+
+	// without any EgressGateway there is no event, clean up after a restart anyway
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if err := r.sync(ctx); err != nil {
+			r.log.Error(err, "failed to sync local EIP addresses")
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,254 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package localaddr
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+type fakeNetlink struct {
+	links []netlink.Link
+	addrs map[string][]netlink.Addr
+}
+
+func newFakeNetlink(addrs map[string][]string) *fakeNetlink {
+	f := &fakeNetlink{addrs: make(map[string][]netlink.Addr)}
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_ = f.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}})
+		for _, item := range addrs[name] {
+			addr, _ := netlink.ParseAddr(item)
+			if ones, bits := addr.Mask.Size(); ones == bits {
+				addr.Flags = unix.IFA_F_NOPREFIXROUTE
+			}
+			f.addrs[name] = append(f.addrs[name], *addr)
+		}
+	}
+	return f
+}
+
+func (f *fakeNetlink) LinkList() ([]netlink.Link, error) { return f.links, nil }
+
+func (f *fakeNetlink) LinkByName(name string) (netlink.Link, error) {
+	for _, link := range f.links {
+		if link.Attrs().Name == name {
+			return link, nil
+		}
+	}
+	return nil, netlink.LinkNotFoundError{}
+}
+
+func (f *fakeNetlink) LinkAdd(link netlink.Link) error {
+	link.Attrs().Index = len(f.links) + 1
+	f.links = append(f.links, link)
+	return nil
+}
+
+func (f *fakeNetlink) LinkSetUp(link netlink.Link) error { return nil }
+
+func (f *fakeNetlink) LinkDel(link netlink.Link) error {
+	for i, item := range f.links {
+		if item.Attrs().Name == link.Attrs().Name {
+			f.links = append(f.links[:i], f.links[i+1:]...)
+			delete(f.addrs, link.Attrs().Name)
+		}
+	}
+	return nil
+}
+
+func (f *fakeNetlink) AddrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	return f.addrs[link.Attrs().Name], nil
+}
+
+func (f *fakeNetlink) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
+	f.addrs[link.Attrs().Name] = append(f.addrs[link.Attrs().Name], *addr)
+	return nil
+}
+
+func (f *fakeNetlink) AddrDel(link netlink.Link, addr *netlink.Addr) error {
+	name := link.Attrs().Name
+	for i, item := range f.addrs[name] {
+		if item.IPNet.String() == addr.IPNet.String() {
+			f.addrs[name] = append(f.addrs[name][:i], f.addrs[name][i+1:]...)
+			return nil
+		}
+	}
+	return unix.EADDRNOTAVAIL
+}
+
+func (f *fakeNetlink) dump() map[string][]string {
+	res := make(map[string][]string)
+	for _, link := range f.links {
+		name := link.Attrs().Name
+		res[name] = []string{}
+		for _, addr := range f.addrs[name] {
+			res[name] = append(res[name], addr.String())
+		}
+	}
+	return res
+}
+
+func TestLocalAddressSync(t *testing.T) {
+	newGateway := func(name string, localAddress *egressv1.LocalAddress, ipv4 []string, eips ...egressv1.Eips) *egressv1.EgressGateway {
+		return &egressv1.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: egressv1.EgressGatewaySpec{
+				Ippools:      egressv1.Ippools{IPv4: ipv4, IPv6: []string{"fd00::21-fd00::30"}},
+				LocalAddress: localAddress,
+			},
+			Status: egressv1.EgressGatewayStatus{
+				NodeList: []egressv1.EgressIPStatus{{Name: "node1", Eips: eips}},
+			},
+		}
+	}
+	resolver := func(egw *egressv1.EgressGateway, eip string) (bool, sets.Set[string], error) {
+		if egw.Name == "announce-eth1" {
+			return false, sets.New[string]("eth1"), nil
+		}
+		return true, sets.New[string](), nil
+	}
+
+	cases := map[string]struct {
+		gateways []*egressv1.EgressGateway
+		addrs    map[string][]string
+		want     map[string][]string
+		sysctls  map[string]int
+	}{
+		"dummy device for all interfaces": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("egw1", &egressv1.LocalAddress{Enable: true}, []string{"10.6.1.21-10.6.1.30"},
+					egressv1.Eips{IPv4: "10.6.1.21", IPv6: "fd00::21"}),
+			},
+			addrs: map[string][]string{"eth1": {"10.6.0.10/24"}},
+			want: map[string][]string{
+				"eth1":       {"10.6.0.10/24"},
+				"egress-eip": {"10.6.1.21/32 egress-eip:egw", "fd00::21/128"},
+			},
+			sysctls: map[string]int{"net/ipv4/conf/all/arp_ignore": 1, "net/ipv4/conf/all/arp_announce": 2},
+		},
+		"announcement interface": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("announce-eth1", &egressv1.LocalAddress{Enable: true}, []string{"10.6.1.21-10.6.1.30"},
+					egressv1.Eips{IPv4: "10.6.1.21", IPv6: "fd00::21"}),
+			},
+			addrs: map[string][]string{"eth1": {"10.6.0.10/24"}},
+			want: map[string][]string{
+				"eth1":       {"10.6.0.10/24", "10.6.1.21/32 eth1:egw"},
+				"egress-eip": {"fd00::21/128"},
+			},
+			sysctls: map[string]int{"net/ipv4/conf/all/arp_ignore": 1, "net/ipv4/conf/all/arp_announce": 2},
+		},
+		"named interface": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("egw1", &egressv1.LocalAddress{Enable: true, Interface: "eth2"}, []string{"10.6.1.21-10.6.1.30"},
+					egressv1.Eips{IPv4: "10.6.1.21"}),
+			},
+			addrs:   map[string][]string{"eth1": {"10.6.0.10/24"}, "eth2": {}},
+			want:    map[string][]string{"eth1": {"10.6.0.10/24"}, "eth2": {"10.6.1.21/32 eth2:egw"}},
+			sysctls: map[string]int{"net/ipv4/conf/all/arp_ignore": 1, "net/ipv4/conf/all/arp_announce": 2},
+		},
+		"named interface too long for the label": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("egw1", &egressv1.LocalAddress{Enable: true, Interface: "egress-uplink0"}, []string{"10.6.1.21-10.6.1.30"},
+					egressv1.Eips{IPv4: "10.6.1.21"}),
+			},
+			addrs:   map[string][]string{"eth1": {"10.6.0.10/24"}},
+			want:    map[string][]string{"eth1": {"10.6.0.10/24"}, "egress-eip": {"10.6.1.21/32 egress-eip:egw"}},
+			sysctls: map[string]int{"net/ipv4/conf/all/arp_ignore": 1, "net/ipv4/conf/all/arp_announce": 2},
+		},
+		"remove moved EIPs and keep the addresses of others": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("announce-eth1", &egressv1.LocalAddress{Enable: true}, []string{"10.6.1.21-10.6.1.30"},
+					egressv1.Eips{IPv4: "10.6.1.22"}),
+			},
+			addrs: map[string][]string{"eth1": {"10.6.0.10/24", "10.6.1.21/32 eth1:egw", "10.6.1.23/32", "10.6.1.25/24"}},
+			want: map[string][]string{
+				"eth1": {"10.6.0.10/24", "10.6.1.23/32", "10.6.1.25/24", "10.6.1.22/32 eth1:egw"},
+			},
+			sysctls: map[string]int{"net/ipv4/conf/all/arp_ignore": 1, "net/ipv4/conf/all/arp_announce": 2},
+		},
+		"EIPs not held are removed": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("announce-eth1", &egressv1.LocalAddress{Enable: true}, []string{"10.6.1.21-10.6.1.30"},
+					egressv1.Eips{IPv4: "10.6.1.21"}, egressv1.Eips{IPv4: "10.6.1.29"}),
+			},
+			addrs: map[string][]string{"eth1": {"10.6.0.10/24", "10.6.1.29/32 eth1:egw"}},
+			want: map[string][]string{
+				"eth1": {"10.6.0.10/24", "10.6.1.21/32 eth1:egw"},
+			},
+			sysctls: map[string]int{"net/ipv4/conf/all/arp_ignore": 1, "net/ipv4/conf/all/arp_announce": 2},
+		},
+		"disabled cleans up after restart": {
+			gateways: []*egressv1.EgressGateway{
+				newGateway("egw1", nil, []string{"10.6.1.21-10.6.1.30"}, egressv1.Eips{IPv4: "10.6.1.21"}),
+			},
+			addrs:   map[string][]string{"eth1": {"10.6.0.10/24", "10.6.1.21/32 eth1:egw"}, "egress-eip": {"10.8.0.1/32"}},
+			want:    map[string][]string{"eth1": {"10.6.0.10/24"}},
+			sysctls: map[string]int{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(schema.GetScheme())
+			for _, egw := range tc.gateways {
+				builder.WithObjects(egw)
+			}
+			nl := newFakeNetlink(tc.addrs)
+			sysctls := make(map[string]int)
+			r := &localAddress{
+				client:   builder.Build(),
+				log:      logr.Discard(),
+				nodeName: "node1",
+				netlink:  nl,
+				resolver: resolver,
+				// the lease of 10.6.1.29 is held by another node
+				held: func(eip string) bool { return eip != "10.6.1.29" },
+				sysctl: func(name string, value int) error {
+					sysctls[name] = value
+					return nil
+				},
+			}
+
+			assert.NoError(t, r.sync(context.Background()))
+			assert.Equal(t, tc.want, nl.dump())
+			assert.Equal(t, tc.sysctls, sysctls)
+
+			// a second sync has nothing to change
+			assert.NoError(t, r.sync(context.Background()))
+			assert.Equal(t, tc.want, nl.dump())
+		})
+	}
+}
+
+func TestNewAddr(t *testing.T) {
+	addr := newAddr("10.6.1.21", "eth1")
+	assert.Equal(t, "10.6.1.21/32", addr.IPNet.String())
+	assert.Equal(t, "eth1:egw", addr.Label)
+	assert.Equal(t, unix.IFA_F_NOPREFIXROUTE, addr.Flags)
+
+	addr = newAddr("fd00::21", DefaultDevice)
+	assert.Equal(t, "fd00::21/128", addr.IPNet.String())
+	assert.Empty(t, addr.Label)
+	assert.Equal(t, unix.IFA_F_NOPREFIXROUTE|unix.IFA_F_NODAD, addr.Flags)
+	assert.Equal(t, net.IPv6len, len(addr.IP))
+}
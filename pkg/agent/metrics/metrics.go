@@ -5,6 +5,11 @@ package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spidernet-io/egressgateway/pkg/agent/bandwidth"
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	"github.com/spidernet-io/egressgateway/pkg/agent/dryrun"
+	"github.com/spidernet-io/egressgateway/pkg/agent/flowlog"
+	"github.com/spidernet-io/egressgateway/pkg/agent/snatmon"
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -12,6 +17,11 @@ import (
 func RegisterMetricCollectors() {
 	var metricCollectors []prometheus.Collector
 	metricCollectors = append(metricCollectors, iptables.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, snatmon.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, bandwidth.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, flowlog.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, datapath.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, dryrun.MetricCollectors()...)
 	for _, collector := range metricCollectors {
 		metrics.Registry.MustRegister(collector)
 	}
@@ -0,0 +1,280 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package podindex
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Index maps the pod IPs found in EgressEndpointSlices and EgressClusterEndpointSlices
+// to the policies that select them. It is shared by all policies of the agent and
+// is updated one slice at a time, every update returns the IPs that each policy
+// gained or lost, so only the affected ipset entries have to be changed.
+type Index struct {
+	nodeName string
+
+	mutex sync.RWMutex
+	// slices keeps the last endpoints seen in each slice
+	slices map[types.NamespacedName]*slice
+	// policies and ips share the same refs, indexed by policy and by IP
+	policies map[egressv1.Policy]map[string]*ref
+	ips      map[string]map[egressv1.Policy]*ref
+}
+
+type slice struct {
+	policy    egressv1.Policy
+	endpoints []endpoint
+}
+
+type endpoint struct {
+	ips   []string
+	local bool
+	pod   types.NamespacedName
+}
+
+// ref counts the endpoints of a policy that have an IP
+type ref struct {
+	count int
+	// local counts the endpoints on the node of the index
+	local int
+	// pod is the last pod seen with the IP
+	pod types.NamespacedName
+}
+
+// Delta is the change of the source IPs of a policy
+type Delta struct {
+	Policy egressv1.Policy
+	// Added and Deleted are the IPs of all endpoints of the policy
+	Added   []string
+	Deleted []string
+	// LocalAdded and LocalDeleted are the IPs of the endpoints on the node of the index
+	LocalAdded   []string
+	LocalDeleted []string
+}
+
+// Empty returns true if no IP of the policy is changed
+func (d Delta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Deleted) == 0 &&
+		len(d.LocalAdded) == 0 && len(d.LocalDeleted) == 0
+}
+
+// New creates an Index, the endpoints on nodeName are counted as local
+func New(nodeName string) *Index {
+	return &Index{
+		nodeName: nodeName,
+		slices:   make(map[types.NamespacedName]*slice),
+		policies: make(map[egressv1.Policy]map[string]*ref),
+		ips:      make(map[string]map[egressv1.Policy]*ref),
+	}
+}
+
+// UpdateSlice replaces the endpoints of the slice key that belongs to policy
+func (i *Index) UpdateSlice(key types.NamespacedName, policy egressv1.Policy, endpoints []egressv1.EgressEndpoint) []Delta {
+	newSlice := &slice{policy: policy, endpoints: make([]endpoint, 0, len(endpoints))}
+	for _, ep := range endpoints {
+		ips := make([]string, 0, len(ep.IPv4)+len(ep.IPv6))
+		ips = append(ips, ep.IPv4...)
+		ips = append(ips, ep.IPv6...)
+		newSlice.endpoints = append(newSlice.endpoints, endpoint{
+			ips:   ips,
+			local: ep.Node == i.nodeName,
+			pod:   types.NamespacedName{Namespace: ep.Namespace, Name: ep.Pod},
+		})
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	tracker := newTracker()
+	if old, ok := i.slices[key]; ok {
+		i.apply(tracker, old, -1)
+	}
+	i.apply(tracker, newSlice, 1)
+	i.slices[key] = newSlice
+	return i.finish(tracker)
+}
+
+// DeleteSlice removes the endpoints of the slice key
+func (i *Index) DeleteSlice(key types.NamespacedName) []Delta {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	old, ok := i.slices[key]
+	if !ok {
+		return nil
+	}
+	tracker := newTracker()
+	i.apply(tracker, old, -1)
+	delete(i.slices, key)
+	return i.finish(tracker)
+}
+
+// PolicyIPs returns the IPv4 and IPv6 addresses of the endpoints of the policy,
+// only the ones on the node of the index if local is true
+func (i *Index) PolicyIPs(policy egressv1.Policy, local bool) ([]string, []string) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	ipv4List := make([]string, 0)
+	ipv6List := make([]string, 0)
+	for ip, item := range i.policies[policy] {
+		if local && item.local == 0 {
+			continue
+		}
+		if IsIPv6(ip) {
+			ipv6List = append(ipv6List, ip)
+		} else {
+			ipv4List = append(ipv4List, ip)
+		}
+	}
+	sort.Strings(ipv4List)
+	sort.Strings(ipv6List)
+	return ipv4List, ipv6List
+}
+
+// Policies returns the policies that select the IP
+func (i *Index) Policies(ip string) []egressv1.Policy {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	res := make([]egressv1.Policy, 0, len(i.ips[ip]))
+	for policy := range i.ips[ip] {
+		res = append(res, policy)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].Namespace != res[b].Namespace {
+			return res[a].Namespace < res[b].Namespace
+		}
+		return res[a].Name < res[b].Name
+	})
+	return res
+}
+
+// Pod returns the pod with the IP
+func (i *Index) Pod(ip string) (types.NamespacedName, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for _, item := range i.ips[ip] {
+		return item.pod, true
+	}
+	return types.NamespacedName{}, false
+}
+
+// Len returns the number of IPs and slices in the index
+func (i *Index) Len() (ips int, slices int) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return len(i.ips), len(i.slices)
+}
+
+// IsIPv6 returns true if ip is an IPv6 address
+func IsIPv6(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && addr.To4() == nil
+}
+
+type state struct {
+	any   bool
+	local bool
+}
+
+// tracker keeps the state of each IP of each policy before the update
+type tracker map[egressv1.Policy]map[string]state
+
+func newTracker() tracker {
+	return make(tracker)
+}
+
+func (i *Index) apply(t tracker, s *slice, sign int) {
+	refs, ok := i.policies[s.policy]
+	if !ok {
+		refs = make(map[string]*ref)
+		i.policies[s.policy] = refs
+	}
+	before, ok := t[s.policy]
+	if !ok {
+		before = make(map[string]state)
+		t[s.policy] = before
+	}
+
+	for _, ep := range s.endpoints {
+		for _, ip := range ep.ips {
+			item, ok := refs[ip]
+			if !ok {
+				item = new(ref)
+				refs[ip] = item
+				if _, ok := i.ips[ip]; !ok {
+					i.ips[ip] = make(map[egressv1.Policy]*ref)
+				}
+				i.ips[ip][s.policy] = item
+			}
+			if _, ok := before[ip]; !ok {
+				before[ip] = state{any: item.count > 0, local: item.local > 0}
+			}
+			item.count += sign
+			if sign > 0 {
+				item.pod = ep.pod
+			}
+			if ep.local {
+				item.local += sign
+			}
+		}
+	}
+}
+
+func (i *Index) finish(t tracker) []Delta {
+	res := make([]Delta, 0, len(t))
+	for policy, before := range t {
+		refs := i.policies[policy]
+		delta := Delta{Policy: policy}
+		for ip, old := range before {
+			item := refs[ip]
+			now := state{any: item.count > 0, local: item.local > 0}
+			switch {
+			case now.any && !old.any:
+				delta.Added = append(delta.Added, ip)
+			case !now.any && old.any:
+				delta.Deleted = append(delta.Deleted, ip)
+			}
+			switch {
+			case now.local && !old.local:
+				delta.LocalAdded = append(delta.LocalAdded, ip)
+			case !now.local && old.local:
+				delta.LocalDeleted = append(delta.LocalDeleted, ip)
+			}
+			if item.count <= 0 {
+				delete(refs, ip)
+				delete(i.ips[ip], policy)
+				if len(i.ips[ip]) == 0 {
+					delete(i.ips, ip)
+				}
+			}
+		}
+		if len(refs) == 0 {
+			delete(i.policies, policy)
+		}
+		if delta.Empty() {
+			continue
+		}
+		sort.Strings(delta.Added)
+		sort.Strings(delta.Deleted)
+		sort.Strings(delta.LocalAdded)
+		sort.Strings(delta.LocalDeleted)
+		res = append(res, delta)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].Policy.Namespace != res[b].Policy.Namespace {
+			return res[a].Policy.Namespace < res[b].Policy.Namespace
+		}
+		return res[a].Policy.Name < res[b].Policy.Name
+	})
+	return res
+}
@@ -0,0 +1,372 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package podindex
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	policy1 = egressv1.Policy{Namespace: "default", Name: "p1"}
+	policy2 = egressv1.Policy{Namespace: "default", Name: "p2"}
+	slice1  = types.NamespacedName{Namespace: "default", Name: "p1-s1"}
+	slice2  = types.NamespacedName{Namespace: "default", Name: "p1-s2"}
+	slice3  = types.NamespacedName{Namespace: "default", Name: "p2-s1"}
+)
+
+func ep(pod, node string, ips ...string) egressv1.EgressEndpoint {
+	res := egressv1.EgressEndpoint{Namespace: "default", Pod: pod, Node: node}
+	for _, ip := range ips {
+		if IsIPv6(ip) {
+			res.IPv6 = append(res.IPv6, ip)
+		} else {
+			res.IPv4 = append(res.IPv4, ip)
+		}
+	}
+	return res
+}
+
+func TestIndex(t *testing.T) {
+	type step struct {
+		key       types.NamespacedName
+		policy    egressv1.Policy
+		endpoints []egressv1.EgressEndpoint
+		delete    bool
+		expDeltas []Delta
+	}
+
+	cases := map[string]struct {
+		steps     []step
+		expPolicy map[egressv1.Policy][2][]string
+		expLocal  map[egressv1.Policy][2][]string
+		expIPs    map[string][]egressv1.Policy
+	}{
+		"add slice": {
+			steps: []step{{
+				key: slice1, policy: policy1,
+				endpoints: []egressv1.EgressEndpoint{
+					ep("pod1", "node1", "10.0.0.1", "fd00::1"),
+					ep("pod2", "node2", "10.0.0.2"),
+				},
+				expDeltas: []Delta{{
+					Policy:     policy1,
+					Added:      []string{"10.0.0.1", "10.0.0.2", "fd00::1"},
+					LocalAdded: []string{"10.0.0.1", "fd00::1"},
+				}},
+			}},
+			expPolicy: map[egressv1.Policy][2][]string{
+				policy1: {{"10.0.0.1", "10.0.0.2"}, {"fd00::1"}},
+			},
+			expLocal: map[egressv1.Policy][2][]string{
+				policy1: {{"10.0.0.1"}, {"fd00::1"}},
+			},
+			expIPs: map[string][]egressv1.Policy{
+				"10.0.0.1": {policy1},
+				"fd00::1":  {policy1},
+			},
+		},
+		"update slice only reports changes": {
+			steps: []step{
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node1", "10.0.0.1"),
+						ep("pod2", "node2", "10.0.0.2"),
+					},
+					expDeltas: []Delta{{
+						Policy:     policy1,
+						Added:      []string{"10.0.0.1", "10.0.0.2"},
+						LocalAdded: []string{"10.0.0.1"},
+					}},
+				},
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node1", "10.0.0.1"),
+						ep("pod3", "node1", "10.0.0.3"),
+					},
+					expDeltas: []Delta{{
+						Policy:     policy1,
+						Added:      []string{"10.0.0.3"},
+						Deleted:    []string{"10.0.0.2"},
+						LocalAdded: []string{"10.0.0.3"},
+					}},
+				},
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node1", "10.0.0.1"),
+						ep("pod3", "node1", "10.0.0.3"),
+					},
+					expDeltas: []Delta{},
+				},
+			},
+			expPolicy: map[egressv1.Policy][2][]string{
+				policy1: {{"10.0.0.1", "10.0.0.3"}, {}},
+			},
+			expIPs: map[string][]egressv1.Policy{
+				"10.0.0.2": {},
+			},
+		},
+		"pod moves between slices of a policy": {
+			steps: []step{
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node1", "10.0.0.1"),
+					},
+					expDeltas: []Delta{{
+						Policy:     policy1,
+						Added:      []string{"10.0.0.1"},
+						LocalAdded: []string{"10.0.0.1"},
+					}},
+				},
+				{
+					key: slice2, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node1", "10.0.0.1"),
+					},
+					expDeltas: []Delta{},
+				},
+				{
+					key: slice1, delete: true,
+					expDeltas: []Delta{},
+				},
+			},
+			expPolicy: map[egressv1.Policy][2][]string{
+				policy1: {{"10.0.0.1"}, {}},
+			},
+		},
+		"pod shared by policies": {
+			steps: []step{
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node2", "10.0.0.1"),
+					},
+					expDeltas: []Delta{{
+						Policy: policy1,
+						Added:  []string{"10.0.0.1"},
+					}},
+				},
+				{
+					key: slice3, policy: policy2,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node2", "10.0.0.1"),
+					},
+					expDeltas: []Delta{{
+						Policy: policy2,
+						Added:  []string{"10.0.0.1"},
+					}},
+				},
+				{
+					key: slice1, delete: true,
+					expDeltas: []Delta{{
+						Policy:  policy1,
+						Deleted: []string{"10.0.0.1"},
+					}},
+				},
+			},
+			expPolicy: map[egressv1.Policy][2][]string{
+				policy1: {{}, {}},
+				policy2: {{"10.0.0.1"}, {}},
+			},
+			expIPs: map[string][]egressv1.Policy{
+				"10.0.0.1": {policy2},
+			},
+		},
+		"pod moves to another node": {
+			steps: []step{
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node1", "10.0.0.1"),
+					},
+					expDeltas: []Delta{{
+						Policy:     policy1,
+						Added:      []string{"10.0.0.1"},
+						LocalAdded: []string{"10.0.0.1"},
+					}},
+				},
+				{
+					key: slice1, policy: policy1,
+					endpoints: []egressv1.EgressEndpoint{
+						ep("pod1", "node2", "10.0.0.1"),
+					},
+					expDeltas: []Delta{{
+						Policy:       policy1,
+						LocalDeleted: []string{"10.0.0.1"},
+					}},
+				},
+			},
+			expLocal: map[egressv1.Policy][2][]string{
+				policy1: {{}, {}},
+			},
+		},
+		"delete unknown slice": {
+			steps: []step{{key: slice1, delete: true}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			index := New("node1")
+			for _, s := range tc.steps {
+				var deltas []Delta
+				if s.delete {
+					deltas = index.DeleteSlice(s.key)
+				} else {
+					deltas = index.UpdateSlice(s.key, s.policy, s.endpoints)
+				}
+				if s.expDeltas == nil {
+					assert.Empty(t, deltas)
+				} else {
+					assert.Equal(t, s.expDeltas, deltas)
+				}
+			}
+			for policy, exp := range tc.expPolicy {
+				ipv4, ipv6 := index.PolicyIPs(policy, false)
+				assert.Equal(t, exp[0], ipv4)
+				assert.Equal(t, exp[1], ipv6)
+			}
+			for policy, exp := range tc.expLocal {
+				ipv4, ipv6 := index.PolicyIPs(policy, true)
+				assert.Equal(t, exp[0], ipv4)
+				assert.Equal(t, exp[1], ipv6)
+			}
+			for ip, exp := range tc.expIPs {
+				assert.Equal(t, exp, index.Policies(ip))
+			}
+		})
+	}
+}
+
+const (
+	benchPolicies         = 500
+	benchPods             = 20000
+	benchPodsPerPolicy    = 2000
+	benchEndpointPerSlice = 100
+	benchNodes            = 100
+)
+
+type benchSlice struct {
+	key       types.NamespacedName
+	policy    egressv1.Policy
+	endpoints []egressv1.EgressEndpoint
+}
+
+// buildBenchSlices spreads 20k pods over 500 policies, each policy selects 2000
+// pods and overlaps with its neighbours
+func buildBenchSlices() []benchSlice {
+	res := make([]benchSlice, 0)
+	for p := 0; p < benchPolicies; p++ {
+		policy := egressv1.Policy{Namespace: "default", Name: fmt.Sprintf("policy-%d", p)}
+		start := p * (benchPods / benchPolicies)
+		var cur *benchSlice
+		for i := 0; i < benchPodsPerPolicy; i++ {
+			if i%benchEndpointPerSlice == 0 {
+				res = append(res, benchSlice{
+					key:    types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("%s-%d", policy.Name, i/benchEndpointPerSlice)},
+					policy: policy,
+				})
+				cur = &res[len(res)-1]
+			}
+			pod := (start + i) % benchPods
+			cur.endpoints = append(cur.endpoints, ep(
+				fmt.Sprintf("pod-%d", pod),
+				fmt.Sprintf("node%d", pod%benchNodes),
+				fmt.Sprintf("10.%d.%d.%d", pod>>16&0xff, pod>>8&0xff, pod&0xff),
+			))
+		}
+	}
+	return res
+}
+
+// BenchmarkIndexBuild builds the index of 500 policies selecting overlapping pods out of 20k pods
+func BenchmarkIndexBuild(b *testing.B) {
+	slices := buildBenchSlices()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		index := New("node1")
+		for _, s := range slices {
+			index.UpdateSlice(s.key, s.policy, s.endpoints)
+		}
+	}
+}
+
+// BenchmarkIndexUpdateSlice changes the IP of one pod in a slice, which is what
+// the agent does for every EgressEndpointSlice event
+func BenchmarkIndexUpdateSlice(b *testing.B) {
+	slices := buildBenchSlices()
+	index := New("node1")
+	for _, s := range slices {
+		index.UpdateSlice(s.key, s.policy, s.endpoints)
+	}
+
+	changes := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		s := slices[n%len(slices)]
+		endpoints := make([]egressv1.EgressEndpoint, len(s.endpoints))
+		copy(endpoints, s.endpoints)
+		endpoints[0] = ep(endpoints[0].Pod, endpoints[0].Node, fmt.Sprintf("172.16.%d.%d", n>>8&0xff, n&0xff))
+		for _, delta := range index.UpdateSlice(s.key, s.policy, endpoints) {
+			changes += len(delta.Added) + len(delta.Deleted)
+		}
+		index.UpdateSlice(s.key, s.policy, s.endpoints)
+	}
+	b.ReportMetric(float64(changes)/float64(b.N), "entries/op")
+}
+
+// BenchmarkFullPolicySrcIPs recomputes all source IPs of the policy of the changed
+// slice, which is what the agent did before the index for every slice event
+func BenchmarkFullPolicySrcIPs(b *testing.B) {
+	slices := buildBenchSlices()
+	byPolicy := make(map[egressv1.Policy][]benchSlice)
+	for _, s := range slices {
+		byPolicy[s.policy] = append(byPolicy[s.policy], s)
+	}
+
+	entries := 0
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		s := slices[n%len(slices)]
+		ipv4List := make([]string, 0)
+		for _, item := range byPolicy[s.policy] {
+			for _, e := range item.endpoints {
+				ipv4List = append(ipv4List, e.IPv4...)
+			}
+		}
+		entries += len(ipv4List)
+	}
+	b.ReportMetric(float64(entries)/float64(b.N), "entries/op")
+}
+
+func TestPod(t *testing.T) {
+	index := New("node1")
+	index.UpdateSlice(slice1, policy1, []egressv1.EgressEndpoint{ep("pod1", "node1", "10.0.0.1", "fd00::1")})
+
+	pod, ok := index.Pod("fd00::1")
+	assert.True(t, ok)
+	assert.Equal(t, types.NamespacedName{Namespace: "default", Name: "pod1"}, pod)
+
+	// the IP is given to another pod
+	index.UpdateSlice(slice1, policy1, []egressv1.EgressEndpoint{ep("pod2", "node1", "10.0.0.1")})
+	pod, ok = index.Pod("10.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, "pod2", pod.Name)
+
+	index.DeleteSlice(slice1)
+	_, ok = index.Pod("10.0.0.1")
+	assert.False(t, ok)
+}
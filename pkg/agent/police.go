@@ -9,20 +9,27 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"path"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/spidernet-io/egressgateway/pkg/agent/bandwidth"
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	"github.com/spidernet-io/egressgateway/pkg/agent/dryrun"
+	"github.com/spidernet-io/egressgateway/pkg/agent/flowlog"
+	"github.com/spidernet-io/egressgateway/pkg/agent/podindex"
+	"github.com/spidernet-io/egressgateway/pkg/agent/route"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/eiplease"
 	"github.com/spidernet-io/egressgateway/pkg/ipset"
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/utils/exec"
@@ -32,6 +39,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -40,6 +48,12 @@ const (
 
 	Mark = 0xff000000
 	Mask = 0xffffffff
+
+	// hashMarkOffset is the first of the marks given by the hash of a flow of a
+	// policy with several gateway nodes, they are replaced by the tunnel mark of
+	// the node chosen by the hash in the same chain
+	hashMarkOffset = 0x27000000
+	hashMarkTuple  = "src,dst,sport,dport,proto"
 )
 
 type policeReconciler struct {
@@ -56,6 +70,31 @@ type policeReconciler struct {
 	filterTables  []*iptables.Table
 	natTables     []*iptables.Table
 	policyMapNode *utils.SyncMap[egressv1.Policy, string]
+	// podIndex maps the pod IPs of all endpoint slices to their policies
+	podIndex *podindex.Index
+	// dryRunPolicies is the set of dry run policies that the rules are built for
+	dryRunPolicies *utils.SyncMap[egressv1.Policy, bool]
+	// fence is nil when the EIP leases are disabled
+	fence *eiplease.Fence
+	// ruleRoute routes the traffic of the EIPs with an uplink
+	ruleRoute *route.RuleRoute
+	// shaper limits the bandwidth of the policies of this node
+	shaper *bandwidth.Shaper
+	// trafficPolicies are the traffic fields of the policies of this node that
+	// the rules are built for
+	trafficPolicies *utils.SyncMap[egressv1.Policy, trafficSpec]
+	// flowLog is nil when the flow log is disabled
+	flowLog *flowlog.Exporter
+	// ipsetEntries are the entries the ipsets should have, for the debug API
+	ipsetEntries *utils.SyncMap[string, []string]
+	// desiredChains are the rules of the tables when they were last applied
+	desiredChainsLock sync.Mutex
+	desiredChains     map[string][]datapath.ChainRule
+	// repair queues the repair of the datapath by the verifier
+	repair chan event.GenericEvent
+	// repairDiff is the drift of the queued repair, counted once it is done
+	repairDiffLock sync.Mutex
+	repairDiff     []datapath.Difference
 }
 
 func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -85,6 +124,10 @@ func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 		res, err = r.reconcileClusterInfo(ctx, newReq, log)
 	case "EgressTunnel":
 		res, err = r.reconcileTunnel(ctx, newReq, log)
+	case "EgressEndpointSlice", "EgressClusterEndpointSlice":
+		res, err = r.reconcileEndpointSlice(ctx, kind, newReq, log)
+	case "Datapath":
+		res, err = r.repairDatapath(ctx, log)
 	default:
 		return reconcile.Result{}, nil
 	}
@@ -96,6 +139,35 @@ type PolicyCommon struct {
 	DestSubnet []string
 	IP         IP
 	UseNodeIP  bool
+	DryRun     bool
+	// SourcePorts is the source port range of the EIP reserved by the policy,
+	// or the range left by the reservations of the other policies
+	SourcePorts string
+	// Extra are the other EIPs of the policy on this node
+	Extra []EIP
+	// NodeNames are the gateway nodes of the policy, the flows are spread over
+	// them by hash when there are several
+	NodeNames []string
+	// Uplink is the uplink of the first EIP of the policy on this node with
+	// one, the EIPs of the policy on the node share it
+	Uplink *uplink
+	// Fenced are the EIPs of the policy on this node whose lease is not held
+	Fenced IP
+	trafficSpec
+}
+
+// fenced reports whether the policy has an EIP of the IP version on this node
+// whose lease is not held, and none whose lease is held.
+func (p *PolicyCommon) fenced(version uint8) bool {
+	for _, eip := range append([]EIP{{IP: p.IP}}, p.Extra...) {
+		if (version == 4 && eip.IP.V4 != "") || (version == 6 && eip.IP.V6 != "") {
+			return false
+		}
+	}
+	if version == 4 {
+		return p.Fenced.V4 != ""
+	}
+	return p.Fenced.V6 != ""
 }
 
 type IP struct {
@@ -103,6 +175,35 @@ type IP struct {
 	V6 string
 }
 
+type EIP struct {
+	IP          IP
+	SourcePorts string
+}
+
+// sourcePorts returns the source port range of the policy on the EIP: the
+// range it reserved, or the largest range not reserved by the other policies
+// when there are reservations, so that it does not take their ports.
+func (r *policeReconciler) sourcePorts(eip egressv1.Eips, policy egressv1.Policy) string {
+	if ports := eip.GetSourcePorts(policy.Namespace, policy.Name); ports != "" || len(eip.SourcePorts) == 0 {
+		return ports
+	}
+	used := make([]string, 0, len(eip.SourcePorts))
+	for _, item := range eip.SourcePorts {
+		used = append(used, item.Ports)
+	}
+	ports, err := utils.FreePortRange(used, utils.SourcePortMin, utils.SourcePortMax)
+	if err != nil {
+		r.log.Error(err, "failed to find the source ports left by the reservations of the EIP, use all the ports",
+			"ipv4", eip.IPv4, "ipv6", eip.IPv6, "policy", policy)
+		return ""
+	}
+	if ports == "" {
+		r.log.Info("no source port is left by the reservations of the EIP, use all the ports",
+			"ipv4", eip.IPv4, "ipv6", eip.IPv6, "policy", policy)
+	}
+	return ports
+}
+
 // initApplyPolicy init applies the given policy
 // list egress gateway
 // list policy/cluster-policy
@@ -114,8 +215,13 @@ func (r *policeReconciler) initApplyPolicy() error {
 	r.log.Info("apply policy")
 	ctx := context.Background()
 
+	err := r.buildPodIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to build pod index: %v", err)
+	}
+
 	gateways := new(egressv1.EgressGatewayList)
-	err := r.client.List(ctx, gateways)
+	err = r.client.List(ctx, gateways)
 	if err != nil {
 		return fmt.Errorf("failed to list gateway: %v", err)
 	}
@@ -141,29 +247,81 @@ func (r *policeReconciler) initApplyPolicy() error {
 					if eip.IPv4 == "" && eip.IPv6 == "" {
 						useNodeIP = true
 					}
+					ipv4, ipv6 := eip.IPv4, eip.IPv6
+					fenced := IP{}
+					if ipv4 != "" && !r.fence.Owns(ipv4) {
+						r.log.Info("lease of EIP is not held, drop the traffic of its policies", "eip", ipv4)
+						fenced.V4, ipv4 = ipv4, ""
+					}
+					if ipv6 != "" && !r.fence.Owns(ipv6) {
+						r.log.Info("lease of EIP is not held, drop the traffic of its policies", "eip", ipv6)
+						fenced.V6, ipv6 = ipv6, ""
+					}
+					up, err := findUplink(item.Spec.Uplinks, IP{V4: eip.IPv4, V6: eip.IPv6})
+					if err != nil {
+						r.log.Error(err, "failed to find the uplink of EIP, use the main routing table")
+					}
 					for _, policy := range eip.Policies {
+						if val, ok := snatPolicies[policy]; ok {
+							// the traffic of a policy leaves through one uplink,
+							// an EIP of another uplink would leave through the
+							// interface of the first one
+							if !sameUplink(val.Uplink, up) {
+								r.log.Info("EIP is not in the uplink of the other EIPs of the policy on the node, it is not used",
+									"ipv4", eip.IPv4, "ipv6", eip.IPv6, "policy", policy)
+								continue
+							}
+							val.Extra = append(val.Extra, EIP{
+								IP:          IP{V4: ipv4, V6: ipv6},
+								SourcePorts: r.sourcePorts(eip, policy),
+							})
+							if fenced.V4 != "" {
+								val.Fenced.V4 = fenced.V4
+							}
+							if fenced.V6 != "" {
+								val.Fenced.V6 = fenced.V6
+							}
+							continue
+						}
 						snatPolicies[policy] = &PolicyCommon{
-							NodeName:  list.Name,
-							IP:        IP{V4: eip.IPv4, V6: eip.IPv6},
-							UseNodeIP: useNodeIP,
+							NodeName:    list.Name,
+							IP:          IP{V4: ipv4, V6: ipv6},
+							UseNodeIP:   useNodeIP,
+							SourcePorts: r.sourcePorts(eip, policy),
+							Uplink:      up,
+							Fenced:      fenced,
 						}
 					}
 				}
 			} else {
 				for _, eip := range list.Eips {
 					for _, policy := range eip.Policies {
-						unSnatPolicies[policy] = &PolicyCommon{NodeName: list.Name}
+						val, ok := unSnatPolicies[policy]
+						if !ok {
+							val = &PolicyCommon{NodeName: list.Name}
+							unSnatPolicies[policy] = val
+						}
+						if !slices.Contains(val.NodeNames, list.Name) {
+							val.NodeNames = append(val.NodeNames, list.Name)
+						}
 					}
 				}
 			}
 		}
 	}
+	// the pods of a policy with an EIP on this node leave through it, and the
+	// flows sent here by the other nodes are not marked again
+	for policy := range snatPolicies {
+		delete(unSnatPolicies, policy)
+	}
 
+	dryRunPolicies := make(map[egressv1.Policy]bool)
 	for policy, val := range unSnatPolicies {
-		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
+		val.DestSubnet, val.DryRun, err = r.getPolicySubnet(policy.Namespace, policy.Name)
 		if err != nil {
 			return err
 		}
+		dryRunPolicies[policy] = val.DryRun
 		err := r.updatePolicyIPSet(policy.Namespace, policy.Name, false, val.DestSubnet)
 		if err != nil {
 			return err
@@ -171,10 +329,15 @@ func (r *policeReconciler) initApplyPolicy() error {
 	}
 
 	for policy, val := range snatPolicies {
-		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
+		val.DestSubnet, val.DryRun, err = r.getPolicySubnet(policy.Namespace, policy.Name)
+		if err != nil {
+			return err
+		}
+		val.trafficSpec, err = r.getPolicyTraffic(policy.Namespace, policy.Name)
 		if err != nil {
 			return err
 		}
+		dryRunPolicies[policy] = val.DryRun
 		err := r.updatePolicyIPSet(policy.Namespace, policy.Name, true, val.DestSubnet)
 		if err != nil {
 			return err
@@ -185,8 +348,40 @@ func (r *policeReconciler) initApplyPolicy() error {
 	if err != nil {
 		return err
 	}
+	uplinkMark, err := parseMark(r.cfg.FileConfig.UplinkMark)
+	if err != nil {
+		return err
+	}
+
+	uplinks := make(map[uplink]struct{})
+	for _, val := range snatPolicies {
+		if !val.DryRun && val.Uplink != nil {
+			uplinks[*val.Uplink] = struct{}{}
+		}
+	}
+	uplinkMarks, err := r.ensureUplinkRoutes(uplinks)
+	if err != nil {
+		return err
+	}
+	bandwidthClasses := r.ensureBandwidth(snatPolicies)
+	r.storeTrafficPolicies(snatPolicies)
+	r.setFlowLogEgress(snatPolicies)
 
 	for _, table := range r.filterTables {
+		// without the lease of its EIPs, the traffic of a policy would leave
+		// with the IP of the node, it is dropped until the lease is held again
+		rules := make([]iptables.Rule, 0)
+		for policy, val := range snatPolicies {
+			if val.DryRun || val.UseNodeIP || !val.fenced(table.IPVersion) {
+				continue
+			}
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			rules = append(rules, buildFenceRule(policyName, table.IPVersion, len(val.DestSubnet) <= 0))
+		}
+		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-FENCE", Rules: rules})
 		chainMapRules := buildFilterStaticRule(baseMark)
 		for chain, rules := range chainMapRules {
 			table.InsertOrAppendRules(chain, rules)
@@ -196,8 +391,12 @@ func (r *policeReconciler) initApplyPolicy() error {
 	for _, table := range r.mangleTables {
 		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-REPLY-ROUTING"})
 		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-MARK-REQUEST"})
+		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-UPLINK"})
+		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-BANDWIDTH"})
+		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-DSCP"})
 		chainMapRules := buildMangleStaticRule(
 			baseMark,
+			uplinkMark,
 			isEgressNode,
 			r.cfg.FileConfig.EnableGatewayReplyRoute,
 			uint32(r.cfg.FileConfig.GatewayReplyRouteMark),
@@ -209,29 +408,51 @@ func (r *policeReconciler) initApplyPolicy() error {
 
 	for _, table := range r.mangleTables {
 		rules := make([]iptables.Rule, 0)
-		for policy, val := range unSnatPolicies {
-			node := new(egressv1.EgressTunnel)
-			err := r.client.Get(context.Background(), types.NamespacedName{Name: val.NodeName}, node)
-			if err != nil {
-				r.log.Error(err, "failed to get egress tunnel, skip building rule of policy")
+		// dry run policies only count the traffic of the pods on each node
+		for policy, val := range snatPolicies {
+			if !val.DryRun {
 				continue
 			}
 			policyName := policy.Name
 			if policy.Namespace != "" {
 				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
 			}
+			rule := r.buildDryRunRule(policyName, table.IPVersion, len(val.DestSubnet) <= 0)
+			rules = append(rules, *rule)
+		}
+		for policy, val := range unSnatPolicies {
+			if val.DryRun {
+				policyName := policy.Name
+				if policy.Namespace != "" {
+					policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+				}
+				rule := r.buildDryRunRule(policyName, table.IPVersion, len(val.DestSubnet) <= 0)
+				rules = append(rules, *rule)
+				continue
+			}
 
-			mark, err := parseMark(node.Status.Mark)
+			marks, err := r.tunnelMarks(ctx, val.NodeNames)
 			if err != nil {
 				return err
 			}
+			if len(marks) == 0 {
+				continue
+			}
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
 
 			isIgnoreInternalCIDR := false
 			if len(val.DestSubnet) <= 0 {
 				isIgnoreInternalCIDR = true
 			}
 
-			rule := r.buildPolicyRule(policyName, mark, table.IPVersion, isIgnoreInternalCIDR)
+			if len(marks) > 1 {
+				rules = append(rules, r.buildBalancedPolicyRules(policyName, marks, table.IPVersion, isIgnoreInternalCIDR)...)
+				continue
+			}
+			rule := r.buildPolicyRule(policyName, marks[0], table.IPVersion, isIgnoreInternalCIDR)
 			rules = append(rules, *rule)
 		}
 		table.UpdateChain(&iptables.Chain{
@@ -239,6 +460,60 @@ func (r *policeReconciler) initApplyPolicy() error {
 			Rules: rules,
 		})
 
+		rules = make([]iptables.Rule, 0)
+		for policy, val := range snatPolicies {
+			if val.DryRun || val.Uplink == nil {
+				continue
+			}
+			mark, ok := uplinkMarks[*val.Uplink]
+			if !ok {
+				continue
+			}
+			if (table.IPVersion == 4 && val.Uplink.NextHopIPv4 == "") ||
+				(table.IPVersion == 6 && val.Uplink.NextHopIPv6 == "") {
+				continue
+			}
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			rules = append(rules, r.buildUplinkRule(policyName, mark, table.IPVersion, len(val.DestSubnet) <= 0))
+		}
+		table.UpdateChain(&iptables.Chain{
+			Name:  "EGRESSGATEWAY-UPLINK",
+			Rules: rules,
+		})
+
+		rules = make([]iptables.Rule, 0)
+		for policy, minor := range bandwidthClasses {
+			val := snatPolicies[policy]
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			rules = append(rules, r.buildBandwidthRule(policyName, minor, table.IPVersion, len(val.DestSubnet) <= 0))
+		}
+		table.UpdateChain(&iptables.Chain{
+			Name:  "EGRESSGATEWAY-BANDWIDTH",
+			Rules: rules,
+		})
+
+		rules = make([]iptables.Rule, 0)
+		for policy, val := range snatPolicies {
+			if val.DryRun || val.DSCP == nil {
+				continue
+			}
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			rules = append(rules, r.buildDSCPRule(policyName, uint8(*val.DSCP), table.IPVersion, len(val.DestSubnet) <= 0))
+		}
+		table.UpdateChain(&iptables.Chain{
+			Name:  "EGRESSGATEWAY-DSCP",
+			Rules: rules,
+		})
+
 		tunnels := new(egressv1.EgressTunnelList)
 		err := r.client.List(ctx, tunnels)
 		if err != nil {
@@ -258,8 +533,10 @@ func (r *policeReconciler) initApplyPolicy() error {
 			}
 			rules = append(rules, rule...)
 		}
+		// the replies coming back from the tunnel belong to the flows of this
+		// node, their saved mark is the one of their gateway node
 		restore := iptables.Rule{
-			Match:  iptables.MatchCriteria{}.CTDirectionOriginal(iptables.DirectionReply),
+			Match:  iptables.MatchCriteria{}.CTDirectionOriginal(iptables.DirectionReply).NotInInterface(r.cfg.FileConfig.VXLAN.Name),
 			Action: iptables.RestoreConnMarkAction{RestoreMask: Mask},
 			Comment: []string{
 				"label for restoring connections, rule is from the EgressGateway",
@@ -275,6 +552,9 @@ func (r *policeReconciler) initApplyPolicy() error {
 	for _, table := range r.natTables {
 		rules := make([]iptables.Rule, 0)
 		for policy, val := range snatPolicies {
+			if val.DryRun {
+				continue
+			}
 			policyName := policy.Name
 			if policy.Namespace != "" {
 				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
@@ -285,15 +565,24 @@ func (r *policeReconciler) initApplyPolicy() error {
 				isIgnoreInternalCIDR = true
 			}
 
-			// support enabling IPv4/IPv6 Dual Stack, only create IPv4 or IPv6 EgressGateway
-			if (table.IPVersion == 4 && (!val.UseNodeIP && val.IP.V4 == "")) ||
-				(table.IPVersion == 6 && (!val.UseNodeIP && val.IP.V6 == "")) {
+			if val.UseNodeIP {
+				rules = append(rules, buildEipRules(policyName, val.IP, table.IPVersion, isIgnoreInternalCIDR, true, "")...)
 				continue
 			}
 
-			rule := buildEipRule(policyName, val.IP, table.IPVersion, isIgnoreInternalCIDR, val.UseNodeIP)
-			if rule != nil {
-				rules = append(rules, *rule)
+			// support enabling IPv4/IPv6 Dual Stack, only create IPv4 or IPv6 EgressGateway
+			eips := make([]EIP, 0, 1+len(val.Extra))
+			for _, eip := range append([]EIP{{IP: val.IP, SourcePorts: val.SourcePorts}}, val.Extra...) {
+				if (table.IPVersion == 4 && eip.IP.V4 != "") || (table.IPVersion == 6 && eip.IP.V6 != "") {
+					eips = append(eips, eip)
+				}
+			}
+			switch len(eips) {
+			case 0:
+			case 1:
+				rules = append(rules, buildEipRules(policyName, eips[0].IP, table.IPVersion, isIgnoreInternalCIDR, false, eips[0].SourcePorts)...)
+			default:
+				rules = append(rules, buildBalancedEipRules(policyName, eips, table.IPVersion, isIgnoreInternalCIDR)...)
 			}
 		}
 
@@ -304,6 +593,16 @@ func (r *policeReconciler) initApplyPolicy() error {
 		}
 	}
 
+	r.dryRunPolicies.Range(func(key egressv1.Policy, _ bool) bool {
+		if _, ok := dryRunPolicies[key]; !ok {
+			r.dryRunPolicies.Delete(key)
+		}
+		return true
+	})
+	for policy, dryRun := range dryRunPolicies {
+		r.dryRunPolicies.Store(policy, dryRun)
+	}
+
 	allTables := append(r.natTables, r.filterTables...)
 	allTables = append(allTables, r.mangleTables...)
 	for _, table := range allTables {
@@ -312,6 +611,7 @@ func (r *policeReconciler) initApplyPolicy() error {
 			return fmt.Errorf("failed to apply rule %v: %v", table.Name, err)
 		}
 	}
+	r.storeDesiredChains()
 
 	setList, err := r.ipset.ListSets()
 	if err != nil {
@@ -339,17 +639,18 @@ func (r *policeReconciler) initApplyPolicy() error {
 	return nil
 }
 
-func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, error) {
+// getPolicySubnet returns the destination subnets of the policy and whether it is in dry run mode
+func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, bool, error) {
 	var obj client.Object
 	key := types.NamespacedName{Namespace: ns, Name: name}
-	getSubnet := func(obj client.Object) []string {
+	getSubnet := func(obj client.Object) ([]string, bool) {
 		switch obj := obj.(type) {
 		case *egressv1.EgressPolicy:
-			return expandAnyCIDR(obj.Spec.DestSubnet)
+			return expandAnyCIDR(obj.Spec.DestSubnet), obj.Spec.Mode == egressv1.PolicyModeDryRun
 		case *egressv1.EgressClusterPolicy:
-			return expandAnyCIDR(obj.Spec.DestSubnet)
+			return expandAnyCIDR(obj.Spec.DestSubnet), obj.Spec.Mode == egressv1.PolicyModeDryRun
 		default:
-			return nil
+			return nil, false
 		}
 	}
 	if ns != "" {
@@ -360,10 +661,11 @@ func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, error) {
 	err := r.client.Get(context.Background(), key, obj)
 	if err != nil {
 		if !apierr.IsNotFound(err) {
-			return nil, err
+			return nil, false, err
 		}
 	}
-	return getSubnet(obj), nil
+	subnet, dryRun := getSubnet(obj)
+	return subnet, dryRun, nil
 }
 
 // expandAnyCIDR replaces 0.0.0.0/0 with 0.0.0.0/1 and 128.0.0.0/1
@@ -386,20 +688,9 @@ func expandAnyCIDR(ips []string) []string {
 }
 
 func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string, isEipNodeSet bool, destSubnet []string) error {
-	// calculate src ip list
-	srcIPv4List, srcIPv6List, err := r.getPolicySrcIPs(policyNs, policyName, func(e egressv1.EgressEndpoint) bool {
-		if e.Node == r.cfg.EnvConfig.NodeName {
-			return true
-		}
-		if isEipNodeSet {
-			return true
-		}
-		return false
-	})
-
-	if err != nil {
-		return err
-	}
+	// calculate src ip list, the eip node needs the pod IPs of all nodes
+	policy := egressv1.Policy{Name: policyName, Namespace: policyNs}
+	srcIPv4List, srcIPv6List := r.podIndex.PolicyIPs(policy, !isEipNodeSet)
 
 	// calculate dst ip list
 	dstIPv4List, dstIPv6List, err := r.getDstCIDR(destSubnet)
@@ -431,14 +722,18 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 		case IPSrc:
 			if set.Stack == IPv4 && r.cfg.FileConfig.EnableIPv4 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, srcIPv4List)
+				r.setIPSetEntries(set.Name, srcIPv4List)
 			} else if r.cfg.FileConfig.EnableIPv6 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, srcIPv6List)
+				r.setIPSetEntries(set.Name, srcIPv6List)
 			}
 		case IPDst:
 			if set.Stack == IPv4 && r.cfg.FileConfig.EnableIPv4 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, dstIPv4List)
+				r.setIPSetEntries(set.Name, dstIPv4List)
 			} else if r.cfg.FileConfig.EnableIPv6 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, dstIPv6List)
+				r.setIPSetEntries(set.Name, dstIPv6List)
 			}
 		}
 		return nil
@@ -473,86 +768,294 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 	return nil
 }
 
-func (r *policeReconciler) getPolicySrcIPs(policyNs, policyName string, filter func(slice egressv1.EgressEndpoint) bool) ([]string, []string, error) {
-	ctx := context.Background()
-	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
-		MatchLabels: map[string]string{egressv1.LabelPolicyName: policyName},
-	})
+// buildPodIndex adds all endpoint slices to the pod index
+func (r *policeReconciler) buildPodIndex(ctx context.Context) error {
+	eps := new(egressv1.EgressEndpointSliceList)
+	err := r.client.List(ctx, eps)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	for _, item := range eps.Items {
+		key := types.NamespacedName{Namespace: item.Namespace, Name: item.Name}
+		policy, ok := slicePolicy(&item)
+		if !ok || !item.DeletionTimestamp.IsZero() {
+			continue
+		}
+		r.podIndex.UpdateSlice(key, policy, item.Endpoints)
 	}
-	opt := &client.ListOptions{LabelSelector: selector}
 
-	ipv4List := make([]string, 0)
-	ipv6List := make([]string, 0)
+	ceps := new(egressv1.EgressClusterEndpointSliceList)
+	err = r.client.List(ctx, ceps)
+	if err != nil {
+		return err
+	}
+	for _, item := range ceps.Items {
+		key := types.NamespacedName{Name: item.Name}
+		policy, ok := slicePolicy(&item)
+		if !ok || !item.DeletionTimestamp.IsZero() {
+			continue
+		}
+		r.podIndex.UpdateSlice(key, policy, item.Endpoints)
+	}
+	return nil
+}
+
+// slicePolicy returns the policy that an endpoint slice belongs to
+func slicePolicy(obj client.Object) (egressv1.Policy, bool) {
+	name, ok := obj.GetLabels()[egressv1.LabelPolicyName]
+	if !ok {
+		return egressv1.Policy{}, false
+	}
+	return egressv1.Policy{Name: name, Namespace: obj.GetNamespace()}, true
+}
 
-	if policyNs == "" {
-		eps := new(egressv1.EgressClusterEndpointSliceList)
-		err = r.client.List(ctx, eps, opt)
+// reconcileEndpointSlice reconcile egress endpoint slice and egress cluster endpoint slice
+// watch add/update/delete events
+// - pod index
+// - ipset entries of the changed pod IPs
+func (r *policeReconciler) reconcileEndpointSlice(ctx context.Context, kind string, req reconcile.Request, log logr.Logger) (reconcile.Result, error) {
+	log = log.WithValues("name", req.Name, "namespace", req.Namespace)
+	log.V(1).Info("reconciling")
+
+	var obj client.Object
+	var endpoints func() []egressv1.EgressEndpoint
+	if kind == "EgressClusterEndpointSlice" {
+		slice := new(egressv1.EgressClusterEndpointSlice)
+		obj, endpoints = slice, func() []egressv1.EgressEndpoint { return slice.Endpoints }
+	} else {
+		slice := new(egressv1.EgressEndpointSlice)
+		obj, endpoints = slice, func() []egressv1.EgressEndpoint { return slice.Endpoints }
+	}
+
+	deleted := false
+	err := r.client.Get(ctx, req.NamespacedName, obj)
+	if err != nil {
+		if !apierr.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		deleted = true
+	}
+	deleted = deleted || !obj.GetDeletionTimestamp().IsZero()
+	policy, ok := slicePolicy(obj)
+
+	var deltas []podindex.Delta
+	if deleted || !ok {
+		deltas = r.podIndex.DeleteSlice(req.NamespacedName)
+	} else {
+		deltas = r.podIndex.UpdateSlice(req.NamespacedName, policy, endpoints())
+	}
+
+	for _, delta := range deltas {
+		err := r.applyPodIndexDelta(ctx, delta, log)
 		if err != nil {
-			return nil, nil, err
+			return reconcile.Result{Requeue: true}, err
 		}
-		for _, ep := range eps.Items {
-			if ep.DeletionTimestamp.IsZero() {
-				for _, e := range ep.Endpoints {
-					if filter(e) {
-						ipv4List = append(ipv4List, e.IPv4...)
-						ipv6List = append(ipv6List, e.IPv6...)
-					}
-				}
-			}
+	}
+	return reconcile.Result{}, nil
+}
+
+// applyPodIndexDelta adds and deletes the changed source IPs of a policy in its
+// ipsets. The ipsets are fully updated when they do not exist yet, or when an
+// entry can not be changed, so they always end up matching the pod index.
+func (r *policeReconciler) applyPodIndexDelta(ctx context.Context, delta podindex.Delta, log logr.Logger) error {
+	destSubnet, isEipNode, found, err := r.getPolicyState(ctx, delta.Policy)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	log = log.WithValues("policy", delta.Policy)
+	var srcV4, srcV6 *ipset.IPSet
+	setNames := buildIPSetNamesByPolicy(delta.Policy.Namespace, delta.Policy.Name,
+		r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
+	full := false
+	for _, set := range setNames {
+		ipSet, ok := r.ipsetMap.Load(set.Name)
+		if !ok {
+			full = true
+			continue
 		}
-	} else {
-		opt.Namespace = policyNs
-		eps := new(egressv1.EgressEndpointSliceList)
-		err = r.client.List(ctx, eps, opt)
+		if set.Kind == IPSrc && set.Stack == IPv4 {
+			srcV4 = ipSet
+		} else if set.Kind == IPSrc && set.Stack == IPv6 {
+			srcV6 = ipSet
+		}
+	}
+	if full {
+		log.V(1).Info("ipset of policy not found, update all entries")
+		return r.updatePolicyIPSet(delta.Policy.Namespace, delta.Policy.Name, isEipNode, destSubnet)
+	}
+
+	added, deleted := delta.LocalAdded, delta.LocalDeleted
+	if isEipNode {
+		added, deleted = delta.Added, delta.Deleted
+	}
+	srcIPv4List, srcIPv6List := r.podIndex.PolicyIPs(delta.Policy, !isEipNode)
+	if srcV4 != nil {
+		r.setIPSetEntries(srcV4.Name, srcIPv4List)
+	}
+	if srcV6 != nil {
+		r.setIPSetEntries(srcV6.Name, srcIPv6List)
+	}
+	srcSet := func(ip string) *ipset.IPSet {
+		if podindex.IsIPv6(ip) {
+			return srcV6
+		}
+		return srcV4
+	}
+
+	log.V(1).Info("update ipset entries", "add", added, "delete", deleted)
+	for _, ip := range added {
+		set := srcSet(ip)
+		if set == nil {
+			continue
+		}
+		err := r.ipset.AddEntry(ip, set, true)
 		if err != nil {
-			return nil, nil, err
+			log.Error(err, "failed to add ipset entry, update all entries", "ip", ip)
+			return r.updatePolicyIPSet(delta.Policy.Namespace, delta.Policy.Name, isEipNode, destSubnet)
 		}
-		for _, ep := range eps.Items {
-			if ep.DeletionTimestamp.IsZero() {
-				for _, e := range ep.Endpoints {
-					if filter(e) {
-						ipv4List = append(ipv4List, e.IPv4...)
-						ipv6List = append(ipv6List, e.IPv6...)
-					}
+	}
+	for _, ip := range deleted {
+		set := srcSet(ip)
+		if set == nil {
+			continue
+		}
+		err := r.ipset.DelEntry(ip, set.Name)
+		if err != nil {
+			log.Error(err, "failed to delete ipset entry, update all entries", "ip", ip)
+			return r.updatePolicyIPSet(delta.Policy.Namespace, delta.Policy.Name, isEipNode, destSubnet)
+		}
+	}
+	return nil
+}
+
+// getPolicyState returns the destination subnets of the policy and whether the
+// EIP of the policy is on this node, found is false when the policy or its
+// gateway does not exist
+func (r *policeReconciler) getPolicyState(ctx context.Context, policy egressv1.Policy) ([]string, bool, bool, error) {
+	var obj client.Object
+	var gatewayName func() string
+	var destSubnet func() []string
+	if policy.Namespace == "" {
+		p := new(egressv1.EgressClusterPolicy)
+		obj = p
+		gatewayName = func() string { return p.Spec.EgressGatewayName }
+		destSubnet = func() []string { return p.Spec.DestSubnet }
+	} else {
+		p := new(egressv1.EgressPolicy)
+		obj = p
+		gatewayName = func() string { return p.Spec.EgressGatewayName }
+		destSubnet = func() []string { return p.Spec.DestSubnet }
+	}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}, obj)
+	if err != nil {
+		if apierr.IsNotFound(err) {
+			return nil, false, false, nil
+		}
+		return nil, false, false, err
+	}
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return nil, false, false, nil
+	}
+
+	gateway := new(egressv1.EgressGateway)
+	err = r.client.Get(ctx, types.NamespacedName{Name: gatewayName()}, gateway)
+	if err != nil {
+		if apierr.IsNotFound(err) {
+			return nil, false, false, nil
+		}
+		return nil, false, false, err
+	}
+
+	nodeName := ""
+	for _, node := range gateway.Status.NodeList {
+		for _, eip := range node.Eips {
+			for _, p := range eip.Policies {
+				// any EIP of the policy on this node makes it a gateway node of the policy
+				if p.Name == policy.Name && p.Namespace == policy.Namespace && nodeName != r.cfg.EnvConfig.NodeName {
+					nodeName = node.Name
 				}
 			}
 		}
 	}
-
-	return ipv4List, ipv6List, nil
+	return destSubnet(), nodeName == r.cfg.EnvConfig.NodeName, true, nil
 }
 
-func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR bool, useNodeIP bool) *iptables.Rule {
+// buildSnatMatch matches the requests of the policy leaving from the node.
+func buildSnatMatch(policyName string, version uint8, isIgnoreInternalCIDR bool) iptables.MatchCriteria {
 	tmp := "v4-"
-	ip := eip.V4
 	ignoreName := EgressClusterCIDRIPv4
 	if version == 6 {
 		tmp = "v6-"
-		ip = eip.V6
 		ignoreName = EgressClusterCIDRIPv6
 	}
 	srcName := formatIPSetName("egress-src-"+tmp, policyName)
 	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
 
-	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
-		CTDirectionOriginal(iptables.DirectionOriginal)
-
 	if isIgnoreInternalCIDR {
-		matchCriteria = iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreName).
+		return iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreName).
 			CTDirectionOriginal(iptables.DirectionOriginal)
 	}
+	return iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
+		CTDirectionOriginal(iptables.DirectionOriginal)
+}
 
-	var action iptables.Action
-	action = iptables.SNATAction{ToAddr: ip}
+// buildEipRules returns the SNAT rules of the policy. With a source port range,
+// the tcp and udp connections are translated within the range, and the other
+// protocols, which have no ports, to the EIP alone.
+func buildEipRules(policyName string, eip IP, version uint8, isIgnoreInternalCIDR bool, useNodeIP bool, sourcePorts string) []iptables.Rule {
+	ip := eip.V4
+	if version == 6 {
+		ip = eip.V6
+	}
+	matchCriteria := buildSnatMatch(policyName, version, isIgnoreInternalCIDR)
+	comment := []string{fmt.Sprintf("snat policy %s", policyName)}
 	if useNodeIP {
-		action = iptables.MasqAction{}
+		return []iptables.Rule{{Match: matchCriteria, Action: iptables.MasqAction{}, Comment: comment}}
 	}
-	rule := &iptables.Rule{Match: matchCriteria, Action: action, Comment: []string{
-		fmt.Sprintf("snat policy %s", policyName),
-	}}
-	return rule
+
+	rules := make([]iptables.Rule, 0, 3)
+	if sourcePorts != "" {
+		for _, protocol := range []string{"tcp", "udp"} {
+			rules = append(rules, iptables.Rule{
+				Match:   append(iptables.MatchCriteria{}.Protocol(protocol), matchCriteria...),
+				Action:  iptables.SNATAction{ToAddr: ip, ToPorts: sourcePorts},
+				Comment: comment,
+			})
+		}
+	}
+	rules = append(rules, iptables.Rule{Match: matchCriteria, Action: iptables.SNATAction{ToAddr: ip}, Comment: comment})
+	return rules
+}
+
+// buildBalancedEipRules spreads the connections of the policy over the EIPs
+// of the node, each rule takes its share of the connections left by the rules
+// before it, so that the EIPs get the same share.
+func buildBalancedEipRules(policyName string, eips []EIP, version uint8, isIgnoreInternalCIDR bool) []iptables.Rule {
+	groups := make([][]iptables.Rule, 0, len(eips))
+	for _, eip := range eips {
+		groups = append(groups, buildEipRules(policyName, eip.IP, version, isIgnoreInternalCIDR, false, eip.SourcePorts))
+	}
+
+	// the rules of a protocol are kept together, the EIPs of a policy have
+	// either all a source port range or none
+	res := make([]iptables.Rule, 0, len(eips)*len(groups[0]))
+	for i := range groups[0] {
+		for j, group := range groups {
+			if i >= len(group) {
+				continue
+			}
+			rule := group[i]
+			if j < len(groups)-1 {
+				rule.Match = append(iptables.MatchCriteria{}, rule.Match...).StatisticRandom(1 / float64(len(groups)-j))
+			}
+			res = append(res, rule)
+		}
+	}
+	return res
 }
 
 func parseMark(mark string) (uint32, error) {
@@ -565,6 +1068,80 @@ func parseMark(mark string) (uint32, error) {
 	return i32, nil
 }
 
+// buildDryRunRule builds a rule that matches the same traffic as buildPolicyRule,
+// it has no action, so the traffic is only counted
+func (r *policeReconciler) buildDryRunRule(policyName string, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+	rule := r.buildPolicyRule(policyName, 0, version, isIgnoreInternalCIDR)
+	rule.Action = nil
+	rule.Comment = []string{dryRunComment(policyName)}
+	return rule
+}
+
+func dryRunComment(policyName string) string {
+	return fmt.Sprintf("Count traffic for dry run EgressPolicy %s", policyName)
+}
+
+// dryRunCommentRegexp matches the comment of the dry run rules.
+var dryRunCommentRegexp = regexp.MustCompile(`--comment "(Count traffic for dry run EgressPolicy [^"]+)"`)
+
+// DryRunCounters reads the counters of the dry run rules from the mangle
+// tables, the counters of the IPv4 and IPv6 rules of a policy are summed.
+func (r *policeReconciler) DryRunCounters() (map[egressv1.Policy]dryrun.Counters, error) {
+	comments := make(map[string]egressv1.Policy)
+	r.dryRunPolicies.Range(func(policy egressv1.Policy, dryRun bool) bool {
+		if dryRun {
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			comments[dryRunComment(policyName)] = policy
+		}
+		return true
+	})
+	res := make(map[egressv1.Policy]dryrun.Counters)
+	if len(comments) == 0 {
+		return res, nil
+	}
+
+	// the rules are found by the hash of their desired state
+	hashes := make([]map[string]egressv1.Policy, len(r.mangleTables))
+	r.desiredChainsLock.Lock()
+	for i, table := range r.mangleTables {
+		hashes[i] = make(map[string]egressv1.Policy)
+		for _, rule := range r.desiredChains[chainKey(table, "EGRESSGATEWAY-MARK-REQUEST")] {
+			captures := dryRunCommentRegexp.FindStringSubmatch(rule.Rule)
+			if captures == nil {
+				continue
+			}
+			if policy, ok := comments[captures[1]]; ok {
+				hashes[i][rule.Hash] = policy
+			}
+		}
+	}
+	r.desiredChainsLock.Unlock()
+
+	for i, table := range r.mangleTables {
+		if len(hashes[i]) == 0 {
+			continue
+		}
+		counters, err := table.ChainCounters("EGRESSGATEWAY-MARK-REQUEST")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ipv%d mangle table: %w", table.IPVersion, err)
+		}
+		for hash, policy := range hashes[i] {
+			item, ok := counters[hash]
+			if !ok {
+				continue
+			}
+			cur := res[policy]
+			cur.Packets += item.Packets
+			cur.Bytes += item.Bytes
+			res[policy] = cur
+		}
+	}
+	return res, nil
+}
+
 func (r *policeReconciler) buildPolicyRule(policyName string, mark uint32, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
 	tmp := "v4-"
 	ignoreInternalCIDRName := EgressClusterCIDRIPv4
@@ -590,6 +1167,80 @@ func (r *policeReconciler) buildPolicyRule(policyName string, mark uint32, versi
 	return rule
 }
 
+// buildBalancedPolicyRules marks the flows of the policy with the tunnel mark
+// of one of its gateway nodes chosen by the hash of the flow. Only the new
+// flows are hashed, their mark is saved to the connection and restored for
+// the next packets, so that a flow keeps its gateway node when the number of
+// nodes changes. The flows established before the rules have no saved mark,
+// they are hashed at each packet.
+func (r *policeReconciler) buildBalancedPolicyRules(policyName string, marks []uint32, version uint8, isIgnoreInternalCIDR bool) []iptables.Rule {
+	base := r.buildPolicyRule(policyName, 0, version, isIgnoreInternalCIDR)
+	hash := iptables.HashMarkAction{Tuple: hashMarkTuple, Mod: uint32(len(marks)), Offset: hashMarkOffset}
+	rules := []iptables.Rule{
+		{
+			Match:  append(iptables.MatchCriteria{}.NotConntrackState("NEW"), base.Match...),
+			Action: iptables.RestoreConnMarkAction{RestoreMask: Mask},
+			Comment: []string{
+				fmt.Sprintf("Restore mark of flows of EgressPolicy %s", policyName),
+			},
+		},
+		{
+			Match:  append(iptables.MatchCriteria{}.ConntrackState("NEW"), base.Match...),
+			Action: hash,
+			Comment: []string{
+				fmt.Sprintf("Hash flows of EgressPolicy %s", policyName),
+			},
+		},
+		{
+			Match:  append(iptables.MatchCriteria{}.NotConntrackState("NEW").MarkClear(Mask), base.Match...),
+			Action: hash,
+			Comment: []string{
+				fmt.Sprintf("Hash flows without saved mark of EgressPolicy %s", policyName),
+			},
+		},
+	}
+	for i, mark := range marks {
+		rules = append(rules, iptables.Rule{
+			Match:  append(iptables.MatchCriteria{}.MarkMatchesWithMask(hashMarkOffset+uint32(i), Mask), base.Match...),
+			Action: iptables.SetMaskedMarkAction{Mark: mark, Mask: Mask},
+			Comment: []string{
+				fmt.Sprintf("Set mark for EgressPolicy %s", policyName),
+			},
+		})
+	}
+	rules = append(rules, iptables.Rule{
+		Match:  append(iptables.MatchCriteria{}.ConntrackState("NEW"), base.Match...),
+		Action: iptables.SaveConnMarkAction{SaveMask: Mask},
+		Comment: []string{
+			fmt.Sprintf("Save mark of flows of EgressPolicy %s", policyName),
+		},
+	})
+	return rules
+}
+
+// tunnelMarks returns the tunnel marks of the nodes sorted by name, so that
+// a flow keeps its gateway node when the rules are rebuilt. The nodes without
+// a tunnel are skipped.
+func (r *policeReconciler) tunnelMarks(ctx context.Context, nodeNames []string) ([]uint32, error) {
+	names := slices.Clone(nodeNames)
+	slices.Sort(names)
+	marks := make([]uint32, 0, len(names))
+	for _, name := range names {
+		node := new(egressv1.EgressTunnel)
+		err := r.client.Get(ctx, types.NamespacedName{Name: name}, node)
+		if err != nil {
+			r.log.Error(err, "failed to get egress tunnel, skip building rule of policy", "node", name)
+			continue
+		}
+		mark, err := parseMark(node.Status.Mark)
+		if err != nil {
+			return nil, err
+		}
+		marks = append(marks, mark)
+	}
+	return marks, nil
+}
+
 func buildNatStaticRule(base uint32) map[string][]iptables.Rule {
 	res := map[string][]iptables.Rule{"POSTROUTING": {
 		{
@@ -739,6 +1390,12 @@ func (r *policeReconciler) reconcileClusterInfo(ctx context.Context, req reconci
 	ipSet4 := &ipset.IPSet{Name: EgressClusterCIDRIPv4, SetType: ipset.HashNet, HashFamily: "inet"}
 	ipSet6 := &ipset.IPSet{Name: EgressClusterCIDRIPv6, SetType: ipset.HashNet, HashFamily: "inet6"}
 
+	if r.cfg.FileConfig.EnableIPv4 {
+		r.setIPSetEntries(EgressClusterCIDRIPv4, ipv4)
+	}
+	if r.cfg.FileConfig.EnableIPv6 {
+		r.setIPSetEntries(EgressClusterCIDRIPv6, ipv6)
+	}
 	err = process(gotIPv4, ipv4, func(item string) error {
 		return r.ipset.AddEntry(item, ipSet4, true)
 	}, func(item string) error {
@@ -798,16 +1455,32 @@ func (r *policeReconciler) reconcileTunnel(ctx context.Context, req reconcile.Re
 	return reconcile.Result{}, nil
 }
 
+// buildFenceRule drops the requests of the policy, see EGRESSGATEWAY-FENCE.
+func buildFenceRule(policyName string, version uint8, isIgnoreInternalCIDR bool) iptables.Rule {
+	return iptables.Rule{
+		Match:   buildSnatMatch(policyName, version, isIgnoreInternalCIDR),
+		Action:  iptables.DropAction{},
+		Comment: []string{fmt.Sprintf("lease of the EIP of policy %s is not held", policyName)},
+	}
+}
+
 func buildFilterStaticRule(base uint32) map[string][]iptables.Rule {
+	fence := iptables.Rule{
+		Match:  iptables.MatchCriteria{},
+		Action: iptables.JumpAction{Target: "EGRESSGATEWAY-FENCE"},
+		Comment: []string{
+			"Drop the egress traffic of the EIPs whose lease is not held",
+		},
+	}
 	res := map[string][]iptables.Rule{
-		"FORWARD": {{
+		"FORWARD": {fence, {
 			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, Mask),
 			Action: iptables.AcceptAction{},
 			Comment: []string{
 				"Accept for egress traffic from pod going to EgressTunnel",
 			},
 		}},
-		"OUTPUT": {{
+		"OUTPUT": {fence, {
 			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, Mask),
 			Action: iptables.AcceptAction{},
 			Comment: []string{
@@ -818,7 +1491,7 @@ func buildFilterStaticRule(base uint32) map[string][]iptables.Rule {
 	return res
 }
 
-func buildMangleStaticRule(base uint32,
+func buildMangleStaticRule(base, uplinkMark uint32,
 	isEgressNode bool,
 	enableGatewayReplyRoute bool, replyMark uint32) map[string][]iptables.Rule {
 
@@ -830,15 +1503,35 @@ func buildMangleStaticRule(base uint32,
 				"Accept for egress traffic from pod going to EgressTunnel",
 			},
 		},
+		{
+			Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(uplinkMark, Mark),
+			Action: iptables.SetMaskedMarkAction{Mark: base, Mask: Mask},
+			Comment: []string{
+				"Accept for egress traffic from pod going to EgressGateway uplink",
+			},
+		},
 	}
 
-	postrouting := []iptables.Rule{{
+	postrouting := make([]iptables.Rule, 0)
+	if isEgressNode {
+		postrouting = append(postrouting, iptables.Rule{
+			Match:   iptables.MatchCriteria{},
+			Action:  iptables.JumpAction{Target: "EGRESSGATEWAY-BANDWIDTH"},
+			Comment: []string{"EgressGateway bandwidth datapath rule, rule is from the EgressGateway"},
+		})
+		postrouting = append(postrouting, iptables.Rule{
+			Match:   iptables.MatchCriteria{},
+			Action:  iptables.JumpAction{Target: "EGRESSGATEWAY-DSCP"},
+			Comment: []string{"EgressGateway DSCP datapath rule, rule is from the EgressGateway"},
+		})
+	}
+	postrouting = append(postrouting, iptables.Rule{
 		Match:  iptables.MatchCriteria{}.MarkMatchesWithMask(base, Mask),
 		Action: iptables.AcceptAction{},
 		Comment: []string{
 			"Accept for egress traffic from pod going to EgressTunnel",
 		},
-	}}
+	})
 
 	prerouting := make([]iptables.Rule, 0)
 	prerouting = append(prerouting, iptables.Rule{
@@ -860,6 +1553,16 @@ func buildMangleStaticRule(base uint32,
 			Action:  iptables.JumpAction{Target: "EGRESSGATEWAY-REPLY-ROUTING"},
 			Comment: []string{"EgressGateway reply datapath rule, rule is from the EgressGateway"},
 		})
+		prerouting = append(prerouting, iptables.Rule{
+			Match:   iptables.MatchCriteria{},
+			Action:  iptables.JumpAction{Target: "EGRESSGATEWAY-UPLINK"},
+			Comment: []string{"EgressGateway uplink datapath rule, rule is from the EgressGateway"},
+		})
+		prerouting = append(prerouting, iptables.Rule{
+			Match:   iptables.MatchCriteria{}.MarkMatchesWithMask(uplinkMark, Mark),
+			Action:  iptables.AcceptAction{},
+			Comment: []string{"EgressGateway uplink datapath rule, rule is from the EgressGateway"},
+		})
 		prerouting = append(prerouting, iptables.Rule{
 			Match:   iptables.MatchCriteria{}.MarkMatchesWithMask(base, Mark),
 			Action:  iptables.AcceptAction{},
@@ -960,7 +1663,8 @@ func (r *policeReconciler) reconcilePolicy(ctx context.Context, req reconcile.Re
 	for _, node := range gateway.Status.NodeList {
 		for _, eip := range node.Eips {
 			for _, p := range eip.Policies {
-				if p.Name == policy.Name && p.Namespace == policy.Namespace {
+				// any EIP of the policy on this node makes it a gateway node of the policy
+				if p.Name == policy.Name && p.Namespace == policy.Namespace && nodeName != r.cfg.EnvConfig.NodeName {
 					nodeName = node.Name
 				}
 			}
@@ -977,6 +1681,18 @@ func (r *policeReconciler) reconcilePolicy(ctx context.Context, req reconcile.Re
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
+
+	err = r.applyPolicyMode(egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace},
+		policy.Spec.Mode, nodeName != "", log)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	err = r.applyPolicyTraffic(egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace},
+		trafficSpec{Bandwidth: policy.Spec.Bandwidth, DSCP: policy.Spec.DSCP},
+		flag && policy.Spec.Mode != egressv1.PolicyModeDryRun, log)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -1022,7 +1738,8 @@ func (r *policeReconciler) reconcileClusterPolicy(ctx context.Context, req recon
 	for _, node := range gateway.Status.NodeList {
 		for _, eip := range node.Eips {
 			for _, p := range eip.Policies {
-				if p.Name == policy.Name && p.Namespace == policy.Namespace {
+				// any EIP of the policy on this node makes it a gateway node of the policy
+				if p.Name == policy.Name && p.Namespace == policy.Namespace && nodeName != r.cfg.EnvConfig.NodeName {
 					nodeName = node.Name
 				}
 			}
@@ -1039,9 +1756,36 @@ func (r *policeReconciler) reconcileClusterPolicy(ctx context.Context, req recon
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
+
+	err = r.applyPolicyMode(egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace},
+		policy.Spec.Mode, nodeName != "", log)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	err = r.applyPolicyTraffic(egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace},
+		trafficSpec{Bandwidth: policy.Spec.Bandwidth, DSCP: policy.Spec.DSCP},
+		flag && policy.Spec.Mode != egressv1.PolicyModeDryRun, log)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
 	return reconcile.Result{}, nil
 }
 
+// applyPolicyMode rebuilds the rules when the mode of an assigned policy is not
+// the one the rules are built for, the rules of a policy which has none yet are
+// built by the reconcile of its gateway
+func (r *policeReconciler) applyPolicyMode(policy egressv1.Policy, mode string, assigned bool, log logr.Logger) error {
+	if !assigned {
+		return nil
+	}
+	dryRun, ok := r.dryRunPolicies.Load(policy)
+	if !ok || dryRun == (mode == egressv1.PolicyModeDryRun) {
+		return nil
+	}
+	log.Info("policy mode changed, rebuild rules", "mode", mode)
+	return r.initApplyPolicy()
+}
+
 func findDiff(oldList, newList []string) (toAdd, toDel []string) {
 	oldCopy := make([]string, len(oldList))
 	copy(oldCopy, oldList)
@@ -1120,6 +1864,7 @@ func (r *policeReconciler) removeIPSet(log logr.Logger, name string) {
 			log.Info("failed to delete ipset", "ipset", name, "warn", err)
 		}
 		r.ipsetMap.Delete(name)
+		r.ipsetEntries.Delete(name)
 	}
 }
 
@@ -1150,7 +1895,7 @@ func (r *policeReconciler) createIPSet(log logr.Logger, set SetName) error {
 	return nil
 }
 
-func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config, fence *eiplease.Fence, datapathServer *datapath.Server) error {
 	iptablesCfg := cfg.FileConfig.IPTables
 	opt := iptables.Options{
 		HistoricChainPrefixes:    []string{"egw"},
@@ -1212,18 +1957,51 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 		filterTables = append(filterTables, filter)
 	}
 
+	shaper := bandwidth.New(log)
+	if err := mgr.Add(shaper); err != nil {
+		return fmt.Errorf("failed to add bandwidth shaper: %w", err)
+	}
+
+	podIndex := podindex.New(cfg.EnvConfig.NodeName)
+	var flowLog *flowlog.Exporter
+	if cfg.FileConfig.FlowLog.Enable {
+		exporter, err := flowlog.New(log, cfg, podIndex)
+		if err != nil {
+			return fmt.Errorf("failed to create flow log exporter: %w", err)
+		}
+		if err := mgr.Add(exporter); err != nil {
+			return fmt.Errorf("failed to add flow log exporter: %w", err)
+		}
+		flowLog = exporter
+	}
+
 	e := exec.New()
 	r := &policeReconciler{
-		client:       mgr.GetClient(),
-		ipsetMap:     utils.NewSyncMap[string, *ipset.IPSet](),
-		log:          log,
-		ipset:        ipset.New(e),
-		cfg:          cfg,
-		mangleTables: mangleTables,
-		filterTables: filterTables,
-		natTables:    natTables,
-		ruleV4Map:    utils.NewSyncMap[string, iptables.Rule](),
-		ruleV6Map:    utils.NewSyncMap[string, iptables.Rule](),
+		client:         mgr.GetClient(),
+		ipsetMap:       utils.NewSyncMap[string, *ipset.IPSet](),
+		log:            log,
+		ipset:          ipset.New(e),
+		cfg:            cfg,
+		mangleTables:   mangleTables,
+		filterTables:   filterTables,
+		natTables:      natTables,
+		ruleV4Map:      utils.NewSyncMap[string, iptables.Rule](),
+		ruleV6Map:      utils.NewSyncMap[string, iptables.Rule](),
+		podIndex:       podIndex,
+		dryRunPolicies: utils.NewSyncMap[egressv1.Policy, bool](),
+		fence:          fence,
+		ruleRoute:      route.NewRuleRoute(route.WithLogger(log)),
+		shaper:         shaper,
+
+		trafficPolicies: utils.NewSyncMap[egressv1.Policy, trafficSpec](),
+		flowLog:         flowLog,
+		ipsetEntries:    utils.NewSyncMap[string, []string](),
+		repair:          make(chan event.GenericEvent, 1),
+	}
+	datapathServer.Register(r)
+
+	if err := mgr.Add(dryrun.New(r, log)); err != nil {
+		return fmt.Errorf("failed to add dry run sampler: %w", err)
 	}
 
 	c, err := controller.New("policy", mgr, controller.Options{Reconciler: r})
@@ -1238,6 +2016,18 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 		return fmt.Errorf("failed to watch EgressGateway: %w", err)
 	}
 
+	if fence != nil {
+		sourceFence := source.Channel(fence.Subscribe(), handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressGateway")))
+		if err := c.Watch(sourceFence); err != nil {
+			return fmt.Errorf("failed to watch EIP leases: %w", err)
+		}
+	}
+
+	sourceRepair := source.Channel(r.repair, handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("Datapath")))
+	if err := c.Watch(sourceRepair); err != nil {
+		return fmt.Errorf("failed to watch datapath repairs: %w", err)
+	}
+
 	sourceEgressPolicy := utils.SourceKind(mgr.GetCache(),
 		&egressv1.EgressPolicy{},
 		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressPolicy")),
@@ -1256,7 +2046,7 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 
 	sourceEgressEndpointSlice := utils.SourceKind(mgr.GetCache(),
 		&egressv1.EgressEndpointSlice{},
-		handler.EnqueueRequestsFromMapFunc(enqueueEndpointSlice()),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressEndpointSlice")),
 		epSlicePredicate{})
 	if err := c.Watch(sourceEgressEndpointSlice); err != nil {
 		return fmt.Errorf("failed to watch EgressEndpointSlice: %w", err)
@@ -1264,7 +2054,7 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 
 	sourceEgressClusterEndpointSlice := utils.SourceKind(mgr.GetCache(),
 		&egressv1.EgressClusterEndpointSlice{},
-		handler.EnqueueRequestsFromMapFunc(enqueueEndpointSlice()),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressClusterEndpointSlice")),
 		epSlicePredicate{})
 	if err := c.Watch(sourceEgressClusterEndpointSlice); err != nil {
 		return fmt.Errorf("failed to watch EgressClusterEndpointSlice: %w", err)
@@ -1368,34 +2158,6 @@ func (p policyPredicate) Generic(_ event.GenericEvent) bool { return false }
 type epSlicePredicate struct{}
 
 func (p epSlicePredicate) Create(_ event.CreateEvent) bool   { return true }
-func (p epSlicePredicate) Delete(_ event.DeleteEvent) bool   { return false }
+func (p epSlicePredicate) Delete(_ event.DeleteEvent) bool   { return true }
 func (p epSlicePredicate) Update(_ event.UpdateEvent) bool   { return true }
 func (p epSlicePredicate) Generic(_ event.GenericEvent) bool { return false }
-
-func enqueueEndpointSlice() handler.MapFunc {
-	return func(ctx context.Context, obj client.Object) []reconcile.Request {
-		namespace := obj.GetNamespace()
-		policyName, ok := obj.GetLabels()[egressv1.LabelPolicyName]
-		if !ok {
-			return nil
-		}
-
-		res := make([]reconcile.Request, 0)
-
-		if namespace == "" {
-			req := types.NamespacedName{
-				Namespace: "EgressClusterPolicy/",
-				Name:      policyName,
-			}
-			res = append(res, reconcile.Request{NamespacedName: req})
-		} else {
-			req := types.NamespacedName{
-				Namespace: path.Join("EgressPolicy", namespace),
-				Name:      policyName,
-			}
-			res = append(res, reconcile.Request{NamespacedName: req})
-		}
-
-		return res
-	}
-}
@@ -0,0 +1,539 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/bandwidth"
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	"github.com/spidernet-io/egressgateway/pkg/agent/dryrun"
+	"github.com/spidernet-io/egressgateway/pkg/agent/podindex"
+	"github.com/spidernet-io/egressgateway/pkg/agent/route"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/eiplease"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	ipsettest "github.com/spidernet-io/egressgateway/pkg/ipset/testing"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/iptables/testutils"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+var testKernelChains = map[string][]string{
+	"filter": {"INPUT", "FORWARD", "OUTPUT"},
+	"nat":    {"PREROUTING", "INPUT", "OUTPUT", "POSTROUTING"},
+	"mangle": {"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"},
+}
+
+func newTestTable(t *testing.T, name string) *iptables.Table {
+	table, _ := newTestTableDataplane(t, name)
+	return table
+}
+
+func newTestTableDataplane(t *testing.T, name string) (*iptables.Table, *testutils.MockDataplane) {
+	chains := make(map[string][]string)
+	for _, chain := range testKernelChains[name] {
+		chains[chain] = []string{}
+	}
+	dataplane := testutils.NewMockDataplane(name, chains, "legacy")
+	table, err := iptables.NewTable(name, 4, "egw:", iptables.Options{
+		HistoricChainPrefixes: []string{"egw"},
+		BackendMode:           "legacy",
+		InsertMode:            "insert",
+		NewCmdOverride:        dataplane.NewCmd,
+		SleepOverride:         dataplane.Sleep,
+		NowOverride:           dataplane.Now,
+		LookPathOverride:      func(file string) (string, error) { return file, nil },
+		XTablesLock:           iptables.DummyLock{},
+	}, logr.Discard())
+	assert.NoError(t, err)
+	return table, dataplane
+}
+
+// newTestPoliceReconciler returns the reconciler of node1 with IPv4 tables on
+// mock dataplanes, the routes and the qdiscs of the host are not changed.
+func newTestPoliceReconciler(t *testing.T, fence *eiplease.Fence, objs ...client.Object) *policeReconciler {
+	patches := gomonkey.ApplyMethodReturn(&route.RuleRoute{}, "PurgeStaleRules", nil)
+	patches.ApplyMethodReturn(&route.RuleRoute{}, "PurgeStaleRoutes", nil)
+	patches.ApplyMethodReturn(&bandwidth.Shaper{}, "Ensure", nil)
+	t.Cleanup(patches.Reset)
+
+	cfg := &config.Config{}
+	cfg.NodeName = "node1"
+	cfg.FileConfig.EnableIPv4 = true
+	cfg.FileConfig.Mark = "0x26000000"
+	cfg.FileConfig.UplinkMark = "0x28000000"
+
+	return &policeReconciler{
+		client:          fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).Build(),
+		log:             logr.Discard(),
+		cfg:             cfg,
+		ipsetMap:        utils.NewSyncMap[string, *ipset.IPSet](),
+		ipset:           ipsettest.NewFake("7.1"),
+		mangleTables:    []*iptables.Table{newTestTable(t, "mangle")},
+		filterTables:    []*iptables.Table{newTestTable(t, "filter")},
+		natTables:       []*iptables.Table{newTestTable(t, "nat")},
+		ruleV4Map:       utils.NewSyncMap[string, iptables.Rule](),
+		ruleV6Map:       utils.NewSyncMap[string, iptables.Rule](),
+		podIndex:        podindex.New(cfg.NodeName),
+		dryRunPolicies:  utils.NewSyncMap[egressv1.Policy, bool](),
+		fence:           fence,
+		ruleRoute:       route.NewRuleRoute(route.WithLogger(logr.Discard())),
+		shaper:          bandwidth.New(logr.Discard()),
+		trafficPolicies: utils.NewSyncMap[egressv1.Policy, trafficSpec](),
+		ipsetEntries:    utils.NewSyncMap[string, []string](),
+	}
+}
+
+// testGateway assigns the EIP to policy default/policy on node1
+func testGateway(ipv4 string) *egressv1.EgressGateway {
+	return &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{{
+			Name: "node1",
+			Eips: []egressv1.Eips{{
+				IPv4:     ipv4,
+				Policies: []egressv1.Policy{{Namespace: "default", Name: "policy"}},
+			}},
+		}}},
+	}
+}
+
+func testPolicy(mode string) *egressv1.EgressPolicy {
+	return &egressv1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "policy"},
+		Spec: egressv1.EgressPolicySpec{
+			EgressGatewayName: "default",
+			Mode:              mode,
+		},
+	}
+}
+
+// testChainRules returns the rendered rules of the chain in the tables
+func testChainRules(tables []*iptables.Table, chain string) []string {
+	res := make([]string, 0)
+	for _, table := range tables {
+		for _, rule := range table.DesiredChains()[chain] {
+			res = append(res, rule.Rule)
+		}
+	}
+	return res
+}
+
+func containsRule(rules []string, parts ...string) bool {
+	for _, rule := range rules {
+		found := true
+		for _, part := range parts {
+			if !strings.Contains(rule, part) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInitApplyPolicyLeaseLost(t *testing.T) {
+	cases := map[string]struct {
+		fence *eiplease.Fence
+		owns  bool
+	}{
+		"lease held": {
+			owns: true,
+		},
+		"lease lost": {
+			fence: eiplease.NewFence(nil, nil, logr.Discard(), &config.Config{}),
+			owns:  false,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := newTestPoliceReconciler(t, tc.fence, testGateway("10.6.1.21"), testPolicy(""))
+			assert.NoError(t, r.initApplyPolicy())
+
+			srcSet := formatIPSetName("egress-src-v4-", "default-policy")
+			snat := testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP")
+			fence := testChainRules(r.filterTables, "EGRESSGATEWAY-FENCE")
+			assert.Equal(t, tc.owns, containsRule(snat, srcSet, "--to-source 10.6.1.21"))
+			assert.Equal(t, !tc.owns, containsRule(fence, srcSet, "--jump DROP"))
+
+			// the traffic is dropped before it is accepted or masqueraded
+			for _, chain := range []string{"FORWARD", "OUTPUT"} {
+				rules := testChainRules(r.filterTables, chain)
+				assert.NotEmpty(t, rules)
+				assert.Contains(t, rules[0], "--jump EGRESSGATEWAY-FENCE")
+			}
+		})
+	}
+}
+
+func TestInitApplyPolicyLeaseLostNodeIP(t *testing.T) {
+	fence := eiplease.NewFence(nil, nil, logr.Discard(), &config.Config{})
+	r := newTestPoliceReconciler(t, fence, testGateway(""), testPolicy(""))
+	assert.NoError(t, r.initApplyPolicy())
+
+	// the policies which use the IP of the node have no lease
+	assert.Empty(t, testChainRules(r.filterTables, "EGRESSGATEWAY-FENCE"))
+	assert.True(t, containsRule(testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP"), "--jump MASQUERADE"))
+}
+
+func TestInitApplyPolicyBalanced(t *testing.T) {
+	gateway := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{
+			{Name: "node3", Eips: []egressv1.Eips{{IPv4: "10.6.1.22", Policies: []egressv1.Policy{{Namespace: "default", Name: "policy"}}}}},
+			{Name: "node2", Eips: []egressv1.Eips{{IPv4: "10.6.1.21", Policies: []egressv1.Policy{{Namespace: "default", Name: "policy"}}}}},
+		}},
+	}
+	tunnels := []client.Object{
+		&egressv1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Status: egressv1.EgressTunnelStatus{Mark: "0x26000002"}},
+		&egressv1.EgressTunnel{ObjectMeta: metav1.ObjectMeta{Name: "node3"}, Status: egressv1.EgressTunnelStatus{Mark: "0x26000003"}},
+	}
+	r := newTestPoliceReconciler(t, nil, append(tunnels, gateway, testPolicy(""))...)
+	assert.NoError(t, r.initApplyPolicy())
+
+	// the saved mark is restored before the hash, only the new flows and the
+	// flows without saved mark are hashed, and the mark is saved once chosen
+	srcSet := formatIPSetName("egress-src-v4-", "default-policy")
+	rules := testChainRules(r.mangleTables, "EGRESSGATEWAY-MARK-REQUEST")
+	exp := [][]string{
+		{"-m conntrack ! --ctstate NEW", "--jump CONNMARK --restore-mark --mask 0xffffffff"},
+		{"-m conntrack --ctstate NEW", "--jump HMARK", "--hmark-mod 2"},
+		{"-m conntrack ! --ctstate NEW", "-m mark --mark 0/0xffffffff", "--jump HMARK"},
+		{"-m mark --mark 0x27000000/0xffffffff", "--jump MARK --set-mark 0x26000002/0xffffffff"},
+		{"-m mark --mark 0x27000001/0xffffffff", "--jump MARK --set-mark 0x26000003/0xffffffff"},
+		{"-m conntrack --ctstate NEW", "--jump CONNMARK --save-mark --mask 0xffffffff"},
+	}
+	assert.Len(t, rules, len(exp))
+	for i, parts := range exp {
+		if i >= len(rules) {
+			break
+		}
+		assert.True(t, containsRule(rules[i:i+1], append(parts, srcSet)...), "rule %d: %s", i, rules[i])
+	}
+}
+
+func TestInitApplyPolicyUplink(t *testing.T) {
+	patches := gomonkey.ApplyMethodReturn(&route.RuleRoute{}, "Ensure", nil)
+	defer patches.Reset()
+
+	policy := egressv1.Policy{Namespace: "default", Name: "policy"}
+	gateway := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: egressv1.EgressGatewaySpec{Uplinks: []egressv1.Uplink{
+			{Interface: "eth1", IPPools: []string{"10.6.1.21-10.6.1.22"}},
+			{Interface: "eth2", IPPools: []string{"10.6.2.21-10.6.2.22"}},
+		}},
+		Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{{
+			Name: "node1",
+			Eips: []egressv1.Eips{
+				{IPv4: "10.6.1.21", Policies: []egressv1.Policy{policy}},
+				{IPv4: "10.6.2.21", Policies: []egressv1.Policy{policy}},
+				{IPv4: "10.6.1.22", Policies: []egressv1.Policy{policy}},
+			},
+		}}},
+	}
+	r := newTestPoliceReconciler(t, nil, gateway, testPolicy(""))
+	assert.NoError(t, r.initApplyPolicy())
+
+	// the EIP of eth2 would leave through eth1 with the other EIPs
+	snat := testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP")
+	assert.True(t, containsRule(snat, "10.6.1.21"))
+	assert.True(t, containsRule(snat, "10.6.1.22"))
+	assert.False(t, containsRule(snat, "10.6.2.21"))
+}
+
+func TestInitApplyPolicySourcePorts(t *testing.T) {
+	gateway := testGateway("10.6.1.21")
+	gateway.Status.NodeList[0].Eips[0].Policies = append(gateway.Status.NodeList[0].Eips[0].Policies,
+		egressv1.Policy{Namespace: "default", Name: "other"})
+	gateway.Status.NodeList[0].Eips[0].SourcePorts = []egressv1.PolicySourcePorts{
+		{Namespace: "default", Name: "policy", Ports: "20000-20999"},
+	}
+	other := testPolicy("")
+	other.Name = "other"
+	r := newTestPoliceReconciler(t, nil, gateway, testPolicy(""), other)
+	assert.NoError(t, r.initApplyPolicy())
+
+	// the tcp and udp connections within the reserved range, the policy
+	// without reservation within the largest range left, the other protocols
+	// to the EIP alone
+	snat := testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP")
+	assert.Len(t, snat, 6)
+	for name, ports := range map[string]string{"policy": ":20000-20999", "other": ":21000-65535"} {
+		srcSet := formatIPSetName("egress-src-v4-", "default-"+name)
+		assert.True(t, containsRule(snat, "-p tcp", srcSet, "--to-source 10.6.1.21"+ports), name)
+		assert.True(t, containsRule(snat, "-p udp", srcSet, "--to-source 10.6.1.21"+ports), name)
+		assert.True(t, slices.ContainsFunc(snat, func(rule string) bool {
+			return strings.Contains(rule, srcSet) && !strings.Contains(rule, "-p ") &&
+				strings.HasSuffix(rule, "--to-source 10.6.1.21")
+		}), name)
+	}
+}
+
+func TestPolicyCommonFenced(t *testing.T) {
+	cases := map[string]struct {
+		policy PolicyCommon
+		exp4   bool
+		exp6   bool
+	}{
+		"nothing fenced": {
+			policy: PolicyCommon{IP: IP{V4: "10.6.1.21"}},
+		},
+		"one family fenced": {
+			policy: PolicyCommon{IP: IP{V4: "10.6.1.21"}, Fenced: IP{V6: "fd00::21"}},
+			exp6:   true,
+		},
+		"another EIP held": {
+			policy: PolicyCommon{Extra: []EIP{{IP: IP{V4: "10.6.1.22"}}}, Fenced: IP{V4: "10.6.1.21"}},
+		},
+		"all fenced": {
+			policy: PolicyCommon{Fenced: IP{V4: "10.6.1.21", V6: "fd00::21"}},
+			exp4:   true,
+			exp6:   true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.exp4, tc.policy.fenced(4))
+			assert.Equal(t, tc.exp6, tc.policy.fenced(6))
+		})
+	}
+}
+
+func TestInitApplyPolicyDryRun(t *testing.T) {
+	r := newTestPoliceReconciler(t, nil, testGateway("10.6.1.21"), testPolicy(egressv1.PolicyModeDryRun))
+	assert.NoError(t, r.initApplyPolicy())
+
+	// the traffic is only counted, it is neither marked nor SNATed
+	srcSet := formatIPSetName("egress-src-v4-", "default-policy")
+	mark := testChainRules(r.mangleTables, "EGRESSGATEWAY-MARK-REQUEST")
+	assert.True(t, containsRule(mark, srcSet, "Count traffic for dry run EgressPolicy default-policy"))
+	assert.False(t, containsRule(mark, "--jump"))
+	assert.False(t, containsRule(testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP"), srcSet))
+	assert.Empty(t, testChainRules(r.filterTables, "EGRESSGATEWAY-FENCE"))
+
+	dryRun, ok := r.dryRunPolicies.Load(egressv1.Policy{Namespace: "default", Name: "policy"})
+	assert.True(t, ok)
+	assert.True(t, dryRun)
+}
+
+func TestApplyPolicyMode(t *testing.T) {
+	policy := testPolicy(egressv1.PolicyModeEnforce)
+	r := newTestPoliceReconciler(t, nil, testGateway("10.6.1.21"), policy)
+	// each rebuild of the rules lists the gateways
+	rebuilds := 0
+	r.client = interceptor.NewClient(r.client.(client.WithWatch), interceptor.Funcs{
+		List: func(ctx context.Context, cli client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*egressv1.EgressGatewayList); ok {
+				rebuilds++
+			}
+			return cli.List(ctx, list, opts...)
+		},
+	})
+	assert.NoError(t, r.initApplyPolicy())
+	assert.Equal(t, 1, rebuilds)
+
+	ctx := context.Background()
+	key := egressv1.Policy{Namespace: "default", Name: "policy"}
+	srcSet := formatIPSetName("egress-src-v4-", "default-policy")
+	snat := func() []string { return testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP") }
+	assert.True(t, containsRule(snat(), srcSet, "--to-source 10.6.1.21"))
+
+	// the mode did not change
+	assert.NoError(t, r.applyPolicyMode(key, egressv1.PolicyModeEnforce, true, logr.Discard()))
+	assert.Equal(t, 1, rebuilds)
+
+	// the policy is switched to dry run
+	policy.Spec.Mode = egressv1.PolicyModeDryRun
+	assert.NoError(t, r.client.Update(ctx, policy))
+	assert.NoError(t, r.applyPolicyMode(key, egressv1.PolicyModeDryRun, true, logr.Discard()))
+	assert.Equal(t, 2, rebuilds)
+	assert.False(t, containsRule(snat(), srcSet))
+	assert.True(t, containsRule(testChainRules(r.mangleTables, "EGRESSGATEWAY-MARK-REQUEST"), srcSet, "dry run"))
+	assert.NoError(t, r.applyPolicyMode(key, egressv1.PolicyModeDryRun, true, logr.Discard()))
+	assert.Equal(t, 2, rebuilds)
+
+	// and back to enforce
+	policy.Spec.Mode = egressv1.PolicyModeEnforce
+	assert.NoError(t, r.client.Update(ctx, policy))
+	assert.NoError(t, r.applyPolicyMode(key, egressv1.PolicyModeEnforce, true, logr.Discard()))
+	assert.Equal(t, 3, rebuilds)
+	assert.True(t, containsRule(snat(), srcSet, "--to-source 10.6.1.21"))
+
+	// the rules of a policy which has none yet are built by its gateway, and
+	// an unassigned policy has no rules
+	other := egressv1.Policy{Namespace: "default", Name: "other"}
+	assert.NoError(t, r.applyPolicyMode(other, egressv1.PolicyModeDryRun, true, logr.Discard()))
+	assert.NoError(t, r.applyPolicyMode(key, egressv1.PolicyModeDryRun, false, logr.Discard()))
+	assert.Equal(t, 3, rebuilds)
+}
+
+// countingIPSet counts the full listings of the sets and fails the next adds
+type countingIPSet struct {
+	*ipsettest.FakeIPSet
+	listed  int
+	failAdd int
+}
+
+func (s *countingIPSet) ListEntries(set string) ([]string, error) {
+	s.listed++
+	return s.FakeIPSet.ListEntries(set)
+}
+
+func (s *countingIPSet) AddEntry(entry string, set *ipset.IPSet, ignoreExistErr bool) error {
+	if s.failAdd > 0 {
+		s.failAdd--
+		return fmt.Errorf("failed to add %s", entry)
+	}
+	return s.FakeIPSet.AddEntry(entry, set, ignoreExistErr)
+}
+
+func testEndpointSlice(ips ...string) *egressv1.EgressEndpointSlice {
+	slice := &egressv1.EgressEndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "policy-abcde",
+			Labels:    map[string]string{egressv1.LabelPolicyName: "policy"},
+		},
+	}
+	for i, ip := range ips {
+		slice.Endpoints = append(slice.Endpoints, egressv1.EgressEndpoint{
+			Namespace: "default",
+			Pod:       fmt.Sprintf("pod%d", i),
+			IPv4:      []string{ip},
+			Node:      "node2",
+		})
+	}
+	return slice
+}
+
+func TestReconcileEndpointSlice(t *testing.T) {
+	ctx := context.Background()
+	srcSet := formatIPSetName("egress-src-v4-", "default-policy")
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy-abcde"}}
+	update := func(t *testing.T, r *policeReconciler, ips ...string) {
+		slice := new(egressv1.EgressEndpointSlice)
+		assert.NoError(t, r.client.Get(ctx, req.NamespacedName, slice))
+		slice.Endpoints = testEndpointSlice(ips...).Endpoints
+		assert.NoError(t, r.client.Update(ctx, slice))
+		_, err := r.reconcileEndpointSlice(ctx, "EgressEndpointSlice", req, logr.Discard())
+		assert.NoError(t, err)
+	}
+
+	cases := map[string]struct {
+		prepare func(r *policeReconciler, set *countingIPSet)
+		full    bool
+	}{
+		"delta": {},
+		"entry can not be added": {
+			prepare: func(_ *policeReconciler, set *countingIPSet) { set.failAdd = 1 },
+			full:    true,
+		},
+		"ipset not found": {
+			prepare: func(r *policeReconciler, _ *countingIPSet) { r.ipsetMap.Delete(srcSet) },
+			full:    true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := newTestPoliceReconciler(t, nil, testGateway("10.6.1.21"), testPolicy(""),
+				testEndpointSlice("10.21.0.2", "10.21.0.3"))
+			set := &countingIPSet{FakeIPSet: ipsettest.NewFake("7.1")}
+			r.ipset = set
+			assert.NoError(t, r.initApplyPolicy())
+			assert.ElementsMatch(t, []string{"10.21.0.2", "10.21.0.3"}, set.Entries[srcSet].UnsortedList())
+
+			if tc.prepare != nil {
+				tc.prepare(r, set)
+			}
+			listed := set.listed
+			update(t, r, "10.21.0.3", "10.21.0.4")
+			assert.ElementsMatch(t, []string{"10.21.0.3", "10.21.0.4"}, set.Entries[srcSet].UnsortedList())
+			assert.Equal(t, tc.full, set.listed > listed, "the ipsets are fully updated")
+			entries, ok := r.ipsetEntries.Load(srcSet)
+			assert.True(t, ok)
+			assert.ElementsMatch(t, []string{"10.21.0.3", "10.21.0.4"}, entries)
+		})
+	}
+}
+
+func TestReconcileEndpointSliceDeleted(t *testing.T) {
+	ctx := context.Background()
+	srcSet := formatIPSetName("egress-src-v4-", "default-policy")
+	slice := testEndpointSlice("10.21.0.2")
+	r := newTestPoliceReconciler(t, nil, testGateway("10.6.1.21"), testPolicy(""), slice)
+	set := &countingIPSet{FakeIPSet: ipsettest.NewFake("7.1")}
+	r.ipset = set
+	assert.NoError(t, r.initApplyPolicy())
+	assert.Equal(t, []string{"10.21.0.2"}, set.Entries[srcSet].UnsortedList())
+
+	listed := set.listed
+	assert.NoError(t, r.client.Delete(ctx, slice))
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: slice.Namespace, Name: slice.Name}}
+	_, err := r.reconcileEndpointSlice(ctx, "EgressEndpointSlice", req, logr.Discard())
+	assert.NoError(t, err)
+	assert.Empty(t, set.Entries[srcSet].UnsortedList())
+	assert.Equal(t, listed, set.listed)
+}
+
+func TestDryRunCounters(t *testing.T) {
+	r := newTestPoliceReconciler(t, nil, testGateway("10.6.1.21"), testPolicy(egressv1.PolicyModeDryRun))
+	mangle, dataplane := newTestTableDataplane(t, "mangle")
+	r.mangleTables = []*iptables.Table{mangle}
+
+	// no policy in dry run mode, iptables is not read
+	counters, err := r.DryRunCounters()
+	assert.NoError(t, err)
+	assert.Empty(t, counters)
+
+	assert.NoError(t, r.initApplyPolicy())
+	dataplane.RuleCounters = make(map[string][2]uint64)
+	for _, rule := range dataplane.Chains["EGRESSGATEWAY-MARK-REQUEST"] {
+		dataplane.RuleCounters[rule] = [2]uint64{10, 1500}
+	}
+	counters, err = r.DryRunCounters()
+	assert.NoError(t, err)
+	assert.Equal(t, map[egressv1.Policy]dryrun.Counters{
+		{Namespace: "default", Name: "policy"}: {Packets: 10, Bytes: 1500},
+	}, counters)
+}
+
+func TestRepairDatapath(t *testing.T) {
+	r := newTestPoliceReconciler(t, nil, testGateway("10.6.1.21"), testPolicy(egressv1.PolicyModeEnforce))
+	r.repair = make(chan event.GenericEvent, 1)
+
+	// the repair is queued, the drift of the last one is kept
+	first := []datapath.Difference{{Kind: "ipset", Name: "egress-src-v4-default-policy", Missing: []string{"10.21.0.10"}}}
+	second := []datapath.Difference{{Kind: "chain", Name: "ipv4/nat/EGRESSGATEWAY-SNAT-EIP", Order: true}}
+	assert.ErrorIs(t, r.Repair(first), datapath.ErrRepairQueued)
+	assert.ErrorIs(t, r.Repair(second), datapath.ErrRepairQueued)
+	assert.Len(t, r.repair, 1)
+	assert.Equal(t, second, r.repairDiff)
+
+	_, err := r.repairDatapath(context.Background(), logr.Discard())
+	assert.NoError(t, err)
+	assert.Nil(t, r.repairDiff)
+	assert.True(t, containsRule(testChainRules(r.natTables, "EGRESSGATEWAY-SNAT-EIP"), "--to-source 10.6.1.21"))
+}
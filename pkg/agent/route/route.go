@@ -5,16 +5,19 @@ package route
 
 import (
 	"net"
+	"sync"
 
 	"github.com/go-logr/logr"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
 	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
 // NewRuleRoute creates a new RuleRoute with the provided options.
 func NewRuleRoute(options ...Option) *RuleRoute {
-	r := &RuleRoute{priority: 99}
+	r := &RuleRoute{priority: 99, desired: make(map[int]desiredRoute)}
 	for _, o := range options {
 		o(r)
 	}
@@ -40,6 +43,16 @@ func WithLogger(logger logr.Logger) Option {
 type RuleRoute struct {
 	log      logr.Logger
 	priority int
+
+	// desired are the rules and routes ensured by table, for the debug API
+	mutex   sync.Mutex
+	desired map[int]desiredRoute
+}
+
+type desiredRoute struct {
+	link       string
+	ipv4, ipv6 *net.IP
+	mark       int
 }
 
 func (r *RuleRoute) PurgeStaleRules(marks map[int]struct{}, baseMark string) error {
@@ -47,6 +60,10 @@ func (r *RuleRoute) PurgeStaleRules(marks map[int]struct{}, baseMark string) err
 	if err != nil {
 		return err
 	}
+	r.forget(func(table int, item desiredRoute) bool {
+		_, ok := marks[item.mark]
+		return !ok && int(start) <= item.mark && int(end) >= item.mark
+	})
 
 	clean := func(rules []netlink.Rule, family int) error {
 		for _, rule := range rules {
@@ -83,10 +100,47 @@ func (r *RuleRoute) PurgeStaleRules(marks map[int]struct{}, baseMark string) err
 	return nil
 }
 
+// PurgeStaleRoutes deletes the routes of the tables in the range of baseMark
+// which are not in tables, the table of a mark is the mark itself.
+func (r *RuleRoute) PurgeStaleRoutes(tables map[int]struct{}, baseMark string) error {
+	start, end, err := markallocator.RangeSize(baseMark)
+	if err != nil {
+		return err
+	}
+	r.forget(func(table int, item desiredRoute) bool {
+		_, ok := tables[table]
+		return !ok && int(start) <= table && int(end) >= table
+	})
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		// an unspecified table lists the routes of all tables
+		routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			if route.Table < int(start) || route.Table > int(end) {
+				continue
+			}
+			if _, ok := tables[route.Table]; ok {
+				continue
+			}
+			r.log.V(1).Info("delete stale route", "route", route.String())
+			if err := netlink.RouteDel(&route); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (r *RuleRoute) Ensure(linkName string, ipv4, ipv6 *net.IP, table int, mark int) error {
 	if mark == 0 {
 		return nil
 	}
+	r.mutex.Lock()
+	r.desired[table] = desiredRoute{link: linkName, ipv4: ipv4, ipv6: ipv6, mark: mark}
+	r.mutex.Unlock()
 
 	log := r.log.WithValues("linkName", linkName, "table", table, "mark", mark)
 
@@ -217,3 +271,82 @@ func (r *RuleRoute) EnsureRule(family int, table int, mark int, log logr.Logger)
 	}
 	return nil
 }
+
+func (r *RuleRoute) forget(stale func(table int, item desiredRoute) bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for table, item := range r.desired {
+		if stale(table, item) {
+			delete(r.desired, table)
+		}
+	}
+}
+
+// Desired adds the rules and the routes ensured by the RuleRoute to the state.
+func (r *RuleRoute) Desired(state *datapath.State) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for table, item := range r.desired {
+		for _, family := range []struct {
+			name string
+			ip   *net.IP
+		}{{"ipv4", item.ipv4}, {"ipv6", item.ipv6}} {
+			if family.ip == nil {
+				continue
+			}
+			state.Rules = append(state.Rules, datapath.Rule{
+				Family: family.name, Priority: r.priority, Mark: item.mark, Table: table,
+			})
+			state.Routes = append(state.Routes, datapath.Route{
+				Family: family.name, Table: table, Link: item.link, Gateway: family.ip.String(),
+			})
+		}
+	}
+}
+
+// Programmed adds the rules of the marks in the range of baseMark, and the
+// routes of their tables to the state.
+func (r *RuleRoute) Programmed(state *datapath.State, baseMark string) error {
+	start, end, err := markallocator.RangeSize(baseMark)
+	if err != nil {
+		return err
+	}
+	inRange := func(val int) bool { return int(start) <= val && int(end) >= val }
+
+	for _, family := range []struct {
+		name string
+		val  int
+	}{{"ipv4", netlink.FAMILY_V4}, {"ipv6", netlink.FAMILY_V6}} {
+		rules, err := netlink.RuleListFiltered(family.val, nil, netlink.RT_FILTER_MARK)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if !inRange(rule.Mark) {
+				continue
+			}
+			state.Rules = append(state.Rules, datapath.Rule{
+				Family: family.name, Priority: rule.Priority, Mark: rule.Mark, Table: rule.Table,
+			})
+		}
+
+		routes, err := netlink.RouteListFiltered(family.val, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			if !inRange(route.Table) {
+				continue
+			}
+			item := datapath.Route{Family: family.name, Table: route.Table}
+			if route.Gw != nil {
+				item.Gateway = route.Gw.String()
+			}
+			if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+				item.Link = link.Attrs().Name
+			}
+			state.Routes = append(state.Routes, item)
+		}
+	}
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
 	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
@@ -71,6 +72,66 @@ func TestPurgeStaleRules(t *testing.T) {
 	}
 }
 
+func TestPurgeStaleRoutes(t *testing.T) {
+	cases := map[string]struct {
+		prepare func() []gomonkey.Patches
+		expErr  bool
+	}{
+		"failed RangeSize": {
+			prepare: errPurgeStaleRulesRangeSize,
+			expErr:  true,
+		},
+		"failed RouteListFiltered": {
+			prepare: func() []gomonkey.Patches {
+				patch := gomonkey.ApplyFuncReturn(netlink.RouteListFiltered, nil, errors.New("some error"))
+				return []gomonkey.Patches{*patch}
+			},
+			expErr: true,
+		},
+		"failed RouteDel": {
+			prepare: func() []gomonkey.Patches {
+				patch := gomonkey.ApplyFuncReturn(netlink.RouteListFiltered, []netlink.Route{{Table: 0x1003}}, nil)
+				patch2 := gomonkey.ApplyFuncReturn(netlink.RouteDel, errors.New("some error"))
+				return []gomonkey.Patches{*patch, *patch2}
+			},
+			expErr: true,
+		},
+		"succeed": {
+			prepare: func() []gomonkey.Patches {
+				routes := []netlink.Route{{Table: 0x1001}, {Table: 0x1003}, {Table: 254}}
+				patch := gomonkey.ApplyFuncReturn(netlink.RouteListFiltered, routes, nil)
+				patch2 := gomonkey.ApplyFunc(netlink.RouteDel, func(route *netlink.Route) error {
+					if route.Table != 0x1003 {
+						return errors.New("the route is not stale")
+					}
+					return nil
+				})
+				return []gomonkey.Patches{*patch, *patch2}
+			},
+		},
+	}
+	ruleRoute := NewRuleRoute()
+
+	tables := map[int]struct{}{
+		0x1001: {},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			patches := tc.prepare()
+			err := ruleRoute.PurgeStaleRoutes(tables, "1000")
+			if tc.expErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, p := range patches {
+				p.Reset()
+			}
+		})
+	}
+}
+
 func TestEnsure(t *testing.T) {
 	cases := map[string]struct {
 		makePatch func(*RuleRoute) []gomonkey.Patches
@@ -135,6 +196,43 @@ func TestEnsure(t *testing.T) {
 	}
 }
 
+func TestDesired(t *testing.T) {
+	ruleRoute := NewRuleRoute()
+	patches := successEnsure(ruleRoute)
+	patches = append(patches, *gomonkey.ApplyMethodReturn(ruleRoute, "EnsureRule", nil))
+	patches = append(patches, *gomonkey.ApplyFuncReturn(netlink.RouteListFiltered, nil, nil))
+	defer func() {
+		for _, p := range patches {
+			p.Reset()
+		}
+	}()
+
+	ipv4 := net.ParseIP("192.200.0.2")
+	assert.NoError(t, ruleRoute.Ensure("egress.vxlan", &ipv4, nil, 0x26000001, 0x26000001))
+	assert.NoError(t, ruleRoute.Ensure("egress.vxlan", &ipv4, nil, 0x26000002, 0x26000002))
+
+	state := datapath.NewState()
+	ruleRoute.Desired(&state)
+	state.Sort()
+	assert.Equal(t, []datapath.Rule{
+		{Family: "ipv4", Priority: 99, Mark: 0x26000001, Table: 0x26000001},
+		{Family: "ipv4", Priority: 99, Mark: 0x26000002, Table: 0x26000002},
+	}, state.Rules)
+	assert.Equal(t, []datapath.Route{
+		{Family: "ipv4", Table: 0x26000001, Link: "egress.vxlan", Gateway: "192.200.0.2"},
+		{Family: "ipv4", Table: 0x26000002, Link: "egress.vxlan", Gateway: "192.200.0.2"},
+	}, state.Routes)
+
+	// the stale tables are no longer desired
+	err := ruleRoute.PurgeStaleRoutes(map[int]struct{}{0x26000002: {}}, "0x26000000")
+	assert.NoError(t, err)
+	state = datapath.NewState()
+	ruleRoute.Desired(&state)
+	assert.Equal(t, []datapath.Route{
+		{Family: "ipv4", Table: 0x26000002, Link: "egress.vxlan", Gateway: "192.200.0.2"},
+	}, state.Routes)
+}
+
 func TestEnsureRoute(t *testing.T) {
 	cases := map[string]struct {
 		makePatch func() []gomonkey.Patches
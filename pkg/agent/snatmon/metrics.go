@@ -0,0 +1,77 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package snatmon
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	gaugeConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "snat",
+		Name:      "connections",
+		Help:      "Number of conntrack entries SNATed to the EIP",
+	}, []string{"eip", "protocol"})
+
+	gaugePortsInUse = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "snat",
+		Name:      "ports_in_use",
+		Help:      "Highest number of source ports of the EIP in use towards a single destination",
+	}, []string{"eip", "protocol"})
+
+	gaugePortUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "snat",
+		Name:      "port_usage_ratio",
+		Help:      "Highest ratio of the source ports of the EIP in use towards a single destination to the ports available to them",
+	}, []string{"eip"})
+
+	gaugeConntrackEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "conntrack",
+		Name:      "entries",
+		Help:      "Number of conntrack entries of the node",
+	})
+
+	gaugeConntrackMax = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "egressgateway",
+		Subsystem: "conntrack",
+		Name:      "max",
+		Help:      "Size of the conntrack table of the node",
+	})
+
+	countInsertFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "conntrack",
+		Name:      "insert_failed_total",
+		Help:      "Number of conntrack entries which could not be inserted, usually because no source port was free",
+	})
+
+	countDrop = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "conntrack",
+		Name:      "drop_total",
+		Help:      "Number of packets dropped because their conntrack entry could not be created",
+	})
+
+	countEarlyDrop = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "conntrack",
+		Name:      "early_drop_total",
+		Help:      "Number of conntrack entries evicted to make room for new ones in a full table",
+	})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		gaugeConnections,
+		gaugePortsInUse,
+		gaugePortUsage,
+		gaugeConntrackEntries,
+		gaugeConntrackMax,
+		countInsertFailed,
+		countDrop,
+		countEarlyDrop,
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package snatmon samples the conntrack table of the gateway node to report
+// the SNAT port usage of each EIP, the conntrack insert failures and drops as
+// metrics, and a SNATPortPressure condition on the EgressGateway of an EIP
+// whose usage is above the threshold.
+package snatmon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	ReasonSNATPortPressure         = "SNATPortPressure"
+	ReasonSNATPortPressureResolved = "SNATPortPressureResolved"
+
+	reasonAboveThreshold = "PortUsageAboveThreshold"
+	reasonBelowThreshold = "PortUsageBelowThreshold"
+
+	// pressureUpdateInterval limits the status updates of an EIP whose usage
+	// stays above the threshold
+	pressureUpdateInterval = time.Minute
+	// pressureExpire removes the entries of a node whose agent stopped
+	// updating them
+	pressureExpire = 5 * time.Minute
+)
+
+// Monitor samples the conntrack table of the node.
+type Monitor struct {
+	client    client.Client
+	recorder  record.EventRecorder
+	log       logr.Logger
+	nodeName  string
+	interval  time.Duration
+	threshold int
+
+	listFlows func(family netlink.InetFamily) ([]*netlink.ConntrackFlow, error)
+	procRoot  string
+	now       func() time.Time
+
+	// last are the kernel counters of the last sample
+	last *kernelStats
+	// above are the EIPs whose usage was above the threshold at the last sample
+	above map[string]bool
+}
+
+func New(cli client.Client, recorder record.EventRecorder, log logr.Logger, cfg *config.Config) *Monitor {
+	monitor := cfg.FileConfig.SNATMonitor
+	return &Monitor{
+		client:    cli,
+		recorder:  recorder,
+		log:       log.WithName("snatMonitor"),
+		nodeName:  cfg.NodeName,
+		interval:  time.Duration(monitor.IntervalSecond) * time.Second,
+		threshold: monitor.UsageThreshold,
+		listFlows: func(family netlink.InetFamily) ([]*netlink.ConntrackFlow, error) {
+			return netlink.ConntrackTableList(netlink.ConntrackTable, family)
+		},
+		procRoot: "/proc",
+		now:      time.Now,
+		above:    make(map[string]bool),
+	}
+}
+
+func (m *Monitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.sample(ctx); err != nil {
+			m.log.Error(err, "failed to sample SNAT port usage")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) sample(ctx context.Context) error {
+	stats, err := readKernelStats(m.procRoot)
+	if err != nil {
+		m.log.V(1).Info("failed to read conntrack stats", "err", err)
+	} else {
+		m.recordStats(stats)
+	}
+
+	egwList := new(egressv1.EgressGatewayList)
+	if err := m.client.List(ctx, egwList); err != nil {
+		return fmt.Errorf("failed to list EgressGateway: %w", err)
+	}
+	eips := nodeEips(egwList, m.nodeName)
+	flows, err := m.flows(eips)
+	if err != nil {
+		return err
+	}
+	usage := computeUsage(flows, eips)
+	recordUsage(usage)
+
+	own := make(map[string][]egressv1.SNATPressure)
+	for eip, item := range usage {
+		gateway := eips[eip].gateway
+		if item.Percent() < m.threshold {
+			if m.above[eip] {
+				m.event(gateway, corev1.EventTypeNormal, ReasonSNATPortPressureResolved,
+					"SNAT port usage of EIP %s on node %s dropped to %d%%", eip, m.nodeName, item.Percent())
+			}
+			continue
+		}
+		if !m.above[eip] {
+			m.event(gateway, corev1.EventTypeWarning, ReasonSNATPortPressure,
+				"SNAT port usage of EIP %s on node %s is %d%%, above the threshold %d%%", eip, m.nodeName, item.Percent(), m.threshold)
+		}
+		own[gateway] = append(own[gateway], egressv1.SNATPressure{
+			IP: eip, Node: m.nodeName, Usage: item.Percent(), LastSeen: metav1.NewTime(m.now()),
+		})
+	}
+	m.above = make(map[string]bool)
+	for _, list := range own {
+		for _, item := range list {
+			m.above[item.IP] = true
+		}
+	}
+
+	var firstErr error
+	for _, egw := range egwList.Items {
+		status := egw.Status.DeepCopy()
+		mergePressure(status, m.nodeName, own[egw.Name], m.now(), egw.Generation)
+		if equality.Semantic.DeepEqual(status.SNATPressure, egw.Status.SNATPressure) &&
+			equality.Semantic.DeepEqual(status.Conditions, egw.Status.Conditions) {
+			continue
+		}
+		if err := m.updateStatus(ctx, egw.Name, own[egw.Name]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// flows lists the conntrack entries of the address families of the EIPs.
+func (m *Monitor) flows(eips map[string]eipRanges) ([]*netlink.ConntrackFlow, error) {
+	var v4, v6 bool
+	for eip := range eips {
+		if net.ParseIP(eip).To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	res := make([]*netlink.ConntrackFlow, 0)
+	for family, ok := range map[netlink.InetFamily]bool{netlink.FAMILY_V4: v4, netlink.FAMILY_V6: v6} {
+		if !ok {
+			continue
+		}
+		flows, err := m.listFlows(family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list conntrack entries: %w", err)
+		}
+		res = append(res, flows...)
+	}
+	return res, nil
+}
+
+func (m *Monitor) event(gateway, eventType, reason, messageFmt string, args ...interface{}) {
+	egw := &egressv1.EgressGateway{ObjectMeta: metav1.ObjectMeta{Name: gateway}}
+	m.recorder.Eventf(egw, eventType, reason, messageFmt, args...)
+}
+
+func (m *Monitor) updateStatus(ctx context.Context, name string, own []egressv1.SNATPressure) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		egw := new(egressv1.EgressGateway)
+		if err := m.client.Get(ctx, types.NamespacedName{Name: name}, egw); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		mergePressure(&egw.Status, m.nodeName, own, m.now(), egw.Generation)
+		return m.client.Status().Update(ctx, egw)
+	})
+}
+
+// mergePressure replaces the entries of node with own, drops the expired
+// entries of the other nodes and sets the SNATPortPressure condition. An entry
+// whose usage did not change keeps its time until pressureUpdateInterval.
+func mergePressure(status *egressv1.EgressGatewayStatus, node string, own []egressv1.SNATPressure, now time.Time, generation int64) {
+	previous := make(map[string]egressv1.SNATPressure)
+	res := make([]egressv1.SNATPressure, 0, len(status.SNATPressure)+len(own))
+	for _, item := range status.SNATPressure {
+		if item.Node == node {
+			previous[item.IP] = item
+			continue
+		}
+		if now.Sub(item.LastSeen.Time) > pressureExpire {
+			continue
+		}
+		res = append(res, item)
+	}
+	for _, item := range own {
+		if prev, ok := previous[item.IP]; ok && prev.Usage == item.Usage &&
+			now.Sub(prev.LastSeen.Time) < pressureUpdateInterval {
+			item.LastSeen = prev.LastSeen
+		}
+		res = append(res, item)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Node != res[j].Node {
+			return res[i].Node < res[j].Node
+		}
+		return res[i].IP < res[j].IP
+	})
+	if len(res) == 0 {
+		res = nil
+	}
+	status.SNATPressure = res
+
+	condition := metav1.Condition{
+		Type:               egressv1.ConditionSNATPortPressure,
+		Status:             metav1.ConditionFalse,
+		Reason:             reasonBelowThreshold,
+		Message:            "the SNAT port usage of the EIPs is below the threshold",
+		ObservedGeneration: generation,
+	}
+	if len(res) > 0 {
+		items := make([]string, 0, len(res))
+		for _, item := range res {
+			items = append(items, fmt.Sprintf("EIP %s on node %s at %d%%", item.IP, item.Node, item.Usage))
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonAboveThreshold
+		condition.Message = "the SNAT port usage is above the threshold: " + strings.Join(items, ", ")
+	} else if apimeta.FindStatusCondition(status.Conditions, egressv1.ConditionSNATPortPressure) == nil {
+		// the condition is only added by the first pressure
+		return
+	}
+	apimeta.SetStatusCondition(&status.Conditions, condition)
+}
+
+func (m *Monitor) recordStats(stats kernelStats) {
+	gaugeConntrackEntries.Set(float64(stats.entries))
+	gaugeConntrackMax.Set(float64(stats.max))
+	if m.last != nil {
+		countInsertFailed.Add(delta(m.last.insertFailed, stats.insertFailed))
+		countDrop.Add(delta(m.last.drop, stats.drop))
+		countEarlyDrop.Add(delta(m.last.earlyDrop, stats.earlyDrop))
+	}
+	m.last = &stats
+}
+
+// delta returns the increase of a kernel counter, which restarts from 0 when
+// a CPU goes offline.
+func delta(last, current uint64) float64 {
+	if current < last {
+		return float64(current)
+	}
+	return float64(current - last)
+}
+
+func recordUsage(usage map[string]*Usage) {
+	gaugeConnections.Reset()
+	gaugePortsInUse.Reset()
+	gaugePortUsage.Reset()
+	for eip, item := range usage {
+		for _, protocol := range protocols {
+			gaugeConnections.WithLabelValues(eip, protocol).Set(float64(item.Connections[protocol]))
+			gaugePortsInUse.WithLabelValues(eip, protocol).Set(float64(item.PortsInUse[protocol]))
+		}
+		gaugePortUsage.WithLabelValues(eip).Set(item.Ratio)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package snatmon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func newMonitor(t *testing.T, cli client.Client, flows *[]*netlink.ConntrackFlow, now *time.Time) (*Monitor, *record.FakeRecorder) {
+	cfg := &config.Config{}
+	cfg.NodeName = "node1"
+	cfg.FileConfig.SNATMonitor = config.SNATMonitor{Enable: true, IntervalSecond: 30, UsageThreshold: 80}
+	recorder := record.NewFakeRecorder(16)
+	m := New(cli, recorder, logr.Discard(), cfg)
+	m.listFlows = func(netlink.InetFamily) ([]*netlink.ConntrackFlow, error) { return *flows, nil }
+	m.now = func() time.Time { return *now }
+
+	// the kernel stats are optional for the status
+	m.procRoot = t.TempDir()
+	return m, recorder
+}
+
+// busyFlows returns count connections to one destination within the
+// reserved range 20000-20009 of 10.6.1.21.
+func busyFlows(count int) []*netlink.ConntrackFlow {
+	res := make([]*netlink.ConntrackFlow, 0, count)
+	for i := 0; i < count; i++ {
+		res = append(res, newFlow(syscall.IPPROTO_TCP, "10.6.1.21", uint16(20000+i), "1.1.1.1", 443))
+	}
+	return res
+}
+
+func TestMonitorSample(t *testing.T) {
+	egw := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+		Status: egressv1.EgressGatewayStatus{
+			NodeList: []egressv1.EgressIPStatus{{Name: "node1", Eips: []egressv1.Eips{{
+				IPv4:        "10.6.1.21",
+				Policies:    []egressv1.Policy{{Name: "p1", Namespace: "default"}},
+				SourcePorts: []egressv1.PolicySourcePorts{{Name: "p1", Namespace: "default", Ports: "20000-20009"}},
+			}}}},
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).
+		WithObjects(egw).WithStatusSubresource(egw).Build()
+	now := time.Now().Truncate(time.Second)
+	flows := busyFlows(5)
+	m, recorder := newMonitor(t, cli, &flows, &now)
+	ctx := context.Background()
+	get := func() *egressv1.EgressGateway {
+		res := new(egressv1.EgressGateway)
+		assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "egw1"}, res))
+		return res
+	}
+
+	// below the threshold, no condition is added
+	assert.NoError(t, m.sample(ctx))
+	assert.Empty(t, get().Status.SNATPressure)
+	assert.Empty(t, get().Status.Conditions)
+
+	// above the threshold
+	flows = busyFlows(9)
+	assert.NoError(t, m.sample(ctx))
+	status := get().Status
+	assert.Equal(t, []egressv1.SNATPressure{{IP: "10.6.1.21", Node: "node1", Usage: 90, LastSeen: metav1.NewTime(now)}}, status.SNATPressure)
+	assert.True(t, apimeta.IsStatusConditionTrue(status.Conditions, egressv1.ConditionSNATPortPressure))
+	assert.Contains(t, <-recorder.Events, ReasonSNATPortPressure)
+
+	// an unchanged usage is not written again
+	now = now.Add(10 * time.Second)
+	rv := get().ResourceVersion
+	assert.NoError(t, m.sample(ctx))
+	assert.Equal(t, rv, get().ResourceVersion)
+	assert.Empty(t, recorder.Events)
+
+	// back below the threshold
+	flows = busyFlows(2)
+	assert.NoError(t, m.sample(ctx))
+	status = get().Status
+	assert.Empty(t, status.SNATPressure)
+	assert.True(t, apimeta.IsStatusConditionFalse(status.Conditions, egressv1.ConditionSNATPortPressure))
+	assert.Contains(t, <-recorder.Events, ReasonSNATPortPressureResolved)
+}
+
+func TestMergePressure(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	status := &egressv1.EgressGatewayStatus{
+		SNATPressure: []egressv1.SNATPressure{
+			{IP: "10.6.1.21", Node: "node1", Usage: 85, LastSeen: metav1.NewTime(now.Add(-30 * time.Second))},
+			{IP: "10.6.1.22", Node: "node2", Usage: 90, LastSeen: metav1.NewTime(now.Add(-30 * time.Second))},
+			{IP: "10.6.1.23", Node: "node3", Usage: 90, LastSeen: metav1.NewTime(now.Add(-10 * time.Minute))},
+		},
+	}
+	own := []egressv1.SNATPressure{{IP: "10.6.1.21", Node: "node1", Usage: 85, LastSeen: metav1.NewTime(now)}}
+	mergePressure(status, "node1", own, now, 1)
+
+	assert.Equal(t, []egressv1.SNATPressure{
+		{IP: "10.6.1.21", Node: "node1", Usage: 85, LastSeen: metav1.NewTime(now.Add(-30 * time.Second))},
+		{IP: "10.6.1.22", Node: "node2", Usage: 90, LastSeen: metav1.NewTime(now.Add(-30 * time.Second))},
+	}, status.SNATPressure)
+	condition := apimeta.FindStatusCondition(status.Conditions, egressv1.ConditionSNATPortPressure)
+	assert.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "the SNAT port usage is above the threshold: EIP 10.6.1.21 on node node1 at 85%, EIP 10.6.1.22 on node node2 at 90%", condition.Message)
+}
+
+func TestRecordStats(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "net/stat"), 0o755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "sys/net/netfilter"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sys/net/netfilter/nf_conntrack_max"), []byte("262144\n"), 0o644))
+	write := func(insertFailed string) {
+		raw := "entries insert_failed drop early_drop\n00000010 " + insertFailed + " 00000000 00000000\n"
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "net/stat/nf_conntrack"), []byte(raw), 0o644))
+	}
+
+	write("00000004")
+	stats, err := readKernelStats(root)
+	assert.NoError(t, err)
+	assert.Equal(t, kernelStats{entries: 16, max: 262144, insertFailed: 4}, stats)
+
+	assert.Equal(t, float64(3), delta(4, 7))
+	assert.Equal(t, float64(2), delta(4, 2))
+}
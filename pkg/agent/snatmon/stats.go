@@ -0,0 +1,87 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package snatmon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// kernelStats are the conntrack statistics of the node.
+type kernelStats struct {
+	entries      uint64
+	max          uint64
+	insertFailed uint64
+	drop         uint64
+	earlyDrop    uint64
+}
+
+// readKernelStats reads the conntrack statistics under procRoot, usually /proc.
+func readKernelStats(procRoot string) (kernelStats, error) {
+	var res kernelStats
+	f, err := os.Open(filepath.Join(procRoot, "net/stat/nf_conntrack"))
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+	res, err = parseConntrackStat(f)
+	if err != nil {
+		return res, err
+	}
+
+	raw, err := os.ReadFile(filepath.Join(procRoot, "sys/net/netfilter/nf_conntrack_max"))
+	if err != nil {
+		return res, err
+	}
+	res.max, err = strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil {
+		return res, fmt.Errorf("invalid nf_conntrack_max: %w", err)
+	}
+	return res, nil
+}
+
+// parseConntrackStat parses /proc/net/stat/nf_conntrack, a header of the
+// column names followed by a row of hex counters per CPU. The entries column
+// is the same in every row, the other counters are per CPU.
+func parseConntrackStat(r io.Reader) (kernelStats, error) {
+	var res kernelStats
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return res, fmt.Errorf("empty conntrack stat")
+	}
+	columns := strings.Fields(scanner.Text())
+
+	first := true
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != len(columns) {
+			return res, fmt.Errorf("conntrack stat has %d columns, want %d", len(fields), len(columns))
+		}
+		for i, name := range columns {
+			value, err := strconv.ParseUint(fields[i], 16, 64)
+			if err != nil {
+				return res, fmt.Errorf("invalid conntrack stat %s: %w", name, err)
+			}
+			switch name {
+			case "entries":
+				if first {
+					res.entries = value
+				}
+			case "insert_failed":
+				res.insertFailed += value
+			case "drop":
+				res.drop += value
+			case "early_drop":
+				res.earlyDrop += value
+			}
+		}
+		first = false
+	}
+	return res, scanner.Err()
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package snatmon
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+var protocols = map[uint8]string{
+	syscall.IPPROTO_TCP: "tcp",
+	syscall.IPPROTO_UDP: "udp",
+}
+
+type portRange struct {
+	start, end int
+}
+
+func (r portRange) contains(port int) bool {
+	return port >= r.start && port <= r.end
+}
+
+func (r portRange) size() int {
+	return r.end - r.start + 1
+}
+
+// eipRanges are the source port ranges of an EIP, the reserved ranges of the
+// policies and the size of the shared range, the largest range left by the
+// reservations that the policies without reservation SNAT to.
+type eipRanges struct {
+	gateway  string
+	reserved []portRange
+	shared   int
+}
+
+func newEipRanges(gateway string, eip egressv1.Eips) eipRanges {
+	res := eipRanges{gateway: gateway, shared: utils.SourcePortMax - utils.SourcePortMin + 1}
+	used := make([]string, 0, len(eip.SourcePorts))
+	for _, item := range eip.SourcePorts {
+		start, end, err := utils.ParsePortRange(item.Ports)
+		if err != nil {
+			continue
+		}
+		res.reserved = append(res.reserved, portRange{start: start, end: end})
+		used = append(used, item.Ports)
+	}
+	// the agent SNATs to all the ports when the reservations leave none
+	free, _ := utils.FreePortRange(used, utils.SourcePortMin, utils.SourcePortMax)
+	if start, end, err := utils.ParsePortRange(free); err == nil {
+		res.shared = end - start + 1
+	}
+	return res
+}
+
+// rangeOf returns the index of the reserved range of port, -1 for the shared
+// range, and the capacity of the range.
+func (e eipRanges) rangeOf(port int) (int, int) {
+	for i, r := range e.reserved {
+		if r.contains(port) {
+			return i, r.size()
+		}
+	}
+	return -1, e.shared
+}
+
+// Usage is the SNAT port usage of an EIP.
+type Usage struct {
+	// Connections is the number of conntrack entries of each protocol
+	Connections map[string]int
+	// PortsInUse is the highest number of source ports of each protocol in
+	// use towards a single destination
+	PortsInUse map[string]int
+	// Ratio is the highest ratio of the source ports in use towards a single
+	// destination to the ports of their range
+	Ratio float64
+}
+
+// Percent returns the ratio as a percentage rounded down.
+func (u Usage) Percent() int {
+	return int(u.Ratio * 100)
+}
+
+type destKey struct {
+	eip      string
+	protocol uint8
+	dst      string
+	dstPort  uint16
+	rng      int
+}
+
+// computeUsage counts the conntrack entries of the EIPs. The reply tuple of
+// a SNATed connection is destined to the EIP and the source port it picked,
+// and a source port is only unique for a given protocol and destination, so
+// the usage is the highest count over the destinations.
+func computeUsage(flows []*netlink.ConntrackFlow, eips map[string]eipRanges) map[string]*Usage {
+	res := make(map[string]*Usage, len(eips))
+	for eip := range eips {
+		res[eip] = &Usage{Connections: map[string]int{}, PortsInUse: map[string]int{}}
+	}
+
+	counts := make(map[destKey]int)
+	capacity := make(map[destKey]int)
+	for _, flow := range flows {
+		name, ok := protocols[flow.Reverse.Protocol]
+		if !ok || flow.Reverse.DstIP == nil {
+			continue
+		}
+		eip := flow.Reverse.DstIP.String()
+		ranges, ok := eips[eip]
+		if !ok {
+			continue
+		}
+		res[eip].Connections[name]++
+
+		rng, size := ranges.rangeOf(int(flow.Reverse.DstPort))
+		key := destKey{
+			eip:      eip,
+			protocol: flow.Reverse.Protocol,
+			dst:      flow.Reverse.SrcIP.String(),
+			dstPort:  flow.Reverse.SrcPort,
+			rng:      rng,
+		}
+		counts[key]++
+		capacity[key] = size
+	}
+
+	for key, count := range counts {
+		usage := res[key.eip]
+		name := protocols[key.protocol]
+		if count > usage.PortsInUse[name] {
+			usage.PortsInUse[name] = count
+		}
+		if ratio := float64(count) / float64(capacity[key]); ratio > usage.Ratio {
+			usage.Ratio = ratio
+		}
+	}
+	return res
+}
+
+// nodeEips returns the port ranges of the EIPs of the node by EIP.
+func nodeEips(egwList *egressv1.EgressGatewayList, nodeName string) map[string]eipRanges {
+	res := make(map[string]eipRanges)
+	for _, egw := range egwList.Items {
+		for _, eip := range egw.Status.GetNodeIPs(nodeName) {
+			for _, item := range []string{eip.IPv4, eip.IPv6} {
+				if addr := net.ParseIP(item); addr != nil {
+					res[addr.String()] = newEipRanges(egw.Name, eip)
+				}
+			}
+		}
+	}
+	return res
+}
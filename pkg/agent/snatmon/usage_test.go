@@ -0,0 +1,123 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package snatmon
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// newFlow returns the entry of a connection from a Pod SNATed to eip:port.
+func newFlow(protocol uint8, eip string, port uint16, dst string, dstPort uint16) *netlink.ConntrackFlow {
+	flow := &netlink.ConntrackFlow{}
+	flow.Forward.Protocol = protocol
+	flow.Forward.SrcIP = net.ParseIP("10.21.0.5")
+	flow.Forward.DstIP = net.ParseIP(dst)
+	flow.Forward.DstPort = dstPort
+	flow.Reverse.Protocol = protocol
+	flow.Reverse.SrcIP = net.ParseIP(dst)
+	flow.Reverse.SrcPort = dstPort
+	flow.Reverse.DstIP = net.ParseIP(eip)
+	flow.Reverse.DstPort = port
+	return flow
+}
+
+func TestComputeUsage(t *testing.T) {
+	eips := map[string]eipRanges{
+		"10.6.1.21": newEipRanges("egw1", egressv1.Eips{IPv4: "10.6.1.21"}),
+		"10.6.1.22": newEipRanges("egw1", egressv1.Eips{
+			IPv4: "10.6.1.22",
+			SourcePorts: []egressv1.PolicySourcePorts{
+				{Name: "p1", Namespace: "default", Ports: "20000-20099"},
+			},
+		}),
+	}
+
+	flows := []*netlink.ConntrackFlow{
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.21", 30000, "1.1.1.1", 443),
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.21", 30001, "1.1.1.1", 443),
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.21", 30000, "1.1.1.1", 80),
+		newFlow(syscall.IPPROTO_UDP, "10.6.1.21", 30000, "8.8.8.8", 53),
+		// the ports of a reserved range towards one destination
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.22", 20000, "1.1.1.1", 443),
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.22", 20001, "1.1.1.1", 443),
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.22", 40000, "1.1.1.1", 443),
+		// not an EIP of the node, and a protocol without ports
+		newFlow(syscall.IPPROTO_TCP, "10.6.1.99", 30000, "1.1.1.1", 443),
+		newFlow(syscall.IPPROTO_ICMP, "10.6.1.21", 0, "1.1.1.1", 0),
+	}
+
+	usage := computeUsage(flows, eips)
+	assert.Len(t, usage, 2)
+
+	assert.Equal(t, map[string]int{"tcp": 3, "udp": 1}, usage["10.6.1.21"].Connections)
+	assert.Equal(t, map[string]int{"tcp": 2, "udp": 1}, usage["10.6.1.21"].PortsInUse)
+	assert.InDelta(t, 2.0/64512, usage["10.6.1.21"].Ratio, 1e-9)
+
+	assert.Equal(t, map[string]int{"tcp": 3}, usage["10.6.1.22"].Connections)
+	assert.Equal(t, map[string]int{"tcp": 2}, usage["10.6.1.22"].PortsInUse)
+	assert.InDelta(t, 0.02, usage["10.6.1.22"].Ratio, 1e-9)
+	assert.Equal(t, 2, usage["10.6.1.22"].Percent())
+}
+
+func TestNewEipRanges(t *testing.T) {
+	reserve := func(ports ...string) egressv1.Eips {
+		eip := egressv1.Eips{IPv4: "10.6.1.21"}
+		for i, item := range ports {
+			eip.SourcePorts = append(eip.SourcePorts, egressv1.PolicySourcePorts{
+				Name: fmt.Sprintf("p%d", i), Namespace: "default", Ports: item,
+			})
+		}
+		return eip
+	}
+
+	assert.Equal(t, 64512, newEipRanges("egw1", reserve()).shared)
+	// the policies without reservation SNAT to the largest range left
+	ranges := newEipRanges("egw1", reserve("1024-2023", "2524-3023"))
+	assert.Len(t, ranges.reserved, 2)
+	assert.Equal(t, 62512, ranges.shared)
+	// all the ports when the reservations leave none
+	assert.Equal(t, 64512, newEipRanges("egw1", reserve("1024-65535")).shared)
+}
+
+func TestNodeEips(t *testing.T) {
+	egwList := &egressv1.EgressGatewayList{Items: []egressv1.EgressGateway{
+		{
+			Status: egressv1.EgressGatewayStatus{NodeList: []egressv1.EgressIPStatus{
+				{Name: "node1", Eips: []egressv1.Eips{{IPv4: "10.6.1.21", IPv6: "fd00:0::21"}, {}}},
+				{Name: "node2", Eips: []egressv1.Eips{{IPv4: "10.6.1.22"}}},
+			}},
+		},
+	}}
+	egwList.Items[0].Name = "egw1"
+
+	eips := nodeEips(egwList, "node1")
+	assert.Len(t, eips, 2)
+	assert.Equal(t, "egw1", eips["10.6.1.21"].gateway)
+	assert.Equal(t, "egw1", eips["fd00::21"].gateway)
+}
+
+func TestParseConntrackStat(t *testing.T) {
+	raw := strings.Join([]string{
+		"entries  clashres found new invalid ignore delete chainlength insert insert_failed drop early_drop error expect_new expect_create expect_delete search_restart",
+		"000000c8  00000000 00000000 00000000 00000010 00000000 00000000 00000000 00000000 00000002 00000001 00000000 00000000 00000000 00000000 00000000 00000000",
+		"000000c8  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000003 0000000a 00000004 00000000 00000000 00000000 00000000 00000000",
+	}, "\n")
+	stats, err := parseConntrackStat(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, kernelStats{entries: 200, insertFailed: 5, drop: 11, earlyDrop: 4}, stats)
+
+	_, err = parseConntrackStat(strings.NewReader("entries drop\n1\n"))
+	assert.Error(t, err)
+	_, err = parseConntrackStat(strings.NewReader(""))
+	assert.Error(t, err)
+}
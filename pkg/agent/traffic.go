@@ -0,0 +1,99 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// trafficSpec are the fields of a policy applied to its traffic on the gateway
+// node.
+type trafficSpec struct {
+	// Bandwidth is the limit of the policy on this node
+	Bandwidth *egressv1.Bandwidth
+	// DSCP is set on the traffic of the policy on this node
+	DSCP *int
+}
+
+func (t trafficSpec) isEmpty() bool {
+	return t.Bandwidth == nil && t.DSCP == nil
+}
+
+// getPolicyTraffic returns the traffic fields of the policy.
+func (r *policeReconciler) getPolicyTraffic(ns, name string) (trafficSpec, error) {
+	var obj client.Object
+	if ns != "" {
+		obj = new(egressv1.EgressPolicy)
+	} else {
+		obj = new(egressv1.EgressClusterPolicy)
+	}
+	err := r.client.Get(context.Background(), types.NamespacedName{Namespace: ns, Name: name}, obj)
+	if err != nil {
+		if !apierr.IsNotFound(err) {
+			return trafficSpec{}, err
+		}
+		return trafficSpec{}, nil
+	}
+	switch obj := obj.(type) {
+	case *egressv1.EgressPolicy:
+		return trafficSpec{Bandwidth: obj.Spec.Bandwidth, DSCP: obj.Spec.DSCP}, nil
+	case *egressv1.EgressClusterPolicy:
+		return trafficSpec{Bandwidth: obj.Spec.Bandwidth, DSCP: obj.Spec.DSCP}, nil
+	}
+	return trafficSpec{}, nil
+}
+
+// storeTrafficPolicies keeps the traffic fields the rules are built for.
+func (r *policeReconciler) storeTrafficPolicies(snatPolicies map[egressv1.Policy]*PolicyCommon) {
+	r.trafficPolicies.Range(func(key egressv1.Policy, _ trafficSpec) bool {
+		if val, ok := snatPolicies[key]; !ok || val.DryRun || val.trafficSpec.isEmpty() {
+			r.trafficPolicies.Delete(key)
+		}
+		return true
+	})
+	for policy, val := range snatPolicies {
+		if !val.DryRun && !val.trafficSpec.isEmpty() {
+			r.trafficPolicies.Store(policy, val.trafficSpec)
+		}
+	}
+}
+
+// applyPolicyTraffic rebuilds the rules when the traffic fields of a policy of
+// this node are not the ones the rules are built for
+func (r *policeReconciler) applyPolicyTraffic(policy egressv1.Policy, spec trafficSpec, onNode bool, log logr.Logger) error {
+	if !onNode {
+		return nil
+	}
+	cur, ok := r.trafficPolicies.Load(policy)
+	if !ok && spec.isEmpty() {
+		return nil
+	}
+	if ok && reflect.DeepEqual(cur, spec) {
+		return nil
+	}
+	log.Info("policy traffic fields changed, rebuild rules")
+	return r.initApplyPolicy()
+}
+
+// buildDSCPRule sets the DSCP value on the traffic of the policy, the packets
+// already carrying it are left alone.
+func (r *policeReconciler) buildDSCPRule(policyName string, dscp uint8, version uint8, isIgnoreInternalCIDR bool) iptables.Rule {
+	rule := r.buildPolicyRule(policyName, 0, version, isIgnoreInternalCIDR)
+	rule.Match = rule.Match.NotDSCP(dscp)
+	rule.Action = iptables.DSCPAction{Value: dscp}
+	rule.Comment = []string{
+		fmt.Sprintf("Set DSCP for EgressPolicy %s", policyName),
+	}
+	return *rule
+}
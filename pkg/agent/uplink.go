@@ -0,0 +1,111 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// uplink is the egress interface and next-hops of the EIPs of a policy on the
+// gateway node, the uplinks of different gateways with the same values share
+// a mark and routing table
+type uplink struct {
+	Interface   string
+	NextHopIPv4 string
+	NextHopIPv6 string
+}
+
+// findUplink returns the uplink of the first uplink including the EIP, or nil.
+func findUplink(uplinks []egressv1.Uplink, eip IP) (*uplink, error) {
+	for _, item := range uplinks {
+		for _, addr := range []string{eip.V4, eip.V6} {
+			if addr == "" {
+				continue
+			}
+			included, err := ip.CheckIPIncluded(addr, item.IPPools)
+			if err != nil {
+				return nil, fmt.Errorf("failed to match EIP %s with uplink: %w", addr, err)
+			}
+			if included {
+				return &uplink{Interface: item.Interface, NextHopIPv4: item.NextHopIPv4, NextHopIPv6: item.NextHopIPv6}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// sameUplink reports whether the EIPs of a and b leave through the same uplink,
+// nil is the main routing table.
+func sameUplink(a, b *uplink) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ensureUplinkRoutes gives each uplink a mark from the uplink mark range, in
+// the order of the interfaces so that the marks are stable, and routes the
+// traffic with the mark through the uplink. The uplinks whose routes could not
+// be set are left out, their traffic keeps the main routing table.
+func (r *policeReconciler) ensureUplinkRoutes(uplinks map[uplink]struct{}) (map[uplink]uint32, error) {
+	base, err := markallocator.Parse(r.cfg.FileConfig.UplinkMark)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uplink mark %s: %w", r.cfg.FileConfig.UplinkMark, err)
+	}
+
+	list := make([]uplink, 0, len(uplinks))
+	for item := range uplinks {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return fmt.Sprint(list[i]) < fmt.Sprint(list[j])
+	})
+
+	res := make(map[uplink]uint32, len(list))
+	marks := make(map[int]struct{}, len(list))
+	for i, item := range list {
+		mark := int(base) + i + 1
+		var ipv4, ipv6 *net.IP
+		if addr := net.ParseIP(item.NextHopIPv4); addr != nil {
+			ipv4 = &addr
+		}
+		if addr := net.ParseIP(item.NextHopIPv6); addr != nil {
+			ipv6 = &addr
+		}
+		err := r.ruleRoute.Ensure(item.Interface, ipv4, ipv6, mark, mark)
+		if err != nil {
+			r.log.Error(err, "failed to set the routes of uplink, use the main routing table",
+				"interface", item.Interface, "nextHopIPv4", item.NextHopIPv4, "nextHopIPv6", item.NextHopIPv6)
+			continue
+		}
+		res[item] = uint32(mark)
+		marks[mark] = struct{}{}
+	}
+
+	if err := r.ruleRoute.PurgeStaleRules(marks, r.cfg.FileConfig.UplinkMark); err != nil {
+		return nil, fmt.Errorf("failed to purge stale uplink rules: %w", err)
+	}
+	if err := r.ruleRoute.PurgeStaleRoutes(marks, r.cfg.FileConfig.UplinkMark); err != nil {
+		return nil, fmt.Errorf("failed to purge stale uplink routes: %w", err)
+	}
+	return res, nil
+}
+
+// buildUplinkRule marks the traffic of the policy for the routing table of its
+// uplink, it runs after the reply routing rules which overwrite the mark of the
+// traffic coming from the tunnel.
+func (r *policeReconciler) buildUplinkRule(policyName string, mark uint32, version uint8, isIgnoreInternalCIDR bool) iptables.Rule {
+	rule := r.buildPolicyRule(policyName, mark, version, isIgnoreInternalCIDR)
+	rule.Comment = []string{
+		fmt.Sprintf("Set uplink mark for EgressPolicy %s", policyName),
+	}
+	return *rule
+}
@@ -26,6 +26,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
 	"github.com/spidernet-io/egressgateway/pkg/agent/route"
 	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
 	"github.com/spidernet-io/egressgateway/pkg/config"
@@ -450,21 +451,7 @@ func (r *vxlanReconciler) keepVXLAN() {
 
 		r.log.V(1).Info("route ensure has completed")
 
-		markMap := make(map[int]struct{})
-		r.peerMap.Range(func(key string, val vxlan.Peer) bool {
-			if val.Mark != 0 {
-				markMap[val.Mark] = struct{}{}
-				err = r.ruleRoute.Ensure(r.cfg.FileConfig.VXLAN.Name, val.IPv4, val.IPv6, val.Mark, val.Mark)
-				if err != nil {
-					r.log.Error(err, "ensure vxlan link with error")
-					reduce = false
-				}
-			}
-			return true
-		})
-		err = r.ruleRoute.PurgeStaleRules(markMap, r.cfg.FileConfig.Mark)
-		if err != nil {
-			r.log.Error(err, "purge stale rules error")
+		if err := r.ensureRuleRoutes(); err != nil {
 			reduce = false
 		}
 
@@ -479,6 +466,30 @@ func (r *vxlanReconciler) keepVXLAN() {
 	}
 }
 
+// ensureRuleRoutes ensures the rules and routes of the marks of the peers and
+// purges the stale rules, the errors are logged and the last one is returned.
+func (r *vxlanReconciler) ensureRuleRoutes() error {
+	var res error
+	markMap := make(map[int]struct{})
+	r.peerMap.Range(func(key string, val vxlan.Peer) bool {
+		if val.Mark != 0 {
+			markMap[val.Mark] = struct{}{}
+			err := r.ruleRoute.Ensure(r.cfg.FileConfig.VXLAN.Name, val.IPv4, val.IPv6, val.Mark, val.Mark)
+			if err != nil {
+				r.log.Error(err, "ensure vxlan link with error")
+				res = err
+			}
+		}
+		return true
+	})
+	err := r.ruleRoute.PurgeStaleRules(markMap, r.cfg.FileConfig.Mark)
+	if err != nil {
+		r.log.Error(err, "purge stale rules error")
+		res = err
+	}
+	return res
+}
+
 func (r *vxlanReconciler) updateTunnelStatus(tunnel *egressv1.EgressTunnel) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.cfg.FileConfig.GatewayFailover.EipEvictionTimeout)*time.Second)
 	defer cancel()
@@ -585,7 +596,7 @@ func parseMarkToInt(mark string) (int, error) {
 	return i32, nil
 }
 
-func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr.Logger) error {
+func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr.Logger, datapathServer *datapath.Server) error {
 	ruleRoute := route.NewRuleRoute(route.WithLogger(log))
 
 	r := &vxlanReconciler{
@@ -616,6 +627,7 @@ func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr
 		r.getParent = vxlan.GetParentByDefaultRoute(netLink)
 	}
 	r.vxlan = vxlan.New(vxlan.WithCustomGetParent(r.getParent))
+	datapathServer.Register(r)
 
 	c, err := controller.New("vxlan", mgr, controller.Options{Reconciler: r})
 	if err != nil {
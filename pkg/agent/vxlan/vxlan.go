@@ -179,6 +179,28 @@ func (dev *Device) ListNeigh() ([]netlink.Neigh, error) {
 	return existingNeigh, nil
 }
 
+// ListNeighFDB lists the v4 and v6 neighbors and the FDB entries of the device.
+func (dev *Device) ListNeighFDB() (neighs []netlink.Neigh, fdb []netlink.Neigh, err error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.notReady() {
+		return nil, nil, nil
+	}
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		list, err := netlink.NeighList(dev.link.Index, family)
+		if err != nil {
+			return nil, nil, err
+		}
+		neighs = append(neighs, list...)
+	}
+	fdb, err = netlink.NeighList(dev.link.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return neighs, fdb, nil
+}
+
 func (dev *Device) Add(peer Peer) error {
 	dev.lock.RLock()
 	defer dev.lock.RUnlock()
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/markallocator"
 )
 
 type Config struct {
@@ -51,6 +53,7 @@ type EnvConfig struct {
 	MetricsBindAddress        string        `mapstructure:"METRICS_BIND_ADDRESS"`
 	HealthProbeBindAddress    string        `mapstructure:"HEALTH_PROBE_BIND_ADDRESS"`
 	GopsPort                  int           `mapstructure:"GOPS_PORT"`
+	DebugBindAddress          string        `mapstructure:"DEBUG_BIND_ADDRESS"`
 	WebhookPort               int           `mapstructure:"WEBHOOK_PORT"`
 	PyroscopeServerAddr       string        `mapstructure:"PYROSCOPE_SERVER_ADDR"`
 	PodName                   string        `mapstructure:"POD_NAME"`
@@ -77,13 +80,23 @@ type FileConfig struct {
 	TunnelDetectMethod           string                        `yaml:"tunnelDetectMethod"`
 	VXLAN                        VXLAN                         `yaml:"vxlan"`
 	MaxNumberEndpointPerSlice    int                           `yaml:"maxNumberEndpointPerSlice"`
+	EndpointSlice                EndpointSlice                 `yaml:"endpointSlice"`
 	Mark                         string                        `yaml:"mark"`
+	UplinkMark                   string                        `yaml:"uplinkMark"`
 	AnnouncedInterfacesToExclude []string                      `yaml:"announcedInterfacesToExclude"`
 	AnnounceExcludeRegexp        *regexp.Regexp                `json:"-"`
+	AnnounceConflict             AnnounceConflict              `yaml:"announceConflict"`
+	EIPLease                     EIPLease                      `yaml:"eipLease"`
 	EnableGatewayReplyRoute      bool                          `yaml:"enableGatewayReplyRoute"`
 	GatewayReplyRouteTable       int                           `yaml:"gatewayReplyRouteTable"`
 	GatewayReplyRouteMark        int                           `yaml:"gatewayReplyRouteMark"`
 	GatewayFailover              GatewayFailover               `yaml:"gatewayFailover"`
+	CloudEIP                     CloudEIP                      `yaml:"cloudEIP"`
+	SNATMonitor                  SNATMonitor                   `yaml:"snatMonitor"`
+	FlowLog                      FlowLog                       `yaml:"flowLog"`
+	DatapathVerifier             DatapathVerifier              `yaml:"datapathVerifier"`
+	AuditLog                     AuditLog                      `yaml:"auditLog"`
+	EIPList                      EIPList                       `yaml:"eipList"`
 	TunnelDetectCustomInterface  []TunnelDetectCustomInterface `yaml:"tunnelDetectCustomInterface"`
 	CacheSyncSyncPeriodSecond    int                           `json:"cacheSyncSyncPeriodSecond "`
 }
@@ -95,6 +108,112 @@ type GatewayFailover struct {
 	EipEvictionTimeout  int  `yaml:"eipEvictionTimeout"`
 }
 
+// AnnounceConflict handles the EIPs announced by another host on the segment
+type AnnounceConflict struct {
+	// StopAnnouncing stops announcing a conflicted EIP on the node for HoldSecond
+	StopAnnouncing bool `yaml:"stopAnnouncing"`
+	HoldSecond     int  `yaml:"holdSecond"`
+}
+
+// EIPLease fences the ownership of each EIP with a coordination.k8s.io Lease
+// renewed by the agent of the gateway node
+type EIPLease struct {
+	Enable bool `yaml:"enable"`
+	// LeaseDurationSecond is the time the controller waits after the last
+	// renewal before it moves the EIP of a not ready node
+	LeaseDurationSecond int `yaml:"leaseDurationSecond"`
+	// RenewDeadlineSecond is the time after the last renewal the agent stops
+	// announcing and SNATing the EIP
+	RenewDeadlineSecond int `yaml:"renewDeadlineSecond"`
+	// RetryPeriodSecond is the interval the agent renews the leases at
+	RetryPeriodSecond int `yaml:"retryPeriodSecond"`
+}
+
+// DatapathVerifier periodically compares the ipsets, iptables rules, VXLAN
+// neighbors and FDB entries, ip rules and routes with the desired state of the
+// agent, and repairs the drift
+type DatapathVerifier struct {
+	Enable bool `yaml:"enable"`
+	// IntervalSecond is the interval the datapath is verified at
+	IntervalSecond int `yaml:"intervalSecond"`
+	// Repair programs the desired state again when the datapath drifts, the
+	// drift is only reported when false
+	Repair bool `yaml:"repair"`
+}
+
+// SNATMonitor samples the conntrack table of the gateway node to report the
+// source port usage of each EIP
+type SNATMonitor struct {
+	Enable bool `yaml:"enable"`
+	// IntervalSecond is the interval the conntrack table is sampled at
+	IntervalSecond int `yaml:"intervalSecond"`
+	// UsageThreshold is the port usage percentage above which the EgressGateway
+	// of the EIP gets a SNATPortPressure condition
+	UsageThreshold int `yaml:"usageThreshold"`
+}
+
+// FlowLog exports a record of each connection which left through an EIP of the
+// gateway node
+type FlowLog struct {
+	Enable bool `yaml:"enable"`
+	// Format is json for JSON lines or ipfix
+	Format string `yaml:"format"`
+	// Collector is the address the records are sent to, tcp://host:port or
+	// udp://host:port, empty disables it
+	Collector string `yaml:"collector"`
+	// File is a local file the records are appended to, empty disables it
+	File string `yaml:"file"`
+	// MaxFileSizeMB is the size of the file at which it is rotated, the
+	// previous records are kept in one file with the .1 suffix, 0 is 100
+	MaxFileSizeMB int `yaml:"maxFileSizeMB"`
+}
+
+// AuditLog writes a record of each EIP assigned, released and moved by the
+// controller, besides the Events of the policies
+type AuditLog struct {
+	// File is the file the records are appended to as JSON lines, - is the
+	// standard output of the controller, empty disables it
+	File string `yaml:"file"`
+	// Webhook is the http or https URL each record is posted to as JSON,
+	// empty disables it
+	Webhook string `yaml:"webhook"`
+}
+
+// EIPList publishes the EIPs in use of each gateway and of each namespace into
+// ConfigMaps and over HTTP, for the allowlists outside the cluster
+type EIPList struct {
+	Enable bool `yaml:"enable"`
+	// Port is the port of the HTTP server of the lists on the controller, 0
+	// only publishes the ConfigMaps
+	Port int `yaml:"port"`
+}
+
+// CloudEIP attaches the EIPs of the gateway node to its NIC through the cloud
+// API, where gratuitous ARP has no effect
+type CloudEIP struct {
+	// Provider is one of aws, azure, gcp and fake, empty disables the cloud EIP mode
+	Provider string `yaml:"provider"`
+	// Interface is the local interface whose NIC the EIPs are attached to,
+	// the primary NIC when empty
+	Interface string `yaml:"interface"`
+	// SyncPeriodSecond is the period to compare the attached IPs with the
+	// EgressGateway status and repair the differences
+	SyncPeriodSecond int `yaml:"syncPeriodSecond"`
+	// Endpoint overrides the cloud API endpoint
+	Endpoint string `yaml:"endpoint"`
+	// MetadataEndpoint overrides the instance metadata endpoint
+	MetadataEndpoint string `yaml:"metadataEndpoint"`
+}
+
+type EndpointSlice struct {
+	// BatchPeriodMillis is the time window in which pod changes of a policy are
+	// batched into one endpoint slice update
+	BatchPeriodMillis int `yaml:"batchPeriodMillis"`
+	// Compaction moves endpoints out of half empty slices so that a policy
+	// uses as few slices as its endpoints need
+	Compaction bool `yaml:"compaction"`
+}
+
 const TunnelInterfaceDefaultRoute = "defaultRouteInterface"
 const TunnelInterfaceSpecific = "interface="
 
@@ -155,6 +274,10 @@ func LoadConfig(isAgent bool) (*Config, error) {
 		},
 		FileConfig: FileConfig{
 			MaxNumberEndpointPerSlice: 100,
+			EndpointSlice: EndpointSlice{
+				BatchPeriodMillis: 1000,
+				Compaction:        true,
+			},
 			IPTables: IPTables{
 				RefreshIntervalSecond:   90,
 				PostWriteIntervalSecond: 1,
@@ -163,13 +286,39 @@ func LoadConfig(isAgent bool) (*Config, error) {
 				LockFilePath:            "/run/xtables.lock",
 				RestoreSupportsLock:     restoreSupportsLock,
 			},
-			Mark: "0x26000000",
+			Mark:       "0x26000000",
+			UplinkMark: "0x28000000",
 			GatewayFailover: GatewayFailover{
 				Enable:              true,
 				TunnelMonitorPeriod: 5,
 				TunnelUpdatePeriod:  5,
 				EipEvictionTimeout:  15,
 			},
+			AnnounceConflict: AnnounceConflict{
+				HoldSecond: 60,
+			},
+			EIPLease: EIPLease{
+				LeaseDurationSecond: 15,
+				RenewDeadlineSecond: 10,
+				RetryPeriodSecond:   2,
+			},
+			CloudEIP: CloudEIP{
+				SyncPeriodSecond: 60,
+			},
+			SNATMonitor: SNATMonitor{
+				IntervalSecond: 30,
+				UsageThreshold: 80,
+			},
+			FlowLog: FlowLog{
+				Format: "json",
+			},
+			DatapathVerifier: DatapathVerifier{
+				IntervalSecond: 60,
+				Repair:         true,
+			},
+			EIPList: EIPList{
+				Port: 5825,
+			},
 			CacheSyncSyncPeriodSecond: 1800,
 		},
 	}
@@ -262,6 +411,102 @@ func LoadConfig(isAgent bool) (*Config, error) {
 			return nil, fmt.Errorf("eipEvictionTimeout should be greater than the sum of tunnelUpdatePeriod and tunnelMonitorPeriod")
 		}
 	}
+	if lease := config.FileConfig.EIPLease; lease.Enable {
+		if lease.RetryPeriodSecond <= 0 || lease.LeaseDurationSecond <= lease.RenewDeadlineSecond+lease.RetryPeriodSecond {
+			return nil, fmt.Errorf("eipLease.leaseDurationSecond should be greater than the sum of renewDeadlineSecond and retryPeriodSecond")
+		}
+	}
+	if monitor := config.FileConfig.SNATMonitor; monitor.Enable {
+		if monitor.IntervalSecond <= 0 {
+			return nil, fmt.Errorf("snatMonitor.intervalSecond should be greater than 0")
+		}
+		if monitor.UsageThreshold <= 0 || monitor.UsageThreshold > 100 {
+			return nil, fmt.Errorf("snatMonitor.usageThreshold should be in 1-100")
+		}
+	}
+	if err := checkFlowLog(config.FileConfig.FlowLog); err != nil {
+		return nil, err
+	}
+	if err := checkAuditLog(config.FileConfig.AuditLog); err != nil {
+		return nil, err
+	}
+	if err := checkEIPList(config.FileConfig.EIPList); err != nil {
+		return nil, err
+	}
+	if err := checkUplinkMark(config.FileConfig.Mark, config.FileConfig.UplinkMark); err != nil {
+		return nil, err
+	}
+	if verifier := config.FileConfig.DatapathVerifier; verifier.Enable && verifier.IntervalSecond <= 0 {
+		return nil, fmt.Errorf("datapathVerifier.intervalSecond should be greater than 0")
+	}
+	switch config.FileConfig.CloudEIP.Provider {
+	case "", "aws", "azure", "gcp", "fake":
+	default:
+		return nil, fmt.Errorf("unsupported cloudEIP provider %q", config.FileConfig.CloudEIP.Provider)
+	}
 
 	return config, nil
 }
+
+func checkFlowLog(flowLog FlowLog) error {
+	if !flowLog.Enable {
+		return nil
+	}
+	if flowLog.Format != "json" && flowLog.Format != "ipfix" {
+		return fmt.Errorf("flowLog.format should be json or ipfix")
+	}
+	if flowLog.Collector == "" && flowLog.File == "" {
+		return fmt.Errorf("flowLog.collector or flowLog.file should be set")
+	}
+	if flowLog.MaxFileSizeMB < 0 {
+		return fmt.Errorf("flowLog.maxFileSizeMB should not be negative")
+	}
+	if flowLog.Collector != "" {
+		u, err := url.Parse(flowLog.Collector)
+		if err != nil {
+			return fmt.Errorf("invalid flowLog.collector %s: %w", flowLog.Collector, err)
+		}
+		if (u.Scheme != "tcp" && u.Scheme != "udp") || u.Host == "" {
+			return fmt.Errorf("flowLog.collector should be tcp://host:port or udp://host:port")
+		}
+	}
+	return nil
+}
+
+func checkAuditLog(auditLog AuditLog) error {
+	if auditLog.Webhook == "" {
+		return nil
+	}
+	u, err := url.Parse(auditLog.Webhook)
+	if err != nil {
+		return fmt.Errorf("invalid auditLog.webhook %s: %w", auditLog.Webhook, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("auditLog.webhook should be an http or https URL")
+	}
+	return nil
+}
+
+func checkEIPList(eipList EIPList) error {
+	if eipList.Enable && (eipList.Port < 0 || eipList.Port > 65535) {
+		return fmt.Errorf("eipList.port should be in 0-65535")
+	}
+	return nil
+}
+
+// checkUplinkMark checks that the marks of the uplinks and the tunnels do not
+// overlap, each takes the range of its base mark
+func checkUplinkMark(mark, uplinkMark string) error {
+	start, end, err := markallocator.RangeSize(mark)
+	if err != nil {
+		return fmt.Errorf("invalid mark %s: %w", mark, err)
+	}
+	uplinkStart, uplinkEnd, err := markallocator.RangeSize(uplinkMark)
+	if err != nil {
+		return fmt.Errorf("invalid uplinkMark %s: %w", uplinkMark, err)
+	}
+	if uplinkStart <= end && start <= uplinkEnd {
+		return fmt.Errorf("uplinkMark %s overlaps with mark %s", uplinkMark, mark)
+	}
+	return nil
+}
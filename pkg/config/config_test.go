@@ -275,3 +275,133 @@ func err_LoadConfig_ParseCIDR_v4() []gomonkey.Patches {
 
 	return []gomonkey.Patches{*patch1}
 }
+
+func Test_checkUplinkMark(t *testing.T) {
+	cases := map[string]struct {
+		mark       string
+		uplinkMark string
+		expErr     bool
+	}{
+		"default marks": {
+			mark:       "0x26000000",
+			uplinkMark: "0x28000000",
+		},
+		"overlapped marks": {
+			mark:       "0x26000000",
+			uplinkMark: "0x26000000",
+			expErr:     true,
+		},
+		"invalid uplink mark": {
+			mark:       "0x26000000",
+			uplinkMark: "0x28xx",
+			expErr:     true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkUplinkMark(tc.mark, tc.uplinkMark)
+			if tc.expErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_checkFlowLog(t *testing.T) {
+	cases := map[string]struct {
+		flowLog FlowLog
+		expErr  bool
+	}{
+		"disabled": {
+			flowLog: FlowLog{Format: "xml"},
+		},
+		"json to file": {
+			flowLog: FlowLog{Enable: true, Format: "json", File: "/var/log/egress-flows.log"},
+		},
+		"ipfix to collector": {
+			flowLog: FlowLog{Enable: true, Format: "ipfix", Collector: "udp://10.6.0.10:4739"},
+		},
+		"invalid format": {
+			flowLog: FlowLog{Enable: true, Format: "xml", File: "/var/log/egress-flows.log"},
+			expErr:  true,
+		},
+		"no sink": {
+			flowLog: FlowLog{Enable: true, Format: "json"},
+			expErr:  true,
+		},
+		"invalid collector": {
+			flowLog: FlowLog{Enable: true, Format: "json", Collector: "http://10.6.0.10:4739"},
+			expErr:  true,
+		},
+		"negative max file size": {
+			flowLog: FlowLog{Enable: true, Format: "json", File: "/var/log/egress-flows.log", MaxFileSizeMB: -1},
+			expErr:  true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkFlowLog(tc.flowLog)
+			if tc.expErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_checkAuditLog(t *testing.T) {
+	cases := map[string]struct {
+		auditLog AuditLog
+		expErr   bool
+	}{
+		"disabled": {},
+		"stdout and webhook": {
+			auditLog: AuditLog{File: "-", Webhook: "https://audit.example.com/eip"},
+		},
+		"invalid webhook": {
+			auditLog: AuditLog{Webhook: "tcp://10.6.0.10:8080"},
+			expErr:   true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkAuditLog(tc.auditLog)
+			if tc.expErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_checkEIPList(t *testing.T) {
+	cases := map[string]struct {
+		eipList EIPList
+		expErr  bool
+	}{
+		"disabled": {
+			eipList: EIPList{Port: -1},
+		},
+		"ConfigMaps only": {
+			eipList: EIPList{Enable: true},
+		},
+		"invalid port": {
+			eipList: EIPList{Enable: true, Port: 65536},
+			expErr:  true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := checkEIPList(tc.eipList)
+			if tc.expErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
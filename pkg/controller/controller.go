@@ -16,6 +16,7 @@ import (
 
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/controller/clusterinfo"
+	"github.com/spidernet-io/egressgateway/pkg/controller/eiplist"
 	"github.com/spidernet-io/egressgateway/pkg/controller/endpoint"
 	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
 	"github.com/spidernet-io/egressgateway/pkg/controller/tunnel"
@@ -96,6 +97,11 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, fmt.Errorf("failed to create cluster endpoint slice controller: %w", err)
 	}
 
+	err = eiplist.NewController(mgr, log, cfg, cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create EIP list controller: %w", err)
+	}
+
 	return &Controller{client: mgr.GetClient(), manager: mgr}, err
 }
 
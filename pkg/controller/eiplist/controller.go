@@ -0,0 +1,184 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eiplist
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+const (
+	// LabelKind is the label of the ConfigMaps of the lists, gateway or
+	// namespace
+	LabelKind = "egressgateway.spidernet.io/eip-list"
+	// LabelName is the label of the ConfigMaps with the name of the gateway
+	// or the namespace of the list
+	LabelName = "egressgateway.spidernet.io/eip-list-name"
+
+	KindGateway   = "gateway"
+	KindNamespace = "namespace"
+
+	// the keys of the ConfigMap data
+	KeyText  = "eips.txt"
+	KeyJSON  = "eips.json"
+	KeyCIDRs = "cidrs.txt"
+)
+
+// ConfigMapName returns the name of the ConfigMap of a list.
+func ConfigMapName(kind, name string) string {
+	return fmt.Sprintf("egressgateway-eips-%s-%s", kind, name)
+}
+
+// NewController publishes the EIP lists into the ConfigMaps of the namespace
+// of the controller, and serves them over HTTP when the port is set. cli
+// reads and writes the ConfigMaps without caching all of them.
+func NewController(mgr manager.Manager, log logr.Logger, cfg *config.Config, cli client.Client) error {
+	if cfg == nil {
+		return fmt.Errorf("cfg can not be nil")
+	}
+	if !cfg.FileConfig.EIPList.Enable {
+		return nil
+	}
+	log = log.WithName("eipList")
+
+	if port := cfg.FileConfig.EIPList.Port; port > 0 {
+		if err := mgr.Add(NewServer(fmt.Sprintf(":%d", port), mgr.GetClient(), log)); err != nil {
+			return fmt.Errorf("failed to add EIP list server: %w", err)
+		}
+	}
+
+	r := &eipList{
+		reader:    mgr.GetClient(),
+		cli:       cli,
+		namespace: cfg.PodNamespace,
+		log:       log,
+	}
+	c, err := controller.New("eipList", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// all the lists are built again on each change, the events are queued
+	// as a single request
+	sourceEgressGateway := utils.SourceKind(mgr.GetCache(),
+		&egress.EgressGateway{},
+		handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "EgressGateway"}}}
+		}),
+		egressGatewayPredicate{})
+	if err = c.Watch(sourceEgressGateway); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+	return nil
+}
+
+type eipList struct {
+	reader    client.Reader
+	cli       client.Client
+	namespace string
+	log       logr.Logger
+}
+
+func (r *eipList) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	gateways := new(egress.EgressGatewayList)
+	if err := r.reader.List(ctx, gateways); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	lists := Build(gateways.Items)
+
+	desired := make(map[string]*corev1.ConfigMap)
+	for name, list := range lists.Gateways {
+		cm := r.configMap(KindGateway, name, list)
+		desired[cm.Name] = cm
+	}
+	for name, list := range lists.Namespaces {
+		cm := r.configMap(KindNamespace, name, list)
+		desired[cm.Name] = cm
+	}
+
+	existing := new(corev1.ConfigMapList)
+	if err := r.cli.List(ctx, existing, client.InNamespace(r.namespace), client.HasLabels{LabelKind}); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	for i := range existing.Items {
+		cm := &existing.Items[i]
+		want, ok := desired[cm.Name]
+		if !ok {
+			r.log.V(1).Info("delete EIP list", "configMap", cm.Name)
+			if err := r.cli.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+				return reconcile.Result{Requeue: true}, err
+			}
+			continue
+		}
+		delete(desired, cm.Name)
+		if reflect.DeepEqual(cm.Data, want.Data) && reflect.DeepEqual(cm.Labels, want.Labels) {
+			continue
+		}
+		r.log.V(1).Info("update EIP list", "configMap", cm.Name)
+		cm.Labels = want.Labels
+		cm.Data = want.Data
+		if err := r.cli.Update(ctx, cm); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+	for _, cm := range desired {
+		r.log.V(1).Info("create EIP list", "configMap", cm.Name)
+		if err := r.cli.Create(ctx, cm); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+func (r *eipList) configMap(kind, name string, list List) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: r.namespace,
+			Name:      ConfigMapName(kind, name),
+			Labels:    map[string]string{LabelKind: kind, LabelName: name},
+		},
+		Data: map[string]string{
+			KeyText:  list.Text(),
+			KeyJSON:  list.JSON(),
+			KeyCIDRs: list.CIDRText(),
+		},
+	}
+}
+
+// egressGatewayPredicate passes the changes of the EIP assignments and of the
+// IP usage of the gateways.
+type egressGatewayPredicate struct{}
+
+func (p egressGatewayPredicate) Create(_ event.CreateEvent) bool { return true }
+func (p egressGatewayPredicate) Delete(_ event.DeleteEvent) bool { return true }
+func (p egressGatewayPredicate) Update(updateEvent event.UpdateEvent) bool {
+	oldObj, ok := updateEvent.ObjectOld.(*egress.EgressGateway)
+	if !ok {
+		return false
+	}
+	newObj, ok := updateEvent.ObjectNew.(*egress.EgressGateway)
+	if !ok {
+		return false
+	}
+	return !reflect.DeepEqual(oldObj.Status.NodeList, newObj.Status.NodeList) ||
+		oldObj.Status.IPUsage != newObj.Status.IPUsage
+}
+func (p egressGatewayPredicate) Generic(_ event.GenericEvent) bool { return false }
@@ -0,0 +1,222 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eiplist
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func gateway(name string, nodes ...egress.EgressIPStatus) *egress.EgressGateway {
+	return &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     egress.EgressGatewayStatus{NodeList: nodes},
+	}
+}
+
+func gateways() []egress.EgressGateway {
+	return []egress.EgressGateway{
+		*gateway("default",
+			egress.EgressIPStatus{Name: "node1", Eips: []egress.Eips{
+				{IPv4: "10.6.1.22", IPv6: "fd00::22", Policies: []egress.Policy{{Namespace: "tenant-a", Name: "policy-a"}}},
+				{IPv4: "10.6.1.21", Policies: []egress.Policy{{Name: "cluster-policy"}}},
+			}},
+			egress.EgressIPStatus{Name: "node2", Eips: []egress.Eips{
+				{IPv4: "10.6.1.23", Policies: []egress.Policy{{Namespace: "tenant-b", Name: "policy-b"}}},
+				// the node IP
+				{Policies: []egress.Policy{{Namespace: "tenant-c", Name: "policy-c"}}},
+			}},
+		),
+		*gateway("other",
+			egress.EgressIPStatus{Name: "node3", Eips: []egress.Eips{
+				{IPv4: "10.6.2.21", Policies: []egress.Policy{{Namespace: "tenant-a", Name: "policy-d"}}},
+			}},
+		),
+		*gateway("empty"),
+	}
+}
+
+func TestBuild(t *testing.T) {
+	lists := Build(gateways())
+	assert.Equal(t, map[string]List{
+		"default": {IPv4: []string{"10.6.1.21", "10.6.1.22", "10.6.1.23"}, IPv6: []string{"fd00::22"}},
+		"other":   {IPv4: []string{"10.6.2.21"}, IPv6: []string{}},
+		"empty":   {IPv4: []string{}, IPv6: []string{}},
+	}, lists.Gateways)
+	assert.Equal(t, map[string]List{
+		"tenant-a": {IPv4: []string{"10.6.1.22", "10.6.2.21"}, IPv6: []string{"fd00::22"}},
+		"tenant-b": {IPv4: []string{"10.6.1.23"}, IPv6: []string{}},
+	}, lists.Namespaces)
+	assert.Equal(t, List{IPv4: []string{"10.6.1.21", "10.6.1.22", "10.6.1.23", "10.6.2.21"}, IPv6: []string{"fd00::22"}}, lists.All())
+}
+
+func TestFormats(t *testing.T) {
+	list := Build(gateways()).Gateways["default"]
+	assert.Equal(t, "10.6.1.21\n10.6.1.22\n10.6.1.23\nfd00::22\n", list.Text())
+	assert.Equal(t, `{"ipv4":["10.6.1.21","10.6.1.22","10.6.1.23"],"ipv6":["fd00::22"]}`+"\n", list.JSON())
+	assert.Equal(t, "10.6.1.21/32\n10.6.1.22/31\nfd00::22/128\n", list.CIDRText())
+
+	empty := newList(nil)
+	assert.Equal(t, "", empty.Text())
+	assert.Equal(t, `{"ipv4":[],"ipv6":[]}`+"\n", empty.JSON())
+}
+
+func TestAggregate(t *testing.T) {
+	cases := map[string]struct {
+		ips []string
+		exp []string
+	}{
+		"empty": {
+			exp: []string{},
+		},
+		"aligned block": {
+			ips: []string{"10.6.1.3", "10.6.1.0", "10.6.1.1", "10.6.1.2"},
+			exp: []string{"10.6.1.0/30"},
+		},
+		"unaligned range": {
+			ips: []string{"10.6.1.1", "10.6.1.2", "10.6.1.3", "10.6.1.4", "10.6.1.9"},
+			exp: []string{"10.6.1.1/32", "10.6.1.2/31", "10.6.1.4/32", "10.6.1.9/32"},
+		},
+		"duplicated": {
+			ips: []string{"10.6.1.4", "10.6.1.4", "10.6.1.5"},
+			exp: []string{"10.6.1.4/31"},
+		},
+		"range end": {
+			ips: []string{"255.255.255.254", "255.255.255.255"},
+			exp: []string{"255.255.255.254/31"},
+		},
+		"ipv6": {
+			ips: []string{"fd00::10", "fd00::11", "fd00::12", "fd00::13", "fd00::14"},
+			exp: []string{"fd00::10/126", "fd00::14/128"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.exp, Aggregate(tc.ips))
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	stale := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "kube-system", Name: ConfigMapName(KindNamespace, "tenant-old"),
+		Labels: map[string]string{LabelKind: KindNamespace, LabelName: "tenant-old"},
+	}}
+	outdated := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kube-system", Name: ConfigMapName(KindGateway, "default"),
+			Labels: map[string]string{LabelKind: KindGateway, LabelName: "default"},
+		},
+		Data: map[string]string{KeyText: "10.6.1.20\n"},
+	}
+	builder := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(stale, outdated)
+	for _, item := range gateways() {
+		builder = builder.WithObjects(item.DeepCopy())
+	}
+	cli := builder.Build()
+	r := &eipList{reader: cli, cli: cli, namespace: "kube-system", log: logr.Discard()}
+
+	ctx := context.Background()
+	_, err := r.Reconcile(ctx, reconcile.Request{})
+	assert.NoError(t, err)
+
+	list := new(corev1.ConfigMapList)
+	assert.NoError(t, cli.List(ctx, list, client.InNamespace("kube-system"), client.HasLabels{LabelKind}))
+	data := make(map[string]map[string]string)
+	for _, item := range list.Items {
+		data[item.Name] = item.Data
+	}
+	assert.Len(t, data, 5)
+	assert.Equal(t, map[string]string{
+		KeyText:  "10.6.1.21\n10.6.1.22\n10.6.1.23\nfd00::22\n",
+		KeyJSON:  `{"ipv4":["10.6.1.21","10.6.1.22","10.6.1.23"],"ipv6":["fd00::22"]}` + "\n",
+		KeyCIDRs: "10.6.1.21/32\n10.6.1.22/31\nfd00::22/128\n",
+	}, data["egressgateway-eips-gateway-default"])
+	assert.Equal(t, "10.6.1.23\n", data["egressgateway-eips-namespace-tenant-b"][KeyText])
+	assert.Contains(t, data, "egressgateway-eips-gateway-other")
+	assert.Contains(t, data, "egressgateway-eips-gateway-empty")
+	assert.Contains(t, data, "egressgateway-eips-namespace-tenant-a")
+}
+
+func TestServer(t *testing.T) {
+	builder := fake.NewClientBuilder().WithScheme(schema.GetScheme())
+	for _, item := range gateways() {
+		builder = builder.WithObjects(item.DeepCopy())
+	}
+	server := httptest.NewServer(NewServer("", builder.Build(), logr.Discard()))
+	defer server.Close()
+
+	cases := map[string]struct {
+		path        string
+		code        int
+		contentType string
+		body        string
+	}{
+		"all": {
+			path:        "/eips",
+			code:        http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        "10.6.1.21\n10.6.1.22\n10.6.1.23\n10.6.2.21\nfd00::22\n",
+		},
+		"gateway as json": {
+			path:        "/eips/gateways/other?format=json",
+			code:        http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ipv4":["10.6.2.21"],"ipv6":[]}` + "\n",
+		},
+		"namespace as cidr": {
+			path:        "/eips/namespaces/tenant-a?format=cidr",
+			code:        http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        "10.6.1.22/32\n10.6.2.21/32\nfd00::22/128\n",
+		},
+		"namespace without EIPs": {
+			path:        "/eips/namespaces/tenant-c?format=json",
+			code:        http.StatusOK,
+			contentType: "application/json",
+			body:        `{"ipv4":[],"ipv6":[]}` + "\n",
+		},
+		"gateway not found": {
+			path: "/eips/gateways/missing",
+			code: http.StatusNotFound,
+		},
+		"unknown path": {
+			path: "/eips/nodes/node1",
+			code: http.StatusNotFound,
+		},
+		"unknown format": {
+			path: "/eips?format=yaml",
+			code: http.StatusBadRequest,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tc.path)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tc.code, resp.StatusCode)
+			if tc.code != http.StatusOK {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.contentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, tc.body, string(body))
+		})
+	}
+}
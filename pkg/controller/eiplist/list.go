@@ -0,0 +1,211 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package eiplist publishes the EIPs in use of each EgressGateway and of each
+// namespace, so that the firewalls and allowlists outside the cluster can read
+// them without the EgressGateway status. The lists are written into ConfigMaps
+// and served over HTTP as plain text, JSON and aggregated CIDRs.
+package eiplist
+
+import (
+	"encoding/json"
+	"net/netip"
+	"sort"
+	"strings"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// List is a list of the EIPs in use, sorted.
+type List struct {
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"`
+}
+
+// Lists are the EIP lists of the gateways and of the namespaces, by name.
+type Lists struct {
+	Gateways   map[string]List
+	Namespaces map[string]List
+}
+
+// Build returns the EIP lists of the gateways. An EIP is in use when it is
+// assigned to a policy, the policies using the node IP have no EIP. The list
+// of a namespace has the EIPs of its EgressPolicies, the ones of the
+// EgressClusterPolicies are only in the list of their gateway.
+func Build(gateways []egress.EgressGateway) Lists {
+	gatewayIPs := make(map[string]map[string]struct{})
+	namespaceIPs := make(map[string]map[string]struct{})
+	for _, gateway := range gateways {
+		gatewayIPs[gateway.Name] = make(map[string]struct{})
+		for _, node := range gateway.Status.NodeList {
+			for _, eip := range node.Eips {
+				for _, ip := range []string{eip.IPv4, eip.IPv6} {
+					if ip == "" {
+						continue
+					}
+					gatewayIPs[gateway.Name][ip] = struct{}{}
+					for _, policy := range eip.Policies {
+						if policy.Namespace == "" {
+							continue
+						}
+						if _, ok := namespaceIPs[policy.Namespace]; !ok {
+							namespaceIPs[policy.Namespace] = make(map[string]struct{})
+						}
+						namespaceIPs[policy.Namespace][ip] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	res := Lists{
+		Gateways:   make(map[string]List, len(gatewayIPs)),
+		Namespaces: make(map[string]List, len(namespaceIPs)),
+	}
+	for name, ips := range gatewayIPs {
+		res.Gateways[name] = newList(ips)
+	}
+	for name, ips := range namespaceIPs {
+		res.Namespaces[name] = newList(ips)
+	}
+	return res
+}
+
+// All returns the EIPs of all the gateways.
+func (l Lists) All() List {
+	ips := make(map[string]struct{})
+	for _, list := range l.Gateways {
+		for _, ip := range list.IPv4 {
+			ips[ip] = struct{}{}
+		}
+		for _, ip := range list.IPv6 {
+			ips[ip] = struct{}{}
+		}
+	}
+	return newList(ips)
+}
+
+func newList(ips map[string]struct{}) List {
+	addrs := make([]netip.Addr, 0, len(ips))
+	for ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+
+	res := List{IPv4: make([]string, 0), IPv6: make([]string, 0)}
+	for _, addr := range addrs {
+		if addr.Is4() {
+			res.IPv4 = append(res.IPv4, addr.String())
+		} else {
+			res.IPv6 = append(res.IPv6, addr.String())
+		}
+	}
+	return res
+}
+
+// Text returns the EIPs one per line, the IPv4 ones first.
+func (l List) Text() string {
+	return lines(append(append([]string{}, l.IPv4...), l.IPv6...))
+}
+
+// JSON returns the list as a JSON object.
+func (l List) JSON() string {
+	data, _ := json.Marshal(l)
+	return string(data) + "\n"
+}
+
+// CIDRText returns the aggregated CIDRs of the EIPs one per line.
+func (l List) CIDRText() string {
+	return lines(append(Aggregate(l.IPv4), Aggregate(l.IPv6)...))
+}
+
+func lines(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return strings.Join(items, "\n") + "\n"
+}
+
+// Aggregate returns the fewest CIDRs which cover exactly the IPs, the IPs of
+// one family are expected.
+func Aggregate(ips []string) []string {
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr.Unmap())
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+
+	res := make([]string, 0)
+	for i := 0; i < len(addrs); {
+		// the range of consecutive IPs from addrs[i]
+		start, end := addrs[i], addrs[i]
+		for i++; i < len(addrs); i++ {
+			if addrs[i] == end {
+				continue
+			}
+			if next := end.Next(); !next.IsValid() || next != addrs[i] {
+				break
+			}
+			end = addrs[i]
+		}
+		for _, prefix := range rangeToPrefixes(start, end) {
+			res = append(res, prefix.String())
+		}
+	}
+	return res
+}
+
+// rangeToPrefixes splits the range from start to end into the largest aligned
+// prefixes.
+func rangeToPrefixes(start, end netip.Addr) []netip.Prefix {
+	res := make([]netip.Prefix, 0)
+	for {
+		prefix := netip.PrefixFrom(start, start.BitLen())
+		for bits := start.BitLen() - 1; bits >= 0; bits-- {
+			candidate := netip.PrefixFrom(start, bits).Masked()
+			if candidate.Addr() != start || end.Less(lastAddr(candidate)) {
+				break
+			}
+			prefix = candidate
+		}
+		res = append(res, prefix)
+		last := lastAddr(prefix)
+		if last == end {
+			return res
+		}
+		start = last.Next()
+	}
+}
+
+// lastAddr returns the last IP of the prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr()
+	if addr.Is4() {
+		b := addr.As4()
+		setHostBits(b[:], prefix.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := addr.As16()
+	setHostBits(b[:], prefix.Bits())
+	return netip.AddrFrom16(b)
+}
+
+func setHostBits(b []byte, bits int) {
+	for i := range b {
+		switch {
+		case bits >= (i+1)*8:
+		case bits <= i*8:
+			b[i] = 0xff
+		default:
+			b[i] |= 0xff >> (bits - i*8)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eiplist
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Path is the path of the EIPs of all the gateways, the lists of a gateway and
+// of a namespace are under Path/gateways/<name> and Path/namespaces/<name>.
+const Path = "/eips"
+
+// the formats of the format query parameter
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatCIDR = "cidr"
+)
+
+// Server serves the EIP lists, it is read-only. The lists are built from the
+// cache at each request, so that every replica of the controller serves them.
+type Server struct {
+	addr   string
+	reader client.Reader
+	log    logr.Logger
+}
+
+// NewServer returns the server listening on addr.
+func NewServer(addr string, reader client.Reader, log logr.Logger) *Server {
+	return &Server{addr: addr, reader: reader, log: log}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatJSON && format != FormatCIDR {
+		http.Error(w, "format should be text, json or cidr", http.StatusBadRequest)
+		return
+	}
+
+	gateways := new(egress.EgressGatewayList)
+	if err := s.reader.List(req.Context(), gateways); err != nil {
+		s.log.Error(err, "failed to list EgressGateways")
+		http.Error(w, "failed to list EgressGateways", http.StatusInternalServerError)
+		return
+	}
+	lists := Build(gateways.Items)
+
+	var list List
+	kind, name, _ := strings.Cut(strings.Trim(strings.TrimPrefix(req.URL.Path, Path), "/"), "/")
+	switch {
+	case kind == "" && name == "":
+		list = lists.All()
+	case kind == "gateways" && name != "" && !strings.Contains(name, "/"):
+		var ok bool
+		if list, ok = lists.Gateways[name]; !ok {
+			http.Error(w, "EgressGateway "+name+" not found", http.StatusNotFound)
+			return
+		}
+	case kind == "namespaces" && name != "" && !strings.Contains(name, "/"):
+		var ok bool
+		if list, ok = lists.Namespaces[name]; !ok {
+			// a namespace without EIPs has an empty list
+			list = newList(nil)
+		}
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	var body string
+	switch format {
+	case FormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		body = list.JSON()
+	case FormatCIDR:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		body = list.CIDRText()
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		body = list.Text()
+	}
+	if _, err := w.Write([]byte(body)); err != nil {
+		s.log.Error(err, "failed to write the EIP list")
+	}
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(Path, s)
+	mux.Handle(Path+"/", s)
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	s.log.Info("EIP list server is started", "addr", s.addr)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) NeedLeaderElection() bool { return false }
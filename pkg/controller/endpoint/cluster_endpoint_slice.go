@@ -8,10 +8,8 @@ import (
 	"fmt"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
 	"reflect"
-	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/spidernet-io/egressgateway/pkg/coalescing"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -132,21 +130,23 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 			}
 		}
 
+		// the slices which receive endpoints move to slicesToUpdate, each
+		// slice is kept in one list only
+		notChange := make([]v1beta1.EgressClusterEndpointSlice, 0, len(slicesNotChange))
 		for _, slice := range slicesNotChange {
-			if len(slice.Endpoints) < r.config.FileConfig.MaxNumberEndpointPerSlice {
-				count := r.config.FileConfig.MaxNumberEndpointPerSlice - len(slice.Endpoints)
-				if count < len(needToCreateEp) {
-					slice.Endpoints = append(slice.Endpoints, needToCreateEp[:count]...)
-					needToCreateEp = needToCreateEp[count:]
-					slicesToUpdate = append(slicesToUpdate, slice)
-				} else {
-					slice.Endpoints = append(slice.Endpoints, needToCreateEp...)
-					needToCreateEp = make([]v1beta1.EgressEndpoint, 0)
-					slicesToUpdate = append(slicesToUpdate, slice)
-					break
-				}
+			if len(needToCreateEp) == 0 || len(slice.Endpoints) >= r.config.FileConfig.MaxNumberEndpointPerSlice {
+				notChange = append(notChange, slice)
+				continue
+			}
+			count := r.config.FileConfig.MaxNumberEndpointPerSlice - len(slice.Endpoints)
+			if count > len(needToCreateEp) {
+				count = len(needToCreateEp)
 			}
+			slice.Endpoints = append(slice.Endpoints[:len(slice.Endpoints):len(slice.Endpoints)], needToCreateEp[:count]...)
+			needToCreateEp = needToCreateEp[count:]
+			slicesToUpdate = append(slicesToUpdate, slice)
 		}
+		slicesNotChange = notChange
 	}
 
 	for len(needToCreateEp) > 0 {
@@ -165,6 +165,11 @@ func (r *endpointClusterReconciler) Reconcile(ctx context.Context, req reconcile
 		}
 	}
 
+	if r.config.FileConfig.EndpointSlice.Compaction {
+		slicesToUpdate, slicesNotChange = compactClusterEndpointSlices(slicesToUpdate, slicesNotChange,
+			r.config.FileConfig.MaxNumberEndpointPerSlice)
+	}
+
 	errs := make([]error, 0) // all errors generated in the process of reconciling
 
 	for _, slice := range slicesToUpdate {
@@ -298,11 +303,10 @@ func NewEgressClusterEpSliceController(mgr manager.Manager, log logr.Logger, cfg
 	name := "cluster-endpoint"
 	log.Info("new egress cluster endpoint slice controller")
 
-	cache, err := coalescing.NewRequestCache(time.Second)
+	reduce, err := newBatchReconciler(r, cfg, log)
 	if err != nil {
 		return err
 	}
-	reduce := coalescing.NewReconciler(r, cache, log)
 
 	c, err := controller.New(name, mgr, controller.Options{Reconciler: reduce})
 	if err != nil {
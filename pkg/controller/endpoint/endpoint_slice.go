@@ -9,7 +9,6 @@ import (
 	"net"
 	"reflect"
 	"sort"
-	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -28,7 +27,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	"github.com/spidernet-io/egressgateway/pkg/coalescing"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
@@ -136,21 +134,23 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 			}
 		}
 
+		// the slices which receive endpoints move to slicesToUpdate, each
+		// slice is kept in one list only
+		notChange := make([]v1beta1.EgressEndpointSlice, 0, len(slicesNotChange))
 		for _, slice := range slicesNotChange {
-			if len(slice.Endpoints) < r.config.FileConfig.MaxNumberEndpointPerSlice {
-				count := r.config.FileConfig.MaxNumberEndpointPerSlice - len(slice.Endpoints)
-				if count < len(needToCreateEp) {
-					slice.Endpoints = append(slice.Endpoints, needToCreateEp[:count]...)
-					needToCreateEp = needToCreateEp[count:]
-					slicesToUpdate = append(slicesToUpdate, slice)
-				} else {
-					slice.Endpoints = append(slice.Endpoints, needToCreateEp...)
-					needToCreateEp = make([]v1beta1.EgressEndpoint, 0)
-					slicesToUpdate = append(slicesToUpdate, slice)
-					break
-				}
+			if len(needToCreateEp) == 0 || len(slice.Endpoints) >= r.config.FileConfig.MaxNumberEndpointPerSlice {
+				notChange = append(notChange, slice)
+				continue
+			}
+			count := r.config.FileConfig.MaxNumberEndpointPerSlice - len(slice.Endpoints)
+			if count > len(needToCreateEp) {
+				count = len(needToCreateEp)
 			}
+			slice.Endpoints = append(slice.Endpoints[:len(slice.Endpoints):len(slice.Endpoints)], needToCreateEp[:count]...)
+			needToCreateEp = needToCreateEp[count:]
+			slicesToUpdate = append(slicesToUpdate, slice)
 		}
+		slicesNotChange = notChange
 	}
 
 	for len(needToCreateEp) > 0 {
@@ -167,6 +167,11 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 		}
 	}
 
+	if r.config.FileConfig.EndpointSlice.Compaction {
+		slicesToUpdate, slicesNotChange = compactEndpointSlices(slicesToUpdate, slicesNotChange,
+			r.config.FileConfig.MaxNumberEndpointPerSlice)
+	}
+
 	errs := make([]error, 0) // all errors generated in the process of reconciling
 
 	for _, slice := range slicesToUpdate {
@@ -348,11 +353,10 @@ func NewEgressEndpointSliceController(mgr manager.Manager, log logr.Logger, cfg
 	}
 	log.Info("new endpoint controller")
 
-	cache, err := coalescing.NewRequestCache(time.Second)
+	reduce, err := newBatchReconciler(r, cfg, log)
 	if err != nil {
 		return err
 	}
-	reduce := coalescing.NewReconciler(r, cache, log)
 
 	c, err := controller.New("endpoint", mgr, controller.Options{Reconciler: reduce})
 	if err != nil {
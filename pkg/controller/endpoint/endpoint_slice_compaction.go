@@ -0,0 +1,140 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/coalescing"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// compactEndpoints moves the endpoints of the least filled slices into the free
+// room of the other slices when fewer slices can hold all the endpoints. Slices
+// that are already changed receive the endpoints first to save API writes.
+// changed is updated for every slice touched, a slice left empty is deleted by
+// the caller.
+func compactEndpoints(endpoints [][]v1beta1.EgressEndpoint, changed []bool, max int) {
+	if max <= 0 {
+		return
+	}
+	total := 0
+	for _, eps := range endpoints {
+		total += len(eps)
+	}
+	need := (total + max - 1) / max
+	if len(endpoints) <= need {
+		return
+	}
+
+	order := make([]int, len(endpoints))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(endpoints[order[i]]) < len(endpoints[order[j]])
+	})
+	donors := order[:len(endpoints)-need]
+	receivers := order[len(endpoints)-need:]
+	sort.SliceStable(receivers, func(i, j int) bool {
+		return changed[receivers[i]] && !changed[receivers[j]]
+	})
+
+	next := 0
+	for _, d := range donors {
+		for len(endpoints[d]) > 0 {
+			r := receivers[next]
+			room := max - len(endpoints[r])
+			if room <= 0 {
+				next++
+				continue
+			}
+			count := len(endpoints[d])
+			if count > room {
+				count = room
+			}
+			tail := len(endpoints[d]) - count
+			endpoints[r] = append(endpoints[r], endpoints[d][tail:]...)
+			endpoints[d] = endpoints[d][:tail]
+			changed[r] = true
+		}
+		changed[d] = true
+	}
+}
+
+// compactEndpointSlices runs compactEndpoints on the existing slices of a policy,
+// slices changed by the compaction move from slicesNotChange to slicesToUpdate
+func compactEndpointSlices(slicesToUpdate, slicesNotChange []v1beta1.EgressEndpointSlice,
+	max int) ([]v1beta1.EgressEndpointSlice, []v1beta1.EgressEndpointSlice) {
+	all := make([]v1beta1.EgressEndpointSlice, 0, len(slicesToUpdate)+len(slicesNotChange))
+	all = append(all, slicesToUpdate...)
+	all = append(all, slicesNotChange...)
+
+	endpoints := make([][]v1beta1.EgressEndpoint, len(all))
+	changed := make([]bool, len(all))
+	for i := range all {
+		endpoints[i] = all[i].Endpoints
+		changed[i] = i < len(slicesToUpdate)
+	}
+	compactEndpoints(endpoints, changed, max)
+
+	update := make([]v1beta1.EgressEndpointSlice, 0, len(all))
+	notChange := make([]v1beta1.EgressEndpointSlice, 0)
+	for i := range all {
+		all[i].Endpoints = endpoints[i]
+		if changed[i] {
+			update = append(update, all[i])
+		} else {
+			notChange = append(notChange, all[i])
+		}
+	}
+	return update, notChange
+}
+
+// compactClusterEndpointSlices is compactEndpointSlices for EgressClusterEndpointSlice
+func compactClusterEndpointSlices(slicesToUpdate, slicesNotChange []v1beta1.EgressClusterEndpointSlice,
+	max int) ([]v1beta1.EgressClusterEndpointSlice, []v1beta1.EgressClusterEndpointSlice) {
+	all := make([]v1beta1.EgressClusterEndpointSlice, 0, len(slicesToUpdate)+len(slicesNotChange))
+	all = append(all, slicesToUpdate...)
+	all = append(all, slicesNotChange...)
+
+	endpoints := make([][]v1beta1.EgressEndpoint, len(all))
+	changed := make([]bool, len(all))
+	for i := range all {
+		endpoints[i] = all[i].Endpoints
+		changed[i] = i < len(slicesToUpdate)
+	}
+	compactEndpoints(endpoints, changed, max)
+
+	update := make([]v1beta1.EgressClusterEndpointSlice, 0, len(all))
+	notChange := make([]v1beta1.EgressClusterEndpointSlice, 0)
+	for i := range all {
+		all[i].Endpoints = endpoints[i]
+		if changed[i] {
+			update = append(update, all[i])
+		} else {
+			notChange = append(notChange, all[i])
+		}
+	}
+	return update, notChange
+}
+
+// newBatchReconciler batches the requests of a policy in the time window of
+// batchPeriodMillis, the window is one second if it is not set
+func newBatchReconciler(r reconcile.Reconciler, cfg *config.Config, log logr.Logger) (reconcile.Reconciler, error) {
+	period := time.Duration(cfg.FileConfig.EndpointSlice.BatchPeriodMillis) * time.Millisecond
+	if period <= 0 {
+		period = time.Second
+	}
+	cache, err := coalescing.NewRequestCache(period)
+	if err != nil {
+		return nil, err
+	}
+	return coalescing.NewReconciler(r, cache, log), nil
+}
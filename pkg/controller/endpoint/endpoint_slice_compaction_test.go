@@ -0,0 +1,420 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func makeEndpoints(prefix string, count int) []v1beta1.EgressEndpoint {
+	res := make([]v1beta1.EgressEndpoint, 0, count)
+	for i := 0; i < count; i++ {
+		res = append(res, v1beta1.EgressEndpoint{Namespace: "default", Pod: fmt.Sprintf("%s-%d", prefix, i)})
+	}
+	return res
+}
+
+func TestCompactEndpoints(t *testing.T) {
+	cases := map[string]struct {
+		lens       []int
+		changed    []bool
+		max        int
+		expLens    []int
+		expChanged []bool
+	}{
+		"no room to compact": {
+			lens:       []int{3, 2},
+			changed:    []bool{false, false},
+			max:        3,
+			expLens:    []int{3, 2},
+			expChanged: []bool{false, false},
+		},
+		"merge half empty slices": {
+			lens:       []int{2, 1, 3},
+			changed:    []bool{false, false, false},
+			max:        3,
+			expLens:    []int{3, 0, 3},
+			expChanged: []bool{true, true, false},
+		},
+		"prefer changed slices as receiver": {
+			lens:       []int{2, 2, 1},
+			changed:    []bool{false, true, false},
+			max:        4,
+			expLens:    []int{2, 3, 0},
+			expChanged: []bool{false, true, true},
+		},
+		"remove empty slices": {
+			lens:       []int{0, 0, 2},
+			changed:    []bool{true, true, false},
+			max:        4,
+			expLens:    []int{0, 0, 2},
+			expChanged: []bool{true, true, false},
+		},
+		"spread donor over receivers": {
+			lens:       []int{2, 2, 2},
+			changed:    []bool{false, false, false},
+			max:        3,
+			expLens:    []int{0, 3, 3},
+			expChanged: []bool{true, true, true},
+		},
+		"invalid max": {
+			lens:       []int{1, 1},
+			changed:    []bool{false, false},
+			max:        0,
+			expLens:    []int{1, 1},
+			expChanged: []bool{false, false},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			endpoints := make([][]v1beta1.EgressEndpoint, len(tc.lens))
+			total := make(map[string]struct{})
+			for i, l := range tc.lens {
+				endpoints[i] = makeEndpoints(fmt.Sprintf("s%d", i), l)
+				for _, ep := range endpoints[i] {
+					total[ep.Pod] = struct{}{}
+				}
+			}
+
+			compactEndpoints(endpoints, tc.changed, tc.max)
+
+			lens := make([]int, len(endpoints))
+			got := make(map[string]struct{})
+			for i, eps := range endpoints {
+				lens[i] = len(eps)
+				for _, ep := range eps {
+					got[ep.Pod] = struct{}{}
+				}
+			}
+			assert.Equal(t, tc.expLens, lens)
+			assert.Equal(t, tc.expChanged, tc.changed)
+			assert.Equal(t, total, got)
+		})
+	}
+}
+
+type writeCounter struct {
+	writes int
+}
+
+func (c *writeCounter) funcs() interceptor.Funcs {
+	return interceptor.Funcs{
+		Create: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if _, ok := obj.(*corev1.Pod); !ok {
+				c.writes++
+			}
+			return cli.Create(ctx, obj, opts...)
+		},
+		Update: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if _, ok := obj.(*corev1.Pod); !ok {
+				c.writes++
+			}
+			return cli.Update(ctx, obj, opts...)
+		},
+		Delete: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if _, ok := obj.(*corev1.Pod); !ok {
+				c.writes++
+			}
+			return cli.Delete(ctx, obj, opts...)
+		},
+	}
+}
+
+func newCompactionPolicy() *v1beta1.EgressPolicy {
+	return &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy1", Namespace: "default"},
+		Spec: v1beta1.EgressPolicySpec{
+			AppliedTo: v1beta1.AppliedTo{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+			},
+		},
+	}
+}
+
+func newCompactionPod(i int) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("pod-%d", i),
+			Namespace: "default",
+			Labels:    map[string]string{"app": "nginx"},
+		},
+		Status: corev1.PodStatus{
+			PodIPs: []corev1.PodIP{{IP: fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)}},
+		},
+	}
+}
+
+func newCompactionReconciler(objs []client.Object, counter *writeCounter, max int, compaction bool) *endpointReconciler {
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(objs...).
+		WithInterceptorFuncs(counter.funcs()).
+		Build()
+	cfg := &config.Config{
+		FileConfig: config.FileConfig{
+			MaxNumberEndpointPerSlice: max,
+			EndpointSlice:             config.EndpointSlice{Compaction: compaction},
+		},
+	}
+	return &endpointReconciler{
+		client: cli,
+		log:    logger.NewLogger(cfg.EnvConfig.Logger),
+		config: cfg,
+	}
+}
+
+func TestEndpointSliceCompaction(t *testing.T) {
+	cases := map[string]struct {
+		compaction bool
+		expSlices  int
+	}{
+		"compaction disabled": {compaction: false, expSlices: 4},
+		"compaction enabled":  {compaction: true, expSlices: 2},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			objs := []client.Object{newCompactionPolicy()}
+			for i := 0; i < 16; i++ {
+				objs = append(objs, newCompactionPod(i))
+			}
+			counter := new(writeCounter)
+			r := newCompactionReconciler(objs, counter, 4, tc.compaction)
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy1"}}
+
+			_, err := r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+
+			// scale down every other pod, each slice is left half empty
+			for i := 0; i < 16; i += 2 {
+				err = r.client.Delete(ctx, newCompactionPod(i))
+				assert.NoError(t, err)
+			}
+			_, err = r.Reconcile(ctx, req)
+			assert.NoError(t, err)
+
+			slices, err := listEndpointSlices(ctx, r.client, "default", "policy1")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expSlices, len(slices.Items))
+
+			pods := make(map[string]struct{})
+			for _, slice := range slices.Items {
+				assert.LessOrEqual(t, len(slice.Endpoints), 4)
+				for _, ep := range slice.Endpoints {
+					pods[ep.Pod] = struct{}{}
+				}
+			}
+			assert.Equal(t, 8, len(pods))
+		})
+	}
+}
+
+// TestEndpointSliceCompactionFilledSlice adds a pod to the slices [4, 1], the
+// slice which receives the new endpoint is compacted once and keeps it
+func TestEndpointSliceCompactionFilledSlice(t *testing.T) {
+	ctx := context.Background()
+	objs := []client.Object{newCompactionPolicy()}
+	for i := 0; i < 5; i++ {
+		objs = append(objs, newCompactionPod(i))
+	}
+	r := newCompactionReconciler(objs, new(writeCounter), 4, true)
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy1"}}
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.client.Create(ctx, newCompactionPod(5)))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	slices, err := listEndpointSlices(ctx, r.client, "default", "policy1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(slices.Items))
+	pods := make(map[string]struct{})
+	for _, slice := range slices.Items {
+		for _, ep := range slice.Endpoints {
+			pods[ep.Pod] = struct{}{}
+		}
+	}
+	assert.Equal(t, 6, len(pods))
+}
+
+func TestClusterEndpointSliceCompactionFilledSlice(t *testing.T) {
+	ctx := context.Background()
+	objs := []client.Object{&v1beta1.EgressClusterPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy1"},
+		Spec: v1beta1.EgressClusterPolicySpec{
+			AppliedTo: v1beta1.ClusterAppliedTo{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+			},
+		},
+	}}
+	for i := 0; i < 5; i++ {
+		objs = append(objs, newCompactionPod(i))
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).Build()
+	cfg := &config.Config{
+		FileConfig: config.FileConfig{
+			MaxNumberEndpointPerSlice: 4,
+			EndpointSlice:             config.EndpointSlice{Compaction: true},
+		},
+	}
+	r := &endpointClusterReconciler{client: cli, log: logger.NewLogger(cfg.EnvConfig.Logger), config: cfg}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "policy1"}}
+	_, err := r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cli.Create(ctx, newCompactionPod(5)))
+	_, err = r.Reconcile(ctx, req)
+	assert.NoError(t, err)
+
+	slices, err := listClusterEndpointSlices(ctx, cli, "policy1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(slices.Items))
+	pods := make(map[string]struct{})
+	for _, slice := range slices.Items {
+		for _, ep := range slice.Endpoints {
+			pods[ep.Pod] = struct{}{}
+		}
+	}
+	assert.Equal(t, 6, len(pods))
+}
+
+// BenchmarkEndpointSliceScaleDown reports the number of slices left after a policy
+// with 10k pods is scaled down by half, and the API writes to scale it down and up again
+func BenchmarkEndpointSliceScaleDown(b *testing.B) {
+	for _, compaction := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compaction=%v", compaction), func(b *testing.B) {
+			benchmarkEndpointSliceScaleDown(b, 10000, compaction)
+		})
+	}
+}
+
+func benchmarkEndpointSliceScaleDown(b *testing.B, podCount int, compaction bool) {
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy1"}}
+	writes, slices := 0, 0
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		objs := []client.Object{newCompactionPolicy()}
+		for i := 0; i < podCount; i++ {
+			objs = append(objs, newCompactionPod(i))
+		}
+		counter := new(writeCounter)
+		r := newCompactionReconciler(objs, counter, 100, compaction)
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < podCount; i += 2 {
+			if err := r.client.Delete(ctx, newCompactionPod(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		counter.writes = 0
+		b.StartTimer()
+
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		list, err := listEndpointSlices(ctx, r.client, "default", "policy1")
+		if err != nil {
+			b.Fatal(err)
+		}
+		slices += len(list.Items)
+		b.StartTimer()
+
+		for i := podCount; i < podCount+podCount/2; i++ {
+			if err := r.client.Create(ctx, newCompactionPod(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+
+		writes += counter.writes
+	}
+
+	b.ReportMetric(float64(writes)/float64(b.N), "writes/op")
+	b.ReportMetric(float64(slices)/float64(b.N), "slices/op")
+}
+
+// BenchmarkEndpointSliceBatch reports the API writes for 100 pod changes of a
+// policy with 10k pods, when every change is reconciled and when the changes
+// are batched in time windows
+func BenchmarkEndpointSliceBatch(b *testing.B) {
+	for _, batch := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			benchmarkEndpointSliceBatch(b, 10000, 100, batch)
+		})
+	}
+}
+
+func benchmarkEndpointSliceBatch(b *testing.B, podCount, changes, batch int) {
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "policy1"}}
+
+	objs := []client.Object{newCompactionPolicy()}
+	for i := 0; i < podCount; i++ {
+		objs = append(objs, newCompactionPod(i))
+	}
+	counter := new(writeCounter)
+	r := newCompactionReconciler(objs, counter, 100, true)
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		b.Fatal(err)
+	}
+	list, err := listEndpointSlices(ctx, r.client, "default", "policy1")
+	if err != nil {
+		b.Fatal(err)
+	}
+	// the changed pods share the first slice
+	names := make([]string, 0, changes)
+	for _, ep := range list.Items[0].Endpoints {
+		if len(names) < changes {
+			names = append(names, ep.Pod)
+		}
+	}
+	counter.writes = 0
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i, name := range names {
+			pod := new(corev1.Pod)
+			if err := r.client.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, pod); err != nil {
+				b.Fatal(err)
+			}
+			pod.Status.PodIPs = []corev1.PodIP{{IP: fmt.Sprintf("172.16.%d.%d", n&0xff, i&0xff)}}
+			if err := r.client.Status().Update(ctx, pod); err != nil {
+				b.Fatal(err)
+			}
+			if (i+1)%batch == 0 {
+				if _, err := r.Reconcile(ctx, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	b.ReportMetric(float64(counter.writes)/float64(b.N), "writes/op")
+}
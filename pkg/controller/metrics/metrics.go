@@ -6,12 +6,16 @@ package metrics
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spidernet-io/egressgateway/pkg/controller/tunnel"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway/audit"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway/notifier"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 func RegisterMetricCollectors() {
 	var metricCollectors []prometheus.Collector
 	metricCollectors = append(metricCollectors, tunnel.EgressTunnelControllerMetricCollectors...)
+	metricCollectors = append(metricCollectors, audit.MetricCollectors()...)
+	metricCollectors = append(metricCollectors, notifier.MetricCollectors()...)
 	for _, collector := range metricCollectors {
 		metrics.Registry.MustRegister(collector)
 	}
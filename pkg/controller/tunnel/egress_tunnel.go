@@ -57,17 +57,39 @@ var (
 		Name: "egress_mark_release_calls",
 		Help: "Total number of mark release calls",
 	})
+
+	countNumMarkDuplicateRepairs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "egress_mark_duplicate_repair_calls",
+		Help: "Total number of egress tunnel marks reallocated because another tunnel owns them",
+	})
+
+	gaugeMarkAllocatorTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "egress_mark_allocator_total",
+		Help: "Total number of marks that can be allocated",
+	})
+
+	gaugeMarkAllocatorUsed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "egress_mark_allocator_used",
+		Help: "Number of marks allocated to egress tunnels",
+	})
 )
 
 var (
 	egressTunnelFinalizers = "egressgateway.spidernet.io/egresstunnel"
+	// markRecordName is the name of the ConfigMap that records the mark of each egress tunnel
+	markRecordName = "egressgateway-mark-record"
 )
 
+const ReasonDuplicateMark = "DuplicateMark"
+
 var EgressTunnelControllerMetricCollectors = []prometheus.Collector{
 	countNumIPAllocateNextCalls,
 	countNumIPReleaseCalls,
 	countNumMarkAllocateNextCalls,
 	countNumMarkReleaseCalls,
+	countNumMarkDuplicateRepairs,
+	gaugeMarkAllocatorTotal,
+	gaugeMarkAllocatorUsed,
 }
 
 type egReconciler struct {
@@ -76,6 +98,7 @@ type egReconciler struct {
 	config      *config.Config
 	doOnce      sync.Once
 	mark        markallocator.Interface
+	markRecord  *markallocator.Record
 	allocatorV4 *ipallocator.Range
 	allocatorV6 *ipallocator.Range
 	initDone    chan struct{}
@@ -256,7 +279,7 @@ func (r *egReconciler) releaseEgressTunnel(node egressv1.EgressTunnel, log logr.
 
 	if node.Status.Mark != "" {
 		log.V(1).Info("try to release egress tunnel mark", "mark", node.Status.Mark)
-		err := r.mark.Release(node.Status.Mark)
+		err := r.releaseMark(context.Background(), node.Name, node.Status.Mark)
 		if err != nil {
 			return fmt.Errorf("failed to release egress tunnel mark: %v", err)
 		}
@@ -264,7 +287,7 @@ func (r *egReconciler) releaseEgressTunnel(node egressv1.EgressTunnel, log logr.
 		countNumMarkReleaseCalls.Inc()
 
 		rollback = append(rollback, func() {
-			_ = r.mark.Allocate(node.Status.Mark)
+			_, _ = r.recordMark(context.Background(), node.Name, node.Status.Mark)
 		})
 	}
 	if node.Status.Tunnel.IPv4 != "" && r.allocatorV4 != nil {
@@ -336,18 +359,15 @@ func (r *egReconciler) reBuildCache(node egressv1.EgressTunnel, log logr.Logger)
 
 	if newNode.Status.Mark != "" {
 		log.V(1).Info("rebuild mark cache", "mark", newNode.Status.Mark)
-		err := r.mark.Allocate(newNode.Status.Mark)
+		ok, err := r.recordMark(context.Background(), newNode.Name, newNode.Status.Mark)
 		if err != nil {
-			newNode.Status.Tunnel.MAC = ""
+			return fmt.Errorf("failed to record mark: %v", err)
+		}
+		if !ok {
+			r.repairDuplicateMark(newNode, log)
 			needUpdate = true
-			log.V(1).Error(err, "can't reused mark")
 		} else {
 			log.V(1).Info("rebuild mark cache succeeded")
-			rollback = append(rollback, func() {
-				if err := r.mark.Release(newNode.Status.Mark); err != nil {
-					log.Error(err, "rollback can't release", "mark", newNode.Status.Mark)
-				}
-			})
 		}
 	}
 
@@ -434,20 +454,27 @@ func (r *egReconciler) keepEgressTunnel(node egressv1.EgressTunnel, log logr.Log
 		log.V(1).Info("generate new mac address succeeded", "mac", newNode.Status.Tunnel.MAC)
 	}
 
+	if newNode.Status.Mark != "" {
+		ok, err := r.recordMark(context.Background(), newNode.Name, newNode.Status.Mark)
+		if err != nil {
+			return fmt.Errorf("failed to record mark: %v", err)
+		}
+		if !ok {
+			r.repairDuplicateMark(newNode, log)
+			needUpdate = true
+		}
+	}
+
 	if newNode.Status.Mark == "" {
 		log.V(1).Info("try to allocate next mark")
-		newNode.Status.Mark, err = r.mark.AllocateNext()
+		// the mark stays recorded for the tunnel if the status update fails,
+		// the next reconcile gets the same mark, so there is no rollback
+		newNode.Status.Mark, err = r.allocateMark(context.Background(), newNode.Name)
 		if err != nil {
 			return fmt.Errorf("can't allocate next mark: %v", err)
 		}
-		countNumMarkAllocateNextCalls.Inc()
 		needUpdate = true
-		rollback = append(rollback, func() {
-			if err := r.mark.Release(newNode.Status.Mark); err != nil {
-				log.Error(err, "rollback can't release", "mark", newNode.Status.Mark)
-			}
-		})
-		log.V(1).Info("allocate next ipv4 address succeeded", "ipv4", newNode.Status.Tunnel.IPv4)
+		log.V(1).Info("allocate next mark succeeded", "mark", newNode.Status.Mark)
 	}
 
 	if newNode.Status.Tunnel.IPv4 == "" && r.allocatorV4 != nil {
@@ -493,6 +520,155 @@ func (r *egReconciler) keepEgressTunnel(node egressv1.EgressTunnel, log logr.Log
 	return nil
 }
 
+// allocateMark returns the mark recorded for the owner, or allocates a new mark
+// and records it before it is written to the egress tunnel status
+func (r *egReconciler) allocateMark(ctx context.Context, owner string) (string, error) {
+	mark := ""
+	allocated := false
+	err := r.markRecord.Update(ctx, func(marks map[string]string) (bool, error) {
+		if allocated {
+			// the last attempt lost the race, its mark was not recorded
+			_ = r.mark.Release(mark)
+			mark, allocated = "", false
+		}
+		r.syncMarkRecord(marks)
+		if item, ok := marks[owner]; ok {
+			mark = item
+			return false, nil
+		}
+		item, err := r.mark.AllocateNext()
+		if err != nil {
+			return false, err
+		}
+		countNumMarkAllocateNextCalls.Inc()
+		mark, allocated = item, true
+		marks[owner] = item
+		return true, nil
+	})
+	if err != nil {
+		if allocated {
+			_ = r.mark.Release(mark)
+		}
+		return "", err
+	}
+	r.updateMarkUsage()
+	return mark, nil
+}
+
+// recordMark records the mark found in the egress tunnel status. It returns false
+// when the tunnel can not keep the mark, because another tunnel owns it or it is
+// out of the range.
+func (r *egReconciler) recordMark(ctx context.Context, owner, mark string) (bool, error) {
+	if item, ok := r.markRecord.Lookup(owner); ok && item == mark && r.mark.Has(mark) {
+		return true, nil
+	}
+
+	ok := false
+	old := ""
+	err := r.markRecord.Update(ctx, func(marks map[string]string) (bool, error) {
+		ok, old = false, ""
+		r.syncMarkRecord(marks)
+		for item, m := range marks {
+			if m == mark && item != owner {
+				return false, nil
+			}
+		}
+		if item, exists := marks[owner]; exists && item == mark {
+			ok = true
+			return false, nil
+		}
+		err := r.mark.Allocate(mark)
+		if err != nil && !errors.Is(err, markallocator.ErrAllocated) {
+			return false, nil
+		}
+		old = marks[owner]
+		marks[owner] = mark
+		ok = true
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if old != "" && old != mark {
+		_ = r.mark.Release(old)
+	}
+	r.updateMarkUsage()
+	return ok, nil
+}
+
+// releaseMark removes the mark of the owner from the record, the mark is kept
+// allocated if another tunnel owns it
+func (r *egReconciler) releaseMark(ctx context.Context, owner, mark string) error {
+	err := r.markRecord.Update(ctx, func(marks map[string]string) (bool, error) {
+		if item, ok := marks[owner]; !ok || item != mark {
+			return false, nil
+		}
+		delete(marks, owner)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, ok := r.markRecord.OwnerOf(mark); !ok {
+		err = r.mark.Release(mark)
+		if err != nil {
+			return err
+		}
+	}
+	r.updateMarkUsage()
+	return nil
+}
+
+// pruneMarkRecord removes the marks of the egress tunnels that no longer exist
+func (r *egReconciler) pruneMarkRecord(ctx context.Context, tunnels []egressv1.EgressTunnel) error {
+	exist := make(map[string]struct{}, len(tunnels))
+	for _, item := range tunnels {
+		exist[item.Name] = struct{}{}
+	}
+	var released []string
+	err := r.markRecord.Update(ctx, func(marks map[string]string) (bool, error) {
+		released = released[:0]
+		for owner, mark := range marks {
+			if _, ok := exist[owner]; !ok {
+				delete(marks, owner)
+				released = append(released, mark)
+			}
+		}
+		return len(released) > 0, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, mark := range released {
+		r.log.Info("release the mark of a deleted egress tunnel", "mark", mark)
+		_ = r.mark.Release(mark)
+	}
+	r.updateMarkUsage()
+	return nil
+}
+
+// syncMarkRecord makes sure every recorded mark is allocated in memory
+func (r *egReconciler) syncMarkRecord(marks map[string]string) {
+	for _, mark := range marks {
+		if !r.mark.Has(mark) {
+			_ = r.mark.Allocate(mark)
+		}
+	}
+}
+
+func (r *egReconciler) repairDuplicateMark(node *egressv1.EgressTunnel, log logr.Logger) {
+	log.Info("the mark can not be used by the egress tunnel, reallocate it", "mark", node.Status.Mark)
+	countNumMarkDuplicateRepairs.Inc()
+	r.recorder.Eventf(node, corev1.EventTypeWarning, ReasonDuplicateMark,
+		"Mark %s is owned by another EgressTunnel, a new mark is allocated.", node.Status.Mark)
+	node.Status.Mark = ""
+}
+
+func (r *egReconciler) updateMarkUsage() {
+	gaugeMarkAllocatorTotal.Set(float64(r.mark.Size()))
+	gaugeMarkAllocatorUsed.Set(float64(r.mark.Size() - r.mark.Free()))
+}
+
 func (r *egReconciler) updateEgressTunnel(node egressv1.EgressTunnel) error {
 	if node.Status.Phase == "" {
 		node.Status.Phase = egressv1.EgressTunnelInit
@@ -540,6 +716,12 @@ func (r *egReconciler) initEgressTunnel() error {
 
 	start := time.Now()
 
+	marks, err := r.markRecord.Load(context.Background())
+	if err != nil {
+		return err
+	}
+	r.syncMarkRecord(marks)
+
 	for _, node := range nodes.Items {
 		log := r.log.WithValues("name", node.Name, "kind", "EgressTunnel")
 
@@ -556,6 +738,11 @@ func (r *egReconciler) initEgressTunnel() error {
 		}
 	}
 
+	err = r.pruneMarkRecord(context.Background(), nodes.Items)
+	if err != nil {
+		return fmt.Errorf("failed to prune mark record: %v", err)
+	}
+
 	end := time.Now()
 	delta := end.Sub(start)
 
@@ -655,13 +842,21 @@ func NewEgressTunnelController(mgr manager.Manager, log logr.Logger, cfg *config
 		return fmt.Errorf("markallocator.NewAllocatorCID with error: %v", err)
 	}
 
+	// the record is read without the informer cache to always get the latest one
+	namespace := cfg.PodNamespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	markRecord := markallocator.NewRecord(mgr.GetAPIReader(), mgr.GetClient(), namespace, markRecordName)
+
 	r := &egReconciler{
-		client:   mgr.GetClient(),
-		log:      log,
-		config:   cfg,
-		doOnce:   sync.Once{},
-		mark:     mark,
-		initDone: make(chan struct{}, 1),
+		client:     mgr.GetClient(),
+		log:        log,
+		config:     cfg,
+		doOnce:     sync.Once{},
+		mark:       mark,
+		markRecord: markRecord,
+		initDone:   make(chan struct{}, 1),
 	}
 
 	if cfg.FileConfig.EnableIPv4 {
@@ -16,6 +16,7 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -68,11 +69,13 @@ func TestEgressTunnelCtrlForEgressTunnel(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	cli := builder.Build()
 	reconciler := egReconciler{
-		client:      builder.Build(),
+		client:      cli,
 		log:         logger.NewLogger(cfg.EnvConfig.Logger),
 		config:      cfg,
 		mark:        mark,
+		markRecord:  markallocator.NewRecord(cli, cli, "default", markRecordName),
 		allocatorV4: allocatorV4,
 		allocatorV6: nil,
 		initDone:    make(chan struct{}, 1),
@@ -142,11 +145,13 @@ func TestEgressTunnelCtrlForNode(t *testing.T) {
 	_, cidr, _ = net.ParseCIDR("fd00::/24")
 	allocatorV6, _ := ipallocator.NewCIDRRange(cidr)
 
+	cli := builder.Build()
 	reconciler := egReconciler{
-		client:      builder.Build(),
+		client:      cli,
 		log:         logger.NewLogger(cfg.EnvConfig.Logger),
 		config:      cfg,
 		mark:        mark,
+		markRecord:  markallocator.NewRecord(cli, cli, "default", markRecordName),
 		allocatorV4: allocatorV4,
 		allocatorV6: allocatorV6,
 		initDone:    make(chan struct{}, 1),
@@ -238,11 +243,13 @@ func TestHealthCheck(t *testing.T) {
 	_, cidr, _ = net.ParseCIDR("fd00::/24")
 	allocatorV6, _ := ipallocator.NewCIDRRange(cidr)
 
+	cli := builder.Build()
 	reconciler := &egReconciler{
-		client:      builder.Build(),
+		client:      cli,
 		log:         logger.NewLogger(cfg.EnvConfig.Logger),
 		config:      cfg,
 		mark:        mark,
+		markRecord:  markallocator.NewRecord(cli, cli, "default", markRecordName),
 		allocatorV4: allocatorV4,
 		allocatorV6: allocatorV6,
 		initDone:    make(chan struct{}, 1),
@@ -259,6 +266,71 @@ func TestHealthCheck(t *testing.T) {
 	time.Sleep(time.Second * 6)
 }
 
+func TestEgressTunnelDuplicateMark(t *testing.T) {
+	cfg := &config.Config{
+		EnvConfig:  config.EnvConfig{},
+		FileConfig: config.FileConfig{EnableIPv4: false, EnableIPv6: false},
+	}
+
+	initialObjects := []client.Object{
+		&egressv1.EgressTunnel{
+			ObjectMeta: v1.ObjectMeta{Name: "node1"},
+			Status:     egressv1.EgressTunnelStatus{Mark: "0x26000000"},
+		},
+		&egressv1.EgressTunnel{
+			ObjectMeta: v1.ObjectMeta{Name: "node2"},
+			Status:     egressv1.EgressTunnelStatus{Mark: "0x26000000"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: markRecordName},
+			Data:       map[string]string{"node2": "0x26000000", "node3": "0x26000001"},
+		},
+	}
+
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(initialObjects...)
+	builder.WithStatusSubresource(initialObjects...)
+
+	mark, err := markallocator.NewAllocatorMarkRange("0x26000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := builder.Build()
+	reconciler := &egReconciler{
+		client:     cli,
+		log:        logger.NewLogger(cfg.EnvConfig.Logger),
+		config:     cfg,
+		mark:       mark,
+		markRecord: markallocator.NewRecord(cli, cli, "default", markRecordName),
+		initDone:   make(chan struct{}, 1),
+		recorder:   record.NewFakeRecorder(10),
+	}
+
+	// node2 is the recorded owner of the mark, node3 no longer exists
+	err = reconciler.initEgressTunnel()
+	assert.NoError(t, err)
+
+	marks, err := reconciler.markRecord.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"node2": "0x26000000"}, marks)
+
+	ctx := context.Background()
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "EgressTunnel/", Name: "node1"}})
+	assert.NoError(t, err)
+
+	node1 := &egressv1.EgressTunnel{}
+	err = cli.Get(ctx, types.NamespacedName{Name: "node1"}, node1)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, node1.Status.Mark)
+	assert.NotEqual(t, "0x26000000", node1.Status.Mark)
+
+	marks, err = reconciler.markRecord.Load(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"node1": node1.Status.Mark, "node2": "0x26000000"}, marks)
+}
+
 func TestNewEgressTunnelController(t *testing.T) {
 	labels := map[string]string{"app": "nginx1"}
 	initialObjects := []client.Object{
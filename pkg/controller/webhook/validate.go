@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"reflect"
 
 	v1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -27,6 +28,7 @@ const (
 	EgressGateway       = "EgressGateway"
 	EgressPolicy        = "EgressPolicy"
 	EgressClusterPolicy = "EgressClusterPolicy"
+	EgressNotifier      = "EgressNotifier"
 )
 
 // ValidateHook ValidateHook
@@ -46,6 +48,8 @@ func ValidateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 				return validateEgressClusterPolicy(ctx, client, req, cfg)
 			case EgressPolicy:
 				return validateEgressPolicy(ctx, client, req, cfg)
+			case EgressNotifier:
+				return validateEgressNotifier(req, cfg)
 			}
 
 			return webhook.Allowed("checked")
@@ -72,6 +76,23 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		if len(egp.Spec.EgressIP.IPv4) != 0 || len(egp.Spec.EgressIP.IPv6) != 0 {
 			return webhook.Denied("useNodeIP cannot be used with egressIP.ipv4 or egressIP.ipv6 at the same time")
 		}
+		if egp.Spec.EgressIP.SourcePorts != 0 {
+			return webhook.Denied("useNodeIP cannot be used with egressIP.sourcePorts at the same time")
+		}
+	}
+
+	if err := checkMultipleEIPs(egp.Spec.EgressIP); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
+	if egp.Spec.Bandwidth != nil {
+		if _, _, err := egp.Spec.Bandwidth.Parse(); err != nil {
+			return webhook.Denied(fmt.Sprintf("invalid bandwidth: %v", err))
+		}
+	}
+
+	if egp.Spec.DSCP != nil && (*egp.Spec.DSCP < 0 || *egp.Spec.DSCP > 63) {
+		return webhook.Denied("dscp should be in 0-63")
 	}
 
 	if len(egp.Spec.EgressIP.IPv4) != 0 && !isIPv4(egp.Spec.EgressIP.IPv4) {
@@ -92,12 +113,14 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		}
 	}
 
+	usageRises := false
 	if req.Operation == v1.Update {
 		oldEgp := new(egressv1.EgressPolicy)
 		err := json.Unmarshal(req.OldObject.Raw, oldEgp)
 		if err != nil {
 			return webhook.Denied(fmt.Sprintf("json unmarshal EgressPolicy with error: %v", err))
 		}
+		usageRises = quotaUsageRises(oldEgp, egp)
 
 		if egp.Spec.EgressGatewayName != oldEgp.Spec.EgressGatewayName {
 			return webhook.Denied("'spec.EgressGatewayName' field is immutable")
@@ -118,6 +141,18 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		if egp.Spec.EgressIP.AllocatorPolicy != oldEgp.Spec.EgressIP.AllocatorPolicy {
 			return webhook.Denied("the EgressIP.AllocatorPolicy field cannot be modified")
 		}
+
+		if egp.Spec.EgressIP.SourcePorts != oldEgp.Spec.EgressIP.SourcePorts {
+			return webhook.Denied("the EgressIP.SourcePorts field cannot be modified")
+		}
+
+		if egp.Spec.EgressIP.Count != oldEgp.Spec.EgressIP.Count {
+			return webhook.Denied("the EgressIP.Count field cannot be modified")
+		}
+
+		if !reflect.DeepEqual(egp.Spec.EgressIP.Additional, oldEgp.Spec.EgressIP.Additional) {
+			return webhook.Denied("the EgressIP.Additional field cannot be modified")
+		}
 	}
 
 	if req.Operation == v1.Create {
@@ -141,6 +176,27 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 			}
 			return webhook.Denied("the Spec.EgressIP.IPv4 or Spec.EgressIP.IPv6 is not within the ip ranges defined in the ippools of the egressgateway")
 		}
+		for _, eip := range egp.Spec.EgressIP.Additional {
+			if ok, err := checkEIPIncluded(client, ctx, eip.Ipv4, eip.Ipv6, egp.Spec.EgressGatewayName); !ok {
+				if err != nil {
+					return webhook.Denied(err.Error())
+				}
+				return webhook.Denied("the Spec.EgressIP.Additional is not within the ip ranges defined in the ippools of the egressgateway")
+			}
+		}
+		if err := checkEIPUplinks(ctx, client, egp.Spec.EgressGatewayName, egp.Spec.EgressIP); err != nil {
+			return webhook.Denied(err.Error())
+		}
+
+	}
+
+	// the quota is only checked again on the updates which take more of it, a
+	// policy admitted before the quota was lowered can still be edited, and a
+	// policy being deleted is never denied
+	if req.Operation == v1.Create || (egp.DeletionTimestamp.IsZero() && usageRises) {
+		if err := checkNamespaceQuota(ctx, client, egp); err != nil {
+			return webhook.Denied(err.Error())
+		}
 	}
 
 	return validateSubnet(egp.Spec.DestSubnet)
@@ -164,6 +220,23 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 		if len(policy.Spec.EgressIP.IPv4) != 0 || len(policy.Spec.EgressIP.IPv6) != 0 {
 			return webhook.Denied("useNodeIP cannot be used with egressIP.ipv4 or egressIP.ipv6 at the same time")
 		}
+		if policy.Spec.EgressIP.SourcePorts != 0 {
+			return webhook.Denied("useNodeIP cannot be used with egressIP.sourcePorts at the same time")
+		}
+	}
+
+	if err := checkMultipleEIPs(policy.Spec.EgressIP); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
+	if policy.Spec.Bandwidth != nil {
+		if _, _, err := policy.Spec.Bandwidth.Parse(); err != nil {
+			return webhook.Denied(fmt.Sprintf("invalid bandwidth: %v", err))
+		}
+	}
+
+	if policy.Spec.DSCP != nil && (*policy.Spec.DSCP < 0 || *policy.Spec.DSCP > 63) {
+		return webhook.Denied("dscp should be in 0-63")
 	}
 
 	if len(policy.Spec.EgressIP.IPv4) != 0 && !isIPv4(policy.Spec.EgressIP.IPv4) {
@@ -211,6 +284,18 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 		if policy.Spec.EgressIP.AllocatorPolicy != oldPolicy.Spec.EgressIP.AllocatorPolicy {
 			return webhook.Denied("the EgressIP.AllocatorPolicy field cannot be modified")
 		}
+
+		if policy.Spec.EgressIP.SourcePorts != oldPolicy.Spec.EgressIP.SourcePorts {
+			return webhook.Denied("the EgressIP.SourcePorts field cannot be modified")
+		}
+
+		if policy.Spec.EgressIP.Count != oldPolicy.Spec.EgressIP.Count {
+			return webhook.Denied("the EgressIP.Count field cannot be modified")
+		}
+
+		if !reflect.DeepEqual(policy.Spec.EgressIP.Additional, oldPolicy.Spec.EgressIP.Additional) {
+			return webhook.Denied("the EgressIP.Additional field cannot be modified")
+		}
 	}
 
 	if req.Operation == v1.Create {
@@ -235,11 +320,83 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 			}
 			return webhook.Denied("the Spec.EgressIP.IPv4 or Spec.EgressIP.IPv6 is not within the ip ranges defined in the ippools of the egressgateway")
 		}
+		for _, eip := range policy.Spec.EgressIP.Additional {
+			if ok, err := checkEIPIncluded(client, ctx, eip.Ipv4, eip.Ipv6, policy.Spec.EgressGatewayName); !ok {
+				if err != nil {
+					return webhook.Denied(err.Error())
+				}
+				return webhook.Denied("the Spec.EgressIP.Additional is not within the ip ranges defined in the ippools of the egressgateway")
+			}
+		}
+		if err := checkEIPUplinks(ctx, client, policy.Spec.EgressGatewayName, policy.Spec.EgressIP); err != nil {
+			return webhook.Denied(err.Error())
+		}
 	}
 
 	return validateSubnet(policy.Spec.DestSubnet)
 }
 
+// validateEgressNotifier refuses the HMAC Secrets outside the namespace of the
+// controller, the EgressNotifiers are cluster scoped and the controller only
+// reads the Secrets of its own namespace.
+func validateEgressNotifier(req webhook.AdmissionRequest, cfg *config.Config) webhook.AdmissionResponse {
+	if req.Operation == v1.Delete {
+		return webhook.Allowed("checked")
+	}
+	notifier := new(egressv1.EgressNotifier)
+	err := json.Unmarshal(req.Object.Raw, notifier)
+	if err != nil {
+		return webhook.Denied(fmt.Sprintf("json unmarshal EgressNotifier with error: %v", err))
+	}
+
+	for _, item := range notifier.Spec.Webhooks {
+		ref := item.HMACSecretRef
+		if ref != nil && ref.Namespace != "" && ref.Namespace != cfg.PodNamespace {
+			return webhook.Denied(fmt.Sprintf("the hmacSecretRef of %s should be in the namespace %s", item.URL, cfg.PodNamespace))
+		}
+	}
+	return webhook.Allowed("checked")
+}
+
+// checkMultipleEIPs checks the EgressIP of a policy with more than one EIP, the
+// extra EIPs are either allocated by count or listed in additional
+func checkMultipleEIPs(eip egressv1.EgressIP) error {
+	if eip.Count == 0 && len(eip.Additional) == 0 {
+		return nil
+	}
+	if eip.UseNodeIP {
+		return fmt.Errorf("useNodeIP cannot be used with egressIP.count or egressIP.additional at the same time")
+	}
+	if eip.Count != 0 && len(eip.Additional) != 0 {
+		return fmt.Errorf("egressIP.count cannot be used with egressIP.additional at the same time")
+	}
+	if eip.Count != 0 && (len(eip.IPv4) != 0 || len(eip.IPv6) != 0) {
+		return fmt.Errorf("egressIP.count cannot be used with egressIP.ipv4 or egressIP.ipv6 at the same time")
+	}
+	if len(eip.Additional) != 0 && len(eip.IPv4) == 0 && len(eip.IPv6) == 0 {
+		return fmt.Errorf("egressIP.additional requires egressIP.ipv4 or egressIP.ipv6")
+	}
+
+	seen := map[string]bool{eip.IPv4: true, eip.IPv6: true}
+	for _, item := range eip.Additional {
+		if len(item.Ipv4) == 0 && len(item.Ipv6) == 0 {
+			return fmt.Errorf("the items of egressIP.additional require ipv4 or ipv6")
+		}
+		if len(item.Ipv4) != 0 && !isIPv4(item.Ipv4) {
+			return fmt.Errorf("invalid ipv4 format in egressIP.additional")
+		}
+		if len(item.Ipv6) != 0 && !isIPv6(item.Ipv6) {
+			return fmt.Errorf("invalid ipv6 format in egressIP.additional")
+		}
+		if (len(item.Ipv4) != 0 && seen[item.Ipv4]) || (len(item.Ipv6) != 0 && seen[item.Ipv6]) {
+			return fmt.Errorf("egressIP.additional has a duplicate EIP %s%s", item.Ipv4, item.Ipv6)
+		}
+		seen[item.Ipv4] = true
+		seen[item.Ipv6] = true
+	}
+	return nil
+}
+
 // checkEGWIppools when creating the policy with the value of the field .Spec.EgressIP.UseNodeIP set to be false, the ippools of the gateway should not be empty
 func checkEGWIppools(client client.Client, cfg *config.Config, ctx context.Context, name, allocatorPolicy string) error {
 
@@ -257,6 +414,121 @@ func checkEGWIppools(client client.Client, cfg *config.Config, ctx context.Conte
 	return nil
 }
 
+// quotaUsageRises reports whether the update of the policy takes more of the
+// namespace quota: another gateway, an EIP it did not have, another allocator
+// which may allocate a new one, or more EIPs.
+func quotaUsageRises(oldEgp, egp *egressv1.EgressPolicy) bool {
+	if egp.Spec.EgressGatewayName != oldEgp.Spec.EgressGatewayName {
+		return true
+	}
+	cur, old := egp.Spec.EgressIP, oldEgp.Spec.EgressIP
+	if cur.UseNodeIP {
+		return false
+	}
+	if old.UseNodeIP || cur.AllocatorPolicy != old.AllocatorPolicy || cur.EIPCount() > old.EIPCount() {
+		return true
+	}
+	known := make(map[string]struct{})
+	for _, eip := range append([]egressv1.Eip{{Ipv4: old.IPv4, Ipv6: old.IPv6}}, old.Additional...) {
+		known[eip.Ipv4] = struct{}{}
+		known[eip.Ipv6] = struct{}{}
+	}
+	for _, eip := range append([]egressv1.Eip{{Ipv4: cur.IPv4, Ipv6: cur.IPv6}}, cur.Additional...) {
+		for _, ip := range []string{eip.Ipv4, eip.Ipv6} {
+			if _, ok := known[ip]; ip != "" && !ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkNamespaceQuota checks that the policy does not exceed the namespace quota
+// of the referenced gateway. The usage of the other policies of the namespace
+// is read from the gateway status, which lags behind the policies admitted
+// just before and not assigned yet, so that concurrent creations may exceed
+// maxEIPs until they are assigned.
+func checkNamespaceQuota(ctx context.Context, cli client.Client, egp *egressv1.EgressPolicy) error {
+	egw := new(egressv1.EgressGateway)
+	err := cli.Get(ctx, types.NamespacedName{Name: egp.Spec.EgressGatewayName}, egw)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get the EgressGateway: %v", err)
+	}
+	quota := egw.Spec.NamespaceQuota
+	if quota == nil {
+		return nil
+	}
+
+	if quota.MaxPolicies > 0 {
+		policies := new(egressv1.EgressPolicyList)
+		err := cli.List(ctx, policies, client.InNamespace(egp.Namespace))
+		if err != nil {
+			return fmt.Errorf("failed to list EgressPolicy: %v", err)
+		}
+		count := 0
+		for _, item := range policies.Items {
+			if item.Spec.EgressGatewayName == egw.Name && item.Name != egp.Name {
+				count++
+			}
+		}
+		if count >= quota.MaxPolicies {
+			return fmt.Errorf("namespace %s has reached the maximum number of policies (%d) of EgressGateway %s",
+				egp.Namespace, quota.MaxPolicies, egw.Name)
+		}
+	}
+
+	if quota.MaxEIPs > 0 && !egp.Spec.EgressIP.UseNodeIP {
+		// the EIPs of the other policies of the namespace, the EIPs of the
+		// policy itself are charged below when it is updated
+		used := make(map[string]struct{})
+		eips := 0
+		for _, node := range egw.Status.NodeList {
+			for _, eip := range node.Eips {
+				if eip.IPv4 == "" && eip.IPv6 == "" {
+					continue
+				}
+				for _, p := range eip.Policies {
+					if p.Namespace == egp.Namespace && p.Name != egp.Name {
+						used[eip.IPv4] = struct{}{}
+						used[eip.IPv6] = struct{}{}
+						eips++
+						break
+					}
+				}
+			}
+		}
+		delete(used, "")
+
+		ipv4, ipv6 := egp.Spec.EgressIP.IPv4, egp.Spec.EgressIP.IPv6
+		if ipv4 == "" && ipv6 == "" && egp.Spec.EgressIP.AllocatorPolicy != egressv1.EipAllocatorRR {
+			ipv4, ipv6 = egw.Spec.Ippools.Ipv4DefaultEIP, egw.Spec.Ippools.Ipv6DefaultEIP
+		}
+		isUsed := func(ipv4, ipv6 string) bool {
+			_, reuseIPv4 := used[ipv4]
+			_, reuseIPv6 := used[ipv6]
+			return (ipv4 != "" && reuseIPv4) || (ipv6 != "" && reuseIPv6)
+		}
+		// the policy is charged with all its EIPs, the known ones which the
+		// namespace already uses are free, the others are allocated
+		known := append([]egressv1.Eip{{Ipv4: ipv4, Ipv6: ipv6}}, egp.Spec.EgressIP.Additional...)
+		needed := egp.Spec.EgressIP.EIPCount() - len(known)
+		for _, eip := range known {
+			if !isUsed(eip.Ipv4, eip.Ipv6) {
+				needed++
+			}
+		}
+		if needed > 0 && eips+needed > quota.MaxEIPs {
+			return fmt.Errorf("namespace %s has reached the maximum number of EIPs (%d) of EgressGateway %s",
+				egp.Namespace, quota.MaxEIPs, egw.Name)
+		}
+	}
+
+	return nil
+}
+
 func checkEIP(client client.Client, ctx context.Context, ipv4, ipv6, egwName string, cfg *config.Config) (bool, error) {
 
 	eipIPV4 := ipv4
@@ -304,6 +576,25 @@ func checkEIP(client client.Client, ctx context.Context, ipv4, ipv6, egwName str
 	return true, nil
 }
 
+// checkEIPUplinks denies the EIPs of a policy in different uplinks of the
+// EgressGateway, the gateway node routes all the EIPs of a policy through the
+// uplink of the first one.
+func checkEIPUplinks(ctx context.Context, cli client.Client, egwName string, eip egressv1.EgressIP) error {
+	if len(eip.Additional) == 0 {
+		return nil
+	}
+	egw := new(egressv1.EgressGateway)
+	err := cli.Get(ctx, types.NamespacedName{Name: egwName}, egw)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get the EgressGateway: %v", err)
+	}
+	eips := append([]egressv1.Eip{{Ipv4: eip.IPv4, Ipv6: eip.IPv6}}, eip.Additional...)
+	return egressgateway.CheckSameUplink(egw.Spec.Uplinks, eips)
+}
+
 // checkEIPIncluded check if the `Spec.EgressIP.IPv4` or `Spec.EgressIP.IPv6` are within the ip ranges defined in the ippools of the egressgateway
 func checkEIPIncluded(client client.Client, ctx context.Context, ipv4, ipv6, egwName string) (bool, error) {
 	eipIPV4 := ipv4
@@ -12,6 +12,8 @@ import (
 	admissionv1 "k8s.io/api/admission/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -44,6 +46,76 @@ func TestValidateEgressGateway(t *testing.T) {
 			},
 			expAllow: false,
 		},
+		"EgressGateway uplink with next-hop": {
+			newResource: &v1beta1.EgressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "eg-test",
+				},
+				Spec: v1beta1.EgressGatewaySpec{
+					Ippools: v1beta1.Ippools{
+						IPv4: []string{"10.6.1.2-10.6.1.5"},
+					},
+					NodeSelector: v1beta1.NodeSelector{
+						Selector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"egress": "true"},
+						},
+					},
+					Uplinks: []v1beta1.Uplink{{
+						IPPools:     []string{"10.6.1.2-10.6.1.3"},
+						Interface:   "eth1",
+						NextHopIPv4: "10.6.0.1",
+					}},
+				},
+			},
+			expAllow: true,
+		},
+		"EgressGateway uplink without next-hop": {
+			newResource: &v1beta1.EgressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "eg-test",
+				},
+				Spec: v1beta1.EgressGatewaySpec{
+					Ippools: v1beta1.Ippools{
+						IPv4: []string{"10.6.1.2-10.6.1.5"},
+					},
+					NodeSelector: v1beta1.NodeSelector{
+						Selector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"egress": "true"},
+						},
+					},
+					Uplinks: []v1beta1.Uplink{{
+						IPPools:   []string{"10.6.1.2-10.6.1.3"},
+						Interface: "eth1",
+					}},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "spec.uplinks[0] needs nextHopIPv4 or nextHopIPv6",
+		},
+		"EgressGateway uplink with invalid next-hop": {
+			newResource: &v1beta1.EgressGateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "eg-test",
+				},
+				Spec: v1beta1.EgressGatewaySpec{
+					Ippools: v1beta1.Ippools{
+						IPv4: []string{"10.6.1.2-10.6.1.5"},
+					},
+					NodeSelector: v1beta1.NodeSelector{
+						Selector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"egress": "true"},
+						},
+					},
+					Uplinks: []v1beta1.Uplink{{
+						IPPools:     []string{"10.6.1.2-10.6.1.3"},
+						Interface:   "eth1",
+						NextHopIPv4: "fd00::1",
+					}},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "invalid IPv4 fd00::1 in spec.uplinks[0].nextHopIPv4",
+		},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -124,6 +196,196 @@ func TestValidateEgressPolicy(t *testing.T) {
 			},
 			expAllow: true,
 		},
+		"useNodeIP with sourcePorts": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP:   true,
+					SourcePorts: 1000,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "useNodeIP cannot be used with egressIP.sourcePorts at the same time",
+		},
+		"bandwidth without rate": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Bandwidth: &v1beta1.Bandwidth{Rate: "0", Burst: "64Ki"},
+			},
+			expAllow:      false,
+			expErrMessage: "invalid bandwidth: rate 0 should be greater than 0",
+		},
+		"dscp out of range": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DSCP: ptr.To(64),
+			},
+			expAllow:      false,
+			expErrMessage: "dscp should be in 0-63",
+		},
+		"bandwidth with too large burst": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Bandwidth: &v1beta1.Bandwidth{Rate: "100M", Burst: "8Gi"},
+			},
+			expAllow:      false,
+			expErrMessage: "invalid bandwidth: burst 8Gi should be in 0-4294967295 bytes",
+		},
+		"count with ipv4": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:  "172.18.1.2",
+					Count: 2,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "egressIP.count cannot be used with egressIP.ipv4 or egressIP.ipv6 at the same time",
+		},
+		"additional without ipv4 or ipv6": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					Additional: []v1beta1.Eip{{Ipv4: "172.18.1.3"}},
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "egressIP.additional requires egressIP.ipv4 or egressIP.ipv6",
+		},
+		"duplicate additional": {
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:       "172.18.1.2",
+					Additional: []v1beta1.Eip{{Ipv4: "172.18.1.2"}},
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "egressIP.additional has a duplicate EIP 172.18.1.2",
+		},
+		"additional out of the ippools": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"172.18.1.2-172.18.1.5"},
+						},
+					},
+				},
+			}, spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:       "172.18.1.2",
+					Additional: []v1beta1.Eip{{Ipv4: "172.18.1.9"}},
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the Spec.EgressIP.Additional is not within the ip ranges defined in the ippools of the egressgateway",
+		},
+		"valid additional": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"172.18.1.2-172.18.1.5"},
+						},
+					},
+				},
+			}, spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:       "172.18.1.2",
+					Additional: []v1beta1.Eip{{Ipv4: "172.18.1.3"}},
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      true,
+			expErrMessage: "",
+		},
+		"additional in another uplink": {
+			existingResources: []client.Object{
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{
+							IPv4: []string{"172.18.1.2-172.18.1.5"},
+						},
+						Uplinks: []v1beta1.Uplink{
+							{Interface: "eth1", IPPools: []string{"172.18.1.2-172.18.1.3"}},
+							{Interface: "eth2", IPPools: []string{"172.18.1.4-172.18.1.5"}},
+						},
+					},
+				},
+			}, spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:       "172.18.1.2",
+					Additional: []v1beta1.Eip{{Ipv4: "172.18.1.3"}, {Ipv4: "172.18.1.4"}},
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EIP 172.18.1.4 is not in the same uplink as the EIP 172.18.1.2",
+		},
 		"case1, not valid": {
 			existingResources: nil,
 			spec: v1beta1.EgressPolicySpec{
@@ -366,7 +628,205 @@ func TestValidateEgressPolicy(t *testing.T) {
 			validator := ValidateHook(cli, conf)
 			resp := validator.Handle(ctx, admission.Request{
 				AdmissionRequest: admissionv1.AdmissionRequest{
-					Name: policy.Name,
+					Name: policy.Name,
+					Kind: metav1.GroupVersionKind{
+						Kind: "EgressPolicy",
+					},
+					Operation: admissionv1.Create,
+					Object: runtime.RawExtension{
+						Raw: marshalledRequestObject,
+					},
+				},
+			})
+
+			assert.Equal(t, c.expAllow, resp.Allowed)
+			if c.expErrMessage != "" {
+				assert.Equal(t, c.expErrMessage, resp.AdmissionResponse.Result.Message)
+			}
+		})
+	}
+}
+
+// quotaGateway returns the gateway test with the quota and the EIPs on node1
+func quotaGateway(quota *v1beta1.NamespaceQuota, eips ...v1beta1.Eips) *v1beta1.EgressGateway {
+	return &v1beta1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: v1beta1.EgressGatewaySpec{
+			Ippools: v1beta1.Ippools{
+				IPv4:           []string{"10.6.1.21-10.6.1.25"},
+				Ipv4DefaultEIP: "10.6.1.21",
+			},
+			NamespaceQuota: quota,
+		},
+		Status: v1beta1.EgressGatewayStatus{
+			NodeList: []v1beta1.EgressIPStatus{{Name: "node1", Eips: eips}},
+		},
+	}
+}
+
+// quotaPolicy returns the policy of namespace default using the gateway test
+func quotaPolicy(name string, egressIP v1beta1.EgressIP) *v1beta1.EgressPolicy {
+	return &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1beta1.EgressPolicySpec{
+			EgressGatewayName: "test",
+			EgressIP:          egressIP,
+			AppliedTo: v1beta1.AppliedTo{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			},
+		},
+	}
+}
+
+func TestValidateEgressPolicyNamespaceQuota(t *testing.T) {
+	ctx := context.Background()
+
+	gateway, policy := quotaGateway, quotaPolicy
+	rr := v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR}
+
+	cases := map[string]struct {
+		existingResources []client.Object
+		policy            *v1beta1.EgressPolicy
+		expAllow          bool
+	}{
+		"no quota": {
+			existingResources: []client.Object{
+				gateway(nil, v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", rr),
+			expAllow: true,
+		},
+		"policies under quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxPolicies: 2}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", rr),
+			expAllow: true,
+		},
+		"policies exceed quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxPolicies: 1}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", rr),
+			expAllow: false,
+		},
+		"rr allocator exceeds eip quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 1},
+					v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", rr),
+			expAllow: false,
+		},
+		"reuse an eip of the namespace": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 1},
+					v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", v1beta1.EgressIP{IPv4: "10.6.1.22"}),
+			expAllow: true,
+		},
+		"eips of other namespaces are not counted": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 1},
+					v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "other"}}}),
+			},
+			policy:   policy("p2", rr),
+			expAllow: true,
+		},
+		"default eip exceeds eip quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 1},
+					v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorDefault}),
+			expAllow: false,
+		},
+		"count exceeds eip quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 2}),
+			},
+			policy:   policy("p1", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR, Count: 3}),
+			expAllow: false,
+		},
+		"count under eip quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 3}),
+			},
+			policy:   policy("p1", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR, Count: 3}),
+			expAllow: true,
+		},
+		"count charges the eips beyond a reused default eip": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 3},
+					v1beta1.Eips{IPv4: "10.6.1.21", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorDefault}),
+			},
+			policy:   policy("p2", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorDefault, Count: 3}),
+			expAllow: true,
+		},
+		"count exceeds eip quota with a reused default eip": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 2},
+					v1beta1.Eips{IPv4: "10.6.1.21", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorDefault}),
+			},
+			policy:   policy("p2", v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorDefault, Count: 3}),
+			expAllow: false,
+		},
+		"additional exceeds eip quota": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 2}),
+			},
+			policy:   policy("p1", v1beta1.EgressIP{IPv4: "10.6.1.22", Additional: []v1beta1.Eip{{Ipv4: "10.6.1.23"}, {Ipv4: "10.6.1.24"}}}),
+			expAllow: false,
+		},
+		"additional reuses an eip of the namespace": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 2},
+					v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", v1beta1.EgressIP{IPv4: "10.6.1.23", Additional: []v1beta1.Eip{{Ipv4: "10.6.1.22"}}}),
+			expAllow: true,
+		},
+		"use node ip is not counted as eip": {
+			existingResources: []client.Object{
+				gateway(&v1beta1.NamespaceQuota{MaxEIPs: 1},
+					v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}),
+				policy("p1", rr),
+			},
+			policy:   policy("p2", v1beta1.EgressIP{UseNodeIP: true}),
+			expAllow: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			marshalledRequestObject, err := json.Marshal(c.policy)
+			assert.NoError(t, err)
+
+			builder := fake.NewClientBuilder()
+			builder.WithScheme(schema.GetScheme())
+			builder.WithObjects(c.existingResources...)
+			cli := builder.Build()
+			conf := &config.Config{
+				FileConfig: config.FileConfig{
+					EnableIPv4: true,
+					EnableIPv6: false,
+				},
+			}
+
+			validator := ValidateHook(cli, conf)
+			resp := validator.Handle(ctx, admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Name:      c.policy.Name,
+					Namespace: c.policy.Namespace,
 					Kind: metav1.GroupVersionKind{
 						Kind: "EgressPolicy",
 					},
@@ -377,14 +837,160 @@ func TestValidateEgressPolicy(t *testing.T) {
 				},
 			})
 
-			assert.Equal(t, c.expAllow, resp.Allowed)
-			if c.expErrMessage != "" {
-				assert.Equal(t, c.expErrMessage, resp.AdmissionResponse.Result.Message)
+			assert.Equal(t, c.expAllow, resp.Allowed, resp.AdmissionResponse.Result)
+		})
+	}
+}
+
+func TestValidateEgressPolicyNamespaceQuotaUpdate(t *testing.T) {
+	ctx := context.Background()
+	rr := v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR}
+	p1 := v1beta1.Eips{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}
+	p2 := v1beta1.Eips{IPv4: "10.6.1.23", Policies: []v1beta1.Policy{{Name: "p2", Namespace: "default"}}}
+	withSubnet := func(policy *v1beta1.EgressPolicy) *v1beta1.EgressPolicy {
+		policy = policy.DeepCopy()
+		policy.Spec.DestSubnet = []string{"10.7.0.0/16"}
+		return policy
+	}
+	withLabel := func(policy *v1beta1.EgressPolicy) *v1beta1.EgressPolicy {
+		policy = policy.DeepCopy()
+		policy.Labels = map[string]string{"team": "a"}
+		return policy
+	}
+	deleting := func(policy *v1beta1.EgressPolicy) *v1beta1.EgressPolicy {
+		policy = withSubnet(policy)
+		now := metav1.Now()
+		policy.DeletionTimestamp = &now
+		policy.Finalizers = []string{"egressgateway.spidernet.io/egresspolicy"}
+		return policy
+	}
+
+	cases := map[string]struct {
+		existingResources []client.Object
+		old               *v1beta1.EgressPolicy
+		new               *v1beta1.EgressPolicy
+		expAllow          bool
+	}{
+		"the eips of the policy are not charged twice": {
+			existingResources: []client.Object{
+				quotaGateway(&v1beta1.NamespaceQuota{MaxEIPs: 1}, p1),
+				quotaPolicy("p1", rr),
+			},
+			old:      quotaPolicy("p1", rr),
+			new:      withSubnet(quotaPolicy("p1", rr)),
+			expAllow: true,
+		},
+		"spec update of a policy over the eip quota": {
+			existingResources: []client.Object{
+				quotaGateway(&v1beta1.NamespaceQuota{MaxEIPs: 1}, p1, p2),
+				quotaPolicy("p1", rr),
+				quotaPolicy("p2", rr),
+			},
+			old:      quotaPolicy("p2", rr),
+			new:      withSubnet(quotaPolicy("p2", rr)),
+			expAllow: true,
+		},
+		"spec update of a policy over the policy quota": {
+			existingResources: []client.Object{
+				quotaGateway(&v1beta1.NamespaceQuota{MaxPolicies: 1}),
+				quotaPolicy("p1", rr),
+				quotaPolicy("p2", rr),
+			},
+			old:      quotaPolicy("p2", rr),
+			new:      withSubnet(quotaPolicy("p2", rr)),
+			expAllow: true,
+		},
+		"metadata update over the quota": {
+			existingResources: []client.Object{
+				quotaGateway(&v1beta1.NamespaceQuota{MaxEIPs: 1}, p1, p2),
+				quotaPolicy("p1", rr),
+				quotaPolicy("p2", rr),
+			},
+			old:      quotaPolicy("p2", rr),
+			new:      withLabel(quotaPolicy("p2", rr)),
+			expAllow: true,
+		},
+		"policy being deleted over the quota": {
+			existingResources: []client.Object{
+				quotaGateway(&v1beta1.NamespaceQuota{MaxEIPs: 1}, p1, p2),
+				quotaPolicy("p1", rr),
+				quotaPolicy("p2", rr),
+			},
+			old:      quotaPolicy("p2", rr),
+			new:      deleting(quotaPolicy("p2", rr)),
+			expAllow: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			oldObject, err := json.Marshal(c.old)
+			assert.NoError(t, err)
+			newObject, err := json.Marshal(c.new)
+			assert.NoError(t, err)
+
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(c.existingResources...).Build()
+			conf := &config.Config{FileConfig: config.FileConfig{EnableIPv4: true}}
+			resp := ValidateHook(cli, conf).Handle(ctx, admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Name:      c.new.Name,
+					Namespace: c.new.Namespace,
+					Kind:      metav1.GroupVersionKind{Kind: "EgressPolicy"},
+					Operation: admissionv1.Update,
+					Object:    runtime.RawExtension{Raw: newObject},
+					OldObject: runtime.RawExtension{Raw: oldObject},
+				},
+			})
+			assert.Equal(t, c.expAllow, resp.Allowed, resp.AdmissionResponse.Result)
+		})
+	}
+}
+
+func TestQuotaUsageRises(t *testing.T) {
+	rr := v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR}
+	cases := map[string]struct {
+		old, new v1beta1.EgressIP
+		gateway  string
+		exp      bool
+	}{
+		"same eips":          {old: rr, new: rr},
+		"fewer eips":         {old: v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR, Count: 3}, new: rr},
+		"to node ip":         {old: rr, new: v1beta1.EgressIP{UseNodeIP: true}},
+		"another gateway":    {old: rr, new: rr, gateway: "other", exp: true},
+		"more eips":          {old: rr, new: v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR, Count: 2}, exp: true},
+		"from node ip":       {old: v1beta1.EgressIP{UseNodeIP: true}, new: rr, exp: true},
+		"another allocator":  {old: v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorDefault}, new: rr, exp: true},
+		"another eip":        {old: v1beta1.EgressIP{IPv4: "10.6.1.22"}, new: v1beta1.EgressIP{IPv4: "10.6.1.23"}, exp: true},
+		"additional swapped": {old: v1beta1.EgressIP{IPv4: "10.6.1.22", Additional: []v1beta1.Eip{{Ipv4: "10.6.1.23"}}}, new: v1beta1.EgressIP{IPv4: "10.6.1.23", Additional: []v1beta1.Eip{{Ipv4: "10.6.1.22"}}}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			oldEgp, egp := quotaPolicy("p1", c.old), quotaPolicy("p1", c.new)
+			if c.gateway != "" {
+				egp.Spec.EgressGatewayName = c.gateway
 			}
+			assert.Equal(t, c.exp, quotaUsageRises(oldEgp, egp))
 		})
 	}
 }
 
+// TestValidateEgressPolicyNamespaceQuotaLag shows the race documented on
+// checkNamespaceQuota: the EIP of a policy admitted but not assigned yet is not
+// in the gateway status, so that it is not counted for the next policy.
+func TestValidateEgressPolicyNamespaceQuotaLag(t *testing.T) {
+	ctx := context.Background()
+	rr := v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR}
+	egw := quotaGateway(&v1beta1.NamespaceQuota{MaxEIPs: 1})
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(egw, quotaPolicy("p1", rr)).Build()
+
+	assert.NoError(t, checkNamespaceQuota(ctx, cli, quotaPolicy("p2", rr)))
+
+	// p2 is denied once the EIP of p1 is in the status
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: egw.Name}, egw))
+	egw.Status.NodeList[0].Eips = []v1beta1.Eips{{IPv4: "10.6.1.22", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "default"}}}}
+	assert.NoError(t, cli.Update(ctx, egw))
+	assert.Error(t, checkNamespaceQuota(ctx, cli, quotaPolicy("p2", rr)))
+}
+
 func TestUpdateEgressPolicy(t *testing.T) {
 	ctx := context.Background()
 
@@ -483,6 +1089,62 @@ func TestUpdateEgressPolicy(t *testing.T) {
 			expAllow:      false,
 			expErrMessage: "",
 		},
+		"change sourcePorts": {
+			old: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:        "10.6.1.21",
+					SourcePorts: 1000,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			new: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:        "10.6.1.21",
+					SourcePorts: 2000,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EgressIP.SourcePorts field cannot be modified",
+		},
+		"change count": {
+			old: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					AllocatorPolicy: v1beta1.EipAllocatorRR,
+					Count:           2,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			new: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					AllocatorPolicy: v1beta1.EipAllocatorRR,
+					Count:           3,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EgressIP.Count field cannot be modified",
+		},
 		"change ipv6": {
 			existingResources: nil,
 			old: v1beta1.EgressPolicySpec{
@@ -624,6 +1286,68 @@ func TestValidateEgressTunnel(t *testing.T) {
 	}
 }
 
+func TestValidateEgressNotifier(t *testing.T) {
+	ctx := context.Background()
+
+	cases := map[string]struct {
+		ref           *v1beta1.SecretKeyRef
+		expAllow      bool
+		expErrMessage string
+	}{
+		"no secret": {
+			expAllow: true,
+		},
+		"secret in the release namespace": {
+			ref:      &v1beta1.SecretKeyRef{Namespace: "egressgateway", Name: "firewall-hmac"},
+			expAllow: true,
+		},
+		"secret without namespace": {
+			ref:      &v1beta1.SecretKeyRef{Name: "firewall-hmac"},
+			expAllow: true,
+		},
+		"secret in another namespace": {
+			ref:           &v1beta1.SecretKeyRef{Namespace: "kube-system", Name: "firewall-hmac"},
+			expAllow:      false,
+			expErrMessage: "the hmacSecretRef of https://firewall.example.com should be in the namespace egressgateway",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			notifier := &v1beta1.EgressNotifier{
+				ObjectMeta: metav1.ObjectMeta{Name: "firewall"},
+				Spec: v1beta1.EgressNotifierSpec{
+					Webhooks: []v1beta1.NotifierWebhook{{URL: "https://firewall.example.com", HMACSecretRef: c.ref}},
+				},
+			}
+			marshalledRequestObject, err := json.Marshal(notifier)
+			assert.NoError(t, err)
+
+			req := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Name: notifier.Name,
+					Kind: metav1.GroupVersionKind{
+						Kind: "EgressNotifier",
+					},
+					Operation: admissionv1.Create,
+					Object: runtime.RawExtension{
+						Raw: marshalledRequestObject,
+					},
+				},
+			}
+
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).Build()
+			conf := &config.Config{EnvConfig: config.EnvConfig{PodNamespace: "egressgateway"}}
+			validator := ValidateHook(cli, conf)
+			resp := validator.Handle(ctx, req)
+
+			assert.Equal(t, c.expAllow, resp.Allowed)
+			if c.expErrMessage != "" {
+				assert.Equal(t, c.expErrMessage, resp.AdmissionResponse.Result.Message)
+			}
+		})
+	}
+}
+
 func TestValidateEgressClusterPolicy(t *testing.T) {
 	ctx := context.Background()
 
@@ -666,6 +1390,86 @@ func TestValidateEgressClusterPolicy(t *testing.T) {
 			},
 			expAllow: true,
 		},
+		"useNodeIP with sourcePorts": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP:   true,
+					SourcePorts: 1000,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "useNodeIP cannot be used with egressIP.sourcePorts at the same time",
+		},
+		"bandwidth without rate": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Bandwidth: &v1beta1.Bandwidth{Rate: "0", Burst: "64Ki"},
+			},
+			expAllow:      false,
+			expErrMessage: "invalid bandwidth: rate 0 should be greater than 0",
+		},
+		"dscp out of range": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				DSCP: ptr.To(64),
+			},
+			expAllow:      false,
+			expErrMessage: "dscp should be in 0-63",
+		},
+		"bandwidth with too large burst": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+				Bandwidth: &v1beta1.Bandwidth{Rate: "100M", Burst: "8Gi"},
+			},
+			expAllow:      false,
+			expErrMessage: "invalid bandwidth: burst 8Gi should be in 0-4294967295 bytes",
+		},
+		"useNodeIP with count": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					UseNodeIP: true,
+					Count:     2,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "useNodeIP cannot be used with egressIP.count or egressIP.additional at the same time",
+		},
 		"case1: Not valid when both PodSelector and DestSubnet exist": {
 			existingResources: nil,
 			spec: v1beta1.EgressClusterPolicySpec{
@@ -940,6 +1744,62 @@ func TestUpdateEgressClusterPolicy(t *testing.T) {
 			expAllow:      false,
 			expErrMessage: "",
 		},
+		"change sourcePorts": {
+			old: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:        "10.6.1.21",
+					SourcePorts: 1000,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			new: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:        "10.6.1.21",
+					SourcePorts: 2000,
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EgressIP.SourcePorts field cannot be modified",
+		},
+		"change additional": {
+			old: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:       "10.6.1.21",
+					Additional: []v1beta1.Eip{{Ipv4: "10.6.1.22"}},
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			new: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "a",
+				EgressIP: v1beta1.EgressIP{
+					IPv4:       "10.6.1.21",
+					Additional: []v1beta1.Eip{{Ipv4: "10.6.1.23"}},
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "the EgressIP.Additional field cannot be modified",
+		},
 		"change ipv6": {
 			existingResources: nil,
 			old: v1beta1.EgressClusterPolicySpec{
@@ -0,0 +1,169 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	policyA = egress.Policy{Namespace: "tenant-a", Name: "policy-a"}
+	policyB = egress.Policy{Name: "cluster-policy-b"}
+	now     = time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+)
+
+func nodeList(items map[string][]egress.Eips) []egress.EgressIPStatus {
+	res := make([]egress.EgressIPStatus, 0, len(items))
+	for _, name := range []string{"node1", "node2"} {
+		if eips, ok := items[name]; ok {
+			res = append(res, egress.EgressIPStatus{Name: name, Eips: eips, Status: "Ready"})
+		}
+	}
+	return res
+}
+
+func TestDiff(t *testing.T) {
+	old := nodeList(map[string][]egress.Eips{
+		"node1": {
+			{IPv4: "10.6.1.21", Policies: []egress.Policy{policyA}},
+			{IPv4: "10.6.1.22", IPv6: "fd00::22", Policies: []egress.Policy{policyB}},
+		},
+		"node2": {{Policies: []egress.Policy{policyA}}},
+	})
+	new := nodeList(map[string][]egress.Eips{
+		"node1": {{IPv4: "10.6.1.23", Policies: []egress.Policy{policyA}}},
+		"node2": {
+			{Policies: []egress.Policy{policyA}},
+			{IPv4: "10.6.1.22", IPv6: "fd00::22", Policies: []egress.Policy{policyB}},
+		},
+	})
+	record := func(policy egress.Policy, action Action, ipv4, ipv6, oldNode, newNode string) Record {
+		return Record{
+			Time: now, Action: action, Gateway: "default",
+			PolicyNamespace: policy.Namespace, Policy: policy.Name,
+			IPv4: ipv4, IPv6: ipv6, OldNode: oldNode, NewNode: newNode, Reason: ReasonNodeNotReady,
+		}
+	}
+	assert.Equal(t, []Record{
+		record(policyB, ActionMove, "10.6.1.22", "fd00::22", "node1", "node2"),
+		record(policyA, ActionRelease, "10.6.1.21", "", "node1", ""),
+		record(policyA, ActionAssign, "10.6.1.23", "", "", "node1"),
+	}, Diff("default", old, new, ReasonNodeNotReady, now))
+	assert.Empty(t, Diff("default", old, old, ReasonNodeNotReady, now))
+}
+
+func TestRecordMessage(t *testing.T) {
+	item := Record{Action: ActionMove, Gateway: "default", IPv4: "10.6.1.22", IPv6: "fd00::22",
+		OldNode: "node1", NewNode: "node2", Reason: ReasonExternal}
+	assert.Equal(t, "EIP 10.6.1.22,fd00::22 of gateway default is moved from node node1 to node node2, reason External", item.Message())
+	item = Record{Action: ActionRelease, Gateway: "default", OldNode: "node1", Reason: ReasonPolicyDeleted}
+	assert.Equal(t, "EIP node IP of gateway default is released from node node1, reason PolicyDeleted", item.Message())
+}
+
+func gateway(resourceVersion string, nodes []egress.EgressIPStatus) *egress.EgressGateway {
+	return &egress.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", ResourceVersion: resourceVersion},
+		Status:     egress.EgressGatewayStatus{NodeList: nodes},
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(10)
+	a := New(logr.Discard(), nil, recorder, config.AuditLog{File: filepath.Join(t.TempDir(), "audit.log")})
+	a.now = func() time.Time { return now }
+
+	onNode1 := nodeList(map[string][]egress.Eips{
+		"node1": {{IPv4: "10.6.1.21", Policies: []egress.Policy{policyA}}},
+		"node2": {},
+	})
+	onNode2 := nodeList(map[string][]egress.Eips{
+		"node1": {},
+		"node2": {{IPv4: "10.6.1.21", Policies: []egress.Policy{policyA}}},
+	})
+
+	// the first status is only known
+	a.Observe(ctx, gateway("1", onNode1))
+	assert.True(t, a.Known("default", "1"))
+	assert.Len(t, recorder.Events, 0)
+
+	// written by the controller
+	a.Update(ctx, "1", gateway("2", onNode2), ReasonNodeNotReady)
+	assert.Equal(t, "Normal EIPMoved EIP 10.6.1.21 of gateway default is moved from node node1 to node node2, reason NodeNotReady", <-recorder.Events)
+
+	// moved back outside the controller
+	a.Observe(ctx, gateway("3", onNode1))
+	assert.Equal(t, "Normal EIPMoved EIP 10.6.1.21 of gateway default is moved from node node2 to node node1, reason External", <-recorder.Events)
+
+	// the status before the write is unknown
+	a.Update(ctx, "4", gateway("5", onNode2), ReasonGatewayChanged)
+	assert.Len(t, recorder.Events, 0)
+	assert.True(t, a.Known("default", "5"))
+
+	a.Forget("default")
+	assert.False(t, a.Known("default", "5"))
+	assert.Len(t, a.queue, 2)
+}
+
+func TestSinks(t *testing.T) {
+	var received []Record
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		data, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		item := Record{}
+		assert.NoError(t, json.Unmarshal(data, &item))
+		received = append(received, item)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "audit.log")
+	a := New(logr.Discard(), nil, nil, config.AuditLog{File: file, Webhook: server.URL})
+	items := []Record{
+		{Time: now, Action: ActionAssign, Gateway: "default", PolicyNamespace: "tenant-a", Policy: "policy-a",
+			IPv4: "10.6.1.21", NewNode: "node1", Reason: ReasonPolicyChanged},
+		{Time: now, Action: ActionRelease, Gateway: "default", PolicyNamespace: "tenant-a", Policy: "policy-a",
+			IPv4: "10.6.1.21", OldNode: "node1", Reason: ReasonPolicyDeleted},
+	}
+	for _, item := range items {
+		a.write(context.Background(), item)
+	}
+	for _, s := range a.sinks {
+		s.Close()
+	}
+
+	assert.Equal(t, items, received)
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, `{"time":"2026-10-19T08:00:00Z","action":"Assign","gateway":"default","policyNamespace":"tenant-a","policy":"policy-a","ipv4":"10.6.1.21","newNode":"node1","reason":"PolicyChanged"}`, lines[0])
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := newWebhookSink(server.URL).Write([]byte("{}"))
+	assert.EqualError(t, err, "webhook "+server.URL+" responded 503 Service Unavailable")
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit writes a record of each change of the EIP assignments of the
+// policies: the EIPs assigned, released and moved to another node. The records
+// are Events of the policies, and JSON records appended to a file and posted
+// to a webhook when they are set.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	queueSize = 1024
+	// retries is the number of times a record is written again to a sink
+	// which failed
+	retries    = 2
+	retryDelay = time.Second
+)
+
+// the reasons of the Events of the records
+var eventReasons = map[Action]string{
+	ActionAssign:  "EIPAssigned",
+	ActionRelease: "EIPReleased",
+	ActionMove:    "EIPMoved",
+}
+
+// status is the last EgressGateway status the auditor knows.
+type status struct {
+	resourceVersion string
+	nodes           []egress.EgressIPStatus
+}
+
+// Auditor records the changes of the EIP assignments of the gateways. It keeps
+// the status of each gateway it knows, the changes made by the controller are
+// recorded with their reason, and the other ones when the status is observed
+// again.
+type Auditor struct {
+	log      logr.Logger
+	reader   client.Reader
+	recorder record.EventRecorder
+	sinks    []sink
+	queue    chan Record
+	now      func() time.Time
+
+	mutex sync.Mutex
+	known map[string]status
+}
+
+// New returns the auditor, reader gets the policies the Events are recorded on.
+func New(log logr.Logger, reader client.Reader, recorder record.EventRecorder, cfg config.AuditLog) *Auditor {
+	a := &Auditor{
+		log:      log.WithName("audit"),
+		reader:   reader,
+		recorder: recorder,
+		queue:    make(chan Record, queueSize),
+		now:      time.Now,
+		known:    make(map[string]status),
+	}
+	if cfg.File != "" {
+		a.sinks = append(a.sinks, newFileSink(cfg.File))
+	}
+	if cfg.Webhook != "" {
+		a.sinks = append(a.sinks, newWebhookSink(cfg.Webhook))
+	}
+	return a
+}
+
+// Known returns true when the auditor knows the status of the gateway at the
+// resource version.
+func (a *Auditor) Known(name, resourceVersion string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	known, ok := a.known[name]
+	return ok && known.resourceVersion == resourceVersion
+}
+
+// Observe records the changes of the status of the gateway since the status
+// the auditor knows, they were not made by the controller. It returns the
+// records.
+func (a *Auditor) Observe(ctx context.Context, gateway *egress.EgressGateway) []Record {
+	a.mutex.Lock()
+	known, ok := a.known[gateway.Name]
+	a.known[gateway.Name] = newStatus(gateway)
+	a.mutex.Unlock()
+
+	if !ok || known.resourceVersion == gateway.ResourceVersion {
+		return nil
+	}
+	return a.record(ctx, Diff(gateway.Name, known.nodes, gateway.Status.NodeList, ReasonExternal, a.now()))
+}
+
+// Update records the changes of the status the controller wrote to the gateway,
+// resourceVersion is the version of the gateway before the write. It returns
+// the records.
+func (a *Auditor) Update(ctx context.Context, resourceVersion string, gateway *egress.EgressGateway, reason string) []Record {
+	a.mutex.Lock()
+	known, ok := a.known[gateway.Name]
+	a.known[gateway.Name] = newStatus(gateway)
+	a.mutex.Unlock()
+
+	if !ok || known.resourceVersion != resourceVersion {
+		// the status before the write is unknown
+		return nil
+	}
+	return a.record(ctx, Diff(gateway.Name, known.nodes, gateway.Status.NodeList, reason, a.now()))
+}
+
+// Forget removes the status of a deleted gateway.
+func (a *Auditor) Forget(name string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.known, name)
+}
+
+func newStatus(gateway *egress.EgressGateway) status {
+	return status{
+		resourceVersion: gateway.ResourceVersion,
+		nodes:           gateway.Status.DeepCopy().NodeList,
+	}
+}
+
+// record writes the Events of the records, and queues them for the sinks.
+func (a *Auditor) record(ctx context.Context, records []Record) []Record {
+	for _, item := range records {
+		a.log.Info("EIP assignment changed", "action", item.Action, "gateway", item.Gateway,
+			"policyNamespace", item.PolicyNamespace, "policy", item.Policy, "ipv4", item.IPv4, "ipv6", item.IPv6,
+			"oldNode", item.OldNode, "newNode", item.NewNode, "reason", item.Reason)
+		if a.recorder != nil {
+			a.recorder.Event(a.policy(ctx, item), corev1.EventTypeNormal, eventReasons[item.Action], item.Message())
+		}
+		if len(a.sinks) == 0 {
+			continue
+		}
+		select {
+		case a.queue <- item:
+		default:
+			a.log.Error(nil, "audit queue is full, the record is dropped", "policy", item.Policy, "eip", item.EIP())
+			for _, s := range a.sinks {
+				countErrors.WithLabelValues(s.Name()).Inc()
+			}
+		}
+	}
+	return records
+}
+
+// policy returns the policy of the record, the Event of a deleted policy is
+// recorded on an object with its name.
+func (a *Auditor) policy(ctx context.Context, item Record) client.Object {
+	key := types.NamespacedName{Namespace: item.PolicyNamespace, Name: item.Policy}
+	meta := metav1.ObjectMeta{Namespace: item.PolicyNamespace, Name: item.Policy}
+	var obj client.Object = &egress.EgressClusterPolicy{ObjectMeta: meta}
+	if item.PolicyNamespace != "" {
+		obj = &egress.EgressPolicy{ObjectMeta: meta}
+	}
+	if a.reader != nil {
+		_ = a.reader.Get(ctx, key, obj)
+	}
+	return obj
+}
+
+// Start writes the queued records to the sinks.
+func (a *Auditor) Start(ctx context.Context) error {
+	defer func() {
+		for _, s := range a.sinks {
+			s.Close()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case item := <-a.queue:
+			a.write(ctx, item)
+		}
+	}
+}
+
+func (a *Auditor) write(ctx context.Context, item Record) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		a.log.Error(err, "failed to encode the audit record")
+		return
+	}
+	for _, s := range a.sinks {
+		err := s.Write(data)
+		for i := 0; err != nil && i < retries; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			err = s.Write(data)
+		}
+		if err != nil {
+			countErrors.WithLabelValues(s.Name()).Inc()
+			a.log.Error(err, "failed to write the audit record", "sink", s.Name(), "policy", item.Policy, "eip", item.EIP())
+			continue
+		}
+		countRecords.WithLabelValues(s.Name()).Inc()
+	}
+}
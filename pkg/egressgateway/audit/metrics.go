@@ -0,0 +1,29 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	countRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "audit",
+		Name:      "records_total",
+		Help:      "Number of EIP assignment audit records written to the sink",
+	}, []string{"sink"})
+
+	countErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "audit",
+		Name:      "errors_total",
+		Help:      "Number of EIP assignment audit records which could not be written to the sink",
+	}, []string{"sink"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		countRecords,
+		countErrors,
+	}
+}
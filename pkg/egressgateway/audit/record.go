@@ -0,0 +1,153 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Action is the change of the EIP assignment of a policy.
+type Action string
+
+const (
+	// ActionAssign is an EIP assigned to the policy on a node
+	ActionAssign Action = "Assign"
+	// ActionRelease is an EIP which the policy no longer uses
+	ActionRelease Action = "Release"
+	// ActionMove is an EIP of the policy moved to another node
+	ActionMove Action = "Move"
+)
+
+// The reasons of the changes, each is what the controller was reconciling when
+// it changed the assignments.
+const (
+	ReasonPolicyChanged  = "PolicyChanged"
+	ReasonPolicyDeleted  = "PolicyDeleted"
+	ReasonGatewayChanged = "GatewayChanged"
+	ReasonNodeChanged    = "NodeChanged"
+	ReasonNodeReady      = "NodeReady"
+	ReasonNodeNotReady   = "NodeNotReady"
+	ReasonNodeRemoved    = "NodeRemoved"
+	// ReasonExternal is a change of the EgressGateway status made outside the
+	// controller, such as egctl vip move
+	ReasonExternal = "External"
+)
+
+// Record is the audit record of a change of the EIP assignment of a policy.
+type Record struct {
+	Time            time.Time `json:"time"`
+	Action          Action    `json:"action"`
+	Gateway         string    `json:"gateway"`
+	PolicyNamespace string    `json:"policyNamespace,omitempty"`
+	Policy          string    `json:"policy"`
+	// IPv4 and IPv6 are the EIP, both are empty when the policy uses the IP
+	// of the node
+	IPv4    string `json:"ipv4,omitempty"`
+	IPv6    string `json:"ipv6,omitempty"`
+	OldNode string `json:"oldNode,omitempty"`
+	NewNode string `json:"newNode,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// EIP returns the EIP of the record as text.
+func (r Record) EIP() string {
+	switch {
+	case r.IPv4 == "" && r.IPv6 == "":
+		return "node IP"
+	case r.IPv4 == "":
+		return r.IPv6
+	case r.IPv6 == "":
+		return r.IPv4
+	}
+	return r.IPv4 + "," + r.IPv6
+}
+
+// Message returns the message of the Event of the record.
+func (r Record) Message() string {
+	switch r.Action {
+	case ActionAssign:
+		return fmt.Sprintf("EIP %s of gateway %s is assigned on node %s, reason %s", r.EIP(), r.Gateway, r.NewNode, r.Reason)
+	case ActionRelease:
+		return fmt.Sprintf("EIP %s of gateway %s is released from node %s, reason %s", r.EIP(), r.Gateway, r.OldNode, r.Reason)
+	}
+	return fmt.Sprintf("EIP %s of gateway %s is moved from node %s to node %s, reason %s", r.EIP(), r.Gateway, r.OldNode, r.NewNode, r.Reason)
+}
+
+// assignment is an EIP of a policy.
+type assignment struct {
+	policy egress.Policy
+	ipv4   string
+	ipv6   string
+}
+
+// assignments returns the node of each EIP of each policy.
+func assignments(nodes []egress.EgressIPStatus) map[assignment]string {
+	res := make(map[assignment]string)
+	for _, node := range nodes {
+		for _, eip := range node.Eips {
+			for _, policy := range eip.Policies {
+				res[assignment{policy: policy, ipv4: eip.IPv4, ipv6: eip.IPv6}] = node.Name
+			}
+		}
+	}
+	return res
+}
+
+// Diff returns the records of the changes between two node lists of the
+// status of a gateway, sorted by policy and EIP.
+func Diff(gateway string, old, new []egress.EgressIPStatus, reason string, now time.Time) []Record {
+	before := assignments(old)
+	after := assignments(new)
+
+	res := make([]Record, 0)
+	add := func(key assignment, action Action, oldNode, newNode string) {
+		res = append(res, Record{
+			Time:            now,
+			Action:          action,
+			Gateway:         gateway,
+			PolicyNamespace: key.policy.Namespace,
+			Policy:          key.policy.Name,
+			IPv4:            key.ipv4,
+			IPv6:            key.ipv6,
+			OldNode:         oldNode,
+			NewNode:         newNode,
+			Reason:          reason,
+		})
+	}
+	for key, oldNode := range before {
+		newNode, ok := after[key]
+		switch {
+		case !ok:
+			add(key, ActionRelease, oldNode, "")
+		case newNode != oldNode:
+			add(key, ActionMove, oldNode, newNode)
+		}
+	}
+	for key, newNode := range after {
+		if _, ok := before[key]; !ok {
+			add(key, ActionAssign, "", newNode)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		for _, cmp := range []int{
+			strings.Compare(a.PolicyNamespace, b.PolicyNamespace),
+			strings.Compare(a.Policy, b.Policy),
+			strings.Compare(a.IPv4, b.IPv4),
+			strings.Compare(a.IPv6, b.IPv6),
+		} {
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	return res
+}
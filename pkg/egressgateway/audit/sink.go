@@ -0,0 +1,100 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// sink is where the JSON records are written, it only appends.
+type sink interface {
+	Name() string
+	Write(data []byte) error
+	Close()
+}
+
+// fileSink appends the records to a local file as JSON lines, - is the
+// standard output.
+type fileSink struct {
+	path string
+	file io.WriteCloser
+}
+
+func newFileSink(path string) *fileSink {
+	return &fileSink{path: path}
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Write(data []byte) error {
+	if s.file == nil {
+		if s.path == "-" {
+			s.file = nopCloser{os.Stdout}
+		} else {
+			file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return err
+			}
+			s.file = file
+		}
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *fileSink) Close() {
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// webhookSink posts each record to a URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookSink(url string) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSink) Write(data []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() {
+	s.client.CloseIdleConnections()
+}
@@ -10,11 +10,15 @@ import (
 	"math/rand"
 	"net"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/constant"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway/audit"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway/notifier"
+	"github.com/spidernet-io/egressgateway/pkg/eiplease"
 	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
 	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
@@ -32,10 +36,12 @@ import (
 )
 
 type egnReconciler struct {
-	client client.Client
-	log    logr.Logger
-	config *config.Config
-	cli    client.Client
+	client   client.Client
+	log      logr.Logger
+	config   *config.Config
+	cli      client.Client
+	audit    *audit.Auditor
+	notifier *notifier.Notifier
 }
 
 func (r *egnReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -84,11 +90,25 @@ func (r *egnReconciler) reconcileTunnel(ctx context.Context, req reconcile.Reque
 			return reconcile.Result{Requeue: true}, err
 		}
 
+		var requeueAfter time.Duration
 		for _, egw := range egwList.Items {
 			var needMoveIPs []egress.Eips
 			needUpdate := false
 			for nodeIndex, node := range egw.Status.NodeList {
 				if node.Name == req.Name {
+					held, free, wait, err := r.splitLeasedEips(ctx, node.Name, node.Eips)
+					if err != nil {
+						return reconcile.Result{Requeue: true}, err
+					}
+					requeueAfter = minRequeue(requeueAfter, wait)
+					if len(held) > 0 {
+						// keep the node until the leases of its EIPs expire
+						needUpdate = true
+						needMoveIPs = append(needMoveIPs, free...)
+						egw.Status.NodeList[nodeIndex].Eips = held
+						egw.Status.NodeList[nodeIndex].Status = string(egress.EgressTunnelNodeNotReady)
+						break
+					}
 					needUpdate = true
 					needMoveIPs = append(needMoveIPs, node.Eips...)
 					egw.Status.NodeList = append(egw.Status.NodeList[:nodeIndex], egw.Status.NodeList[nodeIndex+1:]...)
@@ -99,7 +119,7 @@ func (r *egnReconciler) reconcileTunnel(ctx context.Context, req reconcile.Reque
 				moveEipToReadyNode(&egw, &needMoveIPs)
 			}
 			if needUpdate {
-				err := updateGatewayStatusWithUsage(ctx, r.client, &egw)
+				err := r.updateGatewayStatus(ctx, &egw, audit.ReasonNodeRemoved)
 				if err != nil {
 					return reconcile.Result{Requeue: true}, err
 				}
@@ -114,17 +134,23 @@ func (r *egnReconciler) reconcileTunnel(ctx context.Context, req reconcile.Reque
 				}
 			}
 		}
-		return reconcile.Result{}, nil
+		return reconcile.Result{RequeueAfter: requeueAfter}, nil
 	}
 
 	fmt.Println("update tunnel")
 
+	reason := audit.ReasonNodeNotReady
+	if tunnel.Status.Phase == egress.EgressTunnelReady {
+		reason = audit.ReasonNodeReady
+	}
+
 	egwList := new(egress.EgressGatewayList)
 	err = r.cli.List(ctx, egwList)
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
 
+	var requeueAfter time.Duration
 	for _, egw := range egwList.Items {
 		var needMoveIPs []egress.Eips
 		needUpdate := false
@@ -149,10 +175,17 @@ func (r *egnReconciler) reconcileTunnel(ctx context.Context, req reconcile.Reque
 					// case 2.2: ready -> not ready / statue not sync
 					//           move ip
 					if len(node.Eips) > 0 {
-						// need move ip
-						needUpdate = true
-						needMoveIPs = append(needMoveIPs, node.Eips...)
-						egw.Status.NodeList[nodeIndex].Eips = make([]egress.Eips, 0)
+						// need move ip, except the EIPs whose leases are still renewed
+						held, free, wait, err := r.splitLeasedEips(ctx, node.Name, node.Eips)
+						if err != nil {
+							return reconcile.Result{Requeue: true}, err
+						}
+						requeueAfter = minRequeue(requeueAfter, wait)
+						if len(free) > 0 {
+							needUpdate = true
+							needMoveIPs = append(needMoveIPs, free...)
+							egw.Status.NodeList[nodeIndex].Eips = held
+						}
 					}
 					// check state are sync
 					if tunnel.Status.Phase.IsNotEqual(node.Status) {
@@ -167,7 +200,7 @@ func (r *egnReconciler) reconcileTunnel(ctx context.Context, req reconcile.Reque
 			moveEipToReadyNode(&egw, &needMoveIPs)
 		}
 		if needUpdate {
-			err := updateGatewayStatusWithUsage(ctx, r.client, &egw)
+			err := r.updateGatewayStatus(ctx, &egw, reason)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, err
 			}
@@ -183,7 +216,53 @@ func (r *egnReconciler) reconcileTunnel(ctx context.Context, req reconcile.Reque
 		}
 	}
 
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// splitLeasedEips splits the EIPs of a not ready node into the ones whose
+// leases are still held by the node and the ones which can be moved, wait is
+// the time until the first held lease expires. Without EIP leases, all the
+// EIPs can be moved.
+func (r *egnReconciler) splitLeasedEips(ctx context.Context, node string, eips []egress.Eips) (held, free []egress.Eips, wait time.Duration, err error) {
+	held = make([]egress.Eips, 0)
+	if !r.config.FileConfig.EIPLease.Enable {
+		return held, eips, 0, nil
+	}
+	now := time.Now()
+	for _, eip := range eips {
+		remaining := time.Duration(0)
+		for _, item := range []string{eip.IPv4, eip.IPv6} {
+			if item == "" {
+				continue
+			}
+			d, err := eiplease.HeldBy(ctx, r.cli, r.config.PodNamespace, node, item, now)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			if d > remaining {
+				remaining = d
+			}
+		}
+		if remaining > 0 {
+			held = append(held, eip)
+			wait = minRequeue(wait, remaining)
+			continue
+		}
+		free = append(free, eip)
+	}
+	if len(held) > 0 {
+		r.log.Info("EIP leases of the not ready node are not expired, wait before moving the EIPs",
+			"node", node, "eips", len(held), "wait", wait)
+	}
+	return held, free, wait, nil
+}
+
+// minRequeue returns the earlier of two requeue delays, 0 means no requeue.
+func minRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
 }
 
 func (r *egnReconciler) checkAndUpdateAllPolicyIfNeedWhenFirstNodeReady(ctx context.Context,
@@ -235,7 +314,11 @@ func (r *egnReconciler) reAssignEgressPolicyIP(ctx context.Context,
 		if assignedIP == nil {
 			return reconcile.Result{Requeue: true}, fmt.Errorf("not enough ip")
 		}
-		err = updateGatewayStatusWithUsage(ctx, r.client, gateway)
+		_, err = assignPolicyEIPs(gateway, req.Namespace, req.Name, policy.Spec.EgressIP, assignedIP)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		err = r.updateGatewayStatus(ctx, gateway, audit.ReasonNodeReady)
 		if err != nil {
 			return reconcile.Result{Requeue: true}, err
 		}
@@ -271,6 +354,10 @@ func (r *egnReconciler) reAssignEgressClusterPolicyIP(ctx context.Context,
 		if assignedIP == nil {
 			return reconcile.Result{Requeue: true}, fmt.Errorf("not enough ip")
 		}
+		_, err = assignPolicyEIPs(gateway, req.Namespace, req.Name, policy.Spec.EgressIP, assignedIP)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
 		err := updateEgressClusterPolicyStatusIfNeed(ctx, r.client, policy, assignedIP)
 		if err != nil {
 			return reconcile.Result{Requeue: true}, err
@@ -370,7 +457,7 @@ func (r *egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request
 			moveEipToReadyNode(&egw, &needMoveIPs)
 		}
 		if needUpdate {
-			err := updateGatewayStatusWithUsage(ctx, r.client, &egw)
+			err := r.updateGatewayStatus(ctx, &egw, audit.ReasonNodeChanged)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, err
 			}
@@ -389,18 +476,16 @@ func (r *egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request
 }
 
 func updateAllPolicyStatus(ctx context.Context, cli client.Client, egw *egress.EgressGateway) error {
+	done := make(map[egress.Policy]bool)
 	for _, node := range egw.Status.NodeList {
 		for _, eip := range node.Eips {
-			assignedIP := &AssignedIP{
-				Node:      node.Name,
-				IPv4:      eip.IPv4,
-				IPv6:      eip.IPv6,
-				UseNodeIP: false,
-			}
-			if assignedIP.IPv4 == "" && assignedIP.IPv6 == "" {
-				assignedIP.UseNodeIP = true
-			}
 			for _, p := range eip.Policies {
+				// a policy with several EIPs is updated once with all of them
+				if done[p] {
+					continue
+				}
+				done[p] = true
+				assignedIP := getAssignedIP(egw, p.Namespace, p.Name)
 				if p.Namespace != "" {
 					policy := new(egress.EgressPolicy)
 					err := cli.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Name}, policy)
@@ -475,6 +560,7 @@ func (r *egnReconciler) reconcileGateway(ctx context.Context, req reconcile.Requ
 	if deleted {
 		// case 1
 		log.Info("request item is deleted")
+		r.audit.Forget(req.Name)
 		count, err := getPolicyCountByGatewayName(ctx, r.client, req.Name)
 		if err != nil {
 			return reconcile.Result{Requeue: true}, err
@@ -491,6 +577,9 @@ func (r *egnReconciler) reconcileGateway(ctx context.Context, req reconcile.Requ
 		return reconcile.Result{Requeue: false}, nil
 	}
 
+	// record the EIPs moved outside the controller, e.g. egctl vip move
+	r.notifier.Notify(ctx, r.audit.Observe(ctx, egw))
+
 	// case2: egw match label update
 	k8sNodeList := &corev1.NodeList{}
 	selector, err := metav1.LabelSelectorAsSelector(egw.Spec.NodeSelector.Selector)
@@ -590,9 +679,14 @@ func (r *egnReconciler) reconcileGateway(ctx context.Context, req reconcile.Requ
 		needUpdate = true
 	}
 
+	// namespace quota added, removed or changed
+	if !reflect.DeepEqual(egw.Status.NamespaceUsage, buildNamespaceUsage(egw)) {
+		needUpdate = true
+	}
+
 	if needUpdate {
 		// update
-		err := updateGatewayStatusWithUsage(ctx, r.client, egw)
+		err := r.updateGatewayStatus(ctx, egw, audit.ReasonGatewayChanged)
 		if err != nil {
 			return reconcile.Result{Requeue: true}, err
 		}
@@ -696,7 +790,11 @@ func (r *egnReconciler) reconcileEgressClusterPolicy(ctx context.Context, req re
 			if assignedIP == nil {
 				return reconcile.Result{Requeue: true}, fmt.Errorf("not enough ip")
 			}
-			err = updateGatewayStatusWithUsage(ctx, r.client, gateway)
+			_, err = assignPolicyEIPs(gateway, req.Namespace, req.Name, policy.Spec.EgressIP, assignedIP)
+			if err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+			err = r.updateGatewayStatus(ctx, gateway, audit.ReasonPolicyChanged)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, err
 			}
@@ -705,7 +803,17 @@ func (r *egnReconciler) reconcileEgressClusterPolicy(ctx context.Context, req re
 				return reconcile.Result{Requeue: true}, err
 			}
 		} else {
-			err := updateEgressClusterPolicyStatusIfNeed(ctx, r.client, policy, assignedIP)
+			changed, err := assignPolicyEIPs(gateway, req.Namespace, req.Name, policy.Spec.EgressIP, assignedIP)
+			if err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+			if changed {
+				err = r.updateGatewayStatus(ctx, gateway, audit.ReasonPolicyChanged)
+				if err != nil {
+					return reconcile.Result{Requeue: true}, err
+				}
+			}
+			err = updateEgressClusterPolicyStatusIfNeed(ctx, r.client, policy, assignedIP)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, err
 			}
@@ -733,7 +841,7 @@ func (r *egnReconciler) reconcileDeletePolicy(ctx context.Context, req reconcile
 				return reconcile.Result{Requeue: true}, err
 			}
 			if update {
-				err := updateGatewayStatusWithUsage(ctx, r.client, &gateway)
+				err := r.updateGatewayStatus(ctx, &gateway, audit.ReasonPolicyDeleted)
 				if err != nil {
 					return reconcile.Result{Requeue: true}, err
 				}
@@ -755,7 +863,7 @@ func (r *egnReconciler) reconcileDeletePolicy(ctx context.Context, req reconcile
 				return reconcile.Result{Requeue: true}, err
 			}
 			if update {
-				err := updateGatewayStatusWithUsage(ctx, r.client, gateway)
+				err := r.updateGatewayStatus(ctx, gateway, audit.ReasonPolicyDeleted)
 				if err != nil {
 					return reconcile.Result{Requeue: true}, err
 				}
@@ -800,7 +908,7 @@ func (r *egnReconciler) reconcileEgressPolicy(ctx context.Context, req reconcile
 					return reconcile.Result{Requeue: true}, err
 				}
 				if update {
-					err := updateGatewayStatusWithUsage(ctx, r.client, &gateway)
+					err := r.updateGatewayStatus(ctx, &gateway, audit.ReasonPolicyDeleted)
 					if err != nil {
 						return reconcile.Result{Requeue: true}, err
 					}
@@ -822,7 +930,7 @@ func (r *egnReconciler) reconcileEgressPolicy(ctx context.Context, req reconcile
 					return reconcile.Result{Requeue: true}, err
 				}
 				if update {
-					err := updateGatewayStatusWithUsage(ctx, r.client, gateway)
+					err := r.updateGatewayStatus(ctx, gateway, audit.ReasonPolicyDeleted)
 					if err != nil {
 						return reconcile.Result{Requeue: true}, err
 					}
@@ -851,7 +959,11 @@ func (r *egnReconciler) reconcileEgressPolicy(ctx context.Context, req reconcile
 			if assignedIP == nil {
 				return reconcile.Result{Requeue: true}, fmt.Errorf("not enough ip")
 			}
-			err = updateGatewayStatusWithUsage(ctx, r.client, gateway)
+			_, err = assignPolicyEIPs(gateway, req.Namespace, req.Name, policy.Spec.EgressIP, assignedIP)
+			if err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+			err = r.updateGatewayStatus(ctx, gateway, audit.ReasonPolicyChanged)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, err
 			}
@@ -864,7 +976,17 @@ func (r *egnReconciler) reconcileEgressPolicy(ctx context.Context, req reconcile
 				return reconcile.Result{Requeue: true}, err
 			}
 		} else {
-			err := updateEgressPolicyStatusIfNeed(ctx, r.client, policy, assignedIP)
+			changed, err := assignPolicyEIPs(gateway, req.Namespace, req.Name, policy.Spec.EgressIP, assignedIP)
+			if err != nil {
+				return reconcile.Result{Requeue: true}, err
+			}
+			if changed {
+				err = r.updateGatewayStatus(ctx, gateway, audit.ReasonPolicyChanged)
+				if err != nil {
+					return reconcile.Result{Requeue: true}, err
+				}
+			}
+			err = updateEgressPolicyStatusIfNeed(ctx, r.client, policy, assignedIP)
 			if err != nil {
 				return reconcile.Result{Requeue: true}, err
 			}
@@ -1123,10 +1245,12 @@ func assignIP(from *egress.EgressGateway, req reconcile.Request, specEgressIP eg
 }
 
 type AssignedIP struct {
-	Node      string
-	IPv4      string
-	IPv6      string
-	UseNodeIP bool
+	Node        string
+	IPv4        string
+	IPv6        string
+	UseNodeIP   bool
+	SourcePorts string
+	Assignments []egress.EipAssignment
 }
 
 func updateEgressPolicyIfNeed(ctx context.Context, cli client.Client, policy *egress.EgressPolicy, assignedIP *AssignedIP) error {
@@ -1146,7 +1270,9 @@ func getAssignedIP(from *egress.EgressGateway, policyNs, policyName string) *Ass
 		for _, eip := range node.Eips {
 			for _, policy := range eip.Policies {
 				if policy.Name == policyName && policy.Namespace == policyNs {
-					return &AssignedIP{Node: node.Name, IPv4: eip.IPv4, IPv6: eip.IPv6}
+					return &AssignedIP{Node: node.Name, IPv4: eip.IPv4, IPv6: eip.IPv6,
+						SourcePorts: eip.GetSourcePorts(policyNs, policyName),
+						Assignments: listAssignments(from, policyNs, policyName)}
 				}
 			}
 		}
@@ -1155,10 +1281,14 @@ func getAssignedIP(from *egress.EgressGateway, policyNs, policyName string) *Ass
 }
 
 func updateEgressPolicyStatusIfNeed(ctx context.Context, cli client.Client, policy *egress.EgressPolicy, assignedIP *AssignedIP) error {
-	if policy.Status.Eip.Ipv4 != assignedIP.IPv4 || policy.Status.Eip.Ipv6 != assignedIP.IPv6 || policy.Status.Node != assignedIP.Node {
+	if policy.Status.Eip.Ipv4 != assignedIP.IPv4 || policy.Status.Eip.Ipv6 != assignedIP.IPv6 || policy.Status.Node != assignedIP.Node ||
+		policy.Status.SourcePorts != assignedIP.SourcePorts ||
+		!reflect.DeepEqual(policy.Status.Assignments, assignedIP.Assignments) {
 		policy.Status.Eip.Ipv4 = assignedIP.IPv4
 		policy.Status.Eip.Ipv6 = assignedIP.IPv6
 		policy.Status.Node = assignedIP.Node
+		policy.Status.SourcePorts = assignedIP.SourcePorts
+		policy.Status.Assignments = assignedIP.Assignments
 
 		err := cli.Status().Update(ctx, policy)
 		if err != nil {
@@ -1184,10 +1314,14 @@ func updateEgressPolicyStatusIfNeed(ctx context.Context, cli client.Client, poli
 }
 
 func updateEgressClusterPolicyStatusIfNeed(ctx context.Context, cli client.Client, policy *egress.EgressClusterPolicy, assignedIP *AssignedIP) error {
-	if policy.Status.Eip.Ipv4 != assignedIP.IPv4 || policy.Status.Eip.Ipv6 != assignedIP.IPv6 || policy.Status.Node != assignedIP.Node {
+	if policy.Status.Eip.Ipv4 != assignedIP.IPv4 || policy.Status.Eip.Ipv6 != assignedIP.IPv6 || policy.Status.Node != assignedIP.Node ||
+		policy.Status.SourcePorts != assignedIP.SourcePorts ||
+		!reflect.DeepEqual(policy.Status.Assignments, assignedIP.Assignments) {
 		policy.Status.Eip.Ipv4 = assignedIP.IPv4
 		policy.Status.Eip.Ipv6 = assignedIP.IPv6
 		policy.Status.Node = assignedIP.Node
+		policy.Status.SourcePorts = assignedIP.SourcePorts
+		policy.Status.Assignments = assignedIP.Assignments
 		err := cli.Status().Update(ctx, policy)
 		if err != nil {
 			if errors.IsConflict(err) {
@@ -1211,6 +1345,27 @@ func updateEgressClusterPolicyStatusIfNeed(ctx context.Context, cli client.Clien
 	return nil
 }
 
+// updateGatewayStatus writes the status of the gateway, and records the changes
+// of its EIP assignments with the reason. The assignments of the policies are
+// changed in the gateway status, e.g. by moveEipToReadyNode, before the status
+// of the policies, so the EgressNotifiers are notified here.
+func (r *egnReconciler) updateGatewayStatus(ctx context.Context, gateway *egress.EgressGateway, reason string) error {
+	resourceVersion := gateway.ResourceVersion
+	if !r.audit.Known(gateway.Name, resourceVersion) {
+		// the status before the write is needed to find the changes
+		stored := new(egress.EgressGateway)
+		if err := r.cli.Get(ctx, types.NamespacedName{Name: gateway.Name}, stored); err != nil {
+			return err
+		}
+		r.notifier.Notify(ctx, r.audit.Observe(ctx, stored))
+	}
+	if err := updateGatewayStatusWithUsage(ctx, r.client, gateway); err != nil {
+		return err
+	}
+	r.notifier.Notify(ctx, r.audit.Update(ctx, resourceVersion, gateway, reason))
+	return nil
+}
+
 func updateGatewayStatusWithUsage(ctx context.Context, cli client.Client, gateway *egress.EgressGateway) error {
 	if gateway == nil {
 		return fmt.Errorf("gateway is nil")
@@ -1223,6 +1378,7 @@ func updateGatewayStatusWithUsage(ctx context.Context, cli client.Client, gatewa
 	gateway.Status.IPUsage.IPv6Free = ipv6sFree
 	gateway.Status.IPUsage.IPv4Total = ipv4sTotal
 	gateway.Status.IPUsage.IPv6Total = ipv6sTotal
+	gateway.Status.NamespaceUsage = buildNamespaceUsage(gateway)
 	err = cli.Status().Update(ctx, gateway)
 	if err != nil {
 		return err
@@ -1237,14 +1393,17 @@ func deleteEgressPolicy(gateway *egress.EgressGateway, policyNs, policyName stri
 
 	policyFound := false
 
-	for nodeIndex, node := range gateway.Status.NodeList {
-		for eipIndex, eip := range node.Eips {
+	// a policy with several EIPs is removed from all of them
+	for nodeIndex := range gateway.Status.NodeList {
+		for eipIndex := len(gateway.Status.NodeList[nodeIndex].Eips) - 1; eipIndex >= 0; eipIndex-- {
+			eip := gateway.Status.NodeList[nodeIndex].Eips[eipIndex]
 			for policyIndex, policy := range eip.Policies {
 				if policy.Name == policyName && policy.Namespace == policyNs {
 					gateway.Status.NodeList[nodeIndex].Eips[eipIndex].Policies = append(
 						gateway.Status.NodeList[nodeIndex].Eips[eipIndex].Policies[:policyIndex],
 						gateway.Status.NodeList[nodeIndex].Eips[eipIndex].Policies[policyIndex+1:]...,
 					)
+					releaseSourcePorts(&gateway.Status.NodeList[nodeIndex].Eips[eipIndex], policyNs, policyName)
 					// if it is the latest policy, we delete this eip
 					if len(gateway.Status.NodeList[nodeIndex].Eips[eipIndex].Policies) == 0 {
 						gateway.Status.NodeList[nodeIndex].Eips = append(
@@ -1256,12 +1415,6 @@ func deleteEgressPolicy(gateway *egress.EgressGateway, policyNs, policyName stri
 					break
 				}
 			}
-			if policyFound {
-				break
-			}
-		}
-		if policyFound {
-			break
 		}
 	}
 	return policyFound, nil
@@ -1272,10 +1425,18 @@ func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *confi
 		return fmt.Errorf("cfg can not be nil")
 	}
 	r := &egnReconciler{
-		client: mgr.GetClient(),
-		log:    log,
-		config: cfg,
-		cli:    client,
+		client:   mgr.GetClient(),
+		log:      log,
+		config:   cfg,
+		cli:      client,
+		audit:    audit.New(log, mgr.GetClient(), mgr.GetEventRecorderFor("egressGateway"), cfg.FileConfig.AuditLog),
+		notifier: notifier.New(log, mgr.GetClient(), client, cfg.PodNamespace, mgr.GetEventRecorderFor("egressNotifier")),
+	}
+	if err := mgr.Add(r.audit); err != nil {
+		return err
+	}
+	if err := mgr.Add(r.notifier); err != nil {
+		return err
 	}
 
 	c, err := controller.New("egressGateway", mgr,
@@ -1383,6 +1544,59 @@ func countGatewayIP(egw *egress.EgressGateway) (ipv4sFree, ipv6sFree, ipv4sTotal
 	return
 }
 
+// buildNamespaceUsage returns the namespace usage to report in the gateway status,
+// it is only reported when the gateway has a namespace quota
+func buildNamespaceUsage(egw *egress.EgressGateway) []egress.NamespaceUsage {
+	if egw.Spec.NamespaceQuota == nil {
+		return nil
+	}
+	usage := CountNamespaceUsage(egw)
+	if len(usage) == 0 {
+		return nil
+	}
+	res := make([]egress.NamespaceUsage, 0, len(usage))
+	for _, item := range usage {
+		res = append(res, *item)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Namespace < res[j].Namespace
+	})
+	return res
+}
+
+// CountNamespaceUsage counts the assigned policies and distinct EIPs of each namespace
+// in the gateway status, EgressClusterPolicy is not counted. The useNodeIP policies
+// are counted as policies without EIP, and a policy with several EIPs is counted once.
+func CountNamespaceUsage(egw *egress.EgressGateway) map[string]*egress.NamespaceUsage {
+	res := make(map[string]*egress.NamespaceUsage)
+	policies := make(map[egress.Policy]struct{})
+	for _, node := range egw.Status.NodeList {
+		for _, eip := range node.Eips {
+			useEip := eip.IPv4 != "" || eip.IPv6 != ""
+			counted := make(map[string]struct{})
+			for _, p := range eip.Policies {
+				if p.Namespace == "" {
+					continue
+				}
+				item, ok := res[p.Namespace]
+				if !ok {
+					item = &egress.NamespaceUsage{Namespace: p.Namespace}
+					res[p.Namespace] = item
+				}
+				if _, ok := policies[p]; !ok {
+					policies[p] = struct{}{}
+					item.Policies++
+				}
+				if _, ok := counted[p.Namespace]; useEip && !ok {
+					counted[p.Namespace] = struct{}{}
+					item.EIPs++
+				}
+			}
+		}
+	}
+	return res
+}
+
 func getPolicyCountByGatewayName(ctx context.Context, client client.Client, name string) (int, error) {
 	var num int
 
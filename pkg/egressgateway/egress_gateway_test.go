@@ -0,0 +1,41 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestBuildNamespaceUsage(t *testing.T) {
+	p1 := egress.Policy{Namespace: "default", Name: "p1"}
+	p2 := egress.Policy{Namespace: "default", Name: "p2"}
+	p3 := egress.Policy{Namespace: "other", Name: "p3"}
+	egw := &egress.EgressGateway{
+		Spec: egress.EgressGatewaySpec{NamespaceQuota: &egress.NamespaceQuota{MaxPolicies: 10}},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{Name: "node1", Eips: []egress.Eips{
+				// p1 has three EIPs on two nodes
+				{IPv4: "10.6.1.21", Policies: []egress.Policy{p1, p2}},
+				{IPv4: "10.6.1.22", Policies: []egress.Policy{p1}},
+				// the node IP
+				{Policies: []egress.Policy{p3}},
+			}},
+			{Name: "node2", Eips: []egress.Eips{
+				{IPv4: "10.6.1.23", Policies: []egress.Policy{p1, {Name: "cluster-policy"}}},
+			}},
+		}},
+	}
+
+	assert.Equal(t, []egress.NamespaceUsage{
+		{Namespace: "default", Policies: 2, EIPs: 3},
+		{Namespace: "other", Policies: 1, EIPs: 0},
+	}, buildNamespaceUsage(egw))
+
+	egw.Spec.NamespaceQuota = nil
+	assert.Nil(t, buildNamespaceUsage(egw))
+}
@@ -131,6 +131,14 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 		}
 	}
 
+	if err := checkAnnouncement(newEg.Spec.Announcement); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
+	if err := checkUplinks(newEg.Spec.Uplinks); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
 	// check if the current egw ip pool is duplicated by other egw ip pools
 	egwList := &egress.EgressGatewayList{}
 	err = egw.Client.List(ctx, egwList)
@@ -197,6 +205,69 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 	return webhook.Allowed("checked")
 }
 
+func checkAnnouncement(announcement *egress.Announcement) error {
+	if announcement == nil {
+		return nil
+	}
+	checkNodes := func(field string, nodes []egress.NodeAnnouncement) error {
+		names := make(map[string]struct{}, len(nodes))
+		for _, node := range nodes {
+			if _, ok := names[node.Name]; ok {
+				return fmt.Errorf("duplicate node %s in %s", node.Name, field)
+			}
+			names[node.Name] = struct{}{}
+		}
+		return nil
+	}
+	if err := checkNodes("spec.announcement.nodes", announcement.Nodes); err != nil {
+		return err
+	}
+	for i, pool := range announcement.Pools {
+		for _, item := range pool.IPPools {
+			if _, _, err := net.ParseCIDR(item); err == nil {
+				continue
+			}
+			if !ip.IsIPv4IPRange(item) && !ip.IsIPv6IPRange(item) {
+				return fmt.Errorf("invalid IP range %s in spec.announcement.pools[%d].ippools", item, i)
+			}
+		}
+		if err := checkNodes(fmt.Sprintf("spec.announcement.pools[%d].nodes", i), pool.Nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkUplinks(uplinks []egress.Uplink) error {
+	for i, uplink := range uplinks {
+		for _, item := range uplink.IPPools {
+			if _, _, err := net.ParseCIDR(item); err == nil {
+				continue
+			}
+			if !ip.IsIPv4IPRange(item) && !ip.IsIPv6IPRange(item) {
+				return fmt.Errorf("invalid IP range %s in spec.uplinks[%d].ippools", item, i)
+			}
+		}
+		if uplink.Interface == "" {
+			return fmt.Errorf("spec.uplinks[%d].interface is empty", i)
+		}
+		if uplink.NextHopIPv4 == "" && uplink.NextHopIPv6 == "" {
+			return fmt.Errorf("spec.uplinks[%d] needs nextHopIPv4 or nextHopIPv6", i)
+		}
+		if uplink.NextHopIPv4 != "" {
+			if ok, _ := ip.IsIPv4(uplink.NextHopIPv4); !ok {
+				return fmt.Errorf("invalid IPv4 %s in spec.uplinks[%d].nextHopIPv4", uplink.NextHopIPv4, i)
+			}
+		}
+		if uplink.NextHopIPv6 != "" {
+			if ok, _ := ip.IsIPv6(uplink.NextHopIPv6); !ok {
+				return fmt.Errorf("invalid IPv6 %s in spec.uplinks[%d].nextHopIPv6", uplink.NextHopIPv6, i)
+			}
+		}
+	}
+	return nil
+}
+
 func buildClusterIPMap(egwList *egress.EgressGatewayList, skipName string) (map[string]map[string]struct{}, error) {
 	res := make(map[string]map[string]struct{})
 	for _, item := range egwList.Items {
@@ -0,0 +1,266 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spidernet-io/egressgateway/pkg/constant"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// assignPolicyEIPs completes the EIPs of a policy which already has its first
+// one: the extra EIPs and the reserved source ports. It reports whether the
+// gateway status changed.
+func assignPolicyEIPs(gateway *egress.EgressGateway, policyNs, policyName string, spec egress.EgressIP, assignedIP *AssignedIP) (bool, error) {
+	changed, err := assignExtraIPs(gateway, policyNs, policyName, spec)
+	if err != nil {
+		return false, err
+	}
+	portsChanged, err := assignSourcePorts(gateway, policyNs, policyName, spec.SourcePorts, assignedIP)
+	if err != nil {
+		return false, err
+	}
+	assignedIP.Assignments = listAssignments(gateway, policyNs, policyName)
+	return changed || portsChanged, nil
+}
+
+// assignExtraIPs places the EIPs of the policy beyond the first one, each on
+// the ready node with the fewest EIPs among the nodes which do not hold the
+// policy yet, so that the flows of the policy leave through several nodes.
+func assignExtraIPs(gateway *egress.EgressGateway, policyNs, policyName string, spec egress.EgressIP) (bool, error) {
+	current := policyEips(gateway, policyNs, policyName)
+	if len(current) == 0 || len(current) >= spec.EIPCount() {
+		return false, nil
+	}
+
+	changed := false
+	if len(spec.Additional) > 0 {
+		for _, eip := range spec.Additional {
+			if holdsEip(current, eip.Ipv4, eip.Ipv6) {
+				continue
+			}
+			if err := placeEip(gateway, policyNs, policyName, eip.Ipv4, eip.Ipv6); err != nil {
+				return changed, err
+			}
+			changed = true
+		}
+		return changed, nil
+	}
+
+	// the gateway node routes all the EIPs of a policy through one uplink,
+	// the extra EIPs are taken in the uplink of the first one
+	uplink, err := UplinkIndex(gateway.Spec.Uplinks, current[0].IPv4, current[0].IPv6)
+	if err != nil {
+		return false, err
+	}
+	for i := len(current); i < spec.EIPCount(); i++ {
+		ipv4, ipv6, err := freeIP(gateway, uplink)
+		if err != nil {
+			return changed, fmt.Errorf("EgressGateway %s does not have enough IPs to allocate for Policy %s/%s: %v",
+				gateway.Name, policyNs, policyName, err)
+		}
+		if err := placeEip(gateway, policyNs, policyName, ipv4, ipv6); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// placeEip adds the policy to the EIP, the EIP is put on a node first when no
+// node holds it.
+func placeEip(gateway *egress.EgressGateway, policyNs, policyName, ipv4, ipv6 string) error {
+	policy := egress.Policy{Name: policyName, Namespace: policyNs}
+	for nodeIndex, node := range gateway.Status.NodeList {
+		for eipIndex, eip := range node.Eips {
+			if (ipv4 != "" && eip.IPv4 == ipv4) || (ipv6 != "" && eip.IPv6 == ipv6) {
+				gateway.Status.NodeList[nodeIndex].Eips[eipIndex].Policies = append(
+					gateway.Status.NodeList[nodeIndex].Eips[eipIndex].Policies, policy)
+				return nil
+			}
+		}
+	}
+
+	nodeIndex := spreadNode(gateway, policyNs, policyName)
+	if nodeIndex == -1 {
+		return fmt.Errorf("EgressGateway %s does not have an available Node", gateway.Name)
+	}
+	gateway.Status.NodeList[nodeIndex].Eips = append(gateway.Status.NodeList[nodeIndex].Eips, egress.Eips{
+		IPv4:     ipv4,
+		IPv6:     ipv6,
+		Policies: []egress.Policy{policy},
+	})
+	return nil
+}
+
+// spreadNode returns the index of the ready node with the fewest EIPs,
+// preferring the nodes which do not hold the policy, or -1.
+func spreadNode(gateway *egress.EgressGateway, policyNs, policyName string) int {
+	best := -1
+	bestHolds := false
+	for i, node := range gateway.Status.NodeList {
+		if node.Status != string(egress.EgressTunnelReady) {
+			continue
+		}
+		holds := nodeHoldsPolicy(node, policyNs, policyName)
+		if best == -1 || (bestHolds && !holds) ||
+			(bestHolds == holds && len(node.Eips) < len(gateway.Status.NodeList[best].Eips)) {
+			best = i
+			bestHolds = holds
+		}
+	}
+	return best
+}
+
+func nodeHoldsPolicy(node egress.EgressIPStatus, policyNs, policyName string) bool {
+	for _, eip := range node.Eips {
+		for _, policy := range eip.Policies {
+			if policy.Namespace == policyNs && policy.Name == policyName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func holdsEip(eips []*egress.Eips, ipv4, ipv6 string) bool {
+	for _, eip := range eips {
+		if (ipv4 != "" && eip.IPv4 == ipv4) || (ipv6 != "" && eip.IPv6 == ipv6) {
+			return true
+		}
+	}
+	return false
+}
+
+// freeIP returns the first IPv4 and IPv6 of the ippools of the gateway in the
+// uplink, -1 for none, which are not used by any EIP.
+func freeIP(gateway *egress.EgressGateway, uplink int) (string, string, error) {
+	var used4, used6 []net.IP
+	for _, node := range gateway.Status.NodeList {
+		for _, eip := range node.Eips {
+			if eip.IPv4 != "" {
+				used4 = append(used4, net.ParseIP(eip.IPv4))
+			}
+			if eip.IPv6 != "" {
+				used6 = append(used6, net.ParseIP(eip.IPv6))
+			}
+		}
+	}
+
+	inUplink := func(item string) (bool, error) {
+		index, err := UplinkIndex(gateway.Spec.Uplinks, item)
+		return index == uplink, err
+	}
+	ipv4, err := firstFreeIP(constant.IPv4, gateway.Spec.Ippools.IPv4, used4, inUplink)
+	if err != nil {
+		return "", "", err
+	}
+	ipv6, err := firstFreeIP(constant.IPv6, gateway.Spec.Ippools.IPv6, used6, inUplink)
+	if err != nil {
+		return "", "", err
+	}
+	if ipv4 == "" && ipv6 == "" {
+		return "", "", fmt.Errorf("the ippools are empty")
+	}
+	return ipv4, ipv6, nil
+}
+
+func firstFreeIP(version constant.IPVersion, pool []string, used []net.IP, keep func(string) (bool, error)) (string, error) {
+	if len(pool) == 0 {
+		return "", nil
+	}
+	ranges, err := ip.MergeIPRanges(version, pool)
+	if err != nil {
+		return "", err
+	}
+	ips, err := ip.ParseIPRanges(version, ranges)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range ip.IPsDiffSet(ips, used, false) {
+		ok, err := keep(item.String())
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return item.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no free IPv%d", version)
+}
+
+// UplinkIndex returns the index of the first uplink including one of the IPs,
+// or -1, it is the uplink the gateway node routes the EIP through.
+func UplinkIndex(uplinks []egress.Uplink, ips ...string) (int, error) {
+	for i, uplink := range uplinks {
+		for _, item := range ips {
+			if item == "" {
+				continue
+			}
+			included, err := ip.CheckIPIncluded(item, uplink.IPPools)
+			if err != nil {
+				return -1, fmt.Errorf("failed to match EIP %s with uplink: %w", item, err)
+			}
+			if included {
+				return i, nil
+			}
+		}
+	}
+	return -1, nil
+}
+
+// CheckSameUplink returns an error when the EIPs are not all in the same
+// uplink, the gateway node routes all the EIPs of a policy through one uplink.
+func CheckSameUplink(uplinks []egress.Uplink, eips []egress.Eip) error {
+	first := 0
+	for i, eip := range eips {
+		index, err := UplinkIndex(uplinks, eip.Ipv4, eip.Ipv6)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			first = index
+			continue
+		}
+		if index != first {
+			return fmt.Errorf("the EIP %s is not in the same uplink as the EIP %s",
+				eipString(eip), eipString(eips[0]))
+		}
+	}
+	return nil
+}
+
+func eipString(eip egress.Eip) string {
+	if eip.Ipv4 != "" && eip.Ipv6 != "" {
+		return eip.Ipv4 + "/" + eip.Ipv6
+	}
+	return eip.Ipv4 + eip.Ipv6
+}
+
+// listAssignments returns the EIPs of a policy with more than one EIP.
+func listAssignments(gateway *egress.EgressGateway, policyNs, policyName string) []egress.EipAssignment {
+	var res []egress.EipAssignment
+	for _, node := range gateway.Status.NodeList {
+		for _, eip := range node.Eips {
+			for _, policy := range eip.Policies {
+				if policy.Namespace == policyNs && policy.Name == policyName {
+					res = append(res, egress.EipAssignment{
+						Ipv4:        eip.IPv4,
+						Ipv6:        eip.IPv6,
+						Node:        node.Name,
+						SourcePorts: eip.GetSourcePorts(policyNs, policyName),
+					})
+					break
+				}
+			}
+		}
+	}
+	if len(res) < 2 {
+		return nil
+	}
+	return res
+}
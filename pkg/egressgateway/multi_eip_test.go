@@ -0,0 +1,77 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var testUplinks = []egress.Uplink{
+	{Interface: "eth1", IPPools: []string{"10.6.1.21-10.6.1.22"}},
+	{Interface: "eth2", IPPools: []string{"10.6.2.21-10.6.2.23"}},
+}
+
+func TestUplinkIndex(t *testing.T) {
+	cases := map[string]struct {
+		ips []string
+		exp int
+	}{
+		"first uplink":  {ips: []string{"10.6.1.22"}, exp: 0},
+		"second uplink": {ips: []string{"", "10.6.2.21"}, exp: 1},
+		"no uplink":     {ips: []string{"10.6.3.21"}, exp: -1},
+		"empty":         {exp: -1},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			index, err := UplinkIndex(testUplinks, c.ips...)
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, index)
+		})
+	}
+}
+
+func TestCheckSameUplink(t *testing.T) {
+	assert.NoError(t, CheckSameUplink(testUplinks, []egress.Eip{{Ipv4: "10.6.2.21"}, {Ipv4: "10.6.2.23"}}))
+	assert.NoError(t, CheckSameUplink(testUplinks, []egress.Eip{{Ipv4: "10.6.3.21"}, {Ipv4: "10.6.3.22"}}))
+	assert.NoError(t, CheckSameUplink(nil, []egress.Eip{{Ipv4: "10.6.1.21"}, {Ipv4: "10.6.2.21"}}))
+
+	err := CheckSameUplink(testUplinks, []egress.Eip{{Ipv4: "10.6.1.21"}, {Ipv4: "10.6.2.21"}})
+	assert.EqualError(t, err, "the EIP 10.6.2.21 is not in the same uplink as the EIP 10.6.1.21")
+	err = CheckSameUplink(testUplinks, []egress.Eip{{Ipv4: "10.6.2.21"}, {Ipv4: "10.6.3.21"}})
+	assert.EqualError(t, err, "the EIP 10.6.3.21 is not in the same uplink as the EIP 10.6.2.21")
+}
+
+func TestAssignExtraIPsSameUplink(t *testing.T) {
+	policy := egress.Policy{Namespace: "default", Name: "p1"}
+	gateway := &egress.EgressGateway{
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.21-10.6.1.22", "10.6.2.21-10.6.2.23"}},
+			Uplinks: testUplinks,
+		},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{Name: "node1", Status: string(egress.EgressTunnelReady), Eips: []egress.Eips{
+				{IPv4: "10.6.2.22", Policies: []egress.Policy{policy}},
+			}},
+			{Name: "node2", Status: string(egress.EgressTunnelReady)},
+		}},
+	}
+
+	// the free IPs of eth1 come first, the extra EIPs are taken in eth2
+	changed, err := assignExtraIPs(gateway, policy.Namespace, policy.Name, egress.EgressIP{Count: 3})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	var eips []string
+	for _, eip := range policyEips(gateway, policy.Namespace, policy.Name) {
+		eips = append(eips, eip.IPv4)
+	}
+	assert.ElementsMatch(t, []string{"10.6.2.21", "10.6.2.22", "10.6.2.23"}, eips)
+
+	// eth2 has no free IP left
+	_, err = assignExtraIPs(gateway, policy.Namespace, policy.Name, egress.EgressIP{Count: 4})
+	assert.ErrorContains(t, err, "does not have enough IPs")
+}
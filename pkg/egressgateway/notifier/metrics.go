@@ -0,0 +1,29 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package notifier
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	countDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "notifier",
+		Name:      "deliveries_total",
+		Help:      "Number of notifications posted to the webhooks of the EgressNotifier",
+	}, []string{"notifier"})
+
+	countErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "notifier",
+		Name:      "errors_total",
+		Help:      "Number of notifications which could not be posted to a webhook of the EgressNotifier after the retries",
+	}, []string{"notifier"})
+)
+
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		countDeliveries,
+		countErrors,
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package notifier posts the changes of the EIP assignments to the webhooks of
+// the EgressNotifiers, so that the firewalls and allowlists outside the cluster
+// can follow the EIPs of the policies.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway/audit"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// HeaderDelivery is the ID of the notification, it is the same for the
+	// retries of a post
+	HeaderDelivery = "X-Egressgateway-Delivery"
+	// HeaderSignature is the HMAC-SHA256 of the body as sha256=<hex>
+	HeaderSignature = "X-Egressgateway-Signature"
+	// ReasonNotificationFailed is the reason of the Event of the EgressNotifier
+	// whose webhook could not be notified
+	ReasonNotificationFailed = "NotificationFailed"
+
+	queueSize      = 1024
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 3
+	defaultKey     = "key"
+)
+
+// Notification is the body posted to the webhooks, with the changes of the EIP
+// assignments written at once.
+type Notification struct {
+	ID       string         `json:"id"`
+	Notifier string         `json:"notifier"`
+	Time     time.Time      `json:"time"`
+	Records  []audit.Record `json:"records"`
+}
+
+type delivery struct {
+	notifier *egress.EgressNotifier
+	webhook  egress.NotifierWebhook
+	id       string
+	body     []byte
+}
+
+// Notifier posts the records of the EgressNotifiers they match. The posts are
+// made in order by a single worker, a failed post is retried with an
+// exponential backoff before the next one.
+type Notifier struct {
+	log      logr.Logger
+	reader   client.Reader
+	secrets  client.Reader
+	recorder record.EventRecorder
+	queue    chan delivery
+	// backoff is the delay before the first retry, doubled at each retry
+	backoff time.Duration
+	now     func() time.Time
+	// namespace is the namespace of the Secrets of the HMAC keys, the one
+	// of the controller
+	namespace string
+}
+
+// New returns the notifier, reader gets the EgressNotifiers and secrets gets
+// the Secrets of the HMAC keys, which are only read from namespace.
+func New(log logr.Logger, reader, secrets client.Reader, namespace string, recorder record.EventRecorder) *Notifier {
+	return &Notifier{
+		log:       log.WithName("notifier"),
+		reader:    reader,
+		secrets:   secrets,
+		namespace: namespace,
+		recorder:  recorder,
+		queue:     make(chan delivery, queueSize),
+		backoff:   time.Second,
+		now:       time.Now,
+	}
+}
+
+// Notify queues a notification of the records for each webhook of the
+// EgressNotifiers which match them.
+func (n *Notifier) Notify(ctx context.Context, records []audit.Record) {
+	if len(records) == 0 {
+		return
+	}
+	list := new(egress.EgressNotifierList)
+	if err := n.reader.List(ctx, list); err != nil {
+		n.log.Error(err, "failed to list EgressNotifiers, the EIP changes are not notified", "records", len(records))
+		return
+	}
+	for i := range list.Items {
+		notifier := &list.Items[i]
+		matched := make([]audit.Record, 0, len(records))
+		for _, item := range records {
+			if match(&notifier.Spec, item) {
+				matched = append(matched, item)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		notification := Notification{
+			ID:       string(uuid.NewUUID()),
+			Notifier: notifier.Name,
+			Time:     n.now(),
+			Records:  matched,
+		}
+		body, err := json.Marshal(notification)
+		if err != nil {
+			n.log.Error(err, "failed to encode the notification", "notifier", notifier.Name)
+			continue
+		}
+		for _, webhook := range notifier.Spec.Webhooks {
+			select {
+			case n.queue <- delivery{notifier: notifier, webhook: webhook, id: notification.ID, body: body}:
+			default:
+				countErrors.WithLabelValues(notifier.Name).Inc()
+				n.log.Error(nil, "notification queue is full, the notification is dropped",
+					"notifier", notifier.Name, "url", webhook.URL)
+			}
+		}
+	}
+}
+
+// match returns true when the EgressNotifier is notified of the record.
+func match(spec *egress.EgressNotifierSpec, item audit.Record) bool {
+	if len(spec.Gateways) > 0 && !contains(spec.Gateways, item.Gateway) {
+		return false
+	}
+	// an EgressClusterPolicy has no namespace
+	if len(spec.Namespaces) > 0 && (item.PolicyNamespace == "" || !contains(spec.Namespaces, item.PolicyNamespace)) {
+		return false
+	}
+	if len(spec.Actions) > 0 {
+		for _, action := range spec.Actions {
+			if string(action) == string(item.Action) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Start posts the queued notifications.
+func (n *Notifier) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-n.queue:
+			if err := n.deliver(ctx, d); err != nil {
+				countErrors.WithLabelValues(d.notifier.Name).Inc()
+				n.log.Error(err, "failed to notify the webhook", "notifier", d.notifier.Name, "url", d.webhook.URL, "id", d.id)
+				if n.recorder != nil {
+					n.recorder.Eventf(d.notifier, corev1.EventTypeWarning, ReasonNotificationFailed,
+						"failed to post notification %s to %s: %v", d.id, d.webhook.URL, err)
+				}
+				continue
+			}
+			countDeliveries.WithLabelValues(d.notifier.Name).Inc()
+		}
+	}
+}
+
+// deliver posts the notification, and retries it after the errors which may
+// be temporary.
+func (n *Notifier) deliver(ctx context.Context, d delivery) error {
+	retries := defaultRetries
+	if d.webhook.MaxRetries != nil {
+		retries = int(*d.webhook.MaxRetries)
+	}
+	delay := n.backoff
+	for i := 0; ; i++ {
+		retry, err := n.post(ctx, d)
+		if err == nil || !retry || i >= retries {
+			return err
+		}
+		n.log.V(1).Info("failed to notify the webhook, retry", "url", d.webhook.URL, "id", d.id, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post posts the notification once, retry is true when the error may be
+// temporary.
+func (n *Notifier) post(ctx context.Context, d delivery) (retry bool, err error) {
+	timeout := defaultTimeout
+	if d.webhook.TimeoutSeconds > 0 {
+		timeout = time.Duration(d.webhook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.webhook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, d.id)
+	if ref := d.webhook.HMACSecretRef; ref != nil {
+		key, err := n.secretKey(ctx, ref)
+		if err != nil {
+			return true, err
+		}
+		req.Header.Set(HeaderSignature, Signature(key, d.body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook responded %s", resp.Status)
+}
+
+// secretKey reads the HMAC key, the Secret is read at each post so that the
+// key can be rotated. Only the Secrets of the namespace of the controller are
+// read, the EgressNotifiers are cluster scoped and must not expose the others.
+func (n *Notifier) secretKey(ctx context.Context, ref *egress.SecretKeyRef) ([]byte, error) {
+	if ref.Namespace != "" && ref.Namespace != n.namespace {
+		return nil, fmt.Errorf("HMAC secret %s/%s is not in the namespace %s", ref.Namespace, ref.Name, n.namespace)
+	}
+	secret := new(corev1.Secret)
+	if err := n.secrets.Get(ctx, types.NamespacedName{Namespace: n.namespace, Name: ref.Name}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get the HMAC secret %s/%s: %w", n.namespace, ref.Name, err)
+	}
+	name := ref.Key
+	if name == "" {
+		name = defaultKey
+	}
+	key, ok := secret.Data[name]
+	if !ok || len(key) == 0 {
+		return nil, fmt.Errorf("HMAC secret %s/%s has no key %s", n.namespace, ref.Name, name)
+	}
+	return key, nil
+}
+
+// Signature returns the value of the signature header of the body, the
+// receivers compare it with the one computed from the body they received.
+func Signature(key, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
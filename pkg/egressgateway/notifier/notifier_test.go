@@ -0,0 +1,209 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway/audit"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+var (
+	now     = time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	assign  = audit.Record{Time: now, Action: audit.ActionAssign, Gateway: "default", PolicyNamespace: "tenant-a", Policy: "policy-a", IPv4: "10.6.1.21", NewNode: "node1", Reason: audit.ReasonPolicyChanged}
+	move    = audit.Record{Time: now, Action: audit.ActionMove, Gateway: "default", PolicyNamespace: "tenant-b", Policy: "policy-b", IPv4: "10.6.1.22", OldNode: "node1", NewNode: "node2", Reason: audit.ReasonNodeNotReady}
+	release = audit.Record{Time: now, Action: audit.ActionRelease, Gateway: "other", Policy: "cluster-policy", IPv4: "10.6.2.21", OldNode: "node3", Reason: audit.ReasonPolicyDeleted}
+)
+
+func TestMatch(t *testing.T) {
+	cases := map[string]struct {
+		spec egress.EgressNotifierSpec
+		exp  []audit.Record
+	}{
+		"all": {
+			exp: []audit.Record{assign, move, release},
+		},
+		"by gateway": {
+			spec: egress.EgressNotifierSpec{Gateways: []string{"default"}},
+			exp:  []audit.Record{assign, move},
+		},
+		"by namespace without cluster policies": {
+			spec: egress.EgressNotifierSpec{Namespaces: []string{"tenant-b"}},
+			exp:  []audit.Record{move},
+		},
+		"by action": {
+			spec: egress.EgressNotifierSpec{Actions: []egress.NotifierAction{egress.NotifierActionAssign, egress.NotifierActionRelease}},
+			exp:  []audit.Record{assign, release},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			res := make([]audit.Record, 0)
+			for _, item := range []audit.Record{assign, move, release} {
+				if match(&tc.spec, item) {
+					res = append(res, item)
+				}
+			}
+			assert.Equal(t, tc.exp, res)
+		})
+	}
+}
+
+type request struct {
+	delivery  string
+	signature string
+	body      []byte
+}
+
+// webhook is a local HTTP server which responds the status codes in order,
+// then 200.
+type webhook struct {
+	mutex    sync.Mutex
+	codes    []int
+	requests []request
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.requests = append(w.requests, request{
+		delivery:  req.Header.Get(HeaderDelivery),
+		signature: req.Header.Get(HeaderSignature),
+		body:      body,
+	})
+	code := http.StatusOK
+	if len(w.codes) > 0 {
+		code, w.codes = w.codes[0], w.codes[1:]
+	}
+	rw.WriteHeader(code)
+}
+
+func newNotifier(objs ...*egress.EgressNotifier) *Notifier {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "firewall-hmac"},
+		Data:       map[string][]byte{"key": []byte("s3cret")},
+	}
+	builder := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(secret)
+	for _, obj := range objs {
+		builder = builder.WithObjects(obj)
+	}
+	cli := builder.Build()
+	n := New(logr.Discard(), cli, cli, "kube-system", nil)
+	n.backoff = time.Millisecond
+	n.now = func() time.Time { return now }
+	return n
+}
+
+func notifierObject(name, url string, spec egress.EgressNotifierSpec) *egress.EgressNotifier {
+	spec.Webhooks = []egress.NotifierWebhook{{URL: url, HMACSecretRef: &egress.SecretKeyRef{Name: "firewall-hmac"}}}
+	return &egress.EgressNotifier{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+}
+
+func TestNotify(t *testing.T) {
+	target := &webhook{codes: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(target)
+	defer server.Close()
+
+	n := newNotifier(
+		notifierObject("firewall", server.URL, egress.EgressNotifierSpec{Gateways: []string{"default"}}),
+		notifierObject("partner", server.URL, egress.EgressNotifierSpec{Namespaces: []string{"tenant-c"}}),
+	)
+	ctx := context.Background()
+	n.Notify(ctx, []audit.Record{assign, move, release})
+	assert.Len(t, n.queue, 1)
+	assert.NoError(t, n.deliver(ctx, <-n.queue))
+
+	// retried twice with the same delivery ID
+	assert.Len(t, target.requests, 3)
+	first := target.requests[0]
+	for _, req := range target.requests {
+		assert.Equal(t, first.delivery, req.delivery)
+		assert.Equal(t, first.body, req.body)
+	}
+	assert.Equal(t, Signature([]byte("s3cret"), first.body), first.signature)
+
+	notification := Notification{}
+	assert.NoError(t, json.Unmarshal(first.body, &notification))
+	assert.Equal(t, first.delivery, notification.ID)
+	assert.Equal(t, "firewall", notification.Notifier)
+	assert.Equal(t, []audit.Record{assign, move}, notification.Records)
+}
+
+func TestDeliverErrors(t *testing.T) {
+	cases := map[string]struct {
+		codes   []int
+		retries int32
+		secret  string
+		// namespace is the namespace of the HMAC secret ref
+		namespace string
+		requests  int
+		expErr    string
+	}{
+		"no retry after a client error": {
+			codes:    []int{http.StatusBadRequest},
+			retries:  3,
+			requests: 1,
+			expErr:   "webhook responded 400 Bad Request",
+		},
+		"retries exhausted": {
+			codes:    []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			retries:  2,
+			requests: 3,
+			expErr:   "webhook responded 502 Bad Gateway",
+		},
+		"missing secret": {
+			secret:   "missing",
+			requests: 0,
+			expErr:   `failed to get the HMAC secret kube-system/missing: secrets "missing" not found`,
+		},
+		"secret in another namespace": {
+			namespace: "default",
+			requests:  0,
+			expErr:    "HMAC secret default/firewall-hmac is not in the namespace kube-system",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			target := &webhook{codes: tc.codes}
+			server := httptest.NewServer(target)
+			defer server.Close()
+
+			obj := notifierObject("firewall", server.URL, egress.EgressNotifierSpec{})
+			obj.Spec.Webhooks[0].MaxRetries = ptr.To(tc.retries)
+			if tc.secret != "" {
+				obj.Spec.Webhooks[0].HMACSecretRef.Name = tc.secret
+			}
+			if tc.namespace != "" {
+				obj.Spec.Webhooks[0].HMACSecretRef.Namespace = tc.namespace
+			}
+			n := newNotifier(obj)
+			n.Notify(context.Background(), []audit.Record{assign})
+			err := n.deliver(context.Background(), <-n.queue)
+			assert.EqualError(t, err, tc.expErr)
+			assert.Len(t, target.requests, tc.requests)
+		})
+	}
+}
+
+func TestSignature(t *testing.T) {
+	assert.Equal(t, "sha256=06988fa1cf02b8383043f7f2735f723f7bb350950d9214409cde13490d6a6373",
+		Signature([]byte("s3cret"), []byte(`{"id":"1"}`)))
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"fmt"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+// assignSourcePorts reserves count source ports of each EIP of the policy, so
+// that the policies sharing an EIP SNAT to disjoint port ranges, the policies
+// without reservation SNAT to the largest range left, and sets the
+// range of the first EIP to assignedIP. It reports whether the gateway status
+// changed.
+func assignSourcePorts(gateway *egress.EgressGateway, policyNs, policyName string, count int, assignedIP *AssignedIP) (bool, error) {
+	changed := false
+	first := true
+	for _, eips := range policyEips(gateway, policyNs, policyName) {
+		if eips.IPv4 == "" && eips.IPv6 == "" {
+			continue
+		}
+		ports, reserved, err := reserveSourcePorts(eips, policyNs, policyName, count)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || reserved
+		if first {
+			assignedIP.SourcePorts = ports
+			first = false
+		}
+	}
+	return changed, nil
+}
+
+// reserveSourcePorts makes the range of the policy on the EIP count ports
+// long, it returns the range and whether it changed.
+func reserveSourcePorts(eips *egress.Eips, policyNs, policyName string, count int) (string, bool, error) {
+	current := eips.GetSourcePorts(policyNs, policyName)
+	if current != "" {
+		start, end, err := utils.ParsePortRange(current)
+		if err == nil && end-start+1 == count {
+			return current, false, nil
+		}
+	}
+	changed := releaseSourcePorts(eips, policyNs, policyName)
+	if count == 0 {
+		return "", changed, nil
+	}
+
+	used := make([]string, 0, len(eips.SourcePorts))
+	for _, item := range eips.SourcePorts {
+		used = append(used, item.Ports)
+	}
+	ports, err := utils.AllocatePortRange(used, count, utils.SourcePortMin, utils.SourcePortMax)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to reserve %d source ports of EIP %s%s for policy %s/%s: %v",
+			count, eips.IPv4, eips.IPv6, policyNs, policyName, err)
+	}
+	if unreserved(eips, policyNs, policyName) {
+		free, err := utils.FreePortRange(append(used, ports), utils.SourcePortMin, utils.SourcePortMax)
+		if err != nil {
+			return "", false, err
+		}
+		if free == "" {
+			return "", false, fmt.Errorf("failed to reserve %d source ports of EIP %s%s for policy %s/%s: no port would be left to the policies without reservation",
+				count, eips.IPv4, eips.IPv6, policyNs, policyName)
+		}
+	}
+	eips.SourcePorts = append(eips.SourcePorts, egress.PolicySourcePorts{
+		Name: policyName, Namespace: policyNs, Ports: ports,
+	})
+	return ports, true, nil
+}
+
+// releaseSourcePorts removes the source ports reserved by the policy, it
+// reports whether there were any.
+func releaseSourcePorts(eips *egress.Eips, policyNs, policyName string) bool {
+	for i, item := range eips.SourcePorts {
+		if item.Namespace == policyNs && item.Name == policyName {
+			eips.SourcePorts = append(eips.SourcePorts[:i], eips.SourcePorts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// unreserved reports whether a policy other than the given one uses the EIP
+// without reserved source ports.
+func unreserved(eips *egress.Eips, policyNs, policyName string) bool {
+	for _, policy := range eips.Policies {
+		if policy.Namespace == policyNs && policy.Name == policyName {
+			continue
+		}
+		if eips.GetSourcePorts(policy.Namespace, policy.Name) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// policyEips returns the EIPs of the policy in the order of the node list.
+func policyEips(gateway *egress.EgressGateway, policyNs, policyName string) []*egress.Eips {
+	var res []*egress.Eips
+	for nodeIndex, node := range gateway.Status.NodeList {
+		for eipIndex, eip := range node.Eips {
+			for _, policy := range eip.Policies {
+				if policy.Namespace == policyNs && policy.Name == policyName {
+					res = append(res, &gateway.Status.NodeList[nodeIndex].Eips[eipIndex])
+					break
+				}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,91 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestReserveSourcePorts(t *testing.T) {
+	eips := &egress.Eips{
+		IPv4:     "10.6.1.21",
+		Policies: []egress.Policy{{Namespace: "default", Name: "p1"}, {Namespace: "default", Name: "p2"}},
+	}
+
+	// reserve
+	ports, changed, err := reserveSourcePorts(eips, "default", "p1", 1000)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "1024-2023", ports)
+	ports, _, err = reserveSourcePorts(eips, "default", "p2", 500)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-2523", ports)
+
+	// the same count keeps the range
+	ports, changed, err = reserveSourcePorts(eips, "default", "p1", 1000)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "1024-2023", ports)
+
+	// resize, the range is reserved again
+	ports, changed, err = reserveSourcePorts(eips, "default", "p1", 2000)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "2524-4523", ports)
+	ports, _, err = reserveSourcePorts(eips, "default", "p1", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, "1024-2023", ports)
+
+	// release
+	_, changed, err = reserveSourcePorts(eips, "default", "p1", 0)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []egress.PolicySourcePorts{{Namespace: "default", Name: "p2", Ports: "2024-2523"}}, eips.SourcePorts)
+	assert.False(t, releaseSourcePorts(eips, "default", "p1"))
+
+	// exhaustion
+	_, _, err = reserveSourcePorts(eips, "default", "p1", 64512)
+	assert.ErrorContains(t, err, "failed to reserve 64512 source ports")
+}
+
+func TestReserveSourcePortsUnreserved(t *testing.T) {
+	eips := &egress.Eips{
+		IPv4:     "10.6.1.21",
+		Policies: []egress.Policy{{Namespace: "default", Name: "p1"}, {Namespace: "default", Name: "p2"}},
+	}
+
+	// p2 SNATs to the ports left by p1, all of them cannot be reserved
+	_, _, err := reserveSourcePorts(eips, "default", "p1", 64512)
+	assert.ErrorContains(t, err, "no port would be left to the policies without reservation")
+	ports, _, err := reserveSourcePorts(eips, "default", "p1", 64511)
+	assert.NoError(t, err)
+	assert.Equal(t, "1024-65534", ports)
+}
+
+func TestAssignSourcePorts(t *testing.T) {
+	policy := egress.Policy{Namespace: "default", Name: "p1"}
+	gateway := &egress.EgressGateway{
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+			{Name: "node1", Eips: []egress.Eips{{IPv4: "10.6.1.21", Policies: []egress.Policy{policy}}}},
+			{Name: "node2", Eips: []egress.Eips{{IPv4: "10.6.1.22", Policies: []egress.Policy{policy}}}},
+		}},
+	}
+
+	assignedIP := &AssignedIP{}
+	changed, err := assignSourcePorts(gateway, "default", "p1", 100, assignedIP)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "1024-1123", assignedIP.SourcePorts)
+	for _, node := range gateway.Status.NodeList {
+		assert.Equal(t, "1024-1123", node.Eips[0].GetSourcePorts("default", "p1"))
+	}
+
+	changed, err = assignSourcePorts(gateway, "default", "p1", 100, assignedIP)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
@@ -0,0 +1,278 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eiplease
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Fence renews the Leases of the EIPs assigned to the node. An EIP is owned
+// while its Lease was renewed within the renew deadline, the datapath only
+// announces and SNATs the owned EIPs.
+type Fence struct {
+	client    client.Client
+	reader    client.Reader
+	log       logr.Logger
+	namespace string
+	holder    string
+
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+	now           func() time.Time
+
+	mu sync.RWMutex
+	// renewed is the local time of the last successful renewal of each EIP,
+	// taken before the request so that the local deadline is conservative
+	renewed map[string]time.Time
+	// state is the ownership of each EIP the subscribers were notified of
+	state       map[string]*ownership
+	subscribers []chan event.GenericEvent
+}
+
+type ownership struct {
+	gateway *egressv1.EgressGateway
+	owned   bool
+}
+
+// NewFence returns the fence of the node, cli writes the Leases and reader
+// reads them without a cache.
+func NewFence(cli client.Client, reader client.Reader, log logr.Logger, cfg *config.Config) *Fence {
+	lease := cfg.FileConfig.EIPLease
+	return &Fence{
+		client:        cli,
+		reader:        reader,
+		log:           log.WithName("eipLease"),
+		namespace:     cfg.PodNamespace,
+		holder:        cfg.NodeName,
+		leaseDuration: time.Duration(lease.LeaseDurationSecond) * time.Second,
+		renewDeadline: time.Duration(lease.RenewDeadlineSecond) * time.Second,
+		retryPeriod:   time.Duration(lease.RetryPeriodSecond) * time.Second,
+		now:           time.Now,
+		renewed:       make(map[string]time.Time),
+		state:         make(map[string]*ownership),
+	}
+}
+
+// Owns reports whether the node may announce and SNAT eip, it is always true
+// for a nil fence.
+func (f *Fence) Owns(eip string) bool {
+	if f == nil {
+		return true
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.owns(eip)
+}
+
+func (f *Fence) owns(eip string) bool {
+	renewed, ok := f.renewed[eip]
+	return ok && f.now().Sub(renewed) < f.renewDeadline
+}
+
+// Subscribe returns a channel receiving the EgressGateway of an EIP whose
+// ownership changed, it must be called before the fence starts.
+func (f *Fence) Subscribe() <-chan event.GenericEvent {
+	ch := make(chan event.GenericEvent, 16)
+	f.subscribers = append(f.subscribers, ch)
+	return ch
+}
+
+func (f *Fence) Start(ctx context.Context) error {
+	ticker := time.NewTicker(f.retryPeriod)
+	defer ticker.Stop()
+	for {
+		changed, err := f.sync(ctx)
+		if err != nil {
+			f.log.Error(err, "failed to renew EIP leases")
+		}
+		for _, egw := range changed {
+			for _, ch := range f.subscribers {
+				select {
+				case ch <- event.GenericEvent{Object: egw}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync renews the leases of the EIPs of the node and releases the others, it
+// returns the gateways of the EIPs whose ownership changed.
+func (f *Fence) sync(ctx context.Context) ([]*egressv1.EgressGateway, error) {
+	egwList := new(egressv1.EgressGatewayList)
+	if err := f.client.List(ctx, egwList); err != nil {
+		// the leases can not be renewed either, let the ownership expire
+		return f.notify(nil), fmt.Errorf("failed to list EgressGateway: %w", err)
+	}
+
+	desired := make(map[string]*egressv1.EgressGateway)
+	for i := range egwList.Items {
+		for _, eip := range egwList.Items[i].Status.GetNodeIPs(f.holder) {
+			for _, item := range []string{eip.IPv4, eip.IPv6} {
+				if addr := net.ParseIP(item); addr != nil {
+					desired[addr.String()] = &egwList.Items[i]
+				}
+			}
+		}
+	}
+
+	var firstErr error
+	for eip := range desired {
+		if err := f.renew(ctx, eip); err != nil {
+			f.log.Error(err, "failed to renew EIP lease", "eip", eip)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	f.mu.RLock()
+	released := make([]string, 0)
+	for eip := range f.renewed {
+		if _, ok := desired[eip]; !ok {
+			released = append(released, eip)
+		}
+	}
+	f.mu.RUnlock()
+	for _, eip := range released {
+		// the EIP moved away, the datapath follows the gateway status
+		f.mu.Lock()
+		delete(f.renewed, eip)
+		delete(f.state, eip)
+		f.mu.Unlock()
+		if err := f.release(ctx, eip); err != nil {
+			f.log.Error(err, "failed to release EIP lease", "eip", eip)
+		}
+	}
+
+	return f.notify(desired), firstErr
+}
+
+// notify records the ownership of the EIPs and the gateways they belong to,
+// and returns the gateways of the EIPs whose ownership changed.
+func (f *Fence) notify(desired map[string]*egressv1.EgressGateway) []*egressv1.EgressGateway {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for eip, egw := range desired {
+		if state, ok := f.state[eip]; ok {
+			state.gateway = egw
+			continue
+		}
+		f.state[eip] = &ownership{gateway: egw}
+	}
+
+	res := make([]*egressv1.EgressGateway, 0)
+	seen := make(map[string]struct{})
+	for eip, state := range f.state {
+		owned := f.owns(eip)
+		if state.owned == owned {
+			continue
+		}
+		state.owned = owned
+		if owned {
+			f.log.Info("acquired lease of EIP", "eip", eip)
+		} else {
+			f.log.Info("lease of EIP is not renewed, stop using it", "eip", eip)
+		}
+		if _, ok := seen[state.gateway.Name]; !ok {
+			seen[state.gateway.Name] = struct{}{}
+			res = append(res, state.gateway)
+		}
+	}
+	return res
+}
+
+// renew acquires or renews the lease of eip, a lease held by another node is
+// only taken once it expired.
+func (f *Fence) renew(ctx context.Context, eip string) error {
+	start := f.now()
+	key := types.NamespacedName{Namespace: f.namespace, Name: Name(eip)}
+	lease := new(coordinationv1.Lease)
+	err := f.reader.Get(ctx, key, lease)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(f.holder),
+				LeaseDurationSeconds: ptr.To(int32(f.leaseDuration / time.Second)),
+				AcquireTime:          &metav1.MicroTime{Time: start},
+				RenewTime:            &metav1.MicroTime{Time: start},
+			},
+		}
+		if err := f.client.Create(ctx, lease); err != nil {
+			return err
+		}
+		f.setRenewed(eip, start)
+		return nil
+	}
+
+	if holder := Holder(lease); holder != f.holder {
+		if !Expired(lease, start) {
+			f.mu.Lock()
+			delete(f.renewed, eip)
+			f.mu.Unlock()
+			f.log.V(1).Info("lease of EIP is held by another node", "eip", eip, "holder", holder)
+			return nil
+		}
+		lease.Spec.HolderIdentity = ptr.To(f.holder)
+		lease.Spec.AcquireTime = &metav1.MicroTime{Time: start}
+		lease.Spec.LeaseTransitions = ptr.To(ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1)
+	}
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(f.leaseDuration / time.Second))
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: start}
+	if err := f.client.Update(ctx, lease); err != nil {
+		return err
+	}
+	f.setRenewed(eip, start)
+	return nil
+}
+
+// release gives up the lease of eip, so that the new owner does not wait for it
+// to expire.
+func (f *Fence) release(ctx context.Context, eip string) error {
+	lease := new(coordinationv1.Lease)
+	err := f.reader.Get(ctx, types.NamespacedName{Namespace: f.namespace, Name: Name(eip)}, lease)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if Holder(lease) != f.holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	return f.client.Update(ctx, lease)
+}
+
+func (f *Fence) setRenewed(eip string, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renewed[eip] = t
+}
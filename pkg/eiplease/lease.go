@@ -0,0 +1,78 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package eiplease fences the EIP ownership with a coordination.k8s.io Lease
+// per EIP. The agent of the gateway node renews the Leases of its EIPs and
+// stops using an EIP when it cannot renew, the controller moves the EIPs of a
+// not ready node only after their Leases expired.
+package eiplease
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const namePrefix = "egress-eip-"
+
+// Name returns the name of the Lease of eip, the dotted form for IPv4 and the
+// hex form for IPv6.
+func Name(eip string) string {
+	addr := net.ParseIP(eip)
+	if addr == nil {
+		return namePrefix + strings.ToLower(eip)
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return namePrefix + strings.ReplaceAll(v4.String(), ".", "-")
+	}
+	return namePrefix + hex.EncodeToString(addr.To16())
+}
+
+// Holder returns the holder of the lease, empty when it is released.
+func Holder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// Remaining returns the time until the lease of holder expires, 0 when it is
+// expired or not held by holder.
+func Remaining(lease *coordinationv1.Lease, holder string, now time.Time) time.Duration {
+	if Holder(lease) != holder || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return 0
+	}
+	expire := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	if !expire.After(now) {
+		return 0
+	}
+	return expire.Sub(now)
+}
+
+// Expired reports whether another holder can take the lease.
+func Expired(lease *coordinationv1.Lease, now time.Time) bool {
+	holder := Holder(lease)
+	return holder == "" || Remaining(lease, holder, now) == 0
+}
+
+// HeldBy returns the time until the lease of eip held by holder expires, 0
+// when there is no such lease.
+func HeldBy(ctx context.Context, reader client.Reader, namespace, holder, eip string, now time.Time) (time.Duration, error) {
+	lease := new(coordinationv1.Lease)
+	err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: Name(eip)}, lease)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get lease of EIP %s: %w", eip, err)
+	}
+	return Remaining(lease, holder, now), nil
+}
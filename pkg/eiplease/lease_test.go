@@ -0,0 +1,224 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eiplease
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestName(t *testing.T) {
+	cases := map[string]string{
+		"10.6.1.21": "egress-eip-10-6-1-21",
+		"fd00::21":  "egress-eip-fd000000000000000000000000000021",
+		"fd00::":    "egress-eip-fd000000000000000000000000000000",
+	}
+	for eip, expected := range cases {
+		assert.Equal(t, expected, Name(eip), eip)
+	}
+}
+
+func newLease(eip, holder string, renew time.Time) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "egress", Name: Name(eip)},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: ptr.To(int32(15)),
+			RenewTime:            &metav1.MicroTime{Time: renew},
+		},
+	}
+	if holder != "" {
+		lease.Spec.HolderIdentity = ptr.To(holder)
+	}
+	return lease
+}
+
+func TestRemaining(t *testing.T) {
+	now := time.Now()
+	cases := map[string]struct {
+		lease     *coordinationv1.Lease
+		remaining time.Duration
+		expired   bool
+	}{
+		"renewed":     {lease: newLease("10.6.1.21", "node1", now.Add(-5*time.Second)), remaining: 10 * time.Second},
+		"expired":     {lease: newLease("10.6.1.21", "node1", now.Add(-20*time.Second)), expired: true},
+		"other node":  {lease: newLease("10.6.1.21", "node2", now)},
+		"released":    {lease: newLease("10.6.1.21", "", now), expired: true},
+		"never renew": {lease: &coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{HolderIdentity: ptr.To("node1")}}, expired: true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.remaining, Remaining(tc.lease, "node1", now))
+			assert.Equal(t, tc.expired, Expired(tc.lease, now))
+		})
+	}
+}
+
+func newFence(cli client.Client, now *time.Time) *Fence {
+	cfg := &config.Config{}
+	cfg.PodNamespace = "egress"
+	cfg.NodeName = "node1"
+	cfg.FileConfig.EIPLease = config.EIPLease{Enable: true, LeaseDurationSecond: 15, RenewDeadlineSecond: 10, RetryPeriodSecond: 2}
+	f := NewFence(cli, cli, logr.Discard(), cfg)
+	f.now = func() time.Time { return *now }
+	return f
+}
+
+func newGateway(name string, eips ...egressv1.Eips) *egressv1.EgressGateway {
+	return &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: egressv1.EgressGatewayStatus{
+			NodeList: []egressv1.EgressIPStatus{{Name: "node1", Eips: eips}},
+		},
+	}
+}
+
+func gatewayNames(list []*egressv1.EgressGateway) []string {
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		res = append(res, item.Name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestFenceSync(t *testing.T) {
+	now := time.Now()
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(
+		newGateway("egw1", egressv1.Eips{IPv4: "10.6.1.21", IPv6: "fd00::21"}),
+		newGateway("egw2", egressv1.Eips{IPv4: "10.6.1.31"}, egressv1.Eips{}),
+		newLease("10.6.1.31", "node2", now.Add(-5*time.Second)),
+	).Build()
+	f := newFence(cli, &now)
+	ctx := context.Background()
+
+	// new leases are acquired, a lease renewed by another node is not taken
+	changed, err := f.sync(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"egw1"}, gatewayNames(changed))
+	assert.True(t, f.Owns("10.6.1.21"))
+	assert.True(t, f.Owns("fd00::21"))
+	assert.False(t, f.Owns("10.6.1.31"))
+
+	lease := new(coordinationv1.Lease)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "egress", Name: Name("10.6.1.21")}, lease))
+	assert.Equal(t, "node1", Holder(lease))
+
+	// nothing changes while the leases are renewed
+	now = now.Add(2 * time.Second)
+	changed, err = f.sync(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+
+	// the lease of the other node expired
+	now = now.Add(10 * time.Second)
+	changed, err = f.sync(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"egw2"}, gatewayNames(changed))
+	assert.True(t, f.Owns("10.6.1.31"))
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "egress", Name: Name("10.6.1.31")}, lease))
+	assert.Equal(t, "node1", Holder(lease))
+	assert.Equal(t, int32(1), ptr.Deref(lease.Spec.LeaseTransitions, 0))
+
+	// the EIP moved to another node, its lease is released
+	egw := new(egressv1.EgressGateway)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "egw2"}, egw))
+	egw.Status.NodeList[0].Name = "node2"
+	assert.NoError(t, cli.Update(ctx, egw))
+	changed, err = f.sync(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.False(t, f.Owns("10.6.1.31"))
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Namespace: "egress", Name: Name("10.6.1.31")}, lease))
+	assert.Equal(t, "", Holder(lease))
+}
+
+func TestFenceRenewFailed(t *testing.T) {
+	now := time.Now()
+	failed := false
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).
+		WithObjects(newGateway("egw1", egressv1.Eips{IPv4: "10.6.1.21"})).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, cli client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if failed {
+					return errors.New("apiserver unreachable")
+				}
+				return cli.List(ctx, list, opts...)
+			},
+			Update: func(ctx context.Context, cli client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if failed {
+					return errors.New("apiserver unreachable")
+				}
+				return cli.Update(ctx, obj, opts...)
+			},
+		}).Build()
+	f := newFence(cli, &now)
+	ctx := context.Background()
+
+	_, err := f.sync(ctx)
+	assert.NoError(t, err)
+	assert.True(t, f.Owns("10.6.1.21"))
+
+	// the node is partitioned, the EIP is used until the renew deadline
+	failed = true
+	now = now.Add(8 * time.Second)
+	changed, err := f.sync(ctx)
+	assert.Error(t, err)
+	assert.Empty(t, changed)
+	assert.True(t, f.Owns("10.6.1.21"))
+
+	now = now.Add(3 * time.Second)
+	changed, err = f.sync(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"egw1"}, gatewayNames(changed))
+	assert.False(t, f.Owns("10.6.1.21"))
+
+	// the partition heals before the controller moved the EIP
+	failed = false
+	changed, err = f.sync(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"egw1"}, gatewayNames(changed))
+	assert.True(t, f.Owns("10.6.1.21"))
+}
+
+func TestHeldBy(t *testing.T) {
+	// the renew time of a stored lease has a microsecond precision
+	now := time.Now().Truncate(time.Second)
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(
+		newLease("10.6.1.21", "node1", now.Add(-5*time.Second)),
+	).Build()
+
+	d, err := HeldBy(context.Background(), cli, "egress", "node1", "10.6.1.21", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, d)
+
+	d, err = HeldBy(context.Background(), cli, "egress", "node2", "10.6.1.21", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+
+	d, err = HeldBy(context.Background(), cli, "egress", "node1", "10.6.1.22", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+}
+
+func TestOwnsNilFence(t *testing.T) {
+	var f *Fence
+	assert.True(t, f.Owns("10.6.1.21"))
+}
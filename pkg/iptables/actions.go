@@ -19,6 +19,7 @@ package iptables
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Action interface {
@@ -146,7 +147,10 @@ func (g DNATAction) String() string {
 }
 
 type SNATAction struct {
-	ToAddr   string
+	ToAddr string
+	// ToPorts is the source port range, e.g. 20000-20999, it requires
+	// a tcp, udp or sctp protocol match
+	ToPorts  string
 	TypeSNAT struct{}
 }
 
@@ -155,11 +159,21 @@ func (g SNATAction) ToFragment(features *Options) string {
 	if features.SNATFullyRandom {
 		fullyRand = " --random-fully"
 	}
-	return fmt.Sprintf("--jump SNAT --to-source %s%s", g.ToAddr, fullyRand)
+	return fmt.Sprintf("--jump SNAT --to-source %s%s", g.toSource(), fullyRand)
+}
+
+func (g SNATAction) toSource() string {
+	if g.ToPorts == "" {
+		return g.ToAddr
+	}
+	if strings.Contains(g.ToAddr, ":") {
+		return fmt.Sprintf("[%s]:%s", g.ToAddr, g.ToPorts)
+	}
+	return fmt.Sprintf("%s:%s", g.ToAddr, g.ToPorts)
 }
 
 func (g SNATAction) String() string {
-	return fmt.Sprintf("SNAT->%s", g.ToAddr)
+	return fmt.Sprintf("SNAT->%s", g.toSource())
 }
 
 type MasqAction struct {
@@ -222,6 +236,52 @@ func (c SetMaskedMarkAction) String() string {
 	return fmt.Sprintf("Set:%#x", c.Mark)
 }
 
+// HashMarkAction sets the mark to Offset plus the hash of Tuple modulo Mod, so
+// that the packets of a flow get the same mark.
+type HashMarkAction struct {
+	// Tuple are the fields of the hash, e.g. src,dst,sport,dport,proto
+	Tuple        string
+	Mod          uint32
+	Offset       uint32
+	TypeHashMark struct{}
+}
+
+func (c HashMarkAction) ToFragment(features *Options) string {
+	return fmt.Sprintf("--jump HMARK --hmark-tuple %s --hmark-mod %d --hmark-offset %#x", c.Tuple, c.Mod, c.Offset)
+}
+
+func (c HashMarkAction) String() string {
+	return fmt.Sprintf("HashMark:%s%%%d+%#x", c.Tuple, c.Mod, c.Offset)
+}
+
+// ClassifyAction puts the packets in the tc class, e.g. 26:1
+type ClassifyAction struct {
+	Class        string
+	TypeClassify struct{}
+}
+
+func (c ClassifyAction) ToFragment(features *Options) string {
+	return fmt.Sprintf("--jump CLASSIFY --set-class %s", c.Class)
+}
+
+func (c ClassifyAction) String() string {
+	return fmt.Sprintf("Classify:%s", c.Class)
+}
+
+// DSCPAction sets the DSCP value of the IPv4 TOS or IPv6 traffic class field
+type DSCPAction struct {
+	Value    uint8
+	TypeDSCP struct{}
+}
+
+func (c DSCPAction) ToFragment(features *Options) string {
+	return fmt.Sprintf("--jump DSCP --set-dscp %d", c.Value)
+}
+
+func (c DSCPAction) String() string {
+	return fmt.Sprintf("DSCP:%d", c.Value)
+}
+
 type NoTrackAction struct {
 	TypeNoTrack struct{}
 }
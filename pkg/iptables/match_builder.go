@@ -85,6 +85,10 @@ func (m MatchCriteria) InInterface(ifaceMatch string) MatchCriteria {
 	return append(m, fmt.Sprintf("--in-interface %s", ifaceMatch))
 }
 
+func (m MatchCriteria) NotInInterface(ifaceMatch string) MatchCriteria {
+	return append(m, fmt.Sprintf("! --in-interface %s", ifaceMatch))
+}
+
 func (m MatchCriteria) SrcMacSource(mac string) MatchCriteria {
 	return append(m, fmt.Sprintf("-m mac --mac-source %s", mac))
 }
@@ -317,6 +321,21 @@ func (m MatchCriteria) CTDirectionOriginal(direction Direction) MatchCriteria {
 	return append(m, fmt.Sprintf("-m conntrack --ctdir %s", direction))
 }
 
+// StatisticRandom matches packets with the given probability, in the nat table
+// it applies to the first packet of a connection only.
+func (m MatchCriteria) StatisticRandom(probability float64) MatchCriteria {
+	return append(m, fmt.Sprintf("-m statistic --mode random --probability %.8f", probability))
+}
+
+// DSCP matches the DSCP value of the IPv4 TOS or IPv6 traffic class field.
+func (m MatchCriteria) DSCP(value uint8) MatchCriteria {
+	return append(m, fmt.Sprintf("-m dscp --dscp %d", value))
+}
+
+func (m MatchCriteria) NotDSCP(value uint8) MatchCriteria {
+	return append(m, fmt.Sprintf("-m dscp ! --dscp %d", value))
+}
+
 // VXLANVNI matches on the VNI contained within the VXLAN header.  It assumes that this is indeed a VXLAN
 // packet; i.e. it should be used with a protocol==UDP and port==VXLAN port match.
 //
@@ -0,0 +1,157 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// counterAppendRegexp matches a rule of iptables-save -c, capturing its packet
+// and byte counters and its chain.
+var counterAppendRegexp = regexp.MustCompile(`^\[(\d+):(\d+)\] -A (\S+)`)
+
+// RuleState is a rule of the table with the hash of its comment.
+type RuleState struct {
+	Hash string
+	Rule string
+}
+
+// RuleCounters are the packets and bytes matched by a rule.
+type RuleCounters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// DesiredChains returns the rules the table programs by chain: all the rules of
+// our chains, and our inserted and appended rules of the other chains. Like the
+// other methods of the table it is not safe to call it concurrently with them.
+func (t *Table) DesiredChains() map[string][]RuleState {
+	res := make(map[string][]RuleState)
+	for chainName := range t.chainNameToChain {
+		chain, ok := t.desiredStateOfChain(chainName)
+		if !ok {
+			continue
+		}
+		hashes := chain.RuleHashes(t.opt)
+		rules := make([]RuleState, 0, len(hashes))
+		for i, hash := range hashes {
+			rules = append(rules, RuleState{
+				Hash: hash,
+				Rule: chain.Rules[i].RenderAppend(chainName, t.commentFrag(hash), t.opt),
+			})
+		}
+		res[chainName] = rules
+	}
+
+	chainNames := make(map[string]struct{})
+	for chainName := range t.chainToInsertedRules {
+		chainNames[chainName] = struct{}{}
+	}
+	for chainName := range t.chainToAppendedRules {
+		chainNames[chainName] = struct{}{}
+	}
+	for chainName := range chainNames {
+		rules := make([]RuleState, 0)
+		if inserted := t.chainToInsertedRules[chainName]; len(inserted) > 0 {
+			for i, hash := range calculateRuleHashes(chainName, inserted, t.opt) {
+				rules = append(rules, RuleState{
+					Hash: hash,
+					Rule: inserted[i].RenderAppend(chainName, t.commentFrag(hash), t.opt),
+				})
+			}
+		}
+		if appended := t.chainToAppendedRules[chainName]; len(appended) > 0 {
+			for i, hash := range calculateRuleHashes(chainName+"*appends*", appended, t.opt) {
+				rules = append(rules, RuleState{
+					Hash: hash,
+					Rule: appended[i].RenderAppend(chainName, t.commentFrag(hash), t.opt),
+				})
+			}
+		}
+		if len(rules) > 0 {
+			res[chainName] = append(res[chainName], rules...)
+		}
+	}
+	return res
+}
+
+// DataplaneChains reads the rules of the table from iptables-save: all the
+// rules of our chains, and the rules with our hash of the other chains. The
+// rules of our chains without a hash have an empty one.
+func (t *Table) DataplaneChains() (map[string][]RuleState, error) {
+	output, err := t.newCmd(t.iptablesSaveCmd, "-t", t.Name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %v", t.iptablesSaveCmd, err)
+	}
+	res := make(map[string][]RuleState)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if nftErrorRegexp.Match(line) {
+			return nil, fmt.Errorf("%s failed because there are incompatible nft rules in the table", t.iptablesSaveCmd)
+		}
+		if captures := chainCreateRegexp.FindSubmatch(line); captures != nil {
+			if chainName := string(captures[1]); t.ourChainsRegexp.MatchString(chainName) {
+				res[chainName] = make([]RuleState, 0)
+			}
+			continue
+		}
+		captures := appendRegexp.FindSubmatch(line)
+		if captures == nil {
+			continue
+		}
+		chainName := string(captures[1])
+		hash := ""
+		if captures := t.hashCommentRegexp.FindSubmatch(line); captures != nil {
+			hash = string(captures[1])
+		}
+		if hash == "" && !t.ourChainsRegexp.MatchString(chainName) {
+			continue
+		}
+		res[chainName] = append(res[chainName], RuleState{Hash: hash, Rule: string(line)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ChainCounters reads the counters of the rules with our hash of the chain
+// from iptables-save, by hash.
+func (t *Table) ChainCounters(chainName string) (map[string]RuleCounters, error) {
+	output, err := t.newCmd(t.iptablesSaveCmd, "-c", "-t", t.Name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %v", t.iptablesSaveCmd, err)
+	}
+	res := make(map[string]RuleCounters)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		captures := counterAppendRegexp.FindSubmatch(line)
+		if captures == nil || string(captures[3]) != chainName {
+			continue
+		}
+		hash := t.hashCommentRegexp.FindSubmatch(line)
+		if hash == nil {
+			continue
+		}
+		packets, err := strconv.ParseUint(string(captures[1]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		byteCount, err := strconv.ParseUint(string(captures[2]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		res[string(hash[1])] = RuleCounters{Packets: packets, Bytes: byteCount}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
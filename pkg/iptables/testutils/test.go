@@ -78,6 +78,9 @@ type MockDataplane struct {
 	Version                        string
 	KernelVersion                  string
 	NftablesMode                   bool
+	// RuleCounters are the packet and byte counters of the rules, listed by
+	// iptables-save -c
+	RuleCounters map[string][2]uint64
 }
 
 func (d *MockDataplane) ResetCmds() {
@@ -108,11 +111,13 @@ func (d *MockDataplane) NewCmd(name string, arg ...string) cmdshim.Command {
 	case "iptables-save", "ip6tables-save",
 		"iptables-legacy-save", "ip6tables-legacy-save",
 		"iptables-nft-save", "ip6tables-nft-save":
-		if !reflect.DeepEqual(arg, []string{"-t", d.Table}) {
+		counters := reflect.DeepEqual(arg, []string{"-c", "-t", d.Table})
+		if !counters && !reflect.DeepEqual(arg, []string{"-t", d.Table}) {
 			panic("arg must be equal {\"-t\", \" d.Table\"})")
 		}
 		cmd = &saveCmd{
 			Dataplane: d,
+			counters:  counters,
 		}
 	case "iptables":
 		if len(arg) != 1 || arg[0] != "--version" {
@@ -438,6 +443,7 @@ func PrependLine(src []string, line string) []string {
 type saveCmd struct {
 	Dataplane  *MockDataplane
 	stdoutPipe *closableBuffer
+	counters   bool
 }
 
 func (d *saveCmd) String() string {
@@ -497,6 +503,10 @@ func (d *saveCmd) Output() ([]byte, error) {
 
 	for chainName, chain := range d.Dataplane.Chains {
 		for _, rule := range chain {
+			if d.counters {
+				counters := d.Dataplane.RuleCounters[rule]
+				buf.WriteString(fmt.Sprintf("[%d:%d] ", counters[0], counters[1]))
+			}
 			buf.WriteString(fmt.Sprintf("-A %s %s\n", chainName, rule))
 		}
 	}
@@ -24,6 +24,7 @@ type EgressClusterPolicyList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv4",description="ipv4",name="ipv4",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv6",description="ipv6",name="ipv6",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.node",description="egressTunnel",name="egressTunnel",type=string
+// +kubebuilder:printcolumn:JSONPath=".spec.mode",description="mode",name="mode",type=string
 type EgressClusterPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
@@ -44,6 +45,19 @@ type EgressClusterPolicySpec struct {
 	DestSubnet []string `json:"destSubnet"`
 	// +kubebuilder:validation:Optional
 	Priority uint64 `json:"priority,omitempty"`
+	// Mode is enforce by default, a dryRun policy gets its EIP and node as usual,
+	// but agents only count the matched traffic without marking or SNAT it
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=enforce;dryRun
+	Mode string `json:"mode,omitempty"`
+	// Bandwidth limits the egress traffic of the policy on each of its gateway nodes
+	// +kubebuilder:validation:Optional
+	Bandwidth *Bandwidth `json:"bandwidth,omitempty"`
+	// DSCP is set on the egress traffic of the policy on its gateway nodes
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=63
+	DSCP *int `json:"dscp,omitempty"`
 }
 
 type ClusterAppliedTo struct {
@@ -43,6 +43,120 @@ type EgressGatewaySpec struct {
 	Ippools Ippools `json:"ippools,omitempty"`
 	// +kubebuilder:validation:Required
 	NodeSelector NodeSelector `json:"nodeSelector,omitempty"`
+	// +kubebuilder:validation:Optional
+	NamespaceQuota *NamespaceQuota `json:"namespaceQuota,omitempty"`
+	// +kubebuilder:validation:Optional
+	Announcement *Announcement `json:"announcement,omitempty"`
+	// +kubebuilder:validation:Optional
+	LocalAddress *LocalAddress `json:"localAddress,omitempty"`
+	// +kubebuilder:validation:Optional
+	Uplinks []Uplink `json:"uplinks,omitempty"`
+}
+
+// Uplink routes the SNATed traffic of the EIPs in IPPools out of Interface via
+// the next-hops on the gateway nodes instead of the main routing table, the
+// first uplink including an EIP is used. IPPools take single IPs, IP ranges and
+// CIDRs.
+type Uplink struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	IPPools []string `json:"ippools"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=15
+	Interface string `json:"interface"`
+	// +kubebuilder:validation:Optional
+	NextHopIPv4 string `json:"nextHopIPv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	NextHopIPv6 string `json:"nextHopIPv6,omitempty"`
+}
+
+// LocalAddress adds the EIPs of the gateway node to a host interface as /32
+// and /128 addresses with noprefixroute
+type LocalAddress struct {
+	// +kubebuilder:validation:Optional
+	Enable bool `json:"enable,omitempty"`
+	// Interface receives the addresses, a dummy device is created when it does
+	// not exist. When empty, the addresses are added to the announcement
+	// interfaces, or to the egress-eip dummy device when the EIPs are announced
+	// on all interfaces. The IPv6 addresses are always added to egress-eip.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MaxLength=15
+	Interface string `json:"interface,omitempty"`
+}
+
+// Announcement selects the interfaces the EIPs are announced on with ARP/NDP,
+// the EIPs are announced on all interfaces when it is not set. The first pool
+// including an EIP takes precedence over the gateway wide target.
+type Announcement struct {
+	AnnouncementTarget `json:",inline"`
+	// +kubebuilder:validation:Optional
+	Nodes []NodeAnnouncement `json:"nodes,omitempty"`
+	// +kubebuilder:validation:Optional
+	Pools []PoolAnnouncement `json:"pools,omitempty"`
+	// +kubebuilder:validation:Optional
+	Gratuitous *GratuitousSchedule `json:"gratuitous,omitempty"`
+}
+
+// GratuitousSchedule is the schedule of the gratuitous ARP and NDP packets
+// sent for the EIPs of the gateway
+type GratuitousSchedule struct {
+	// Count is the number of packets sent when an EIP is assigned to the
+	// node or its interface changes, 5 when it is not set
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Count int `json:"count,omitempty"`
+	// IntervalMillis is the interval between the packets of a burst, 1100
+	// when it is not set
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=10
+	// +kubebuilder:validation:Maximum=60000
+	IntervalMillis int `json:"intervalMillis,omitempty"`
+	// RefreshSecond sends a packet again at this interval after the burst,
+	// for the switches that age out ARP entries quickly, 0 disables it
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	RefreshSecond int `json:"refreshSecond,omitempty"`
+}
+
+// AnnouncementTarget selects interfaces by name, by VLAN ID, or the VLAN
+// interfaces of the named parent interfaces when both are set
+type AnnouncementTarget struct {
+	// +kubebuilder:validation:Optional
+	Interfaces []string `json:"interfaces,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	VLAN int `json:"vlan,omitempty"`
+}
+
+// NodeAnnouncement overrides the announcement target on a node
+type NodeAnnouncement struct {
+	// +kubebuilder:validation:Required
+	Name               string `json:"name"`
+	AnnouncementTarget `json:",inline"`
+}
+
+// PoolAnnouncement selects the announcement target of the EIPs in IPPools,
+// which take single IPs, IP ranges and CIDRs
+type PoolAnnouncement struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	IPPools            []string `json:"ippools"`
+	AnnouncementTarget `json:",inline"`
+	// +kubebuilder:validation:Optional
+	Nodes []NodeAnnouncement `json:"nodes,omitempty"`
+}
+
+// NamespaceQuota limits what a single namespace can consume from the gateway,
+// zero means no limit
+type NamespaceQuota struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxPolicies int `json:"maxPolicies,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxEIPs int `json:"maxEIPs,omitempty"`
 }
 
 type Ippools struct {
@@ -68,6 +182,52 @@ type EgressGatewayStatus struct {
 	NodeList []EgressIPStatus `json:"nodeList,omitempty"`
 	// +kubebuilder:validation:Optional
 	IPUsage IPUsage `json:"ipUsage,omitempty"`
+	// +kubebuilder:validation:Optional
+	NamespaceUsage []NamespaceUsage `json:"namespaceUsage,omitempty"`
+	// +kubebuilder:validation:Optional
+	Conflicts []EIPConflict `json:"conflicts,omitempty"`
+	// +kubebuilder:validation:Optional
+	SNATPressure []SNATPressure `json:"snatPressure,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ConditionSNATPortPressure is true when the SNAT port usage of an EIP
+	// of the gateway is above the threshold of the agent
+	ConditionSNATPortPressure = "SNATPortPressure"
+)
+
+// SNATPressure is an EIP whose SNAT port usage is above the threshold, it is
+// removed when the usage drops below the threshold
+type SNATPressure struct {
+	// +kubebuilder:validation:Optional
+	IP string `json:"ip,omitempty"`
+	// +kubebuilder:validation:Optional
+	Node string `json:"node,omitempty"`
+	// Usage is the highest percentage of the source ports in use towards
+	// a single destination
+	// +kubebuilder:validation:Optional
+	Usage int `json:"usage,omitempty"`
+	// +kubebuilder:validation:Optional
+	LastSeen metav1.Time `json:"lastSeen,omitempty"`
+}
+
+// EIPConflict is an EIP announced by another host on the segment of a gateway
+// node, it is removed after some minutes without conflict
+type EIPConflict struct {
+	// +kubebuilder:validation:Optional
+	IP string `json:"ip,omitempty"`
+	// +kubebuilder:validation:Optional
+	Node string `json:"node,omitempty"`
+	// +kubebuilder:validation:Optional
+	Interface string `json:"interface,omitempty"`
+	// +kubebuilder:validation:Optional
+	MAC string `json:"mac,omitempty"`
+	// +kubebuilder:validation:Optional
+	LastSeen metav1.Time `json:"lastSeen,omitempty"`
 }
 
 type IPUsage struct {
@@ -81,6 +241,16 @@ type IPUsage struct {
 	IPv6Free int `json:"ipv6Free"`
 }
 
+// NamespaceUsage is reported only when spec.namespaceQuota is set
+type NamespaceUsage struct {
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// +kubebuilder:validation:Optional
+	Policies int `json:"policies"`
+	// +kubebuilder:validation:Optional
+	EIPs int `json:"eips"`
+}
+
 func (status *EgressGatewayStatus) GetNodeIPs(nodeName string) []Eips {
 	for _, items := range status.NodeList {
 		if items.Name == nodeName {
@@ -116,6 +286,30 @@ type Eips struct {
 	IPv6 string `json:"ipv6,omitempty"`
 	// +kubebuilder:validation:Optional
 	Policies []Policy `json:"policies,omitempty"`
+	// SourcePorts are the source port ranges reserved by the policies of the EIP
+	// +kubebuilder:validation:Optional
+	SourcePorts []PolicySourcePorts `json:"sourcePorts,omitempty"`
+}
+
+// PolicySourcePorts is the source port range of the EIP reserved by a policy
+type PolicySourcePorts struct {
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// +kubebuilder:validation:Optional
+	Ports string `json:"ports,omitempty"`
+}
+
+// GetSourcePorts returns the source port range of the EIP reserved by the
+// policy, empty when the policy shares the full range.
+func (eips Eips) GetSourcePorts(namespace, name string) string {
+	for _, item := range eips.SourcePorts {
+		if item.Namespace == namespace && item.Name == name {
+			return item.Ports
+		}
+	}
+	return ""
 }
 
 type Policy struct {
@@ -0,0 +1,93 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+// EgressNotifierList contains a list of EgressNotifier
+// +kubebuilder:object:root=true
+type EgressNotifierList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressNotifier `json:"items"`
+}
+
+// EgressNotifier posts the EIPs assigned, released and moved by the controller
+// to HTTP webhooks
+// +kubebuilder:resource:categories={egressnotifier},path="egressnotifiers",singular="egressnotifier",scope="Cluster",shortName={egn}
+// +kubebuilder:printcolumn:JSONPath=".spec.gateways",description="gateways",name="gateways",type=string
+// +kubebuilder:printcolumn:JSONPath=".spec.namespaces",description="namespaces",name="namespaces",type=string
+// +kubebuilder:printcolumn:JSONPath=".spec.actions",description="actions",name="actions",type=string
+// +kubebuilder:object:root=true
+type EgressNotifier struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec EgressNotifierSpec `json:"spec,omitempty"`
+}
+
+type EgressNotifierSpec struct {
+	// Webhooks are the targets each notification is posted to
+	// +kubebuilder:validation:MinItems=1
+	Webhooks []NotifierWebhook `json:"webhooks"`
+	// Gateways are the names of the EgressGateways notified of, all when empty
+	// +kubebuilder:validation:Optional
+	Gateways []string `json:"gateways,omitempty"`
+	// Namespaces are the namespaces of the EgressPolicies notified of, all
+	// the policies when empty. The EgressClusterPolicies are only notified of
+	// when it is empty
+	// +kubebuilder:validation:Optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Actions are the changes notified of, all when empty
+	// +kubebuilder:validation:Optional
+	Actions []NotifierAction `json:"actions,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Assign;Release;Move
+type NotifierAction string
+
+const (
+	NotifierActionAssign  NotifierAction = "Assign"
+	NotifierActionRelease NotifierAction = "Release"
+	NotifierActionMove    NotifierAction = "Move"
+)
+
+type NotifierWebhook struct {
+	// URL is the http or https URL the notifications are posted to
+	// +kubebuilder:validation:Pattern=`^https?://.+`
+	URL string `json:"url"`
+	// HMACSecretRef is the Secret key used to sign the notifications with
+	// HMAC-SHA256, they are not signed when it is not set. The Secret must be
+	// in the namespace of the egressgateway release
+	// +kubebuilder:validation:Optional
+	HMACSecretRef *SecretKeyRef `json:"hmacSecretRef,omitempty"`
+	// TimeoutSeconds is the timeout of each post
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=5
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// MaxRetries is the number of times a failed post is retried, with an
+	// exponential backoff
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default:=3
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+}
+
+type SecretKeyRef struct {
+	// Namespace is the namespace of the Secret, the namespace of the
+	// egressgateway release when empty, other namespaces are refused
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="key"
+	Key string `json:"key,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressNotifier{}, &EgressNotifierList{})
+}
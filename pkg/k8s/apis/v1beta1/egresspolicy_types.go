@@ -4,6 +4,9 @@
 package v1beta1
 
 import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -24,6 +27,7 @@ type EgressPolicyList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv4",description="ipv4",name="ipv4",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv6",description="ipv6",name="ipv6",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.node",description="egressNode",name="egressNode",type=string
+// +kubebuilder:printcolumn:JSONPath=".spec.mode",description="mode",name="mode",type=string
 type EgressPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
@@ -44,6 +48,19 @@ type EgressPolicySpec struct {
 	DestSubnet []string `json:"destSubnet"`
 	// +kubebuilder:validation:Optional
 	Priority uint64 `json:"priority,omitempty"`
+	// Mode is enforce by default, a dryRun policy gets its EIP and node as usual,
+	// but agents only count the matched traffic without marking or SNAT it
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=enforce;dryRun
+	Mode string `json:"mode,omitempty"`
+	// Bandwidth limits the egress traffic of the policy on each of its gateway nodes
+	// +kubebuilder:validation:Optional
+	Bandwidth *Bandwidth `json:"bandwidth,omitempty"`
+	// DSCP is set on the egress traffic of the policy on its gateway nodes
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=63
+	DSCP *int `json:"dscp,omitempty"`
 }
 
 type EgressPolicyStatus struct {
@@ -51,6 +68,24 @@ type EgressPolicyStatus struct {
 	Eip Eip `json:"eip,omitempty"`
 	// +kubebuilder:validation:Optional
 	Node string `json:"node,omitempty"`
+	// SourcePorts is the source port range reserved on the EIP, e.g. 20000-20999
+	// +kubebuilder:validation:Optional
+	SourcePorts string `json:"sourcePorts,omitempty"`
+	// Assignments are all the EIPs of a policy with more than one EIP and
+	// their nodes, eip and node are the first of them
+	// +kubebuilder:validation:Optional
+	Assignments []EipAssignment `json:"assignments,omitempty"`
+}
+
+type EipAssignment struct {
+	// +kubebuilder:validation:Optional
+	Ipv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	Ipv6 string `json:"ipv6,omitempty"`
+	// +kubebuilder:validation:Optional
+	Node string `json:"node,omitempty"`
+	// +kubebuilder:validation:Optional
+	SourcePorts string `json:"sourcePorts,omitempty"`
 }
 
 type Eip struct {
@@ -71,6 +106,24 @@ type EgressIP struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:="default"
 	AllocatorPolicy string `json:"allocatorPolicy,omitempty"`
+	// SourcePorts reserves a range of this many source ports of the EIP for
+	// the policy, so that the policies sharing the EIP do not compete for the
+	// same ports. 0 shares the full port range.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=64512
+	SourcePorts int `json:"sourcePorts,omitempty"`
+	// Count is the number of EIPs allocated from the ippools of the gateway,
+	// the flows of the policy are spread over them by hash
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=16
+	Count int `json:"count,omitempty"`
+	// Additional are the EIPs used with ipv4 and ipv6, the flows of the
+	// policy are spread over them by hash
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MaxItems=15
+	Additional []Eip `json:"additional,omitempty"`
 }
 
 type AppliedTo struct {
@@ -85,7 +138,60 @@ func init() {
 }
 
 func (eip EgressIP) IsEmpty() bool {
-	return eip.IPv4 == EgressIP{}.IPv4 && eip.IPv6 == EgressIP{}.IPv6 && eip.UseNodeIP == EgressIP{}.UseNodeIP && eip.AllocatorPolicy == EgressIP{}.AllocatorPolicy
+	return eip.IPv4 == EgressIP{}.IPv4 && eip.IPv6 == EgressIP{}.IPv6 && eip.UseNodeIP == EgressIP{}.UseNodeIP && eip.AllocatorPolicy == EgressIP{}.AllocatorPolicy && eip.SourcePorts == EgressIP{}.SourcePorts &&
+		eip.Count == EgressIP{}.Count && len(eip.Additional) == 0
+}
+
+// EIPCount returns the number of EIPs of the policy.
+func (eip EgressIP) EIPCount() int {
+	switch {
+	case eip.UseNodeIP:
+		return 1
+	case len(eip.Additional) > 0:
+		return 1 + len(eip.Additional)
+	case eip.Count > 1:
+		return eip.Count
+	}
+	return 1
+}
+
+// Bandwidth limits the egress traffic of a policy with a tc class on the
+// egress interfaces of the gateway node. Each gateway node of the policy
+// applies the rate, a policy with EIPs on several nodes sends up to the rate
+// times the number of nodes.
+type Bandwidth struct {
+	// Rate is the limit in bits per second, e.g. 100M or 1G
+	// +kubebuilder:validation:Required
+	Rate string `json:"rate"`
+	// Burst is the number of bytes sent above the rate at once, e.g. 64Ki,
+	// computed from the rate when not set
+	// +kubebuilder:validation:Optional
+	Burst string `json:"burst,omitempty"`
+}
+
+// maxBurst is the largest burst of a tc class
+const maxBurst = 1<<32 - 1
+
+// Parse returns the rate in bits per second and the burst in bytes.
+func (b Bandwidth) Parse() (uint64, uint32, error) {
+	rate, err := resource.ParseQuantity(b.Rate)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid rate %s: %w", b.Rate, err)
+	}
+	if rate.Value() <= 0 {
+		return 0, 0, fmt.Errorf("rate %s should be greater than 0", b.Rate)
+	}
+	if b.Burst == "" {
+		return uint64(rate.Value()), 0, nil
+	}
+	burst, err := resource.ParseQuantity(b.Burst)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid burst %s: %w", b.Burst, err)
+	}
+	if burst.Value() < 0 || burst.Value() > maxBurst {
+		return 0, 0, fmt.Errorf("burst %s should be in 0-%d bytes", b.Burst, maxBurst)
+	}
+	return uint64(rate.Value()), uint32(burst.Value()), nil
 }
 
 const (
@@ -94,3 +200,10 @@ const (
 	// The unassigned EIP is preferred. If no EIP is available, select one at random
 	EipAllocatorRR = "rr"
 )
+
+const (
+	// PolicyModeEnforce marks and SNATs the matched traffic, it is the default mode
+	PolicyModeEnforce = "enforce"
+	// PolicyModeDryRun only counts the matched traffic
+	PolicyModeDryRun = "dryRun"
+)
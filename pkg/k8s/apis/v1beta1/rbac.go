@@ -1,7 +1,7 @@
 // Copyright 2022 Authors of spidernet-io
 // SPDX-License-Identifier: Apache-2.0
 
-// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways;egresstunnels;egressclusterpolicies;egresspolicies;egressendpointslices;egressclusterendpointslices;egressclusterinfos,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways;egresstunnels;egressclusterpolicies;egresspolicies;egressendpointslices;egressclusterendpointslices;egressclusterinfos;egressnotifiers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways/status;egresstunnels/status;egressclusterpolicies/status;egresspolicies/status;egressclusterinfos/status,verbs=get;update;patch
 
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;get;list;watch;update;delete
@@ -9,6 +9,61 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Announcement) DeepCopyInto(out *Announcement) {
+	*out = *in
+	in.AnnouncementTarget.DeepCopyInto(&out.AnnouncementTarget)
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeAnnouncement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]PoolAnnouncement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Gratuitous != nil {
+		in, out := &in.Gratuitous, &out.Gratuitous
+		*out = new(GratuitousSchedule)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Announcement.
+func (in *Announcement) DeepCopy() *Announcement {
+	if in == nil {
+		return nil
+	}
+	out := new(Announcement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AnnouncementTarget) DeepCopyInto(out *AnnouncementTarget) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AnnouncementTarget.
+func (in *AnnouncementTarget) DeepCopy() *AnnouncementTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(AnnouncementTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppliedTo) DeepCopyInto(out *AppliedTo) {
 	*out = *in
@@ -49,6 +104,21 @@ func (in *AutoDetect) DeepCopy() *AutoDetect {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Bandwidth) DeepCopyInto(out *Bandwidth) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Bandwidth.
+func (in *Bandwidth) DeepCopy() *Bandwidth {
+	if in == nil {
+		return nil
+	}
+	out := new(Bandwidth)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterAppliedTo) DeepCopyInto(out *ClusterAppliedTo) {
 	*out = *in
@@ -83,6 +153,22 @@ func (in *ClusterAppliedTo) DeepCopy() *ClusterAppliedTo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EIPConflict) DeepCopyInto(out *EIPConflict) {
+	*out = *in
+	in.LastSeen.DeepCopyInto(&out.LastSeen)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EIPConflict.
+func (in *EIPConflict) DeepCopy() *EIPConflict {
+	if in == nil {
+		return nil
+	}
+	out := new(EIPConflict)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressClusterEndpointSlice) DeepCopyInto(out *EgressClusterEndpointSlice) {
 	*out = *in
@@ -272,7 +358,7 @@ func (in *EgressClusterPolicy) DeepCopyInto(out *EgressClusterPolicy) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressClusterPolicy.
@@ -328,13 +414,23 @@ func (in *EgressClusterPolicyList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressClusterPolicySpec) DeepCopyInto(out *EgressClusterPolicySpec) {
 	*out = *in
-	out.EgressIP = in.EgressIP
+	in.EgressIP.DeepCopyInto(&out.EgressIP)
 	in.AppliedTo.DeepCopyInto(&out.AppliedTo)
 	if in.DestSubnet != nil {
 		in, out := &in.DestSubnet, &out.DestSubnet
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Bandwidth != nil {
+		in, out := &in.Bandwidth, &out.Bandwidth
+		*out = new(Bandwidth)
+		**out = **in
+	}
+	if in.DSCP != nil {
+		in, out := &in.DSCP, &out.DSCP
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressClusterPolicySpec.
@@ -500,6 +596,28 @@ func (in *EgressGatewaySpec) DeepCopyInto(out *EgressGatewaySpec) {
 	*out = *in
 	in.Ippools.DeepCopyInto(&out.Ippools)
 	in.NodeSelector.DeepCopyInto(&out.NodeSelector)
+	if in.NamespaceQuota != nil {
+		in, out := &in.NamespaceQuota, &out.NamespaceQuota
+		*out = new(NamespaceQuota)
+		**out = **in
+	}
+	if in.Announcement != nil {
+		in, out := &in.Announcement, &out.Announcement
+		*out = new(Announcement)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LocalAddress != nil {
+		in, out := &in.LocalAddress, &out.LocalAddress
+		*out = new(LocalAddress)
+		**out = **in
+	}
+	if in.Uplinks != nil {
+		in, out := &in.Uplinks, &out.Uplinks
+		*out = make([]Uplink, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewaySpec.
@@ -523,6 +641,32 @@ func (in *EgressGatewayStatus) DeepCopyInto(out *EgressGatewayStatus) {
 		}
 	}
 	out.IPUsage = in.IPUsage
+	if in.NamespaceUsage != nil {
+		in, out := &in.NamespaceUsage, &out.NamespaceUsage
+		*out = make([]NamespaceUsage, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conflicts != nil {
+		in, out := &in.Conflicts, &out.Conflicts
+		*out = make([]EIPConflict, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SNATPressure != nil {
+		in, out := &in.SNATPressure, &out.SNATPressure
+		*out = make([]SNATPressure, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressGatewayStatus.
@@ -538,6 +682,11 @@ func (in *EgressGatewayStatus) DeepCopy() *EgressGatewayStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressIP) DeepCopyInto(out *EgressIP) {
 	*out = *in
+	if in.Additional != nil {
+		in, out := &in.Additional, &out.Additional
+		*out = make([]Eip, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressIP.
@@ -572,13 +721,108 @@ func (in *EgressIPStatus) DeepCopy() *EgressIPStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNotifier) DeepCopyInto(out *EgressNotifier) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNotifier.
+func (in *EgressNotifier) DeepCopy() *EgressNotifier {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNotifier)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressNotifier) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNotifierList) DeepCopyInto(out *EgressNotifierList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressNotifier, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNotifierList.
+func (in *EgressNotifierList) DeepCopy() *EgressNotifierList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNotifierList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressNotifierList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressNotifierSpec) DeepCopyInto(out *EgressNotifierSpec) {
+	*out = *in
+	if in.Webhooks != nil {
+		in, out := &in.Webhooks, &out.Webhooks
+		*out = make([]NotifierWebhook, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Gateways != nil {
+		in, out := &in.Gateways, &out.Gateways
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Actions != nil {
+		in, out := &in.Actions, &out.Actions
+		*out = make([]NotifierAction, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressNotifierSpec.
+func (in *EgressNotifierSpec) DeepCopy() *EgressNotifierSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressNotifierSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressPolicy) DeepCopyInto(out *EgressPolicy) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicy.
@@ -634,13 +878,23 @@ func (in *EgressPolicyList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressPolicySpec) DeepCopyInto(out *EgressPolicySpec) {
 	*out = *in
-	out.EgressIP = in.EgressIP
+	in.EgressIP.DeepCopyInto(&out.EgressIP)
 	in.AppliedTo.DeepCopyInto(&out.AppliedTo)
 	if in.DestSubnet != nil {
 		in, out := &in.DestSubnet, &out.DestSubnet
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Bandwidth != nil {
+		in, out := &in.Bandwidth, &out.Bandwidth
+		*out = new(Bandwidth)
+		**out = **in
+	}
+	if in.DSCP != nil {
+		in, out := &in.DSCP, &out.DSCP
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicySpec.
@@ -657,6 +911,11 @@ func (in *EgressPolicySpec) DeepCopy() *EgressPolicySpec {
 func (in *EgressPolicyStatus) DeepCopyInto(out *EgressPolicyStatus) {
 	*out = *in
 	out.Eip = in.Eip
+	if in.Assignments != nil {
+		in, out := &in.Assignments, &out.Assignments
+		*out = make([]EipAssignment, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicyStatus.
@@ -775,6 +1034,21 @@ func (in *Eip) DeepCopy() *Eip {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EipAssignment) DeepCopyInto(out *EipAssignment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EipAssignment.
+func (in *EipAssignment) DeepCopy() *EipAssignment {
+	if in == nil {
+		return nil
+	}
+	out := new(EipAssignment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Eips) DeepCopyInto(out *Eips) {
 	*out = *in
@@ -783,6 +1057,11 @@ func (in *Eips) DeepCopyInto(out *Eips) {
 		*out = make([]Policy, len(*in))
 		copy(*out, *in)
 	}
+	if in.SourcePorts != nil {
+		in, out := &in.SourcePorts, &out.SourcePorts
+		*out = make([]PolicySourcePorts, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Eips.
@@ -795,6 +1074,21 @@ func (in *Eips) DeepCopy() *Eips {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GratuitousSchedule) DeepCopyInto(out *GratuitousSchedule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GratuitousSchedule.
+func (in *GratuitousSchedule) DeepCopy() *GratuitousSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(GratuitousSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPListPair) DeepCopyInto(out *IPListPair) {
 	*out = *in
@@ -860,6 +1154,67 @@ func (in *Ippools) DeepCopy() *Ippools {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocalAddress) DeepCopyInto(out *LocalAddress) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalAddress.
+func (in *LocalAddress) DeepCopy() *LocalAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(LocalAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceQuota) DeepCopyInto(out *NamespaceQuota) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceQuota.
+func (in *NamespaceQuota) DeepCopy() *NamespaceQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceUsage) DeepCopyInto(out *NamespaceUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceUsage.
+func (in *NamespaceUsage) DeepCopy() *NamespaceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeAnnouncement) DeepCopyInto(out *NodeAnnouncement) {
+	*out = *in
+	in.AnnouncementTarget.DeepCopyInto(&out.AnnouncementTarget)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeAnnouncement.
+func (in *NodeAnnouncement) DeepCopy() *NodeAnnouncement {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeAnnouncement)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSelector) DeepCopyInto(out *NodeSelector) {
 	*out = *in
@@ -880,6 +1235,31 @@ func (in *NodeSelector) DeepCopy() *NodeSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotifierWebhook) DeepCopyInto(out *NotifierWebhook) {
+	*out = *in
+	if in.HMACSecretRef != nil {
+		in, out := &in.HMACSecretRef, &out.HMACSecretRef
+		*out = new(SecretKeyRef)
+		**out = **in
+	}
+	if in.MaxRetries != nil {
+		in, out := &in.MaxRetries, &out.MaxRetries
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotifierWebhook.
+func (in *NotifierWebhook) DeepCopy() *NotifierWebhook {
+	if in == nil {
+		return nil
+	}
+	out := new(NotifierWebhook)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Parent) DeepCopyInto(out *Parent) {
 	*out = *in
@@ -910,6 +1290,80 @@ func (in *Policy) DeepCopy() *Policy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicySourcePorts) DeepCopyInto(out *PolicySourcePorts) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicySourcePorts.
+func (in *PolicySourcePorts) DeepCopy() *PolicySourcePorts {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicySourcePorts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolAnnouncement) DeepCopyInto(out *PoolAnnouncement) {
+	*out = *in
+	if in.IPPools != nil {
+		in, out := &in.IPPools, &out.IPPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.AnnouncementTarget.DeepCopyInto(&out.AnnouncementTarget)
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeAnnouncement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolAnnouncement.
+func (in *PoolAnnouncement) DeepCopy() *PoolAnnouncement {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolAnnouncement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SNATPressure) DeepCopyInto(out *SNATPressure) {
+	*out = *in
+	in.LastSeen.DeepCopyInto(&out.LastSeen)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SNATPressure.
+func (in *SNATPressure) DeepCopy() *SNATPressure {
+	if in == nil {
+		return nil
+	}
+	out := new(SNATPressure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyRef) DeepCopyInto(out *SecretKeyRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyRef.
+func (in *SecretKeyRef) DeepCopy() *SecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Tunnel) DeepCopyInto(out *Tunnel) {
 	*out = *in
@@ -925,3 +1379,23 @@ func (in *Tunnel) DeepCopy() *Tunnel {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Uplink) DeepCopyInto(out *Uplink) {
+	*out = *in
+	if in.IPPools != nil {
+		in, out := &in.IPPools, &out.IPPools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Uplink.
+func (in *Uplink) DeepCopy() *Uplink {
+	if in == nil {
+		return nil
+	}
+	out := new(Uplink)
+	in.DeepCopyInto(out)
+	return out
+}
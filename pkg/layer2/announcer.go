@@ -12,6 +12,7 @@
 package layer2
 
 import (
+	"bytes"
 	"net"
 	"os"
 	"regexp"
@@ -38,11 +39,48 @@ type Announce struct {
 	// to avoid deadlocking.
 	spamCh        chan IPAdvertisement
 	excludeRegexp *regexp.Regexp
+
+	localMACs       map[string]struct{}  // MAC addresses of all local interfaces
+	conflicts       map[string]time.Time // ip.String() -> end of the announcement stop
+	conflictHandler func(Conflict)
+	conflictHold    time.Duration
+	linkHandler     func()
+}
+
+// Conflict is an announcement of an owned IP from a MAC address of another host.
+type Conflict struct {
+	IP        net.IP
+	Interface string
+	MAC       net.HardwareAddr
 }
 
-// New returns an initialized Announce.
+const (
+	// interfaceScanPeriod is the period of the full interface rescan, it is the
+	// fallback when link events are lost or not available.
+	interfaceScanPeriod = 10 * time.Second
+	// linkEventSettle is how long to wait for more link events before rescanning,
+	// a bond failover or a VLAN coming up sends a burst of them.
+	linkEventSettle = 100 * time.Millisecond
+)
+
+// New returns an initialized Announce which tracks interfaces from netlink events.
 func New(l logr.Logger, excludeRegexp *regexp.Regexp) (*Announce, error) {
-	ret := &Announce{
+	return NewWithLinkEvents(l, excludeRegexp, NewNetlinkEventSource(l))
+}
+
+// NewWithLinkEvents returns an initialized Announce which rescans interfaces
+// whenever source reports a link change.
+func NewWithLinkEvents(l logr.Logger, excludeRegexp *regexp.Regexp, source LinkEventSource) (*Announce, error) {
+	ret := newAnnounce(l, excludeRegexp)
+
+	go ret.interfaceScan(source, make(chan struct{}))
+	go ret.spamLoop()
+
+	return ret, nil
+}
+
+func newAnnounce(l logr.Logger, excludeRegexp *regexp.Regexp) *Announce {
+	return &Announce{
 		logger:         l,
 		nodeInterfaces: []string{},
 		arps:           map[int]*arpResponder{},
@@ -51,18 +89,171 @@ func New(l logr.Logger, excludeRegexp *regexp.Regexp) (*Announce, error) {
 		ipRefcnt:       map[string]int{},
 		spamCh:         make(chan IPAdvertisement, 1024),
 		excludeRegexp:  excludeRegexp,
+		localMACs:      map[string]struct{}{},
+		conflicts:      map[string]time.Time{},
 	}
+}
 
-	go ret.interfaceScan()
-	go ret.spamLoop()
+// OnConflict sets the handler of the conflicts, it is called from the responders
+// and must not block. When hold is positive, an IP is not announced on this
+// node until hold passed since its last conflict.
+func (a *Announce) OnConflict(handler func(Conflict), hold time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.conflictHandler = handler
+	a.conflictHold = hold
+}
 
-	return ret, nil
+// OnLinkChange sets the handler called after the interfaces were scanned again
+// on link events, such as a VLAN interface created after its EIPs were
+// announced. It is called from the interface scan and must not block.
+func (a *Announce) OnLinkChange(handler func()) {
+	a.Lock()
+	defer a.Unlock()
+	a.linkHandler = handler
+}
+
+// checkConflict reports a conflict when ip is owned and was announced by a MAC
+// address which does not belong to this node.
+func (a *Announce) checkConflict(ip net.IP, intf string, mac net.HardwareAddr) {
+	reason := a.shouldAnnounce(ip, intf)
+	if reason != dropReasonNone && reason != dropReasonConflict {
+		return
+	}
+
+	a.Lock()
+	if _, ok := a.localMACs[mac.String()]; ok {
+		a.Unlock()
+		return
+	}
+	if a.conflictHold > 0 {
+		a.conflicts[ip.String()] = time.Now().Add(a.conflictHold)
+	}
+	handler := a.conflictHandler
+	a.Unlock()
+
+	stats.DetectedConflict(ip.String(), intf)
+	a.logger.Info("detected announcement of owned IP from another host",
+		"event", "conflict", "ip", ip, "interface", intf, "mac", mac)
+	if handler != nil {
+		handler(Conflict{IP: ip, Interface: intf, MAC: mac})
+	}
+}
+
+// conflicted returns whether announcing ip is stopped after a conflict, the
+// caller must hold the lock.
+func (a *Announce) conflicted(ip net.IP) bool {
+	until, ok := a.conflicts[ip.String()]
+	return ok && time.Now().Before(until)
+}
+
+// Conflicted returns whether announcing ip is stopped after a conflict.
+func (a *Announce) Conflicted(ip net.IP) bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.conflicted(ip)
 }
 
-func (a *Announce) interfaceScan() {
+// interfaceScan updates the responders on every link event, and periodically
+// as a resync. After a link event the IPs announced on the changed interfaces
+// are announced again, so that neighbours learn the new link right away.
+func (a *Announce) interfaceScan(source LinkEventSource, done <-chan struct{}) {
+	events := a.subscribeLinkEvents(source, done)
+	a.updateInterfaces()
+
+	ticker := time.NewTicker(interfaceScanPeriod)
+	defer ticker.Stop()
 	for {
-		a.updateInterfaces()
-		time.Sleep(10 * time.Second)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			a.updateInterfaces()
+			if events == nil {
+				events = a.subscribeLinkEvents(source, done)
+			}
+		case ev, ok := <-events:
+			if !ok {
+				a.logger.Info("link event subscription closed, fall back to periodic interface scan",
+					"event", "linkEventsClosed")
+				events = nil
+				continue
+			}
+			changed := map[string]struct{}{ev.Interface: {}}
+			collectLinkEvents(events, changed)
+			a.updateInterfaces()
+			a.reannounce(changed)
+			a.RLock()
+			handler := a.linkHandler
+			a.RUnlock()
+			if handler != nil {
+				handler()
+			}
+		}
+	}
+}
+
+func (a *Announce) subscribeLinkEvents(source LinkEventSource, done <-chan struct{}) <-chan LinkEvent {
+	if source == nil {
+		return nil
+	}
+	events, err := source.Subscribe(done)
+	if err != nil {
+		a.logger.Error(err, "couldn't subscribe to link events", "op", "subscribeLinkEvents")
+		return nil
+	}
+	return events
+}
+
+// collectLinkEvents adds the interfaces of the events which arrive within
+// linkEventSettle to changed.
+func collectLinkEvents(events <-chan LinkEvent, changed map[string]struct{}) {
+	timer := time.NewTimer(linkEventSettle)
+	defer timer.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			changed[ev.Interface] = struct{}{}
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// reannounce sends gratuitous announcements for the IPs announced on the changed
+// interfaces, an unresolved interface affects every IP.
+func (a *Announce) reannounce(changed map[string]struct{}) {
+	_, unknown := changed[""]
+
+	a.RLock()
+	seen := map[string]struct{}{}
+	advs := make([]IPAdvertisement, 0)
+	for _, ipAdvertisements := range a.ips {
+		for _, adv := range ipAdvertisements {
+			if _, ok := seen[adv.ip.String()]; ok {
+				continue
+			}
+			match := unknown
+			for intf := range changed {
+				if match {
+					break
+				}
+				match = adv.matchInterface(intf)
+			}
+			if match {
+				seen[adv.ip.String()] = struct{}{}
+				advs = append(advs, adv)
+			}
+		}
+	}
+	a.RUnlock()
+
+	for _, adv := range advs {
+		a.logger.V(1).Info("announce IP again after link change", "op", "reannounce", "ip", adv.ip)
+		a.doSpam(adv)
 	}
 }
 
@@ -81,8 +272,12 @@ func (a *Announce) updateInterfaces() {
 
 	keepARP, keepNDP := map[int]bool{}, map[int]bool{}
 	curIfs := make([]string, 0, len(ifs))
+	localMACs := make(map[string]struct{}, len(ifs))
 	for _, intf := range ifs {
 		ifi := intf
+		if len(ifi.HardwareAddr) > 0 {
+			localMACs[ifi.HardwareAddr.String()] = struct{}{}
+		}
 
 		if (a.excludeRegexp != nil) && a.excludeRegexp.MatchString(ifi.Name) {
 			a.logger.V(1).Info("announced interface to exclude interface", "interface", ifi.Name)
@@ -129,8 +324,21 @@ func (a *Announce) updateInterfaces() {
 			break
 		}
 
+		// the MAC address changes on bond failover, the responders must be
+		// re-created to answer with the new one
+		if client := a.arps[ifi.Index]; client != nil && !bytes.Equal(client.hardwareAddr, ifi.HardwareAddr) {
+			client.Close()
+			delete(a.arps, ifi.Index)
+			l.Info("deleted ARP responder for interface with changed MAC address", "event", "deleteARPResponder")
+		}
+		if client := a.ndps[ifi.Index]; client != nil && !bytes.Equal(client.hardwareAddr, ifi.HardwareAddr) {
+			client.Close()
+			delete(a.ndps, ifi.Index)
+			l.Info("deleted NDP responder for interface with changed MAC address", "event", "deleteNDPResponder")
+		}
+
 		if keepARP[ifi.Index] && a.arps[ifi.Index] == nil {
-			resp, err := newARPResponder(a.logger, &ifi, a.shouldAnnounce)
+			resp, err := newARPResponder(a.logger, &ifi, a.shouldAnnounce, a.checkConflict)
 			if err != nil {
 				l.Error(err, "failed to create ARP responder", "op", "createARPResponder")
 				continue
@@ -139,17 +347,26 @@ func (a *Announce) updateInterfaces() {
 			l.Info("created ARP responder for interface", "event", "createARPResponder")
 		}
 		if keepNDP[ifi.Index] && a.ndps[ifi.Index] == nil {
-			resp, err := newNDPResponder(a.logger, &ifi, a.shouldAnnounce)
+			resp, err := newNDPResponder(a.logger, &ifi, a.shouldAnnounce, a.checkConflict)
 			if err != nil {
 				l.Error(err, "failed to create NDP responder", "op", "createNDPResponder")
 				continue
 			}
 			a.ndps[ifi.Index] = resp
 			l.Info("created NDP responder for interface", "event", "createNDPResponder")
+			for ip, cnt := range a.ipRefcnt {
+				if cnt <= 0 {
+					continue
+				}
+				if err := resp.Watch(net.ParseIP(ip)); err != nil {
+					l.Error(err, "failed to watch NDP multicast group for IP", "op", "watchMulticastGroup", "ip", ip)
+				}
+			}
 		}
 	}
 
 	a.nodeInterfaces = curIfs
+	a.localMACs = localMACs
 
 	for i, client := range a.arps {
 		if !keepARP[i] {
@@ -168,46 +385,38 @@ func (a *Announce) updateInterfaces() {
 }
 
 // spamLoop is used to send gratuitous ARP or NDP in the network to avoid ARP/NDP
-// cache issues, and to periodically stop spam to maintain network performance.
+// cache issues, following the schedule of each advertisement.
 func (a *Announce) spamLoop() {
-	// Map IP to spam stop time.
-	type timedSpam struct {
-		until time.Time
-		IPAdvertisement
-	}
-	m := map[string]timedSpam{}
-	// We can't create a stopped ticker, so create one with a big period to avoid ticking for nothing
-	ticker := time.NewTicker(time.Hour)
-	ticker.Stop()
+	scheduler := newSpamScheduler()
+	// We can't create a stopped timer, so create one with a big period to avoid firing for nothing
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
 	for {
 		select {
 		case s := <-a.spamCh:
-			if len(m) == 0 {
-				// See https://github.com/metallb/metallb/issues/172 for the 1100 choice.
-				ticker.Reset(1100 * time.Millisecond)
-			}
-			ipStr := s.ip.String()
-			_, ok := m[ipStr]
-			// Set spam stop time to 5 seconds from now.
-			m[ipStr] = timedSpam{time.Now().Add(5 * time.Second), s}
-			if !ok {
-				// Spam right away to avoid waiting up to 1100 milliseconds even if
-				// it means we call gratuitous() twice in a row in a short amount of time.
-				a.gratuitous(s)
-			}
-		case now := <-ticker.C:
-			for ipStr, tSpam := range m {
-				if now.After(tSpam.until) {
-					// We have spammed enough - remove the IP from the map.
-					delete(m, ipStr)
+			now := time.Now()
+			if scheduler.add(s) {
+				// Spam right away instead of waiting for the timer.
+				if a.gratuitous(s) {
+					scheduler.sent(s, now)
 				} else {
-					a.gratuitous(tSpam.IPAdvertisement)
+					scheduler.remove(s)
 				}
 			}
-			if len(m) == 0 {
-				ticker.Stop()
+		case now := <-timer.C:
+			for _, adv := range scheduler.due(now) {
+				if a.gratuitous(adv) {
+					scheduler.sent(adv, now)
+				} else {
+					// We've lost control of the IP - stop announcing it.
+					scheduler.remove(adv)
+				}
 			}
 		}
+		timer.Stop()
+		if next, ok := scheduler.next(); ok {
+			timer.Reset(time.Until(next))
+		}
 	}
 }
 
@@ -218,13 +427,13 @@ func (a *Announce) doSpam(adv IPAdvertisement) {
 // gratuitous sends arp packets
 //  1. Acquire a read lock for the Announce object.
 //  2. Check whether the Announce object still holds control over the given IP address.
-//     If not, return without further action.
+//     If not, return false without further action.
 //  3. Iterate through the arps or ndps list in the Announce object, depending on the IP
 //     address type (IPv4 or IPv6), and send gratuitous ARP or NDP messages for the given
 //     IP address on each network interface.
 //  4. If an error occurs during the sending process, log it.
 //  5. Finally, release the read lock for the Announce object.
-func (a *Announce) gratuitous(adv IPAdvertisement) {
+func (a *Announce) gratuitous(adv IPAdvertisement) bool {
 	a.RLock()
 	defer a.RUnlock()
 
@@ -232,7 +441,11 @@ func (a *Announce) gratuitous(adv IPAdvertisement) {
 	if a.ipRefcnt[ip.String()] <= 0 {
 		// We've lost control of the IP, someone else is
 		// doing announcements.
-		return
+		return false
+	}
+	if a.conflicted(ip) {
+		a.logger.V(1).Info("skip gratuitous announcement of conflicted IP", "op", "gratuitousAnnounce", "ip", ip)
+		return true
 	}
 
 	if ip.To4() != nil {
@@ -244,7 +457,9 @@ func (a *Announce) gratuitous(adv IPAdvertisement) {
 			if err := client.Gratuitous(ip); err != nil {
 				a.logger.Error(err, "failed to make gratuitous ARP announcement",
 					"op", "gratuitousAnnounce", "ip", ip)
+				continue
 			}
+			stats.SentGratuitous(adv.gateway, ip.String())
 		}
 	} else {
 		for _, client := range a.ndps {
@@ -255,9 +470,12 @@ func (a *Announce) gratuitous(adv IPAdvertisement) {
 			if err := client.Gratuitous(ip); err != nil {
 				a.logger.Error(err, "failed to make gratuitous NDP announcement",
 					"op", "gratuitousAnnounce", "ip", ip)
+				continue
 			}
+			stats.SentGratuitous(adv.gateway, ip.String())
 		}
 	}
+	return true
 }
 
 func (a *Announce) shouldAnnounce(ip net.IP, intf string) dropReason {
@@ -269,6 +487,9 @@ func (a *Announce) shouldAnnounce(ip net.IP, intf string) dropReason {
 			if i.ip.Equal(ip) {
 				ipFound = true
 				if i.matchInterface(intf) {
+					if a.conflicted(ip) {
+						return dropReasonConflict
+					}
 					return dropReasonNone
 				}
 			}
@@ -327,19 +548,60 @@ func (a *Announce) DeleteBalancer(name string) {
 	delete(a.ips, name)
 
 	for _, cur := range advs {
-		a.ipRefcnt[cur.ip.String()]--
-		if a.ipRefcnt[cur.ip.String()] > 0 {
-			// Another service is still using this IP, don't touch any
-			// more things.
+		a.release(cur)
+	}
+}
+
+// DeleteBalancerIP stops announcing ip under name, the other addresses of name
+// are still announced.
+func (a *Announce) DeleteBalancerIP(name string, ip net.IP) {
+	a.Lock()
+	defer a.Unlock()
+
+	advs := a.ips[name]
+	for i, cur := range advs {
+		if !cur.ip.Equal(ip) {
 			continue
 		}
+		advs = append(advs[:i:i], advs[i+1:]...)
+		if len(advs) == 0 {
+			delete(a.ips, name)
+		} else {
+			a.ips[name] = advs
+		}
+		a.release(cur)
+		return
+	}
+}
 
-		for _, client := range a.ndps {
-			if err := client.Unwatch(cur.ip); err != nil {
-				a.logger.Error(err, "failed to unwatch NDP multicast group for IP",
-					"op", "unwatchMulticastGroup", "ip", cur.ip, "interface", client.intf,
-				)
-			}
+// BalancerIPs returns the addresses announced for name.
+func (a *Announce) BalancerIPs(name string) []net.IP {
+	a.RLock()
+	defer a.RUnlock()
+
+	res := make([]net.IP, 0, len(a.ips[name]))
+	for _, adv := range a.ips[name] {
+		res = append(res, adv.ip)
+	}
+	return res
+}
+
+// release drops a use of the address of adv, it must be called with the lock
+// held.
+func (a *Announce) release(adv IPAdvertisement) {
+	a.ipRefcnt[adv.ip.String()]--
+	if a.ipRefcnt[adv.ip.String()] > 0 {
+		// Another service is still using this IP, don't touch any
+		// more things.
+		return
+	}
+	delete(a.conflicts, adv.ip.String())
+
+	for _, client := range a.ndps {
+		if err := client.Unwatch(adv.ip); err != nil {
+			a.logger.Error(err, "failed to unwatch NDP multicast group for IP",
+				"op", "unwatchMulticastGroup", "ip", adv.ip, "interface", client.intf,
+			)
 		}
 	}
 }
@@ -377,4 +639,5 @@ const (
 	dropReasonEthernetDestination
 	dropReasonAnnounceIP
 	dropReasonNotMatchInterface
+	dropReasonConflict
 )
@@ -0,0 +1,263 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/go-logr/logr"
+	"github.com/mdlayher/arp"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type fakeLinkEvents struct {
+	ch  chan LinkEvent
+	err error
+}
+
+func (f *fakeLinkEvents) Subscribe(done <-chan struct{}) (<-chan LinkEvent, error) {
+	return f.ch, f.err
+}
+
+func TestInterfaceScanLinkEvents(t *testing.T) {
+	cases := map[string]struct {
+		events []LinkEvent
+		want   []string
+	}{
+		"event of announced interface": {
+			events: []LinkEvent{{Interface: "eth1"}},
+			want:   []string{"10.6.1.21", "10.6.1.22"},
+		},
+		"burst of events": {
+			events: []LinkEvent{{Interface: "eth1"}, {Interface: "eth1"}, {Interface: "eth2"}},
+			want:   []string{"10.6.1.21", "10.6.1.22", "10.6.1.23"},
+		},
+		"event of other interface": {
+			events: []LinkEvent{{Interface: "eth3"}},
+			want:   []string{"10.6.1.22"},
+		},
+		"event of unresolved interface": {
+			events: []LinkEvent{{Interface: ""}},
+			want:   []string{"10.6.1.21", "10.6.1.22", "10.6.1.23"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var scans atomic.Int32
+			patches := gomonkey.ApplyFunc(net.Interfaces, func() ([]net.Interface, error) {
+				scans.Add(1)
+				return nil, nil
+			})
+			defer patches.Reset()
+
+			a := newAnnounce(logr.Discard(), nil)
+			a.ips = map[string][]IPAdvertisement{
+				"gw1": {NewIPAdvertisement(net.ParseIP("10.6.1.21"), false, sets.New[string]("eth1"))},
+				"gw2": {NewIPAdvertisement(net.ParseIP("10.6.1.22"), true, nil)},
+				"gw3": {NewIPAdvertisement(net.ParseIP("10.6.1.23"), false, sets.New[string]("eth2"))},
+			}
+
+			source := &fakeLinkEvents{ch: make(chan LinkEvent, len(tc.events))}
+			done := make(chan struct{})
+			defer close(done)
+			go a.interfaceScan(source, done)
+
+			assert.Eventually(t, func() bool { return scans.Load() == 1 }, time.Second, 10*time.Millisecond)
+			for _, ev := range tc.events {
+				source.ch <- ev
+			}
+
+			got := sets.New[string]()
+			timeout := time.After(time.Second)
+			for got.Len() < len(tc.want) {
+				select {
+				case adv := <-a.spamCh:
+					got.Insert(adv.ip.String())
+				case <-timeout:
+					t.Fatalf("announced %v, want %v", sets.List(got), tc.want)
+				}
+			}
+			assert.Equal(t, tc.want, sets.List(got))
+			assert.Equal(t, int32(2), scans.Load(), "a burst of events should trigger one rescan")
+
+			select {
+			case adv := <-a.spamCh:
+				t.Fatalf("unexpected announcement of %s", adv.ip)
+			case <-time.After(2 * linkEventSettle):
+			}
+		})
+	}
+}
+
+func TestInterfaceScanLinkChange(t *testing.T) {
+	patches := gomonkey.ApplyFuncReturn(net.Interfaces, nil, nil)
+	defer patches.Reset()
+
+	a := newAnnounce(logr.Discard(), nil)
+	var changes atomic.Int32
+	a.OnLinkChange(func() { changes.Add(1) })
+
+	source := &fakeLinkEvents{ch: make(chan LinkEvent, 2)}
+	done := make(chan struct{})
+	defer close(done)
+	go a.interfaceScan(source, done)
+
+	// the handler is called once for a burst of events
+	source.ch <- LinkEvent{Interface: "eth1.100"}
+	source.ch <- LinkEvent{Interface: "eth1.200"}
+	assert.Eventually(t, func() bool { return changes.Load() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(2 * linkEventSettle)
+	assert.Equal(t, int32(1), changes.Load())
+}
+
+func TestInterfaceScanSubscribeFailed(t *testing.T) {
+	var scans atomic.Int32
+	patches := gomonkey.ApplyFunc(net.Interfaces, func() ([]net.Interface, error) {
+		scans.Add(1)
+		return nil, nil
+	})
+	defer patches.Reset()
+
+	a := newAnnounce(logr.Discard(), nil)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		a.interfaceScan(&fakeLinkEvents{err: errors.New("netlink unavailable")}, done)
+		close(finished)
+	}()
+
+	assert.Eventually(t, func() bool { return scans.Load() == 1 }, time.Second, 10*time.Millisecond)
+	close(done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("interfaceScan did not stop")
+	}
+}
+
+func TestCheckConflict(t *testing.T) {
+	localMAC, _ := net.ParseMAC("02:00:00:00:00:01")
+	foreignMAC, _ := net.ParseMAC("02:00:00:00:00:99")
+
+	cases := map[string]struct {
+		ip           string
+		intf         string
+		mac          net.HardwareAddr
+		hold         time.Duration
+		wantConflict bool
+		wantReason   dropReason
+	}{
+		"foreign MAC": {
+			ip: "10.6.1.21", intf: "eth1", mac: foreignMAC,
+			wantConflict: true, wantReason: dropReasonNone,
+		},
+		"foreign MAC stops announcing": {
+			ip: "10.6.1.21", intf: "eth1", mac: foreignMAC, hold: time.Minute,
+			wantConflict: true, wantReason: dropReasonConflict,
+		},
+		"local MAC": {
+			ip: "10.6.1.21", intf: "eth1", mac: localMAC, hold: time.Minute,
+			wantReason: dropReasonNone,
+		},
+		"not owned IP": {
+			ip: "10.6.1.99", intf: "eth1", mac: foreignMAC, hold: time.Minute,
+			wantReason: dropReasonAnnounceIP,
+		},
+		"not announced interface": {
+			ip: "10.6.1.21", intf: "eth2", mac: foreignMAC, hold: time.Minute,
+			wantReason: dropReasonNotMatchInterface,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := newAnnounce(logr.Discard(), nil)
+			a.localMACs[localMAC.String()] = struct{}{}
+			a.SetBalancer("gw1", NewIPAdvertisement(net.ParseIP("10.6.1.21"), false, sets.New[string]("eth1")))
+			<-a.spamCh
+
+			conflicts := make([]Conflict, 0)
+			a.OnConflict(func(c Conflict) { conflicts = append(conflicts, c) }, tc.hold)
+			a.checkConflict(net.ParseIP(tc.ip), tc.intf, tc.mac)
+
+			if tc.wantConflict {
+				assert.Equal(t, []Conflict{{IP: net.ParseIP(tc.ip), Interface: tc.intf, MAC: tc.mac}}, conflicts)
+			} else {
+				assert.Empty(t, conflicts)
+			}
+			assert.Equal(t, tc.wantReason, a.shouldAnnounce(net.ParseIP(tc.ip), tc.intf))
+
+			// conflicts are still reported while announcing is stopped
+			a.checkConflict(net.ParseIP(tc.ip), tc.intf, tc.mac)
+			if tc.wantConflict {
+				assert.Len(t, conflicts, 2)
+			}
+
+			a.DeleteBalancer("gw1")
+			a.SetBalancer("gw1", NewIPAdvertisement(net.ParseIP("10.6.1.21"), false, sets.New[string]("eth1")))
+			assert.Equal(t, dropReasonNone, a.shouldAnnounce(net.ParseIP("10.6.1.21"), "eth1"),
+				"a new assignment of the IP announces it again")
+		})
+	}
+}
+
+func TestDeleteBalancerIP(t *testing.T) {
+	a := newAnnounce(logr.Discard(), nil)
+	ipv4, ipv6 := net.ParseIP("10.6.1.21"), net.ParseIP("fd00::21")
+	a.SetBalancer("policy1", NewIPAdvertisement(ipv4, true, sets.New[string]()))
+	a.SetBalancer("policy1", NewIPAdvertisement(ipv6, true, sets.New[string]()))
+	a.SetBalancer("policy2", NewIPAdvertisement(ipv4, true, sets.New[string]()))
+	<-a.spamCh
+	<-a.spamCh
+	<-a.spamCh
+
+	// the other family of the name is kept
+	a.DeleteBalancerIP("policy1", ipv4)
+	assert.True(t, a.AnnounceName("policy1"))
+	assert.Len(t, a.ips["policy1"], 1)
+	assert.True(t, a.ips["policy1"][0].ip.Equal(ipv6))
+	assert.Equal(t, []net.IP{ipv6}, a.BalancerIPs("policy1"))
+	assert.Equal(t, 1, a.ipRefcnt[ipv4.String()], "the IP is still announced for policy2")
+
+	a.DeleteBalancerIP("policy1", ipv4)
+	assert.Len(t, a.ips["policy1"], 1)
+
+	a.DeleteBalancerIP("policy1", ipv6)
+	assert.False(t, a.AnnounceName("policy1"))
+	assert.Empty(t, a.BalancerIPs("policy1"))
+	assert.Equal(t, 0, a.ipRefcnt[ipv6.String()])
+}
+
+func TestIsARPAnnouncement(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:99")
+	cases := map[string]struct {
+		op       arp.Operation
+		sender   string
+		target   string
+		expected bool
+	}{
+		"reply":         {op: arp.OperationReply, sender: "10.6.1.21", target: "10.6.0.1", expected: true},
+		"gratuitous":    {op: arp.OperationRequest, sender: "10.6.1.21", target: "10.6.1.21", expected: true},
+		"request":       {op: arp.OperationRequest, sender: "10.6.0.1", target: "10.6.1.21", expected: false},
+		"address probe": {op: arp.OperationRequest, sender: "0.0.0.0", target: "10.6.1.21", expected: false},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			pkt := &arp.Packet{
+				Operation:          tc.op,
+				SenderHardwareAddr: mac,
+				SenderIP:           net.ParseIP(tc.sender).To4(),
+				TargetIP:           net.ParseIP(tc.target).To4(),
+			}
+			assert.Equal(t, tc.expected, isARPAnnouncement(pkt))
+		})
+	}
+}
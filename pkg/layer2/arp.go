@@ -22,6 +22,10 @@ import (
 
 type announceFunc func(net.IP, string) dropReason
 
+// conflictFunc is called with the IP, interface and MAC address of every
+// announcement seen on the segment
+type conflictFunc func(net.IP, string, net.HardwareAddr)
+
 type arpResponder struct {
 	logger       logr.Logger
 	intf         string
@@ -29,9 +33,10 @@ type arpResponder struct {
 	conn         *arp.Client
 	closed       chan struct{}
 	announce     announceFunc
+	conflict     conflictFunc
 }
 
-func newARPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (*arpResponder, error) {
+func newARPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc, conflict conflictFunc) (*arpResponder, error) {
 	client, err := arp.Dial(ifi)
 	if err != nil {
 		return nil, fmt.Errorf("creating ARP responder for %q: %s", ifi.Name, err)
@@ -44,6 +49,7 @@ func newARPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (
 		conn:         client,
 		closed:       make(chan struct{}),
 		announce:     ann,
+		conflict:     conflict,
 	}
 	go ret.run()
 	return ret, nil
@@ -65,7 +71,6 @@ func (a *arpResponder) Gratuitous(ip net.IP) error {
 		if err = a.conn.WriteTo(pkt, ethernet.Broadcast); err != nil {
 			return fmt.Errorf("writing %q gratuitous packet for %q: %s", op, ip, err)
 		}
-		stats.SentGratuitous(ip.String())
 	}
 	return nil
 }
@@ -92,6 +97,10 @@ func (a *arpResponder) processRequest() dropReason {
 		return dropReasonError
 	}
 
+	if isARPAnnouncement(pkt) {
+		a.conflict(pkt.SenderIP, a.intf, pkt.SenderHardwareAddr)
+	}
+
 	// Ignore ARP replies.
 	if pkt.Operation != arp.OperationRequest {
 		return dropReasonARPReply
@@ -135,3 +144,12 @@ func (a *arpResponder) processRequest() dropReason {
 	}
 	return dropReasonNone
 }
+
+// isARPAnnouncement returns whether the sender of pkt claims its sender IP,
+// which is the case for replies and gratuitous ARP, but not for probes
+func isARPAnnouncement(pkt *arp.Packet) bool {
+	if pkt.SenderIP == nil || pkt.SenderIP.IsUnspecified() {
+		return false
+	}
+	return pkt.Operation == arp.OperationReply || pkt.SenderIP.Equal(pkt.TargetIP)
+}
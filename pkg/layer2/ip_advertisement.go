@@ -19,6 +19,9 @@ type IPAdvertisement struct {
 	ip            net.IP
 	interfaces    sets.Set[string]
 	allInterfaces bool
+	// gateway is the EgressGateway of the IP, it labels the metrics
+	gateway  string
+	schedule Schedule
 }
 
 func NewIPAdvertisement(ip net.IP, allInterfaces bool, interfaces sets.Set[string]) IPAdvertisement {
@@ -26,9 +29,27 @@ func NewIPAdvertisement(ip net.IP, allInterfaces bool, interfaces sets.Set[strin
 		ip:            ip,
 		interfaces:    interfaces,
 		allInterfaces: allInterfaces,
+		schedule:      DefaultSchedule,
 	}
 }
 
+// WithGateway returns the advertisement labeled with the EgressGateway name.
+func (i IPAdvertisement) WithGateway(gateway string) IPAdvertisement {
+	i.gateway = gateway
+	return i
+}
+
+// WithSchedule returns the advertisement using the gratuitous schedule s.
+func (i IPAdvertisement) WithSchedule(s Schedule) IPAdvertisement {
+	i.schedule = s
+	return i
+}
+
+// IP returns the advertised IP.
+func (i IPAdvertisement) IP() net.IP {
+	return i.ip
+}
+
 func (i *IPAdvertisement) Equal(other *IPAdvertisement) bool {
 	if i == nil && other == nil {
 		return true
@@ -0,0 +1,94 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"net"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+)
+
+// LinkEvent is sent when a link or one of its addresses changes. An empty
+// Interface means the link could not be resolved, for example because it has
+// already been deleted.
+type LinkEvent struct {
+	Interface string
+}
+
+// LinkEventSource notifies the announcer about interface changes, the returned
+// channel is closed when the subscription ends.
+type LinkEventSource interface {
+	Subscribe(done <-chan struct{}) (<-chan LinkEvent, error)
+}
+
+// NetlinkEventSource is the LinkEventSource backed by netlink link and address updates.
+type NetlinkEventSource struct {
+	logger logr.Logger
+}
+
+// NewNetlinkEventSource returns a LinkEventSource subscribing to netlink.
+func NewNetlinkEventSource(l logr.Logger) *NetlinkEventSource {
+	return &NetlinkEventSource{logger: l}
+}
+
+// Subscribe subscribes to netlink link and address updates until done is closed.
+func (s *NetlinkEventSource) Subscribe(done <-chan struct{}) (<-chan LinkEvent, error) {
+	// the subscriptions are stopped together, if one of them fails the other one
+	// must not outlive it
+	stop := make(chan struct{})
+	errorCallback := func(err error) {
+		s.logger.Error(err, "netlink subscription failed", "op", "subscribeLinkEvents")
+	}
+
+	linkCh := make(chan netlink.LinkUpdate, 64)
+	err := netlink.LinkSubscribeWithOptions(linkCh, stop, netlink.LinkSubscribeOptions{
+		ErrorCallback: errorCallback,
+	})
+	if err != nil {
+		close(stop)
+		return nil, err
+	}
+	addrCh := make(chan netlink.AddrUpdate, 64)
+	err = netlink.AddrSubscribeWithOptions(addrCh, stop, netlink.AddrSubscribeOptions{
+		ErrorCallback: errorCallback,
+	})
+	if err != nil {
+		close(stop)
+		return nil, err
+	}
+
+	events := make(chan LinkEvent, 64)
+	go func() {
+		defer close(events)
+		defer close(stop)
+		for {
+			var ev LinkEvent
+			select {
+			case <-done:
+				return
+			case update, ok := <-linkCh:
+				if !ok {
+					return
+				}
+				if update.Link != nil {
+					ev.Interface = update.Link.Attrs().Name
+				}
+			case update, ok := <-addrCh:
+				if !ok {
+					return
+				}
+				if ifi, err := net.InterfaceByIndex(update.LinkIndex); err == nil {
+					ev.Interface = ifi.Name
+				}
+			}
+			select {
+			case events <- ev:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -25,12 +25,13 @@ type ndpResponder struct {
 	conn         *ndp.Conn
 	closed       chan struct{}
 	announce     announceFunc
+	conflict     conflictFunc
 	// Refcount of how many watchers for each solicited node
 	// multicast group.
 	solicitedNodeGroups map[string]int64
 }
 
-func newNDPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (*ndpResponder, error) {
+func newNDPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc, conflict conflictFunc) (*ndpResponder, error) {
 	// Use link-local address as the source IPv6 address for NDP communications.
 	conn, _, err := ndp.Dial(ifi, ndp.LinkLocal)
 	if err != nil {
@@ -44,6 +45,7 @@ func newNDPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (
 		conn:                conn,
 		closed:              make(chan struct{}),
 		announce:            ann,
+		conflict:            conflict,
 		solicitedNodeGroups: map[string]int64{},
 	}
 	go ret.run()
@@ -58,9 +60,7 @@ func (n *ndpResponder) Close() error {
 }
 
 func (n *ndpResponder) Gratuitous(ip net.IP) error {
-	err := n.advertise(net.IPv6linklocalallnodes, ip, true)
-	stats.SentGratuitous(ip.String())
-	return err
+	return n.advertise(net.IPv6linklocalallnodes, ip, true)
 }
 
 func (n *ndpResponder) Watch(ip net.IP) error {
@@ -116,6 +116,13 @@ func (n *ndpResponder) processRequest() dropReason {
 		return dropReasonError
 	}
 
+	if na, ok := msg.(*ndp.NeighborAdvertisement); ok {
+		if lla := targetLinkLayerAddr(na); lla != nil {
+			n.conflict(na.TargetAddress, n.intf, lla)
+		}
+		return dropReasonMessageType
+	}
+
 	ns, ok := msg.(*ndp.NeighborSolicitation)
 	if !ok {
 		return dropReasonMessageType
@@ -173,3 +180,15 @@ func (n *ndpResponder) advertise(dst, target net.IP, gratuitous bool) error {
 	}
 	return n.conn.WriteTo(m, nil, dst)
 }
+
+// targetLinkLayerAddr returns the MAC address the advertisement claims its
+// target address for
+func targetLinkLayerAddr(na *ndp.NeighborAdvertisement) net.HardwareAddr {
+	for _, o := range na.Options {
+		lla, ok := o.(*ndp.LinkLayerAddress)
+		if ok && lla.Direction == ndp.Target {
+			return lla.Addr
+		}
+	}
+	return nil
+}
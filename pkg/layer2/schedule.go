@@ -0,0 +1,101 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"time"
+)
+
+// Schedule is the schedule of the gratuitous announcements of an IP: a burst
+// of Count announcements Interval apart after a change, then one announcement
+// every Refresh when it is positive.
+type Schedule struct {
+	Count    int
+	Interval time.Duration
+	Refresh  time.Duration
+}
+
+// DefaultSchedule sends 5 announcements 1100 milliseconds apart, see
+// https://github.com/metallb/metallb/issues/172 for the 1100 choice.
+var DefaultSchedule = Schedule{Count: 5, Interval: 1100 * time.Millisecond}
+
+// spamState is the announcement state of an IP.
+type spamState struct {
+	adv IPAdvertisement
+	// sent is the number of announcements sent in the current burst
+	sent int
+	next time.Time
+}
+
+// spamScheduler tracks when each IP is announced next.
+type spamScheduler struct {
+	states map[string]*spamState
+}
+
+func newSpamScheduler() *spamScheduler {
+	return &spamScheduler{states: map[string]*spamState{}}
+}
+
+// add starts a burst for adv, it returns true when adv is to be announced
+// right away. A burst in progress is extended instead, so that a flapping
+// link does not send more than the schedule.
+func (s *spamScheduler) add(adv IPAdvertisement) bool {
+	key := adv.ip.String()
+	state, ok := s.states[key]
+	if ok && state.sent < state.adv.schedule.Count {
+		// the next announcement of the burst stands for the skipped one
+		state.adv = adv
+		state.sent = 0
+		return false
+	}
+	s.states[key] = &spamState{adv: adv}
+	return true
+}
+
+// sent records an announcement of adv sent at now, and schedules the next one.
+func (s *spamScheduler) sent(adv IPAdvertisement, now time.Time) {
+	key := adv.ip.String()
+	state, ok := s.states[key]
+	if !ok {
+		return
+	}
+	state.sent++
+	schedule := state.adv.schedule
+	switch {
+	case state.sent < schedule.Count:
+		state.next = now.Add(schedule.Interval)
+	case schedule.Refresh > 0:
+		state.sent = schedule.Count
+		state.next = now.Add(schedule.Refresh)
+	default:
+		delete(s.states, key)
+	}
+}
+
+// remove stops announcing the IP of adv.
+func (s *spamScheduler) remove(adv IPAdvertisement) {
+	delete(s.states, adv.ip.String())
+}
+
+// due returns the advertisements to announce at now.
+func (s *spamScheduler) due(now time.Time) []IPAdvertisement {
+	res := make([]IPAdvertisement, 0)
+	for _, state := range s.states {
+		if !state.next.After(now) {
+			res = append(res, state.adv)
+		}
+	}
+	return res
+}
+
+// next returns the time of the next announcement, false when there is none.
+func (s *spamScheduler) next() (time.Time, bool) {
+	var res time.Time
+	for _, state := range s.states {
+		if res.IsZero() || state.next.Before(res) {
+			res = state.next
+		}
+	}
+	return res, !res.IsZero()
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run announces the due advertisements until end and returns the offsets of
+// the announcements of ip
+func run(s *spamScheduler, start, end time.Time) []time.Duration {
+	res := make([]time.Duration, 0)
+	for {
+		next, ok := s.next()
+		if !ok || next.After(end) {
+			return res
+		}
+		for _, adv := range s.due(next) {
+			res = append(res, next.Sub(start))
+			s.sent(adv, next)
+		}
+	}
+}
+
+func TestSpamScheduler(t *testing.T) {
+	start := time.Now()
+	cases := map[string]struct {
+		schedule Schedule
+		expected []time.Duration
+	}{
+		"default": {
+			schedule: DefaultSchedule,
+			expected: []time.Duration{0, 1100 * time.Millisecond, 2200 * time.Millisecond, 3300 * time.Millisecond, 4400 * time.Millisecond},
+		},
+		"single announcement": {
+			schedule: Schedule{Count: 1, Interval: time.Second},
+			expected: []time.Duration{0},
+		},
+		"burst and refresh": {
+			schedule: Schedule{Count: 2, Interval: 100 * time.Millisecond, Refresh: 30 * time.Second},
+			expected: []time.Duration{0, 100 * time.Millisecond, 30100 * time.Millisecond, 60100 * time.Millisecond},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := newSpamScheduler()
+			adv := NewIPAdvertisement(net.ParseIP("10.6.1.21"), true, nil).WithSchedule(tc.schedule)
+			assert.True(t, s.add(adv))
+			s.sent(adv, start)
+			got := append([]time.Duration{0}, run(s, start, start.Add(time.Minute+time.Second))...)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestSpamSchedulerAdd(t *testing.T) {
+	start := time.Now()
+	s := newSpamScheduler()
+	adv := NewIPAdvertisement(net.ParseIP("10.6.1.21"), true, nil).
+		WithSchedule(Schedule{Count: 3, Interval: time.Second, Refresh: time.Minute})
+
+	assert.True(t, s.add(adv))
+	s.sent(adv, start)
+
+	// a change during the burst extends it without an extra announcement
+	assert.False(t, s.add(adv))
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		run(s, start, start.Add(30*time.Second)))
+
+	// a change after the burst starts a new one right away
+	assert.True(t, s.add(adv))
+	s.sent(adv, start.Add(40*time.Second))
+	next, ok := s.next()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(41*time.Second), next)
+
+	s.remove(adv)
+	_, ok = s.next()
+	assert.False(t, ok)
+}
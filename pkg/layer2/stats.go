@@ -33,22 +33,35 @@ var stats = metrics{
 		Namespace: "egressgateway",
 		Subsystem: "layer2",
 		Name:      "gratuitous_sent",
-		Help:      "Number of gratuitous layer2 packets sent for owned IPs as a result of failovers",
+		Help:      "Number of gratuitous layer2 announcements sent for owned IPs after failovers and link changes, and by the periodic refresh",
 	}, []string{
+		"gateway",
 		"ip",
 	}),
+
+	conflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "egressgateway",
+		Subsystem: "layer2",
+		Name:      "conflicts_detected",
+		Help:      "Number of layer2 announcements of owned IPs from foreign MAC addresses",
+	}, []string{
+		"ip",
+		"interface",
+	}),
 }
 
 type metrics struct {
 	in         *prometheus.CounterVec
 	out        *prometheus.CounterVec
 	gratuitous *prometheus.CounterVec
+	conflicts  *prometheus.CounterVec
 }
 
 func init() {
 	prometheus.MustRegister(stats.in)
 	prometheus.MustRegister(stats.out)
 	prometheus.MustRegister(stats.gratuitous)
+	prometheus.MustRegister(stats.conflicts)
 }
 
 func (m *metrics) GotRequest(addr string) {
@@ -59,6 +72,10 @@ func (m *metrics) SentResponse(addr string) {
 	m.out.WithLabelValues(addr).Add(1)
 }
 
-func (m *metrics) SentGratuitous(addr string) {
-	m.gratuitous.WithLabelValues(addr).Add(1)
+func (m *metrics) SentGratuitous(gateway, addr string) {
+	m.gratuitous.WithLabelValues(gateway, addr).Add(1)
+}
+
+func (m *metrics) DetectedConflict(addr, intf string) {
+	m.conflicts.WithLabelValues(addr, intf).Add(1)
 }
@@ -23,6 +23,10 @@ type Interface interface {
 	AllocateNext() (string, error)
 	Release(mark string) error
 	ForEach(func(mark string))
+	// Free returns the count of marks left in the range
+	Free() int
+	// Size returns the count of marks in the range
+	Size() int
 
 	// Has function for testing
 	Has(mark string) bool
@@ -156,6 +160,16 @@ func (r *Range) ForEach(fn func(mark string)) {
 	})
 }
 
+// Free returns the count of marks left in the range.
+func (r *Range) Free() int {
+	return r.alloc.Free()
+}
+
+// Size returns the count of marks in the range.
+func (r *Range) Size() int {
+	return r.max
+}
+
 // GetIndexedMark returns a string that is r.base + index in the contiguous mark space.
 func GetIndexedMark(base *big.Int, index int) (string, error) {
 	mark := addMarkOffset(base, index)
@@ -0,0 +1,136 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package markallocator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Record keeps the mark of each owner in a ConfigMap, so that the allocation
+// survives controller restarts and leader changes. The data of the ConfigMap
+// maps the owner name to its mark. Every write carries the resourceVersion
+// that was read, a concurrent writer makes the update retry on the latest record.
+type Record struct {
+	reader client.Reader
+	writer client.Client
+	key    types.NamespacedName
+
+	mutex sync.RWMutex
+	cache map[string]string
+}
+
+// NewRecord creates a Record stored in the ConfigMap namespace/name, reader
+// should not be backed by the informer cache to always read the latest record
+func NewRecord(reader client.Reader, writer client.Client, namespace, name string) *Record {
+	return &Record{
+		reader: reader,
+		writer: writer,
+		key:    types.NamespacedName{Namespace: namespace, Name: name},
+		cache:  make(map[string]string),
+	}
+}
+
+// Load reads the record from the API server and returns a copy of it
+func (r *Record) Load(ctx context.Context) (map[string]string, error) {
+	cm, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.setCache(cm.Data)
+	return copyMarks(cm.Data), nil
+}
+
+// Lookup returns the mark of the owner in the last record read or written
+func (r *Record) Lookup(owner string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	mark, ok := r.cache[owner]
+	return mark, ok
+}
+
+// OwnerOf returns the owner of the mark in the last record read or written
+func (r *Record) OwnerOf(mark string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for owner, item := range r.cache {
+		if item == mark {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// Update calls fn with the latest record and writes the record back when fn
+// returns true. fn can be called more than once if the record is modified
+// by another writer in the meantime.
+func (r *Record) Update(ctx context.Context, fn func(marks map[string]string) (bool, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := r.get(ctx)
+		if err != nil {
+			return err
+		}
+		marks := copyMarks(cm.Data)
+		changed, err := fn(marks)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			r.setCache(cm.Data)
+			return nil
+		}
+
+		cm.Data = marks
+		if cm.ResourceVersion == "" {
+			err = r.writer.Create(ctx, cm)
+			if k8serr.IsAlreadyExists(err) {
+				// created by another writer, retry on the record it wrote
+				return k8serr.NewConflict(corev1.Resource("configmaps"), r.key.Name, err)
+			}
+		} else {
+			err = r.writer.Update(ctx, cm)
+		}
+		if err != nil {
+			return err
+		}
+		r.setCache(marks)
+		return nil
+	})
+}
+
+func (r *Record) get(ctx context.Context) (*corev1.ConfigMap, error) {
+	cm := new(corev1.ConfigMap)
+	err := r.reader.Get(ctx, r.key, cm)
+	if err != nil {
+		if !k8serr.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get mark record %s: %w", r.key, err)
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: r.key.Namespace, Name: r.key.Name},
+		}
+	}
+	return cm, nil
+}
+
+func (r *Record) setCache(marks map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cache = copyMarks(marks)
+}
+
+func copyMarks(marks map[string]string) map[string]string {
+	res := make(map[string]string, len(marks))
+	for owner, mark := range marks {
+		res[owner] = mark
+	}
+	return res
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package markallocator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestRecord(t *testing.T) {
+	cases := map[string]struct {
+		objs      []client.Object
+		fn        func(marks map[string]string) (bool, error)
+		expErr    bool
+		expRecord map[string]string
+	}{
+		"create record": {
+			fn: func(marks map[string]string) (bool, error) {
+				marks["node1"] = "0x26000000"
+				return true, nil
+			},
+			expRecord: map[string]string{"node1": "0x26000000"},
+		},
+		"update record": {
+			objs: []client.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mark"},
+				Data:       map[string]string{"node1": "0x26000000"},
+			}},
+			fn: func(marks map[string]string) (bool, error) {
+				delete(marks, "node1")
+				marks["node2"] = "0x26000001"
+				return true, nil
+			},
+			expRecord: map[string]string{"node2": "0x26000001"},
+		},
+		"unchanged record": {
+			objs: []client.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mark"},
+				Data:       map[string]string{"node1": "0x26000000"},
+			}},
+			fn: func(marks map[string]string) (bool, error) {
+				marks["node2"] = "0x26000001"
+				return false, nil
+			},
+			expRecord: map[string]string{"node1": "0x26000000"},
+		},
+		"failed fn": {
+			fn: func(marks map[string]string) (bool, error) {
+				return false, errors.New("some error")
+			},
+			expErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(tc.objs...).Build()
+			record := NewRecord(cli, cli, "default", "mark")
+
+			err := record.Update(context.Background(), tc.fn)
+			if tc.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			cm := new(corev1.ConfigMap)
+			err = cli.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "mark"}, cm)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expRecord, cm.Data)
+
+			for owner, mark := range tc.expRecord {
+				item, ok := record.Lookup(owner)
+				assert.True(t, ok)
+				assert.Equal(t, mark, item)
+
+				item, ok = record.OwnerOf(mark)
+				assert.True(t, ok)
+				assert.Equal(t, owner, item)
+			}
+
+			marks, err := NewRecord(cli, cli, "default", "mark").Load(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expRecord, marks)
+		})
+	}
+}
+
+func TestRecordConflict(t *testing.T) {
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).Build()
+	record := NewRecord(cli, cli, "default", "mark")
+	other := NewRecord(cli, cli, "default", "mark")
+
+	calls := 0
+	err := record.Update(context.Background(), func(marks map[string]string) (bool, error) {
+		calls++
+		if calls == 1 {
+			// another writer records a mark between the read and the write
+			err := other.Update(context.Background(), func(marks map[string]string) (bool, error) {
+				marks["node1"] = "0x26000000"
+				return true, nil
+			})
+			assert.NoError(t, err)
+		}
+		marks["node2"] = "0x26000001"
+		return true, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	marks, err := record.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"node1": "0x26000000", "node2": "0x26000001"}, marks)
+}
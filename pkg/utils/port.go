@@ -0,0 +1,103 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParsePortRange parses a port range such as 20000-20999, a single port is
+// a range of one port.
+func ParsePortRange(s string) (int, int, error) {
+	first, last, found := strings.Cut(s, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	end := start
+	if found {
+		end, err = strconv.Atoi(last)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+		}
+	}
+	if start <= 0 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return start, end, nil
+}
+
+// SourcePortMin and SourcePortMax bound the source ports of the EIPs, the
+// ports below are left to the local services of the gateway node.
+const (
+	SourcePortMin = 1024
+	SourcePortMax = 65535
+)
+
+type portRange struct{ start, end int }
+
+// sortedPortRanges parses the port ranges and sorts them by their start.
+func sortedPortRanges(used []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(used))
+	for _, item := range used {
+		start, end, err := ParsePortRange(item)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, portRange{start, end})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	return ranges, nil
+}
+
+// AllocatePortRange returns the first range of count ports within low and high
+// that does not overlap the used ranges.
+func AllocatePortRange(used []string, count, low, high int) (string, error) {
+	ranges, err := sortedPortRanges(used)
+	if err != nil {
+		return "", err
+	}
+
+	next := low
+	for _, r := range ranges {
+		if r.start-next >= count {
+			break
+		}
+		if r.end+1 > next {
+			next = r.end + 1
+		}
+	}
+	if count <= 0 || next+count-1 > high {
+		return "", fmt.Errorf("no free range of %d ports in %d-%d", count, low, high)
+	}
+	return fmt.Sprintf("%d-%d", next, next+count-1), nil
+}
+
+// FreePortRange returns the largest range within low and high that does not
+// overlap the used ranges, the first one among the largest, or an empty
+// string when all the ports are used.
+func FreePortRange(used []string, low, high int) (string, error) {
+	ranges, err := sortedPortRanges(used)
+	if err != nil {
+		return "", err
+	}
+
+	best := portRange{start: 0, end: -1}
+	next := low
+	for _, r := range append(ranges, portRange{start: high + 1, end: high + 1}) {
+		if end := min(r.start-1, high); end-next > best.end-best.start {
+			best = portRange{start: next, end: end}
+		}
+		if r.end+1 > next {
+			next = r.end + 1
+		}
+	}
+	if best.end < best.start {
+		return "", nil
+	}
+	return fmt.Sprintf("%d-%d", best.start, best.end), nil
+}
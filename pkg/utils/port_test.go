@@ -0,0 +1,87 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	cases := map[string]struct {
+		in         string
+		start, end int
+		wantErr    bool
+	}{
+		"range":       {in: "20000-20999", start: 20000, end: 20999},
+		"single port": {in: "8080", start: 8080, end: 8080},
+		"reversed":    {in: "2000-1000", wantErr: true},
+		"zero":        {in: "0-10", wantErr: true},
+		"too large":   {in: "65000-65536", wantErr: true},
+		"not number":  {in: "a-b", wantErr: true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			start, end, err := ParsePortRange(tc.in)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.start, start)
+			assert.Equal(t, tc.end, end)
+		})
+	}
+}
+
+func TestAllocatePortRange(t *testing.T) {
+	cases := map[string]struct {
+		used    []string
+		count   int
+		want    string
+		wantErr bool
+	}{
+		"empty":           {count: 1000, want: "1024-2023"},
+		"after used":      {used: []string{"1024-2023"}, count: 10, want: "2024-2033"},
+		"fill gap":        {used: []string{"3024-4023", "1024-2023"}, count: 1000, want: "2024-3023"},
+		"gap too small":   {used: []string{"1024-2023", "2524-3023"}, count: 1000, want: "3024-4023"},
+		"full":            {used: []string{"1024-65535"}, count: 1, wantErr: true},
+		"exact remainder": {used: []string{"1024-65000"}, count: 535, want: "65001-65535"},
+		"too many":        {count: 64513, wantErr: true},
+		"invalid used":    {used: []string{"x"}, count: 1, wantErr: true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := AllocatePortRange(tc.used, tc.count, 1024, 65535)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFreePortRange(t *testing.T) {
+	cases := map[string]struct {
+		used []string
+		want string
+	}{
+		"empty":         {want: "1024-65535"},
+		"after used":    {used: []string{"1024-2023"}, want: "2024-65535"},
+		"largest gap":   {used: []string{"1024-2023", "12024-65535"}, want: "2024-12023"},
+		"first largest": {used: []string{"2024-3023", "4024-65535"}, want: "1024-2023"},
+		"full":          {used: []string{"1024-65535"}, want: ""},
+		"overlapping":   {used: []string{"1024-30000", "20000-65000"}, want: "65001-65535"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := FreePortRange(tc.used, 1024, 65535)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}